@@ -30,6 +30,7 @@ type ScanConfig struct {
 	Repeat          uint32
 	NInterval       uint32 // Stats dump nrequests interval
 	Consistency     bool   // Use session consistency
+	Priority        string // Priority class of scan: interactive, batch or background
 	Scans           client.Scans
 	IndexProjection *client.IndexProjection
 	GroupAggr       *client.GroupAggr
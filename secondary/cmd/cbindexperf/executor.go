@@ -91,6 +91,9 @@ func RunJob(client *qclient.GsiClient, job *Job, aggrQ chan *JobResult, scanRang
 	startTime := time.Now()
 	uuid := fmt.Sprintf("%d", atomic.AddUint64(&requestCounter, 1))
 	var scanParams = map[string]interface{}{"skipReadMetering": true, "user": ""}
+	if spec.Priority != "" {
+		scanParams["priority"] = spec.Priority
+	}
 
 	switch spec.Type {
 	case "All":
@@ -49,6 +49,9 @@ func advanced_usage() {
     cbindexplan -command=rebalance -plan="saved-plan.json"
     cbindexplan -command=rebalance -plan="saved-plan.json" -output="newplan.json"
     cbindexplan -command=rebalance -plan="saved-plan.json" -addNode=1
+- Redundant Index Report
+    cbindexplan -command=redundant -cluster="127.0.0.1:8091" -username="<user>" -password="<pwd>" -unusedDays=30
+    cbindexplan -command=redundant -plan="saved-plan.json" -output="redundant.json"
     `)
 	fmt.Fprintln(os.Stderr, `Usage Note:
 1) cbindexplan should only be used with MOI clsuter.
@@ -174,6 +177,7 @@ var gEjectedNode string
 var gGetUsage bool
 var gNumNewReplica int
 var gEnableShardAffinity bool
+var gUnusedDays int
 
 //////////////////////////////////////////////////////////////
 // Initialization
@@ -187,7 +191,7 @@ func init() {
 	flag.StringVar(&gGenStmt, "ddl", "", "generate DDL statement after planning for new/moved indexes")
 
 	// command + index specification
-	flag.StringVar(&gCommand, "command", "", "command = {plan | rebalance | retrieve | swap}")
+	flag.StringVar(&gCommand, "command", "", "command = {plan | rebalance | retrieve | swap | redundant}")
 	flag.StringVar(&gClusterUrl, "cluster", "", "fetch existing index layout plan from cluster url")
 	flag.StringVar(&gUsername, "username", "", "admin user for the cluster")
 	flag.StringVar(&gPassword, "password", "", "admin password for the cluster")
@@ -215,6 +219,9 @@ func init() {
 
 	// enable shard affinity when creating/moving/repairing indexes
 	flag.BoolVar(&gEnableShardAffinity, "enableShardAffinity", true, "flag to enable shard affinity during the plan phase of index creation/rebalance/replica repair/ restore")
+
+	// redundant index report
+	flag.IntVar(&gUnusedDays, "unusedDays", 30, "report indexes not scanned in these many days since they were built as unused (0 to disable). Applicable only when command is redundant.")
 }
 
 func main() {
@@ -270,7 +277,7 @@ func main() {
 		}
 	}
 
-	if gCommand == planner.CommandRebalance && plan == nil {
+	if (gCommand == planner.CommandRebalance || gCommand == planner.CommandRedundant) && plan == nil {
 		logging.Fatalf("Unable to get index layout from either argument 'plan' or 'cluster'.")
 		usage()
 		return
@@ -396,6 +403,14 @@ func main() {
 			return
 		}
 
+	} else if gCommand == string(planner.CommandRedundant) {
+
+		_, err := planner.ExecuteRedundantIndexReport(plan, gUnusedDays, gOutput)
+		if err != nil {
+			logging.Fatalf("Planner error: %v.", err)
+			return
+		}
+
 	} else {
 		logging.Fatalf("Invalid argument: Invalid value for 'command' : %v", gCommand)
 		usage()
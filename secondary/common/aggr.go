@@ -10,7 +10,10 @@ package common
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
+
 	"github.com/couchbase/indexing/secondary/collatejson"
 	"github.com/couchbase/query/value"
 )
@@ -23,6 +26,11 @@ const (
 	AGG_SUM
 	AGG_COUNT
 	AGG_COUNTN
+	AGG_AVG
+	AGG_ARRAY_AGG
+	AGG_VARIANCE
+	AGG_STDDEV
+	AGG_COUNT_APPROX
 	AGG_INVALID
 )
 
@@ -39,11 +47,46 @@ func (a AggrFuncType) String() string {
 		return "COUNT"
 	case AGG_COUNTN:
 		return "COUNTN"
+	case AGG_AVG:
+		return "AVG"
+	case AGG_ARRAY_AGG:
+		return "ARRAY_AGG"
+	case AGG_VARIANCE:
+		return "VARIANCE"
+	case AGG_STDDEV:
+		return "STDDEV"
+	case AGG_COUNT_APPROX:
+		return "COUNT_APPROX"
 	default:
 		return "AGG_UNKNOWN"
 	}
 }
 
+// NeedsDecode returns true if the aggregate is computed on decoded
+// index key values rather than on their collatejson encoding.
+func (a AggrFuncType) NeedsDecode() bool {
+
+	switch a {
+	case AGG_SUM, AGG_AVG, AGG_ARRAY_AGG, AGG_VARIANCE, AGG_STDDEV:
+		return true
+	default:
+		return false
+	}
+}
+
+// HasPartialState returns true if partial results of the aggregate are
+// returned as intermediate state, which has to be merged and finalized
+// by the client, rather than as a value.
+func (a AggrFuncType) HasPartialState() bool {
+
+	switch a {
+	case AGG_AVG, AGG_ARRAY_AGG, AGG_VARIANCE, AGG_STDDEV, AGG_COUNT_APPROX:
+		return true
+	default:
+		return false
+	}
+}
+
 type AggrFunc interface {
 	Type() AggrFuncType
 	AddDelta(delta interface{})
@@ -54,10 +97,42 @@ type AggrFunc interface {
 	IsValid() bool
 }
 
+// PartialAggrFunc is an aggregate that can be computed in parts, e.g.
+// per partition, and merged. State returns the intermediate state that
+// is sent instead of Value for partial aggregates. MergeState merges the
+// intermediate state of another part into the aggregate.
+type PartialAggrFunc interface {
+	AggrFunc
+	State() interface{}
+	MergeState(state value.Value) error
+}
+
 var (
 	encodedNull = []byte{2, 0}
 )
 
+var ErrInvalidAggrState = errors.New("Invalid partial aggregate state")
+
+// NewPartialAggrFunc returns an empty aggregate, which can be used
+// to merge intermediate state of partial aggregates.
+func NewPartialAggrFunc(typ AggrFuncType, distinct bool, n1qlValue bool) PartialAggrFunc {
+
+	switch typ {
+
+	case AGG_AVG:
+		return &AggrFuncAvg{typ: AGG_AVG, distinct: distinct, n1qlValue: n1qlValue}
+	case AGG_ARRAY_AGG:
+		return &AggrFuncArrayAgg{typ: AGG_ARRAY_AGG, distinct: distinct, n1qlValue: n1qlValue}
+	case AGG_VARIANCE, AGG_STDDEV:
+		return &AggrFuncVariance{typ: typ, distinct: distinct, n1qlValue: n1qlValue}
+	case AGG_COUNT_APPROX:
+		return &AggrFuncCountApprox{typ: AGG_COUNT_APPROX, hll: NewHyperLogLog(),
+			distinct: distinct, n1qlValue: n1qlValue}
+	default:
+		return nil
+	}
+}
+
 func NewAggrFunc(typ AggrFuncType, val interface{}, distinct bool, n1qlValue bool) AggrFunc {
 
 	var agg AggrFunc
@@ -74,6 +149,8 @@ func NewAggrFunc(typ AggrFuncType, val interface{}, distinct bool, n1qlValue boo
 		agg = &AggrFuncMin{typ: AGG_MIN, distinct: distinct, n1qlValue: n1qlValue}
 	case AGG_MAX:
 		agg = &AggrFuncMax{typ: AGG_MAX, distinct: distinct, n1qlValue: n1qlValue}
+	case AGG_AVG, AGG_ARRAY_AGG, AGG_VARIANCE, AGG_STDDEV, AGG_COUNT_APPROX:
+		agg = NewPartialAggrFunc(typ, distinct, n1qlValue)
 	default:
 		return nil
 	}
@@ -607,6 +684,421 @@ func (a AggrFuncMax) String() string {
 	}
 }
 
+type AggrFuncAvg struct {
+	typ   AggrFuncType
+	sum   AggrFuncSum
+	count int64
+
+	lastObj   value.Value
+	distinct  bool
+	n1qlValue bool
+}
+
+func (a AggrFuncAvg) Type() AggrFuncType {
+	return AGG_AVG
+}
+
+func (a AggrFuncAvg) Value() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+
+	sum, _ := toFloat64(a.sum.Value())
+	return sum / float64(a.count)
+}
+
+// State of AVG is [sum, count]
+func (a AggrFuncAvg) State() interface{} {
+	return []interface{}{a.sum.Value(), a.count}
+}
+
+func (a AggrFuncAvg) Distinct() bool {
+	return a.distinct
+}
+
+func (a AggrFuncAvg) IsValid() bool {
+	return a.count > 0
+}
+
+func (a *AggrFuncAvg) AddDelta(delta interface{}) {
+	//not implemented
+}
+
+//Only numeric values are considered.
+//null/missing/non-numeric are ignored.
+func (a *AggrFuncAvg) AddDeltaObj(delta value.Value) {
+
+	if !isNumeric(delta) {
+		return
+	}
+
+	if a.distinct {
+		if !checkDistinctObj(&a.lastObj, delta) {
+			return
+		}
+	}
+	a.sum.AddDelta(delta.ActualForIndex())
+	a.count += 1
+
+}
+
+func (a *AggrFuncAvg) AddDeltaRaw(delta []byte) {
+	//not implemented
+}
+
+func (a *AggrFuncAvg) MergeState(state value.Value) error {
+
+	vals, err := partialState(state, 2)
+	if err != nil || vals == nil {
+		return err
+	}
+
+	count, ok := toFloat64(vals[1])
+	if !ok {
+		return ErrInvalidAggrState
+	}
+	a.sum.AddDelta(vals[0])
+	a.count += int64(count)
+	return nil
+}
+
+func (a AggrFuncAvg) String() string {
+	return fmt.Sprintf("Type %v Sum %v Count %v Distinct %v", a.typ, a.sum.Value(), a.count, a.distinct)
+}
+
+type AggrFuncArrayAgg struct {
+	typ  AggrFuncType
+	vals []interface{}
+	seen map[string]bool //values seen so far for distinct
+
+	distinct  bool
+	n1qlValue bool
+}
+
+func (a AggrFuncArrayAgg) Type() AggrFuncType {
+	return AGG_ARRAY_AGG
+}
+
+func (a AggrFuncArrayAgg) Value() interface{} {
+	if len(a.vals) == 0 {
+		return nil
+	}
+	return a.vals
+}
+
+// State of ARRAY_AGG is the array of values
+func (a AggrFuncArrayAgg) State() interface{} {
+	if a.vals == nil {
+		return []interface{}{}
+	}
+	return a.vals
+}
+
+func (a AggrFuncArrayAgg) Distinct() bool {
+	return a.distinct
+}
+
+func (a AggrFuncArrayAgg) IsValid() bool {
+	return len(a.vals) > 0
+}
+
+func (a *AggrFuncArrayAgg) AddDelta(delta interface{}) {
+	//not implemented
+}
+
+//missing is ignored.
+//all other values are added to the array
+func (a *AggrFuncArrayAgg) AddDeltaObj(delta value.Value) {
+
+	if delta.Type() == value.MISSING {
+		return
+	}
+
+	if a.distinct {
+		key, err := delta.MarshalJSON()
+		if err == nil {
+			if a.seen == nil {
+				a.seen = make(map[string]bool)
+			}
+			if a.seen[string(key)] {
+				return
+			}
+			a.seen[string(key)] = true
+		}
+	}
+	a.vals = append(a.vals, delta.ActualForIndex())
+
+}
+
+func (a *AggrFuncArrayAgg) AddDeltaRaw(delta []byte) {
+	//not implemented
+}
+
+func (a *AggrFuncArrayAgg) MergeState(state value.Value) error {
+
+	if isNullOrMissing(state) {
+		return nil
+	}
+
+	vals, ok := state.ActualForIndex().([]interface{})
+	if !ok {
+		return ErrInvalidAggrState
+	}
+	for _, v := range vals {
+		a.AddDeltaObj(value.NewValue(v))
+	}
+	return nil
+}
+
+func (a AggrFuncArrayAgg) String() string {
+	return fmt.Sprintf("Type %v Len %v Distinct %v", a.typ, len(a.vals), a.distinct)
+}
+
+// AggrFuncVariance computes sample variance, or standard deviation,
+// using Welford's online algorithm. m2 is the sum of squared differences
+// from the mean.
+type AggrFuncVariance struct {
+	typ   AggrFuncType
+	count int64
+	mean  float64
+	m2    float64
+
+	lastObj   value.Value
+	distinct  bool
+	n1qlValue bool
+}
+
+func (a AggrFuncVariance) Type() AggrFuncType {
+	return a.typ
+}
+
+func (a AggrFuncVariance) Value() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+
+	var variance float64
+	if a.count > 1 {
+		variance = a.m2 / float64(a.count-1)
+	}
+
+	if a.typ == AGG_STDDEV {
+		return math.Sqrt(variance)
+	}
+	return variance
+}
+
+// State of VARIANCE and STDDEV is [count, mean, m2]
+func (a AggrFuncVariance) State() interface{} {
+	return []interface{}{a.count, a.mean, a.m2}
+}
+
+func (a AggrFuncVariance) Distinct() bool {
+	return a.distinct
+}
+
+func (a AggrFuncVariance) IsValid() bool {
+	return a.count > 0
+}
+
+func (a *AggrFuncVariance) AddDelta(delta interface{}) {
+	//not implemented
+}
+
+//Only numeric values are considered.
+//null/missing/non-numeric are ignored.
+func (a *AggrFuncVariance) AddDeltaObj(delta value.Value) {
+
+	if !isNumeric(delta) {
+		return
+	}
+
+	if a.distinct {
+		if !checkDistinctObj(&a.lastObj, delta) {
+			return
+		}
+	}
+
+	v, _ := toFloat64(delta.ActualForIndex())
+	a.count += 1
+	d := v - a.mean
+	a.mean += d / float64(a.count)
+	a.m2 += d * (v - a.mean)
+
+}
+
+func (a *AggrFuncVariance) AddDeltaRaw(delta []byte) {
+	//not implemented
+}
+
+// MergeState combines the state of two parts (Chan et al.)
+func (a *AggrFuncVariance) MergeState(state value.Value) error {
+
+	vals, err := partialState(state, 3)
+	if err != nil || vals == nil {
+		return err
+	}
+
+	var s [3]float64
+	for i, v := range vals {
+		var ok bool
+		if s[i], ok = toFloat64(v); !ok {
+			return ErrInvalidAggrState
+		}
+	}
+
+	count, mean, m2 := int64(s[0]), s[1], s[2]
+	if count == 0 {
+		return nil
+	}
+
+	total := a.count + count
+	d := mean - a.mean
+	a.mean += d * float64(count) / float64(total)
+	a.m2 += m2 + d*d*float64(a.count)*float64(count)/float64(total)
+	a.count = total
+	return nil
+}
+
+func (a AggrFuncVariance) String() string {
+	return fmt.Sprintf("Type %v Count %v Mean %v M2 %v Distinct %v", a.typ, a.count, a.mean, a.m2, a.distinct)
+}
+
+// AggrFuncCountApprox estimates the number of distinct values using
+// HyperLogLog. It is always distinct.
+type AggrFuncCountApprox struct {
+	typ AggrFuncType
+	hll *HyperLogLog
+	buf []byte
+
+	distinct  bool
+	n1qlValue bool
+}
+
+func (a AggrFuncCountApprox) Type() AggrFuncType {
+	return AGG_COUNT_APPROX
+}
+
+func (a AggrFuncCountApprox) Value() interface{} {
+	return int64(a.hll.Estimate())
+}
+
+// State of COUNT_APPROX is the encoded HyperLogLog sketch
+func (a AggrFuncCountApprox) State() interface{} {
+	return a.hll.String()
+}
+
+func (a AggrFuncCountApprox) Distinct() bool {
+	return a.distinct
+}
+
+func (a AggrFuncCountApprox) IsValid() bool {
+	return true
+}
+
+func (a *AggrFuncCountApprox) AddDelta(delta interface{}) {
+	//not implemented
+}
+
+// null/missing are ignored. Values are hashed in their collatejson
+// encoding, same as AddDeltaRaw, so that the sketches of parts computed
+// on decoded and on encoded values can be merged.
+func (a *AggrFuncCountApprox) AddDeltaObj(delta value.Value) {
+
+	if isNullOrMissing(delta) {
+		return
+	}
+
+	if cap(a.buf) == 0 {
+		a.buf = make([]byte, 0, TEMP_BUF_SIZE)
+	}
+
+	key, err := codec.EncodeN1QLValue(delta, a.buf[:0])
+	if err == collatejson.ErrorOutputLen {
+		var b []byte
+		if b, err = delta.MarshalJSON(); err == nil {
+			a.buf = make([]byte, 0, len(b)*3)
+			key, err = codec.EncodeN1QLValue(delta, a.buf)
+		}
+	}
+	if err == nil {
+		a.hll.Add(key)
+	}
+
+}
+
+//null/missing are ignored.
+func (a *AggrFuncCountApprox) AddDeltaRaw(delta []byte) {
+
+	if isNullOrMissingRaw(delta) {
+		return
+	}
+
+	a.hll.Add(delta)
+
+}
+
+func (a *AggrFuncCountApprox) MergeState(state value.Value) error {
+
+	//empty COUNT_APPROX is returned as 0 when there are no rows
+	if isNullOrMissing(state) || isNumeric(state) {
+		return nil
+	}
+
+	s, ok := state.ActualForIndex().(string)
+	if !ok {
+		return ErrInvalidAggrState
+	}
+
+	hll, err := ParseHyperLogLog(s)
+	if err != nil {
+		return err
+	}
+	a.hll.Merge(hll)
+	return nil
+}
+
+func (a AggrFuncCountApprox) String() string {
+	return fmt.Sprintf("Type %v Value %v", a.typ, a.hll.Estimate())
+}
+
+// partialState returns the elements of an array state of length n,
+// or nil if the state is null.
+func partialState(state value.Value, n int) ([]interface{}, error) {
+
+	if isNullOrMissing(state) {
+		return nil, nil
+	}
+
+	vals, ok := state.ActualForIndex().([]interface{})
+	if !ok || len(vals) != n {
+		return nil, ErrInvalidAggrState
+	}
+	return vals, nil
+}
+
+func checkDistinctObj(lastObj *value.Value, newObj value.Value) bool {
+
+	if *lastObj != nil && newObj.EquivalentTo(*lastObj) {
+		return false
+	}
+	*lastObj = newObj
+
+	return true
+
+}
+
+func toFloat64(val interface{}) (float64, bool) {
+
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
 func isNullOrMissing(val value.Value) bool {
 
 	if val.Type() == value.MISSING || val.Type() == value.NULL {
@@ -0,0 +1,143 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+// mergeParts aggregates vals split in parts and merges the state of parts,
+// as it would be done for partial aggregates returned by partitions.
+func mergeParts(typ AggrFuncType, vals []interface{}, parts int) (AggrFunc, error) {
+	merged := NewPartialAggrFunc(typ, false, true)
+	for p := 0; p < parts; p++ {
+		part := NewPartialAggrFunc(typ, false, true)
+		for i := p; i < len(vals); i += parts {
+			part.AddDeltaObj(value.NewValue(vals[i]))
+		}
+
+		// state is sent to the client as json
+		b, err := json.Marshal(part.State())
+		if err != nil {
+			return nil, err
+		}
+		if err := merged.MergeState(value.NewValue(b)); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+func TestAggrFuncAvg(t *testing.T) {
+	vals := []interface{}{int64(1), int64(2), 3.5, nil, "a", int64(10)}
+
+	avg := NewAggrFunc(AGG_AVG, value.NewValue(vals[0]), false, true)
+	for _, v := range vals[1:] {
+		avg.AddDeltaObj(value.NewValue(v))
+	}
+	if avg.Value() != 4.125 {
+		t.Fatalf("failed AVG: expected 4.125 got %v", avg.Value())
+	}
+
+	merged, err := mergeParts(AGG_AVG, vals, 3)
+	if err != nil || merged.Value() != 4.125 {
+		t.Fatalf("failed AVG merge: expected 4.125 got %v %v", merged, err)
+	}
+
+	if v := NewPartialAggrFunc(AGG_AVG, false, true).Value(); v != nil {
+		t.Fatalf("failed AVG: expected nil for no values got %v", v)
+	}
+}
+
+func TestAggrFuncVariance(t *testing.T) {
+	var vals []interface{}
+	for i := 0; i < 100; i++ {
+		vals = append(vals, float64(i*i%17))
+	}
+
+	var sum, sumsq float64
+	for _, v := range vals {
+		sum += v.(float64)
+		sumsq += v.(float64) * v.(float64)
+	}
+	n := float64(len(vals))
+	expected := (sumsq - sum*sum/n) / (n - 1)
+
+	for _, typ := range []AggrFuncType{AGG_VARIANCE, AGG_STDDEV} {
+		want := expected
+		if typ == AGG_STDDEV {
+			want = math.Sqrt(expected)
+		}
+
+		for _, parts := range []int{1, 4} {
+			fn, err := mergeParts(typ, vals, parts)
+			if err != nil {
+				t.Fatalf("failed %v merge: %v", typ, err)
+			}
+			if got := fn.Value().(float64); math.Abs(got-want) > 1e-9 {
+				t.Fatalf("failed %v with %v parts: expected %v got %v", typ, parts, want, got)
+			}
+		}
+	}
+
+	single := NewAggrFunc(AGG_VARIANCE, value.NewValue(5), false, true)
+	if single.Value() != float64(0) {
+		t.Fatalf("failed VARIANCE: expected 0 for single value got %v", single.Value())
+	}
+}
+
+func TestAggrFuncArrayAgg(t *testing.T) {
+	vals := []interface{}{"a", int64(1), "a", nil, int64(1)}
+
+	fn, err := mergeParts(AGG_ARRAY_AGG, vals, 2)
+	if err != nil || len(fn.Value().([]interface{})) != len(vals) {
+		t.Fatalf("failed ARRAY_AGG merge: got %v %v", fn, err)
+	}
+
+	distinct := NewAggrFunc(AGG_ARRAY_AGG, value.NewValue(vals[0]), true, true)
+	for _, v := range vals[1:] {
+		distinct.AddDeltaObj(value.NewValue(v))
+	}
+	distinct.AddDeltaObj(value.NewMissingValue())
+	if got := distinct.Value().([]interface{}); len(got) != 3 {
+		t.Fatalf("failed ARRAY_AGG distinct: expected 3 values got %v", got)
+	}
+}
+
+func TestAggrFuncCountApprox(t *testing.T) {
+	var vals []interface{}
+	for i := 0; i < 20000; i++ {
+		vals = append(vals, fmt.Sprintf("doc-%v", i%10000))
+	}
+
+	fn, err := mergeParts(AGG_COUNT_APPROX, vals, 4)
+	if err != nil {
+		t.Fatalf("failed COUNT_APPROX merge: %v", err)
+	}
+	if got := fn.Value().(int64); got < 9500 || got > 10500 {
+		t.Fatalf("failed COUNT_APPROX: expected about 10000 got %v", got)
+	}
+
+	// Values and their collatejson encoding in index keys are counted alike
+	obj := NewPartialAggrFunc(AGG_COUNT_APPROX, false, true)
+	raw := NewPartialAggrFunc(AGG_COUNT_APPROX, false, false)
+	for _, v := range []string{`"abc"`, `10`, `2.5`, `[1,"a"]`, `{"a":true}`} {
+		obj.AddDeltaObj(value.NewValue([]byte(v)))
+		code, err := codec.EncodeN1QLValue(value.NewValue([]byte(v)), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.AddDeltaRaw(code)
+	}
+	if obj.State() != raw.State() {
+		t.Fatalf("failed COUNT_APPROX: sketch of values %v differs from sketch of encoded values %v",
+			obj.State(), raw.State())
+	}
+
+	if _, err := ParseHyperLogLog("abc"); err == nil {
+		t.Fatal("failed ParseHyperLogLog: expected error for invalid sketch")
+	}
+}
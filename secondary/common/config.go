@@ -322,6 +322,46 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"projector.dcp.record.keyspaces": ConfigValue{
+		"",
+		"comma separated list of keyspaceIds, or \"*\" for all keyspaces, " +
+			"whose DCP events are recorded to dcp.record.dir, " +
+			"changing this value does not affect existing feeds.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.record.dir": ConfigValue{
+		"",
+		"directory to record DCP events of dcp.record.keyspaces",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.record.segment_size": ConfigValue{
+		67108864,
+		"size in bytes after which a recording continues in a new log file",
+		67108864,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.record.max_segments": ConfigValue{
+		16,
+		"number of log files after which recording is stopped, " +
+			"0 records without a limit",
+		16,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dcp.replay.dir": ConfigValue{
+		"",
+		"directory of DCP recordings, keyspaces having a recording for " +
+			"the topic of a feed are fed from the latest recording instead " +
+			"of KV, changing this value does not affect existing feeds.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
 	// projector adminport parameters
 	"projector.adminport.name": ConfigValue{
 		"projector.adminport",
@@ -420,6 +460,17 @@ var SystemConfig = Config{
 		false,      // mutable
 		false,      // case-insensitive
 	},
+	"projector.dataport.compression": ConfigValue{
+		"none",
+		"compression proposed for payload sent from router to downstream " +
+			"client, one of none, snappy or gzip. Compression is negotiated " +
+			"when the connection is opened, and payload is sent uncompressed " +
+			"if the client does not agree to it. Enable only after all indexer " +
+			"nodes are upgraded, does not affect existing feeds.",
+		"none",
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"projector.dataport.maxPayload": ConfigValue{
 		1024 * 1024,
 		"maximum payload length, in bytes, for transmission data from " +
@@ -671,6 +722,24 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.settings.compression": ConfigValue{
+		"none",
+		"compression requested for scan responses, one of none, snappy or gzip. " +
+			"Servers that do not support compression respond uncompressed. " +
+			"A change applies to the connections opened after it.",
+		"none",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.multiplex": ConfigValue{
+		false,
+		"multiplex concurrent scans as streams on a single connection to each indexer, " +
+			"instead of a connection per scan. Servers that do not support multiplexing " +
+			"are scanned on regular connections.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"queryport.client.settings.poolSize": ConfigValue{
 		5000,
 		"number of simultaneous active connections in a pool",
@@ -798,6 +867,15 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"queryport.client.scan.max_aggr_merge_groups": ConfigValue{
+		1000000,
+		"maximum number of groups held in memory by the client, while merging partial aggregates " +
+			"of a partitioned index scattered across indexer nodes. Scan fails if there are more groups. " +
+			"0 specifies no limit.",
+		1000000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"queryport.client.log_level": ConfigValue{
 		"info", // keep in sync with index_settings_manager.erl
 		"GsiClient logging level",
@@ -1271,6 +1349,40 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.moi.useIncrementalPersistence": ConfigValue{
+		false,
+		"Persist on-disk snapshots as deltas of the previous snapshot, " +
+			"delta interleaving is not used if enabled. Items removed since " +
+			"the last on-disk snapshot are retained in memory till the next one",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.moi.incrementalPersistence.maxDeltas": ConfigValue{
+		4,
+		"Number of deltas of an on-disk snapshot beyond which they are " +
+			"compacted into a new base in the background",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.encryption.atRest.keyFile": ConfigValue{
+		"",
+		"Keyfile of the keys to encrypt MOI snapshots, persisted stats and " +
+			"cache files at rest. Encryption at rest is disabled if empty",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.encryption.atRest.migratePlaintext": ConfigValue{
+		false,
+		"Read files written in plaintext before encryption at rest was " +
+			"enabled, they are encrypted when written next. Files in " +
+			"plaintext are rejected once encryption at rest is enabled otherwise",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.moi.exposeItemCopy": ConfigValue{
 		false,
 		"Expose item copy from storage to GSI during scans and mutations",
@@ -2684,6 +2796,53 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.settings.statistics.sample_size": ConfigValue{
+		100000,
+		"maximum number of index entries read to compute the distinct count, " +
+			"size and min/max key of a statistics request. The distinct count " +
+			"and size of larger spans are extrapolated from the entries read.",
+		100000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan.slow_threshold": ConfigValue{
+		5000,
+		"Scans taking longer than this threshold, in milliseconds, are recorded " +
+			"in the slow scan log. 0 disables the slow scan log.",
+		5000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scan.slow_log_size": ConfigValue{
+		100,
+		"Number of most recent slow scans kept in the slow scan log",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.histogram.num_bins": ConfigValue{
+		100,
+		"Number of bins of the leading key histogram, if not specified by the client",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.histogram.refresh_percent": ConfigValue{
+		10,
+		"Leading key histogram of an index partition is rebuilt once the " +
+			"mutations indexed since it was built exceed this percentage of its entries",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.histogram.refresh_min_mutations": ConfigValue{
+		10000,
+		"Minimum number of mutations indexed before the leading key histogram " +
+			"of an index partition is rebuilt",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.eTagPeriod": ConfigValue{
 		240,
 		"Average ETag expiration period in seconds",
@@ -3253,6 +3412,30 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.pause_resume.blob_storage_part_size": ConfigValue{
+		16 * 1024 * 1024,
+		"Size in bytes of the parts of multipart uploads to S3 blob storage. " +
+			"Files no larger than this are uploaded in a single request",
+		16 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.pause_resume.blob_storage_max_retries": ConfigValue{
+		5,
+		"Number of retries of a failed request to S3 blob storage, with " +
+			"exponential backoff",
+		5,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.pause_resume.blob_storage_access_timeout": ConfigValue{
+		30,
+		"Timeout in seconds of the check for access to S3 blob storage " +
+			"before a pause or shard transfer is started",
+		30,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.pause_resume.test_action.enabled": ConfigValue{
 		false,
 		"flag to enable test path. used for testing purposes only",
@@ -3297,6 +3480,30 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.scrub.enabled": ConfigValue{
+		true,
+		"Verify the persisted data of memory optimized and forestdb indexes " +
+			"in the background, and rebuild the partitions found corrupted.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scrub.interval": ConfigValue{
+		86400,
+		"Interval in seconds between the start of two passes of the scrubber " +
+			"over all the index partitions.",
+		86400,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.scrub.rate_limit": ConfigValue{
+		4,
+		"Maximum rate in MB/sec at which the scrubber reads an index partition, " +
+			"0 for no limit.",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.build.background.disable": ConfigValue{
 		false,
 		"Disable background index build, except during upgrade",
@@ -3339,6 +3546,99 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.scan.admission.enabled": ConfigValue{
+		true,
+		"limit the number of concurrent scans of each priority class (interactive, batch, background)",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.interactive.concurrency": ConfigValue{
+		0,
+		"maximum number of concurrent interactive scans, 0 for 8 per cpu",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.batch.concurrency": ConfigValue{
+		0,
+		"maximum number of concurrent batch scans, 0 for 2 per cpu. Halved under memory pressure.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.background.concurrency": ConfigValue{
+		0,
+		"maximum number of concurrent background scans, 0 for 1 per 2 cpus. Halved under memory pressure.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.queue_size": ConfigValue{
+		1024,
+		"maximum number of scans of a priority class waiting to be admitted. Scans beyond it are rejected as busy.",
+		1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.queue_timeout": ConfigValue{
+		2000,
+		"time (ms) a scan waits to be admitted before it is rejected as busy, for the client to retry on a replica",
+		2000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.mem_pressure_percent": ConfigValue{
+		90,
+		"percent of memory quota used above which the concurrency of batch and background scans is halved",
+		90,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.admission.cpu_pressure_percent": ConfigValue{
+		90,
+		"percent of the cpus available to the indexer used above which the concurrency of batch and " +
+			"background scans is halved, 0 to disable",
+		90,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.cursor_snapshot_retention": ConfigValue{
+		60,
+		"time (sec) for which the snapshot of a scan returning a scan cursor is retained after its last use, " +
+			"so that the scan can be resumed against the same snapshot. 0 disables the retention.",
+		60,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.watch.max_watches": ConfigValue{
+		100,
+		"maximum number of range watches on the indexer. Watches beyond it are rejected.",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.watch.max_buffered_events": ConfigValue{
+		100000,
+		"maximum number of changes buffered for a range watch. A watch whose client falls behind is terminated.",
+		100000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.watch.max_keys": ConfigValue{
+		1000000,
+		"maximum number of entries in the range of a range watch",
+		1000000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.scan.watch.keepalive_interval": ConfigValue{
+		30000,
+		"time (ms) after which an idle range watch sends an empty response, to keep the client connection alive",
+		30000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.planner.timeout": ConfigValue{
 		300,
 		"timeout (sec) on planner",
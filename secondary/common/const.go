@@ -53,8 +53,18 @@ var ErrIndexNotReady = errors.New("Index not ready for serving queries")
 // ErrClientCancel when query client cancels an ongoing scan request.
 var ErrClientCancel = errors.New("Client requested cancel")
 
+// ErrAdminCancel when an ongoing scan request is cancelled by admin.
+var ErrAdminCancel = errors.New("Scan cancelled by admin")
+
 var ErrIndexerInBootstrap = errors.New("Indexer In Warmup State. Please retry the request later.")
 
+// ErrIndexerBusy when indexer cannot admit a scan request at its priority.
+var ErrIndexerBusy = errors.New("Indexer busy. Please retry the request on another replica.")
+
+// ErrIndexCorrupted when a partition of the index is found corrupted by the
+// scrubber, and is not yet rebuilt.
+var ErrIndexCorrupted = errors.New("Index partition corrupted. Please retry the request on another replica.")
+
 var ErrMarshalFailed = errors.New("json.Marshal failed")
 var ErrUnmarshalFailed = errors.New("json.Unmarshal failed")
 
@@ -0,0 +1,195 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Histogram is an equi-depth histogram of the leading key of an index,
+// used by the cost based optimizer to estimate the selectivity of spans.
+// Bin boundaries are collatejson encoded values of the leading key, in
+// ascending collation order irrespective of the index key order.
+type Histogram struct {
+	NumDocs int64           `json:"numDocs"` // number of index entries
+	Bins    []*HistogramBin `json:"bins,omitempty"`
+}
+
+// HistogramBin covers leading key values from Min to Max, both inclusive.
+// A value is never split across bins.
+type HistogramBin struct {
+	Min      []byte `json:"min"`
+	Max      []byte `json:"max"`
+	Size     int64  `json:"size"`     // number of index entries
+	Distinct int64  `json:"distinct"` // number of distinct leading key values
+}
+
+// HistogramBuilder builds a histogram of numBins bins from leading key
+// values added in index order, ascending or descending.
+type HistogramBuilder struct {
+	binSize int64
+	hist    *Histogram
+	last    []byte
+}
+
+// NewHistogramBuilder returns a builder of a histogram with numBins bins
+// over about total values.
+func NewHistogramBuilder(total int64, numBins int) *HistogramBuilder {
+	if numBins <= 0 {
+		numBins = 1
+	}
+
+	binSize := (total + int64(numBins) - 1) / int64(numBins)
+	if binSize <= 0 {
+		binSize = 1
+	}
+
+	return &HistogramBuilder{binSize: binSize, hist: &Histogram{}}
+}
+
+// Add adds a collatejson encoded value. A new bin is started once the
+// current bin is full, unless the value is equal to the previous value.
+func (b *HistogramBuilder) Add(val []byte) {
+	h := b.hist
+	n := len(h.Bins)
+	same := n != 0 && bytes.Equal(val, b.last)
+
+	if n == 0 || (h.Bins[n-1].Size >= b.binSize && !same) {
+		h.Bins = append(h.Bins, &HistogramBin{})
+		n++
+	}
+
+	bin := h.Bins[n-1]
+	if bin.Size == 0 || bytes.Compare(val, bin.Min) < 0 {
+		bin.Min = append([]byte(nil), val...)
+	}
+	if bin.Size == 0 || bytes.Compare(val, bin.Max) > 0 {
+		bin.Max = append([]byte(nil), val...)
+	}
+	if !same || bin.Size == 0 {
+		bin.Distinct++
+	}
+	bin.Size++
+	h.NumDocs++
+
+	b.last = append(b.last[:0], val...)
+}
+
+// Histogram returns the histogram of the values added so far, with bins
+// in ascending order.
+func (b *HistogramBuilder) Histogram() *Histogram {
+	sortHistogramBins(b.hist.Bins)
+	return b.hist
+}
+
+// MergeHistograms merges histograms of disjoint sets of index entries,
+// such as the partitions of an index, into a histogram of about numBins
+// bins with disjoint boundaries.
+//
+// Partitions can hold the same values, so bins of different histograms
+// can overlap. The bins are re-binned onto the boundaries (Min and Max) of
+// all the bins. Each boundary is the upper bound of a slot, which holds the
+// values greater than the previous boundary, and the size and distinct
+// count of a bin are spread evenly over the slots it overlaps. Slots are
+// then grouped into merged bins in order, so that a merged bin holds the
+// values greater than the Max of the previous merged bin, up to its Max.
+// Min of a merged bin is the smallest boundary in the bin. The distinct
+// count of a merged bin is an upper bound.
+func MergeHistograms(hists []*Histogram, numBins int) *Histogram {
+
+	merged := &Histogram{}
+
+	var bins []*HistogramBin
+	for _, h := range hists {
+		if h == nil {
+			continue
+		}
+		merged.NumDocs += h.NumDocs
+		bins = append(bins, h.Bins...)
+	}
+
+	if len(bins) == 0 {
+		return merged
+	}
+
+	if numBins <= 0 {
+		numBins = 1
+	}
+	binSize := (merged.NumDocs + int64(numBins) - 1) / int64(numBins)
+
+	// Distinct boundaries of all bins, in ascending order
+	bounds := make([][]byte, 0, 2*len(bins))
+	for _, bin := range bins {
+		bounds = append(bounds, bin.Min, bin.Max)
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return bytes.Compare(bounds[i], bounds[j]) < 0
+	})
+	n := 0
+	for _, b := range bounds {
+		if n == 0 || !bytes.Equal(b, bounds[n-1]) {
+			bounds[n] = b
+			n++
+		}
+	}
+	bounds = bounds[:n]
+
+	find := func(val []byte) int {
+		return sort.Search(len(bounds), func(i int) bool {
+			return bytes.Compare(bounds[i], val) >= 0
+		})
+	}
+
+	// Spread every bin over the slots from its Min to its Max
+	sizes := make([]int64, len(bounds))
+	distincts := make([]int64, len(bounds))
+	for _, bin := range bins {
+		first, last := find(bin.Min), find(bin.Max)
+		spreadHistogramBin(sizes[first:last+1], bin.Size)
+		spreadHistogramBin(distincts[first:last+1], bin.Distinct)
+	}
+
+	var curr *HistogramBin
+	for i, b := range bounds {
+		if sizes[i] == 0 && distincts[i] == 0 {
+			continue
+		}
+		if curr == nil || curr.Size >= binSize {
+			curr = &HistogramBin{Min: b}
+			merged.Bins = append(merged.Bins, curr)
+		}
+		curr.Max = b
+		curr.Size += sizes[i]
+		curr.Distinct += distincts[i]
+		if curr.Distinct > curr.Size {
+			curr.Distinct = curr.Size
+		}
+	}
+
+	return merged
+}
+
+// spreadHistogramBin spreads n evenly over slots, the remainder going to
+// the first slots.
+func spreadHistogramBin(slots []int64, n int64) {
+	k := int64(len(slots))
+	for i := range slots {
+		slots[i] += n / k
+		if int64(i) < n%k {
+			slots[i]++
+		}
+	}
+}
+
+func sortHistogramBins(bins []*HistogramBin) {
+	sort.SliceStable(bins, func(i, j int) bool {
+		return bytes.Compare(bins[i].Max, bins[j].Max) < 0
+	})
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	build := func(vals ...byte) *Histogram {
+		b := NewHistogramBuilder(int64(len(vals)), 3)
+		for _, v := range vals {
+			b.Add([]byte{v})
+		}
+		return b.Histogram()
+	}
+
+	check := func(h *Histogram, numDocs int64, expected ...HistogramBin) {
+		if h.NumDocs != numDocs || len(h.Bins) != len(expected) {
+			t.Fatalf("Expected %v docs in %v bins, received %v in %v", numDocs, len(expected), h.NumDocs, len(h.Bins))
+		}
+		for i, e := range expected {
+			b := h.Bins[i]
+			if !bytes.Equal(b.Min, e.Min) || !bytes.Equal(b.Max, e.Max) || b.Size != e.Size || b.Distinct != e.Distinct {
+				t.Fatalf("Expected bin %v %v, received %v", i, e, *b)
+			}
+		}
+	}
+
+	// Equal values are not split across bins
+	asc := build(1, 1, 1, 2, 3, 4, 5, 6, 6, 6)
+	check(asc, 10,
+		HistogramBin{Min: []byte{1}, Max: []byte{2}, Size: 4, Distinct: 2},
+		HistogramBin{Min: []byte{3}, Max: []byte{6}, Size: 6, Distinct: 4})
+
+	// Descending index order
+	desc := build(6, 6, 6, 5, 4, 3, 2, 1, 1, 1)
+	check(desc, 10,
+		HistogramBin{Min: []byte{1}, Max: []byte{4}, Size: 6, Distinct: 4},
+		HistogramBin{Min: []byte{5}, Max: []byte{6}, Size: 4, Distinct: 2})
+
+	// Overlapping bins are re-binned onto disjoint bins
+	merged := MergeHistograms([]*Histogram{asc, nil, desc}, 2)
+	check(merged, 20,
+		HistogramBin{Min: []byte{1}, Max: []byte{3}, Size: 11, Distinct: 6},
+		HistogramBin{Min: []byte{4}, Max: []byte{6}, Size: 9, Distinct: 6})
+
+	// Partitions of a hash partitioned index span the same values
+	var hists []*Histogram
+	for p := 0; p < 4; p++ {
+		var vals []byte
+		for v := 0; v < 100; v++ {
+			if v%4 == p {
+				vals = append(vals, byte(v))
+			}
+		}
+		hists = append(hists, build(vals...))
+	}
+	merged = MergeHistograms(hists, 4)
+	if merged.NumDocs != 100 || len(merged.Bins) < 3 || len(merged.Bins) > 5 {
+		t.Fatalf("Expected 100 docs in about 4 bins, received %v in %v", merged.NumDocs, len(merged.Bins))
+	}
+	var size int64
+	for i, b := range merged.Bins {
+		size += b.Size
+		if bytes.Compare(b.Min, b.Max) > 0 || b.Distinct > b.Size {
+			t.Errorf("Invalid bin %v %v", i, *b)
+		}
+		if i > 0 && bytes.Compare(merged.Bins[i-1].Max, b.Min) >= 0 {
+			t.Errorf("Bin %v %v overlaps bin %v", i, *b, *merged.Bins[i-1])
+		}
+	}
+	if size != 100 {
+		t.Errorf("Expected 100 entries in bins, received %v", size)
+	}
+
+	check(MergeHistograms(nil, 2), 0)
+}
@@ -0,0 +1,88 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hllPrecision is the number of hash bits used to pick a register. With
+// 2^12 registers the standard error of the estimate is about 1.6%.
+const hllPrecision = 12
+const hllRegisters = 1 << hllPrecision
+
+var ErrInvalidHyperLogLog = errors.New("Invalid HyperLogLog sketch")
+
+// HyperLogLog estimates the number of distinct items added to it. Sketches
+// built over disjoint or overlapping sets of items can be merged.
+type HyperLogLog struct {
+	registers []uint8
+}
+
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{registers: make([]uint8, hllRegisters)}
+}
+
+// ParseHyperLogLog parses a sketch encoded using String.
+func ParseHyperLogLog(s string) (*HyperLogLog, error) {
+	registers, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(registers) != hllRegisters {
+		return nil, ErrInvalidHyperLogLog
+	}
+	return &HyperLogLog{registers: registers}, nil
+}
+
+func (h *HyperLogLog) Add(item []byte) {
+	hash := xxhash.Sum64(item)
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(hllRegisters)
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// small range correction
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// String returns base64 encoding of the registers.
+func (h *HyperLogLog) String() string {
+	return base64.StdEncoding.EncodeToString(h.registers)
+}
@@ -41,6 +41,8 @@ type IndexStatistics interface {
 	MinKey() (SecondaryKey, error)
 	MaxKey() (SecondaryKey, error)
 	DistinctCount() (int64, error)
+	// Size is the approximate size, in bytes, of the entries.
+	Size() (int64, error)
 	Bins() ([]IndexStatistics, error)
 }
 
@@ -70,6 +72,10 @@ type HashScheme int
 
 const (
 	CRC32 HashScheme = iota
+	// XXHASH spreads skewed keys more evenly than CRC32.
+	XXHASH
+	// JUMP is jump consistent hashing over xxhash of the partition key.
+	JUMP
 )
 
 func (s HashScheme) String() string {
@@ -77,11 +83,31 @@ func (s HashScheme) String() string {
 	switch s {
 	case CRC32:
 		return "CRC32"
+	case XXHASH:
+		return "XXHASH"
+	case JUMP:
+		return "JUMP"
 	}
 
 	return "HASH_SCHEME_UNKNOWN"
 }
 
+// ParseHashScheme returns the hash scheme for the given (case insensitive)
+// name, as specified in the with clause of create index.
+func ParseHashScheme(name string) (HashScheme, error) {
+
+	switch strings.ToUpper(name) {
+	case "CRC32":
+		return CRC32, nil
+	case "XXHASH":
+		return XXHASH, nil
+	case "JUMP":
+		return JUMP, nil
+	}
+
+	return CRC32, fmt.Errorf("Unknown hash scheme %v", name)
+}
+
 type IndexState int
 
 const (
@@ -190,6 +216,15 @@ const (
 	// the user session has observed so far. Note that this consistency option
 	// internal to indexer and not used in clients
 	SessionConsistencyStrict
+
+	// BoundedStalenessConsistency indexer would return data that is at
+	// most a given staleness behind the mutations received by the indexer
+	// when the scan arrived. Unlike SessionConsistency, it does not need
+	// the KV timestamp, and so the staleness is not bounded against KV:
+	// mutations not yet received from the projector are not included. The
+	// staleness is passed to GsiClient scans as scanParams["staleness"], a
+	// time.Duration.
+	BoundedStalenessConsistency
 )
 
 func (cons Consistency) String() string {
@@ -202,6 +237,8 @@ func (cons Consistency) String() string {
 		return "QUERY_CONSISTENCY"
 	case SessionConsistencyStrict:
 		return "SESSION_CONSISTENCY_STRICT"
+	case BoundedStalenessConsistency:
+		return "BOUNDED_STALENESS_CONSISTENCY"
 	default:
 		return "UNKNOWN_CONSISTENCY"
 	}
@@ -242,6 +279,24 @@ type IndexDefn struct {
 	IndexMissingLeadingKey bool       `json:"indexMissingLeadingKey,omitempty"`
 	IsPartnKeyDocId        bool       `json:"isPartnKeyDocId,omitempty"`
 
+	// PartitionRanges are the JSON encoded boundaries of a RANGE
+	// partitioned index, in ascending collation order. Boundary i is the
+	// exclusive upper bound of partition i+1.
+	PartitionRanges []string `json:"partitionRanges,omitempty"`
+
+	// VectorMeta is set for a vector index, which can be used for
+	// nearest-neighbour scans on its vector key.
+	VectorMeta *VectorMetadata `json:"vectorMeta,omitempty"`
+
+	// RebuildOf is set for the shadow index of ALTER INDEX REBUILD. The
+	// shadow index has the same name as the index it rebuilds, and
+	// replaces it once the shadow index is built.
+	RebuildOf IndexDefnId `json:"rebuildOf,omitempty"`
+
+	// Aggregates are the group aggregates precomputed by the indexer,
+	// created with CreateAggregate.
+	Aggregates []*IndexAggregate `json:"aggregates,omitempty"`
+
 	// Sizing info
 	NumDoc        uint64  `json:"numDoc,omitempty"`
 	SecKeySize    uint64  `json:"secKeySize,omitempty"`
@@ -332,6 +387,18 @@ func (idx IndexDefn) String() string {
 	fmt.Fprintf(&str, "\n\t\tPartitionScheme: %v ", idx.PartitionScheme)
 	fmt.Fprintf(&str, "\n\t\tHashScheme: %v ", idx.HashScheme.String())
 	fmt.Fprintf(&str, "PartitionKeys: %v ", idx.PartitionKeys)
+	if len(idx.PartitionRanges) != 0 {
+		fmt.Fprintf(&str, "PartitionRanges: %v ", logging.TagUD(idx.PartitionRanges))
+	}
+	if idx.VectorMeta != nil {
+		fmt.Fprintf(&str, "\n\t\tVectorMeta: %v ", idx.VectorMeta)
+	}
+	if idx.RebuildOf != 0 {
+		fmt.Fprintf(&str, "RebuildOf: %v ", idx.RebuildOf)
+	}
+	for _, ia := range idx.Aggregates {
+		fmt.Fprintf(&str, "\n\t\tAggregate: %v ", ia)
+	}
 	fmt.Fprintf(&str, "WhereExpr: %v ", logging.TagUD(idx.WhereExpr))
 	fmt.Fprintf(&str, "RetainDeletedXATTR: %v ", idx.RetainDeletedXATTR)
 	fmt.Fprintf(&str, "\n\t\tAlternateShardIds: %v ", idx.AlternateShardIds)
@@ -358,6 +425,10 @@ func (idx IndexDefn) Clone() *IndexDefn {
 		ExprType:               idx.ExprType,
 		PartitionScheme:        idx.PartitionScheme,
 		PartitionKeys:          idx.PartitionKeys,
+		PartitionRanges:        idx.PartitionRanges,
+		VectorMeta:             idx.VectorMeta.Clone(),
+		RebuildOf:              idx.RebuildOf,
+		Aggregates:             CloneIndexAggregates(idx.Aggregates),
 		HashScheme:             idx.HashScheme,
 		WhereExpr:              idx.WhereExpr,
 		Deferred:               idx.Deferred,
@@ -813,6 +884,20 @@ func IsEquivalentIndex(d1, d2 *IndexDefn) bool {
 		}
 	}
 
+	if len(d1.PartitionRanges) != len(d2.PartitionRanges) {
+		return false
+	}
+
+	for i, s1 := range d1.PartitionRanges {
+		if s1 != d2.PartitionRanges[i] {
+			return false
+		}
+	}
+
+	if !d1.VectorMeta.Equals(d2.VectorMeta) {
+		return false
+	}
+
 	if len(d1.Desc) != len(d2.Desc) {
 		return false
 	}
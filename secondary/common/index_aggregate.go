@@ -0,0 +1,117 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndexAggregate is a named group by / aggregate over index keys, that is
+// precomputed by the indexer and kept up to date as mutations are applied
+// to the index. A group aggregate scan with the same name and shape is
+// served from the precomputed aggregate instead of scanning the index.
+type IndexAggregate struct {
+	Name  string              `json:"name"`
+	Group []int32             `json:"group,omitempty"` // positions of group keys
+	Aggrs []IndexAggregateKey `json:"aggrs,omitempty"`
+}
+
+// IndexAggregateKey is an aggregate of the index key at KeyPos.
+type IndexAggregateKey struct {
+	AggrFunc AggrFuncType `json:"aggrFunc"`
+	KeyPos   int32        `json:"keyPos"`
+}
+
+// IsMaterializable returns true if aggregate typ can be precomputed, i.e.
+// it can be incrementally updated as index entries are added and removed.
+func (a AggrFuncType) IsMaterializable() bool {
+
+	switch a {
+	case AGG_MIN, AGG_MAX, AGG_SUM, AGG_COUNT, AGG_COUNTN, AGG_AVG:
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks the aggregate against an index with numKeys keys.
+func (ia *IndexAggregate) Validate(numKeys int) error {
+
+	if ia.Name == "" {
+		return fmt.Errorf("Index aggregate name is not specified")
+	}
+
+	if len(ia.Aggrs) == 0 {
+		return fmt.Errorf("Index aggregate %v has no aggregates", ia.Name)
+	}
+
+	for _, pos := range ia.Group {
+		if pos < 0 || int(pos) >= numKeys {
+			return fmt.Errorf("Index aggregate %v group key %v is not an index key", ia.Name, pos)
+		}
+	}
+
+	for _, ak := range ia.Aggrs {
+		if !ak.AggrFunc.IsMaterializable() {
+			return fmt.Errorf("Index aggregate %v does not support %v", ia.Name, ak.AggrFunc)
+		}
+		if ak.KeyPos < 0 || int(ak.KeyPos) >= numKeys {
+			return fmt.Errorf("Index aggregate %v aggregate key %v is not an index key", ia.Name, ak.KeyPos)
+		}
+	}
+
+	return nil
+}
+
+func (ia *IndexAggregate) Clone() *IndexAggregate {
+	if ia == nil {
+		return nil
+	}
+	return &IndexAggregate{
+		Name:  ia.Name,
+		Group: append([]int32(nil), ia.Group...),
+		Aggrs: append([]IndexAggregateKey(nil), ia.Aggrs...),
+	}
+}
+
+func (ia *IndexAggregate) String() string {
+	if ia == nil {
+		return ""
+	}
+
+	aggrs := make([]string, len(ia.Aggrs))
+	for i, ak := range ia.Aggrs {
+		aggrs[i] = fmt.Sprintf("%v(%v)", ak.AggrFunc, ak.KeyPos)
+	}
+	return fmt.Sprintf("Name: %v Group: %v Aggrs: [%v]", ia.Name, ia.Group, strings.Join(aggrs, " "))
+}
+
+// CloneIndexAggregates returns a deep copy of aggrs.
+func CloneIndexAggregates(aggrs []*IndexAggregate) []*IndexAggregate {
+	if aggrs == nil {
+		return nil
+	}
+
+	clone := make([]*IndexAggregate, len(aggrs))
+	for i, ia := range aggrs {
+		clone[i] = ia.Clone()
+	}
+	return clone
+}
+
+// FindIndexAggregate returns the aggregate of the given name in aggrs.
+func FindIndexAggregate(aggrs []*IndexAggregate, name string) *IndexAggregate {
+	for _, ia := range aggrs {
+		if ia.Name == name {
+			return ia
+		}
+	}
+	return nil
+}
@@ -9,9 +9,14 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
 	"hash/crc32"
+	"sort"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/couchbase/indexing/secondary/logging"
+	qvalue "github.com/couchbase/query/value"
 )
 
 //KeyPartitionDefn defines a key based partition in terms of topology
@@ -50,10 +55,14 @@ type KeyPartitionContainer struct {
 	NumPartitions int
 	scheme        PartitionScheme
 	hash          HashScheme
+	ranges        []string
 }
 
-//NewKeyPartitionContainer initializes a new KeyPartitionContainer and returns
-func NewKeyPartitionContainer(numPartitions int, scheme PartitionScheme, hash HashScheme) PartitionContainer {
+//NewKeyPartitionContainer initializes a new KeyPartitionContainer and returns.
+//ranges are the partition boundaries of a RANGE partitioned index and are
+//ignored for other schemes.
+func NewKeyPartitionContainer(numPartitions int, scheme PartitionScheme, hash HashScheme,
+	ranges []string) PartitionContainer {
 
 	if !IsPartitioned(scheme) {
 		numPartitions = 1
@@ -64,6 +73,7 @@ func NewKeyPartitionContainer(numPartitions int, scheme PartitionScheme, hash Ha
 		NumPartitions: numPartitions,
 		scheme:        scheme,
 		hash:          hash,
+		ranges:        ranges,
 	}
 	return kpc
 
@@ -100,6 +110,8 @@ func (pc *KeyPartitionContainer) GetPartitionIdByPartitionKey(key PartitionKey)
 
 	if pc.scheme == KEY {
 		return HashKeyPartition(key, pc.NumPartitions, pc.hash)
+	} else if pc.scheme == RANGE {
+		return RangeKeyPartition(key, pc.ranges)
 	}
 
 	return PartitionId(NON_PARTITION_ID)
@@ -165,7 +177,7 @@ func (pc *KeyPartitionContainer) GetNumPartitions() int {
 }
 
 func (pc *KeyPartitionContainer) Clone() PartitionContainer {
-	clone := NewKeyPartitionContainer(pc.NumPartitions, pc.scheme, pc.hash)
+	clone := NewKeyPartitionContainer(pc.NumPartitions, pc.scheme, pc.hash, pc.ranges)
 
 	for id, partition := range pc.PartitionMap {
 		clone.AddPartition(id, partition)
@@ -177,7 +189,82 @@ func (pc *KeyPartitionContainer) Clone() PartitionContainer {
 func HashKeyPartition(key []byte, numPartitions int, scheme HashScheme) PartitionId {
 
 	//run hash function on partition key and return partition id
+	switch scheme {
+	case XXHASH:
+		hash := xxhash.Sum64(key)
+		return PartitionId(int(hash%uint64(numPartitions)) + 1)
+
+	case JUMP:
+		hash := xxhash.Sum64(key)
+		return PartitionId(jumpHash(hash, numPartitions) + 1)
+	}
+
 	hash := crc32.ChecksumIEEE([]byte(key))
 	partnId := (int(hash) % numPartitions) + 1
 	return PartitionId(partnId)
 }
+
+//jumpHash implements jump consistent hash (Lamping and Veach), which maps
+//the key to a bucket in [0, numBuckets).  Growing the number of buckets
+//only moves the keys that belong to the new buckets.
+func jumpHash(key uint64, numBuckets int) int {
+
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+//RangeKeyPartition returns the partition owning the partition key of a RANGE
+//partitioned index. ranges[i] is the exclusive upper bound of partition i+1,
+//so a key below ranges[0] (including a missing key) belongs to partition 1
+//and a key at or above the last boundary belongs to the last partition.
+//The partition key is encoded by the projector as a JSON array of the values
+//of the partition expressions, and a RANGE partitioned index has a single
+//partition expression. Its value is unwrapped from the array, to be compared
+//with the boundaries and the scan spans, which are the values themselves.
+func RangeKeyPartition(key []byte, ranges []string) PartitionId {
+
+	val := qvalue.MISSING_VALUE
+	if len(key) != 0 {
+		if v, ok := qvalue.NewValue(key).Index(0); ok {
+			val = v
+		}
+	}
+
+	return RangeValuePartition(val, ranges)
+}
+
+//RangeValuePartition is same as RangeKeyPartition, for a partition key that
+//is already parsed.
+func RangeValuePartition(val qvalue.Value, ranges []string) PartitionId {
+
+	i := sort.Search(len(ranges), func(i int) bool {
+		return val.Collate(qvalue.NewValue([]byte(ranges[i]))) < 0
+	})
+	return PartitionId(i + 1)
+}
+
+//ValidatePartitionRanges checks that the boundaries of a RANGE partitioned
+//index are valid JSON values in strictly ascending collation order.
+func ValidatePartitionRanges(ranges []string) error {
+
+	var prev qvalue.Value
+	for i, r := range ranges {
+		var v interface{}
+		if err := json.Unmarshal([]byte(r), &v); err != nil {
+			return fmt.Errorf("Invalid partition range boundary %v: %v", r, err)
+		}
+
+		curr := qvalue.NewValue(v)
+		if i != 0 && prev.Collate(curr) >= 0 {
+			return fmt.Errorf("Partition range boundaries must be in ascending order (%v >= %v)", ranges[i-1], r)
+		}
+		prev = curr
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRangeKeyPartition(t *testing.T) {
+	ranges := []string{`10`, `20`, `"a"`}
+
+	// Partition keys are JSON arrays of the value of the partition expression,
+	// as encoded by the projector
+	testcases := []struct {
+		key     string
+		partnId PartitionId
+	}{
+		{``, 1},
+		{`[null]`, 1},
+		{`[5]`, 1},
+		{`[10]`, 2},
+		{`[19.5]`, 2},
+		{`[20]`, 3},
+		{`["Z"]`, 3},
+		{`["a"]`, 4},
+		{`[[1]]`, 4},
+		{`[{"a":1}]`, 4},
+	}
+
+	pc := NewKeyPartitionContainer(len(ranges)+1, RANGE, CRC32, ranges)
+	for _, tc := range testcases {
+		if partnId := RangeKeyPartition([]byte(tc.key), ranges); partnId != tc.partnId {
+			t.Fatalf("failed RangeKeyPartition for key %v: expected %v got %v", tc.key, tc.partnId, partnId)
+		}
+		if partnId := pc.GetPartitionIdByPartitionKey(PartitionKey(tc.key)); partnId != tc.partnId {
+			t.Fatalf("failed GetPartitionIdByPartitionKey for key %v: expected %v got %v", tc.key, tc.partnId, partnId)
+		}
+	}
+}
+
+func TestValidatePartitionRanges(t *testing.T) {
+	if err := ValidatePartitionRanges([]string{`10`, `20`, `"a"`}); err != nil {
+		t.Fatalf("failed ValidatePartitionRanges: %v", err)
+	}
+	if err := ValidatePartitionRanges([]string{`20`, `10`}); err == nil {
+		t.Fatal("failed ValidatePartitionRanges: expected error for descending boundaries")
+	}
+	if err := ValidatePartitionRanges([]string{`10`, `10`}); err == nil {
+		t.Fatal("failed ValidatePartitionRanges: expected error for duplicate boundaries")
+	}
+	if err := ValidatePartitionRanges([]string{`abc`}); err == nil {
+		t.Fatal("failed ValidatePartitionRanges: expected error for invalid json")
+	}
+}
+
+func TestHashKeyPartition(t *testing.T) {
+	numPartitions := 8
+
+	for _, scheme := range []HashScheme{CRC32, XXHASH, JUMP} {
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("%v", i))
+			partnId := HashKeyPartition(key, numPartitions, scheme)
+			if partnId < 1 || int(partnId) > numPartitions {
+				t.Fatalf("failed HashKeyPartition(%v): partition %v out of range", scheme, partnId)
+			}
+			if partnId != HashKeyPartition(key, numPartitions, scheme) {
+				t.Fatalf("failed HashKeyPartition(%v): partition is not deterministic", scheme)
+			}
+		}
+	}
+
+	// jump hash only moves keys to the new partition when growing
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("%v", i))
+		before := HashKeyPartition(key, numPartitions, JUMP)
+		after := HashKeyPartition(key, numPartitions+1, JUMP)
+		if before != after && int(after) != numPartitions+1 {
+			t.Fatalf("failed HashKeyPartition(JUMP): key %s moved from %v to %v", key, before, after)
+		}
+	}
+}
+
+func TestParseHashScheme(t *testing.T) {
+	for _, scheme := range []HashScheme{CRC32, XXHASH, JUMP} {
+		if parsed, err := ParseHashScheme(scheme.String()); err != nil || parsed != scheme {
+			t.Fatalf("failed ParseHashScheme(%v): %v %v", scheme, parsed, err)
+		}
+	}
+	if _, err := ParseHashScheme("md5"); err == nil {
+		t.Fatal("failed ParseHashScheme: expected error for unknown scheme")
+	}
+}
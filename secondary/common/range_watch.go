@@ -0,0 +1,35 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+// WatchEventType is the type of change to an entry in the key range of a
+// range watch.
+type WatchEventType uint32
+
+const (
+	// WatchInsert when a document enters the range.
+	WatchInsert WatchEventType = iota + 1
+	// WatchUpdate when the key of a document changes within the range.
+	WatchUpdate
+	// WatchDelete when a document leaves the range, or is deleted.
+	WatchDelete
+)
+
+func (t WatchEventType) String() string {
+	switch t {
+	case WatchInsert:
+		return "insert"
+	case WatchUpdate:
+		return "update"
+	case WatchDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
@@ -92,6 +92,46 @@ func (k ScanResultKey) GetRaw() (interface{}, error) {
 	}
 }
 
+// NewScanResultKey returns ScanResultKey for decoded values, encoded
+// in dataEncFmt.
+func NewScanResultKey(vals []qvalue.Value, dataEncFmt DataEncodingFormat) (ScanResultKey, error) {
+
+	key := ScanResultKey{DataEncFmt: dataEncFmt}
+
+	if dataEncFmt == DATA_ENC_COLLATEJSON {
+		arr := make([]interface{}, len(vals))
+		for i, v := range vals {
+			arr[i] = v
+		}
+		val := qvalue.NewValue(arr)
+
+		code, err := codec.EncodeN1QLValue(val, make([]byte, 0, TEMP_BUF_SIZE))
+		if err == collatejson.ErrorOutputLen {
+			var b []byte
+			if b, err = val.MarshalJSON(); err == nil {
+				code, err = codec.EncodeN1QLValue(val, make([]byte, 0, len(b)*3))
+			}
+		}
+		if err != nil {
+			return key, err
+		}
+		key.Skeycjson = code
+	} else if dataEncFmt == DATA_ENC_JSON {
+		key.Skey = make(SecondaryKey, len(vals))
+		for i, v := range vals {
+			if v.Type() == qvalue.MISSING {
+				key.Skey[i] = string(collatejson.MissingLiteral)
+			} else {
+				key.Skey[i] = v.ActualForIndex()
+			}
+		}
+	} else {
+		return key, ErrUnexpectedDataEncFmt
+	}
+
+	return key, nil
+}
+
 // --------------------------
 // ScanResultEntries
 // --------------------------
@@ -0,0 +1,72 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ScanTrace is the breakdown of time spent by the indexer(s) serving a
+// scan request, returned when the scan is requested with trace enabled.
+// For a scan served by multiple indexer nodes, time of each phase is the
+// sum over all nodes.
+type ScanTrace struct {
+	Wait      time.Duration // waiting for snapshot / scan consistency
+	Iterate   time.Duration // storage iteration
+	Filter    time.Duration // filtering and projection of rows
+	Aggregate time.Duration // group aggregation
+	Send      time.Duration // writing rows to the client
+	Total     time.Duration
+
+	Partitions []PartitionScanTrace
+}
+
+// PartitionScanTrace is the storage iteration time of one partition.
+type PartitionScanTrace struct {
+	PartitionId PartitionId
+	Iterate     time.Duration
+	RowsScanned uint64
+}
+
+// Merge adds trace of another indexer node serving the same request.
+func (t *ScanTrace) Merge(o *ScanTrace) {
+	if o == nil {
+		return
+	}
+
+	t.Wait += o.Wait
+	t.Iterate += o.Iterate
+	t.Filter += o.Filter
+	t.Aggregate += o.Aggregate
+	t.Send += o.Send
+	t.Total += o.Total
+	t.Partitions = append(t.Partitions, o.Partitions...)
+
+	sort.Slice(t.Partitions, func(i, j int) bool {
+		return t.Partitions[i].PartitionId < t.Partitions[j].PartitionId
+	})
+}
+
+func (t *ScanTrace) String() string {
+	if t == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "wait: %v iterate: %v filter: %v aggregate: %v send: %v total: %v",
+		t.Wait, t.Iterate, t.Filter, t.Aggregate, t.Send, t.Total)
+	for _, p := range t.Partitions {
+		fmt.Fprintf(&sb, "\n  partition %v iterate: %v rowsScanned: %v",
+			p.PartitionId, p.Iterate, p.RowsScanned)
+	}
+	return sb.String()
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanTraceMerge(t *testing.T) {
+	var trace ScanTrace
+
+	trace.Merge(&ScanTrace{
+		Wait:       time.Millisecond,
+		Iterate:    2 * time.Millisecond,
+		Total:      5 * time.Millisecond,
+		Partitions: []PartitionScanTrace{{PartitionId: 3, Iterate: 2 * time.Millisecond, RowsScanned: 10}},
+	})
+	trace.Merge(nil)
+	trace.Merge(&ScanTrace{
+		Wait:       time.Millisecond,
+		Send:       time.Millisecond,
+		Total:      3 * time.Millisecond,
+		Partitions: []PartitionScanTrace{{PartitionId: 1, RowsScanned: 5}},
+	})
+
+	if trace.Wait != 2*time.Millisecond || trace.Send != time.Millisecond || trace.Total != 8*time.Millisecond {
+		t.Fatalf("Unexpected merged trace %v", &trace)
+	}
+	if len(trace.Partitions) != 2 || trace.Partitions[0].PartitionId != 1 || trace.Partitions[1].RowsScanned != 10 {
+		t.Fatalf("Unexpected merged partitions %v", trace.Partitions)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrInvalidVector = errors.New("Vector must be an array of numbers of the index dimension")
+
+// VectorSimilarity is the distance metric used to compare vectors.
+type VectorSimilarity string
+
+const (
+	// L2 is the squared euclidean distance
+	L2 VectorSimilarity = "L2"
+	// COSINE is one minus the cosine similarity
+	COSINE VectorSimilarity = "COSINE"
+	// DOT is the negated dot product
+	DOT VectorSimilarity = "DOT"
+)
+
+// ParseVectorSimilarity returns the similarity for the given (case
+// insensitive) name, as specified in the with clause of create index.
+func ParseVectorSimilarity(name string) (VectorSimilarity, error) {
+
+	switch s := VectorSimilarity(strings.ToUpper(name)); s {
+	case L2, COSINE, DOT:
+		return s, nil
+	}
+
+	return L2, fmt.Errorf("Unknown vector similarity %v", name)
+}
+
+// VectorMetadata describes the vector key of a vector index. The vector
+// key is a fixed-dimension array of numbers, other index keys are scalar
+// keys that can be used to pre-filter nearest-neighbour scans.
+type VectorMetadata struct {
+	KeyPos     int              `json:"keyPos"`
+	Dimension  int              `json:"dimension,omitempty"`
+	Similarity VectorSimilarity `json:"similarity,omitempty"`
+
+	// Number of IVF lists the vectors are partitioned into
+	NumCentroids int `json:"numCentroids,omitempty"`
+}
+
+func (m *VectorMetadata) Clone() *VectorMetadata {
+	if m == nil {
+		return nil
+	}
+	clone := *m
+	return &clone
+}
+
+func (m *VectorMetadata) Equals(o *VectorMetadata) bool {
+	if m == nil || o == nil {
+		return m == o
+	}
+	return *m == *o
+}
+
+func (m *VectorMetadata) String() string {
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("KeyPos: %v Dimension: %v Similarity: %v NumCentroids: %v",
+		m.KeyPos, m.Dimension, m.Similarity, m.NumCentroids)
+}
+
+// VectorDistance returns the distance between a and b for similarity.
+// Smaller distance means more similar vectors, for all similarities.
+func VectorDistance(similarity VectorSimilarity, a, b []float32) float32 {
+
+	switch similarity {
+	case COSINE:
+		var dot, na, nb float64
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+			na += float64(a[i]) * float64(a[i])
+			nb += float64(b[i]) * float64(b[i])
+		}
+		if na == 0 || nb == 0 {
+			return 1
+		}
+		return float32(1 - dot/math.Sqrt(na*nb))
+
+	case DOT:
+		var dot float64
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+		}
+		return float32(-dot)
+
+	default:
+		var sum float64
+		for i := range a {
+			d := float64(a[i]) - float64(b[i])
+			sum += d * d
+		}
+		return float32(sum)
+	}
+}
+
+// ToVector converts decoded json array val to a vector of dimension.
+func ToVector(val interface{}, dimension int) ([]float32, error) {
+
+	arr, ok := val.([]interface{})
+	if !ok || len(arr) != dimension {
+		return nil, ErrInvalidVector
+	}
+
+	vec := make([]float32, dimension)
+	for i, v := range arr {
+		switch n := v.(type) {
+		case float64:
+			vec[i] = float32(n)
+		case int64:
+			vec[i] = float32(n)
+		default:
+			return nil, ErrInvalidVector
+		}
+	}
+
+	return vec, nil
+}
@@ -195,8 +195,15 @@ func NewRouterEndpoint(
 
 	endpoint.stats.Init()
 	endpoint.stats.endpCh = endpoint.ch
-	// TODO: add configuration params for transport flags.
 	flags := transport.TransportFlag(0).SetProtobuf()
+	compression := transport.CompressionNone
+	if cv, ok := config["compression"]; ok {
+		var err error
+		if compression, err = transport.ParseCompression(cv.String()); err != nil {
+			logging.Errorf("ENDP[<-%v #%v] invalid compression %v\n", raddr, topic, cv.String())
+			return nil, err
+		}
+	}
 	maxPayload := config["maxPayload"].Int()
 	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
 	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
@@ -223,6 +230,20 @@ func NewRouterEndpoint(
 		return nil, err
 	}
 
+	// Payload is compressed only if the server agrees to it
+	if compression != transport.CompressionNone {
+		if compression, err = endpoint.doHelo(conn, compression); err != nil {
+			logging.Errorf("%v doHelo error %v", endpoint.logPrefix, err)
+			conn.Close()
+			return nil, err
+		}
+		if compression != transport.CompressionNone {
+			endpoint.pkt = transport.NewTransportPacket(maxPayload, flags.SetCompression(compression))
+			endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+			endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+		}
+	}
+
 	endpoint.conn = conn
 
 	endpoint.bufferTm *= time.Millisecond
@@ -345,6 +366,47 @@ func (endpoint *RouterEndpoint) doAuth(conn net.Conn) error {
 	return nil
 }
 
+// heloTimeout is the time to wait for server to respond to HeloRequest.
+const heloTimeout = 10 * time.Second
+
+// doHelo proposes payload compression to the server, and returns the
+// compression the server agrees to.
+func (endpoint *RouterEndpoint) doHelo(conn net.Conn, compression byte) (byte, error) {
+	heloReq := &protobuf.HeloRequest{
+		Compressions: []uint32{uint32(compression)},
+	}
+
+	if err := endpoint.pkt.Send(conn, heloReq); err != nil {
+		logging.Errorf("%v doHelo pkt.Send returns error %v", endpoint.logPrefix, err)
+		return transport.CompressionNone, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(heloTimeout)); err != nil {
+		logging.Warnf("%v doHelo error %v in SetReadDeadline", endpoint.logPrefix, err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	payload, err := endpoint.pkt.Receive(conn)
+	if err != nil {
+		logging.Errorf("%v doHelo pkt.Receive returns error %v", endpoint.logPrefix, err)
+		return transport.CompressionNone, err
+	}
+
+	heloResp, ok := payload.(*protobuf.HeloResponse)
+	if !ok {
+		return transport.CompressionNone, ErrorPayload
+	}
+
+	compression = byte(heloResp.GetCompression())
+	if !transport.IsSupportedCompression(compression) {
+		return transport.CompressionNone, transport.ErrorCompressionUnknown
+	}
+
+	logging.Infof("%v doHelo negotiated compression %v", endpoint.logPrefix,
+		transport.CompressionName(compression))
+	return compression, nil
+}
+
 // ResetConfig synchronous call.
 func (endpoint *RouterEndpoint) ResetConfig(config c.Config) error {
 	respch := make(chan []interface{}, 1)
@@ -80,6 +80,16 @@ func protobufEncode(payload interface{}) (data []byte, err error) {
 			User: proto.String(*val.User),
 			Pass: proto.String(*val.Pass),
 		}
+
+	case *protobuf.HeloRequest:
+		pl.HeloRequest = &protobuf.HeloRequest{
+			Compressions: val.GetCompressions(),
+		}
+
+	case *protobuf.HeloResponse:
+		pl.HeloResponse = &protobuf.HeloResponse{
+			Compression: proto.Uint32(val.GetCompression()),
+		}
 	}
 
 	if err == nil {
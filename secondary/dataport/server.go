@@ -70,6 +70,7 @@ import (
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
 	"github.com/couchbase/indexing/secondary/security"
 	"github.com/couchbase/indexing/secondary/transport"
+	"github.com/golang/protobuf/proto"
 )
 
 // Error codes
@@ -851,6 +852,14 @@ loop:
 				}
 			}
 
+		} else if heloReq, ok := payload.(*protobuf.HeloRequest); ok {
+			if err := doHelo(prefix, conn, maxPayload, heloReq); err != nil {
+				msg.cmd, msg.err = serverCmdError, err
+				datach <- []interface{}{msg}
+				logging.Errorf("%v worker %q exit: %v\n", prefix, msg.raddr, err)
+				break loop
+			}
+
 		} else {
 			msg.cmd, msg.err = serverCmdError, ErrorPayload
 			datach <- []interface{}{msg}
@@ -861,6 +870,29 @@ loop:
 	nc.active = false
 }
 
+// doHelo responds to HeloRequest with the first compression proposed by
+// the endpoint that this server can decompress.
+func doHelo(prefix string, conn net.Conn, maxPayload int, heloReq *protobuf.HeloRequest) error {
+	compression := transport.CompressionNone
+	for _, proposed := range heloReq.GetCompressions() {
+		if proposed <= 0xFF && transport.IsSupportedCompression(byte(proposed)) {
+			compression = byte(proposed)
+			break
+		}
+	}
+
+	heloResp := &protobuf.HeloResponse{
+		Compression: proto.Uint32(uint32(compression)),
+	}
+	if err := newTransportPkt(maxPayload).Send(conn, heloResp); err != nil {
+		return err
+	}
+
+	logging.Infof("%v connection %q negotiated compression %v", prefix,
+		conn.RemoteAddr().String(), transport.CompressionName(compression))
+	return nil
+}
+
 func vbucketSchedule(vb *protobuf.VbKeyVersions) (s, e *protobuf.KeyVersions) {
 	for _, kv := range vb.GetKvs() {
 		commands := kv.GetCommands()
@@ -37,6 +37,75 @@ func TestPktKeyVersions(t *testing.T) {
 	}
 }
 
+func TestPktKeyVersionsCompressed(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	for _, compression := range []byte{transport.CompressionSnappy, transport.CompressionGzip} {
+		tc := newTestConnection()
+		tc.reset()
+		flags := transport.TransportFlag(0).SetProtobuf().SetCompression(compression)
+		spkt := transport.NewTransportPacket(1000*1024, flags)
+		spkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+		// receiver learns compression from packet flags
+		flags = transport.TransportFlag(0).SetProtobuf()
+		rpkt := transport.NewTransportPacket(1000*1024, flags)
+		rpkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+
+		if err := spkt.Send(tc, vbsRef); err != nil {
+			t.Fatal(err)
+		}
+		payload, err := rpkt.Receive(tc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+		if len(vbsRef) != len(vbs) {
+			t.Fatalf("Mismatch in length for %v", transport.CompressionName(compression))
+		}
+		for i, vb := range vbs {
+			if vb.Equal(vbsRef[i]) == false {
+				t.Fatalf("Mismatch in VbKeyVersions for %v", transport.CompressionName(compression))
+			}
+		}
+	}
+}
+
+func TestHelo(t *testing.T) {
+	maxPayload := 1000 * 1024
+	for _, proposed := range []byte{transport.CompressionSnappy, transport.CompressionBzip2} {
+		conn, sconn := net.Pipe()
+
+		errch := make(chan error, 1)
+		go func() {
+			payload, err := newTransportPkt(maxPayload).Receive(sconn)
+			if err == nil {
+				err = doHelo("test", sconn, maxPayload, payload.(*protobuf.HeloRequest))
+			}
+			errch <- err
+		}()
+
+		endpoint := &RouterEndpoint{pkt: newTransportPkt(maxPayload), logPrefix: "test"}
+		compression, err := endpoint.doHelo(conn, proposed)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := <-errch; err != nil {
+			t.Fatal(err)
+		}
+
+		// Server can not compress with bzip2, and agrees to no compression
+		expected := proposed
+		if proposed == transport.CompressionBzip2 {
+			expected = transport.CompressionNone
+		}
+		if compression != expected {
+			t.Errorf("Expected %v, received %v", transport.CompressionName(expected),
+				transport.CompressionName(compression))
+		}
+		conn.Close()
+		sconn.Close()
+	}
+}
+
 func TestPktVbmap(t *testing.T) {
 	vbmapRef := &c.VbConnectionMap{
 		Bucket:   "default",
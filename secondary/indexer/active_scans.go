@@ -0,0 +1,175 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// ActiveScan is an in-flight scan request, as listed by the active scans
+// REST API. Elapsed and the row and byte counts are as of the listing, and
+// durations are in nanoseconds.
+type ActiveScan struct {
+	ScanId       uint64               `json:"scanId"`
+	RequestId    string               `json:"requestId"`
+	ScanType     string               `json:"scanType"`
+	Index        string               `json:"index"`
+	InstId       common.IndexInstId   `json:"instId"`
+	PartitionIds []common.PartitionId `json:"partitionIds,omitempty"`
+	Spans        string               `json:"spans"`
+	Consistency  string               `json:"consistency,omitempty"`
+	Priority     string               `json:"priority"`
+	StartTime    time.Time            `json:"startTime"`
+	Elapsed      time.Duration        `json:"elapsed"`
+	RowsReturned uint64               `json:"rowsReturned"`
+	RowsScanned  uint64               `json:"rowsScanned"`
+	BytesRead    uint64               `json:"bytesRead"`
+	ClientAddr   string               `json:"clientAddr"`
+	Cancelled    bool                 `json:"cancelled,omitempty"`
+}
+
+// activeScanRegistry keeps track of the in-flight scan requests by ScanId,
+// so that an admin can list and cancel them.
+type activeScanRegistry struct {
+	mutex sync.Mutex
+	scans map[uint64]*activeScan
+}
+
+// activeScan is the entry of a scan in the registry. The description of the
+// scan is captured when it is added, as the request is owned by the scan.
+type activeScan struct {
+	ActiveScan
+	pipeline      *ScanPipeline // nil until the scan pipeline is created
+	adminCancelCh chan struct{}
+	logPrefix     string
+
+	// keyspace of the index, for the permission to list the scan
+	bucket, scope, collection string
+}
+
+// add registers the scan request, which can then be cancelled by admin
+// through req.adminCancelCh.
+func (reg *activeScanRegistry) add(req *ScanRequest, clientAddr string) {
+
+	scan := &activeScan{
+		ActiveScan: ActiveScan{
+			ScanId:       req.ScanId,
+			RequestId:    req.RequestId,
+			ScanType:     string(req.ScanType),
+			InstId:       req.IndexInstId,
+			PartitionIds: req.PartitionIds,
+			Spans:        req.spansString(),
+			Priority:     req.priority.String(),
+			StartTime:    time.Now(),
+			ClientAddr:   clientAddr,
+		},
+		adminCancelCh: make(chan struct{}),
+		logPrefix:     req.LogPrefix,
+	}
+	if defn := &req.IndexInst.Defn; defn.Name != "" {
+		scan.Index = strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection, defn.Name}, ":")
+		scan.bucket, scan.scope, scan.collection = defn.Bucket, defn.Scope, defn.Collection
+	}
+	if req.Consistency != nil {
+		scan.Consistency = strings.ToLower(req.Consistency.String())
+	}
+	req.adminCancelCh = scan.adminCancelCh
+
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if reg.scans == nil {
+		reg.scans = make(map[uint64]*activeScan)
+	}
+	reg.scans[req.ScanId] = scan
+}
+
+func (reg *activeScanRegistry) remove(scanId uint64) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	delete(reg.scans, scanId)
+}
+
+// setPipeline records the pipeline of the scan, for its progress.
+func (reg *activeScanRegistry) setPipeline(scanId uint64, pipeline *ScanPipeline) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if scan, ok := reg.scans[scanId]; ok {
+		scan.pipeline = pipeline
+	}
+}
+
+// cancel cancels the scan with ErrAdminCancel, returning false if there is
+// no such scan in flight.
+func (reg *activeScanRegistry) cancel(scanId uint64) bool {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	scan, ok := reg.scans[scanId]
+	if !ok {
+		return false
+	}
+
+	if !scan.Cancelled {
+		logging.Infof("%s cancelled by admin, requestId: %v", scan.logPrefix, scan.RequestId)
+		scan.Cancelled = true
+		close(scan.adminCancelCh)
+	}
+	return true
+}
+
+// list returns the in-flight scans on the keyspaces that are allowed, oldest
+// first.
+func (reg *activeScanRegistry) list(allowed func(bucket, scope, collection string) bool) []*ActiveScan {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	now := time.Now()
+	scans := make([]*ActiveScan, 0, len(reg.scans))
+	for _, scan := range reg.scans {
+		if !allowed(scan.bucket, scan.scope, scan.collection) {
+			continue
+		}
+
+		e := new(ActiveScan)
+		*e = scan.ActiveScan
+		e.Elapsed = now.Sub(scan.StartTime)
+		if scan.pipeline != nil {
+			e.RowsReturned = scan.pipeline.RowsReturned()
+			e.RowsScanned = scan.pipeline.RowsScanned()
+			e.BytesRead = scan.pipeline.BytesRead()
+		}
+		scans = append(scans, e)
+	}
+
+	sort.Slice(scans, func(i, j int) bool {
+		return scans[i].ScanId < scans[j].ScanId
+	})
+	return scans
+}
+
+// ActiveScans returns the in-flight scans on the keyspaces that are allowed,
+// oldest first.
+func (s *scanCoordinator) ActiveScans(allowed func(bucket, scope, collection string) bool) []*ActiveScan {
+	return s.activeScans.list(allowed)
+}
+
+// CancelScan cancels the in-flight scan, returning false if there is no
+// such scan.
+func (s *scanCoordinator) CancelScan(scanId uint64) bool {
+	return s.activeScans.cancel(scanId)
+}
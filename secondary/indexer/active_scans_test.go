@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestActiveScanRegistry(t *testing.T) {
+	var reg activeScanRegistry
+
+	all := func(bucket, scope, collection string) bool { return true }
+
+	req := &ScanRequest{ScanId: 1, RequestId: "req1"}
+	req.IndexInst.Defn = common.IndexDefn{Bucket: "b1", Scope: "s1", Collection: "c1", Name: "idx"}
+	reg.add(req, "127.0.0.1:9101")
+
+	scans := reg.list(all)
+	if len(scans) != 1 || scans[0].RequestId != "req1" || scans[0].ClientAddr != "127.0.0.1:9101" ||
+		scans[0].Index != "b1:s1:c1:idx" {
+		t.Fatalf("Unexpected active scans %+v", scans)
+	}
+
+	// Scans are listed only on the allowed keyspaces
+	if scans := reg.list(func(bucket, scope, collection string) bool {
+		return bucket == "b2"
+	}); len(scans) != 0 {
+		t.Errorf("Expected scans of other buckets to not be listed, got %+v", scans)
+	}
+
+	errCh := make(chan error, 1)
+	cancelCb := NewCancelCallback(req, func(err error) { errCh <- err })
+	cancelCb.Run()
+	defer cancelCb.Done()
+
+	if reg.cancel(2) {
+		t.Errorf("Expected cancel of unknown scan to fail")
+	}
+	if !reg.cancel(1) || !reg.cancel(1) {
+		t.Fatalf("Expected cancel of active scan to succeed")
+	}
+
+	select {
+	case err := <-errCh:
+		if err != common.ErrAdminCancel {
+			t.Errorf("Expected %v, got %v", common.ErrAdminCancel, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected scan to be cancelled")
+	}
+	if scans := reg.list(all); len(scans) != 1 || !scans[0].Cancelled {
+		t.Errorf("Expected scan to be listed as cancelled, got %+v", scans)
+	}
+
+	reg.remove(1)
+	if scans := reg.list(all); len(scans) != 0 {
+		t.Errorf("Expected no active scans, got %+v", scans)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/query/value"
+)
+
+// indexAggregate returns the precomputed aggregate that can serve the
+// group aggregate of the request, or nil. The request must name the
+// aggregate, have the same group and aggregate keys and scan the whole
+// index.
+func (r *ScanRequest) indexAggregate() *common.IndexAggregate {
+
+	ga := r.GroupAggr
+	if ga == nil || ga.Name == "" || ga.HasExpr || r.Distinct || r.resumeCursor != nil {
+		return nil
+	}
+
+	if len(r.Scans) != 1 || r.Scans[0].ScanType != AllReq {
+		return nil
+	}
+
+	ia := common.FindIndexAggregate(r.IndexInst.Defn.Aggregates, ga.Name)
+	if ia == nil || len(ia.Group) != len(ga.Group) || len(ia.Aggrs) != len(ga.Aggrs) {
+		return nil
+	}
+
+	for i, gk := range ga.Group {
+		if gk.KeyPos != ia.Group[i] {
+			return nil
+		}
+	}
+
+	for i, ak := range ga.Aggrs {
+		if ak.Distinct || ak.KeyPos != ia.Aggrs[i].KeyPos || ak.AggrFunc != ia.Aggrs[i].AggrFunc {
+			return nil
+		}
+	}
+
+	return ia
+}
+
+// indexAggregateRows returns the group aggregate rows of the request from
+// the precomputed aggregate, merged over all partitions of the snapshot.
+// Rows are sorted on group keys in index order. It returns false if the
+// request cannot be served from a precomputed aggregate, in which case
+// the index is scanned.
+func (r *ScanRequest) indexAggregateRows(snapshots []SliceSnapshot) ([]*aggrRow, bool) {
+
+	ia := r.indexAggregate()
+	if ia == nil {
+		return nil, false
+	}
+
+	merged := make(map[string]*aggrGroupSummary)
+	for i, ss := range snapshots {
+		snap, ok := ss.Snapshot().(*aggregateSnapshot)
+		if !ok {
+			return nil, false
+		}
+
+		sa, err := snap.aggregates(r.Ctxs[i])
+		if err != nil {
+			logging.Warnf("%v Unable to compute aggregate %v from snapshot. Scanning index. Error %v",
+				r.LogPrefix, ia.Name, err)
+			return nil, false
+		}
+
+		groups, ok := sa.summary(ia)
+		if !ok {
+			return nil, false
+		}
+
+		for groupKey, g := range groups {
+			if m, ok := merged[groupKey]; ok {
+				m.merge(g)
+			} else {
+				merged[groupKey] = g
+			}
+		}
+	}
+
+	rows := make([]*aggrRow, 0, len(merged))
+	for _, g := range merged {
+		row := &aggrRow{
+			groups: make([]*groupKey, len(g.keys)),
+			aggrs:  make([]*aggrVal, len(g.aggrs)),
+		}
+		for i, k := range g.keys {
+			row.groups[i] = &groupKey{raw: k, projectId: r.GroupAggr.Group[i].EntryKeyId}
+		}
+		for i, a := range g.aggrs {
+			ak := r.GroupAggr.Aggrs[i]
+			row.aggrs[i] = &aggrVal{fn: newIndexAggrFunc(ak.AggrFunc, a), projectId: ak.EntryKeyId}
+		}
+		rows = append(rows, row)
+	}
+
+	desc := r.IndexInst.Defn.Desc
+	sort.Slice(rows, func(i, j int) bool {
+		for k, pos := range ia.Group {
+			c := bytes.Compare(rows[i].groups[k].raw, rows[j].groups[k].raw)
+			if c == 0 {
+				continue
+			}
+			if desc != nil && desc[pos] {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+
+	return rows, true
+}
+
+func (g *aggrGroupSummary) merge(o *aggrGroupSummary) {
+	for i := range g.aggrs {
+		a, b := &g.aggrs[i], &o.aggrs[i]
+		a.count += b.count
+		a.countn += b.countn
+		a.sum.merge(b.sum)
+		if a.min == nil || (b.min != nil && bytes.Compare(b.min, a.min) < 0) {
+			a.min = b.min
+		}
+		if a.max == nil || (b.max != nil && bytes.Compare(b.max, a.max) > 0) {
+			a.max = b.max
+		}
+	}
+}
+
+// indexAggrFunc is the value of a precomputed aggregate. Value is in the
+// same form as that of the aggregate computed by scan.
+type indexAggrFunc struct {
+	typ common.AggrFuncType
+	val interface{}
+}
+
+func newIndexAggrFunc(typ common.AggrFuncType, a aggrSummary) common.AggrFunc {
+
+	switch typ {
+	case common.AGG_COUNT:
+		return &indexAggrFunc{typ: typ, val: a.count}
+	case common.AGG_COUNTN:
+		return &indexAggrFunc{typ: typ, val: a.countn}
+	case common.AGG_SUM:
+		if a.countn == 0 {
+			return &indexAggrFunc{typ: typ}
+		}
+		return &indexAggrFunc{typ: typ, val: a.sum.value()}
+	case common.AGG_MIN, common.AGG_MAX:
+		val := a.min
+		if typ == common.AGG_MAX {
+			val = a.max
+		}
+		if val == nil {
+			val = encodedNull
+		}
+		return &indexAggrFunc{typ: typ, val: val}
+	case common.AGG_AVG:
+		fn := common.NewPartialAggrFunc(typ, false, false)
+		if a.countn != 0 {
+			fn.MergeState(value.NewValue([]interface{}{a.sum.value(), a.countn}))
+		}
+		return fn
+	}
+
+	return &indexAggrFunc{typ: typ}
+}
+
+func (a *indexAggrFunc) Type() common.AggrFuncType {
+	return a.typ
+}
+
+func (a *indexAggrFunc) AddDelta(delta interface{}) {
+	//not implemented
+}
+
+func (a *indexAggrFunc) AddDeltaObj(delta value.Value) {
+	//not implemented
+}
+
+func (a *indexAggrFunc) AddDeltaRaw(delta []byte) {
+	//not implemented
+}
+
+func (a *indexAggrFunc) Value() interface{} {
+	return a.val
+}
+
+func (a *indexAggrFunc) Distinct() bool {
+	return false
+}
+
+func (a *indexAggrFunc) IsValid() bool {
+	return a.val != nil
+}
@@ -0,0 +1,465 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// isAggregateEligible returns true if precomputed aggregates are to be
+// served for the index. Aggregates are opt-in: the slice of an index is
+// wrapped only if the index has aggregates when the slice is opened.
+func isAggregateEligible(defn *common.IndexDefn) bool {
+	return len(defn.Aggregates) != 0 && !defn.IsPrimary && !defn.IsArrayIndex &&
+		defn.VectorMeta == nil
+}
+
+// aggregateSlice wraps the storage slice of an index and serves the
+// precomputed aggregates of the index (common.IndexAggregate). Mutations
+// are passed through to the storage slice as is.
+//
+// The aggregates of a storage snapshot are computed from the snapshot on
+// the first aggregate scan of the snapshot, and are shared by all scans of
+// the snapshot. They are reused for the next snapshot that has the same
+// timestamp, i.e. if no mutations are flushed in between. Only the groups
+// of the aggregates are held in memory.
+type aggregateSlice struct {
+	Slice
+	aggrs *indexAggregates
+}
+
+func newAggregateSlice(slice Slice, defn common.IndexDefn) *aggregateSlice {
+
+	aggrs := newIndexAggregates(defn.Desc)
+	aggrs.set(defn.Aggregates)
+
+	logging.Infof("aggregateSlice:: created aggregates for instance %v partition %v: %v",
+		slice.IndexInstId(), slice.IndexPartnId(), defn.Aggregates)
+
+	return &aggregateSlice{Slice: slice, aggrs: aggrs}
+}
+
+// setAggregates replaces the aggregates served by the slice. The
+// aggregates are computed from the snapshot on the next matching scan.
+func (s *aggregateSlice) setAggregates(defns []*common.IndexAggregate) {
+	s.aggrs.set(defns)
+}
+
+func (s *aggregateSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	snap, err := s.Slice.OpenSnapshot(info)
+	if err != nil {
+		return nil, err
+	}
+	return &aggregateSnapshot{Snapshot: snap, aggrs: s.aggrs, gen: s.aggrs.generation()}, nil
+}
+
+func (s *aggregateSlice) Rollback(info SnapshotInfo) error {
+	err := s.Slice.Rollback(info)
+	s.aggrs.reset()
+	return err
+}
+
+func (s *aggregateSlice) RollbackToZero(initialBuild bool) error {
+	err := s.Slice.RollbackToZero(initialBuild)
+	s.aggrs.reset()
+	return err
+}
+
+func (s *aggregateSlice) Destroy() {
+	s.aggrs.reset()
+	s.Slice.Destroy()
+}
+
+// aggregateSnapshot is the storage snapshot of an aggregateSlice, along
+// with the aggregates computed from the snapshot.
+type aggregateSnapshot struct {
+	Snapshot
+	aggrs *indexAggregates
+	gen   uint64 // generation of the aggregates when the snapshot is opened
+
+	mutex    sync.Mutex
+	computed *snapshotAggregates
+}
+
+// aggregates returns the aggregates of the snapshot, computing them from
+// the snapshot on first use. Concurrent scans of the snapshot wait for the
+// aggregates to be computed once.
+func (s *aggregateSnapshot) aggregates(ctx IndexReaderContext) (*snapshotAggregates, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.computed != nil {
+		return s.computed, nil
+	}
+
+	ts := s.Snapshot.Timestamp()
+	if sa := s.aggrs.cached(s.gen, ts); sa != nil {
+		s.computed = sa
+		return sa, nil
+	}
+
+	defns, desc := s.aggrs.definitions()
+	sa := newSnapshotAggregates(defns, desc, s.gen, ts)
+	if err := sa.compute(ctx, s.Snapshot); err != nil {
+		return nil, err
+	}
+
+	s.computed = sa
+	s.aggrs.cache(sa)
+	return sa, nil
+}
+
+// aggrGroup is a group of a precomputed aggregate.
+type aggrGroup struct {
+	keys  [][]byte // collatejson encoded group keys
+	count int64    // number of index entries in the group
+	aggrs []*aggrState
+}
+
+// aggrState is the state of an aggregate within a group.
+type aggrState struct {
+	count  int64    // non null, non missing values
+	countn int64    // numeric values
+	sum    exactSum // sum of numeric values
+	min    []byte   // collatejson encoded, nil if there are no values
+	max    []byte
+}
+
+// aggrSummary is the value of an aggregate within a group. MIN and MAX
+// are collatejson encoded, nil if there are no values.
+type aggrSummary struct {
+	count  int64
+	countn int64
+	sum    exactSum
+	min    []byte
+	max    []byte
+}
+
+// exactSum is the sum of numeric values, which does not depend on the
+// order in which the values are added. Integers are summed as int64 until
+// a value is not an integer or the sum overflows, and as big.Rat after.
+type exactSum struct {
+	i int64
+	r *big.Rat
+}
+
+// add adds a JSON encoded number.
+func (s *exactSum) add(js []byte) {
+	if s.r == nil {
+		if v, err := strconv.ParseInt(string(js), 10, 64); err == nil && s.addInt(v) {
+			return
+		}
+		s.r = new(big.Rat).SetInt64(s.i)
+	}
+	if v, ok := new(big.Rat).SetString(string(js)); ok {
+		s.r.Add(s.r, v)
+	}
+}
+
+// addInt adds v to the integer sum, or returns false if the sum overflows.
+func (s *exactSum) addInt(v int64) bool {
+	sum := s.i + v
+	if (v >= 0) != (sum >= s.i) {
+		return false
+	}
+	s.i = sum
+	return true
+}
+
+func (s *exactSum) merge(o exactSum) {
+	if s.r == nil && o.r == nil && s.addInt(o.i) {
+		return
+	}
+	if s.r == nil {
+		s.r = new(big.Rat).SetInt64(s.i)
+	} else {
+		// s.r is shared with the computed aggregates
+		s.r = new(big.Rat).Set(s.r)
+	}
+	if o.r != nil {
+		s.r.Add(s.r, o.r)
+	} else {
+		s.r.Add(s.r, new(big.Rat).SetInt64(o.i))
+	}
+}
+
+// value returns the sum as int64, or as float64 if not all values are
+// integers or the sum overflows int64, like that of the scan.
+func (s *exactSum) value() interface{} {
+	if s.r == nil {
+		return s.i
+	}
+	f, _ := s.r.Float64()
+	return f
+}
+
+// apply adds value val of the aggregate.
+func (a *aggrState) apply(typ common.AggrFuncType, val []byte, buf []byte) {
+
+	// ignore if null or missing
+	if len(val) == 0 || val[0] == collatejson.TypeMissing || val[0] == collatejson.TypeNull {
+		return
+	}
+	a.count++
+
+	switch typ {
+	case common.AGG_MIN, common.AGG_MAX:
+		if a.min == nil || string(val) < string(a.min) {
+			a.min = append([]byte(nil), val...)
+		}
+		if a.max == nil || string(val) > string(a.max) {
+			a.max = append([]byte(nil), val...)
+		}
+
+	case common.AGG_SUM, common.AGG_AVG, common.AGG_COUNTN:
+		if val[0] != collatejson.TypeNumber {
+			return
+		}
+		a.countn++
+		if typ == common.AGG_COUNTN {
+			return
+		}
+
+		if js, err := jsonEncoder.Decode(val, buf); err == nil {
+			a.sum.add(js)
+		}
+	}
+}
+
+func (a *aggrState) summary() aggrSummary {
+	return aggrSummary{count: a.count, countn: a.countn, sum: a.sum, min: a.min, max: a.max}
+}
+
+// aggrGroupSummary is the value of all aggregates of a group.
+type aggrGroupSummary struct {
+	keys  [][]byte
+	aggrs []aggrSummary
+}
+
+// indexAggregates holds the aggregates of a slice, and the aggregates
+// computed from the most recent snapshot of the slice. The generation
+// is incremented whenever the aggregates are replaced or the slice is
+// rolled back, which invalidates the computed aggregates.
+type indexAggregates struct {
+	sync.Mutex
+
+	defns []*common.IndexAggregate
+	desc  []bool
+	gen   uint64
+
+	last *snapshotAggregates
+}
+
+func newIndexAggregates(desc []bool) *indexAggregates {
+	return &indexAggregates{desc: desc}
+}
+
+// set replaces the aggregates.
+func (ia *indexAggregates) set(defns []*common.IndexAggregate) {
+	ia.Lock()
+	defer ia.Unlock()
+
+	ia.defns = common.CloneIndexAggregates(defns)
+	ia.resetLOCKED()
+}
+
+func (ia *indexAggregates) reset() {
+	ia.Lock()
+	defer ia.Unlock()
+
+	ia.resetLOCKED()
+}
+
+func (ia *indexAggregates) resetLOCKED() {
+	ia.last = nil
+	ia.gen++
+}
+
+func (ia *indexAggregates) generation() uint64 {
+	ia.Lock()
+	defer ia.Unlock()
+
+	return ia.gen
+}
+
+func (ia *indexAggregates) definitions() ([]*common.IndexAggregate, []bool) {
+	ia.Lock()
+	defer ia.Unlock()
+
+	return ia.defns, ia.desc
+}
+
+// cached returns the aggregates computed from the most recent snapshot,
+// if it has the same generation and timestamp, or nil.
+func (ia *indexAggregates) cached(gen uint64, ts *common.TsVbuuid) *snapshotAggregates {
+	ia.Lock()
+	defer ia.Unlock()
+
+	if ia.last == nil || ia.last.gen != gen || ia.gen != gen ||
+		ts == nil || ia.last.ts == nil || !ia.last.ts.Equal(ts) {
+		return nil
+	}
+	return ia.last
+}
+
+// cache keeps the aggregates computed from a snapshot, unless they are
+// invalidated already.
+func (ia *indexAggregates) cache(sa *snapshotAggregates) {
+	ia.Lock()
+	defer ia.Unlock()
+
+	if sa.gen == ia.gen {
+		ia.last = sa
+	}
+}
+
+// snapshotAggregates are the aggregates computed from a storage snapshot.
+// Every aggregate has a map of groups, keyed by the concatenated collatejson
+// encoded group keys. They are not modified once computed.
+type snapshotAggregates struct {
+	defns []*common.IndexAggregate
+	desc  []bool
+	gen   uint64
+	ts    *common.TsVbuuid
+
+	groups []map[string]*aggrGroup
+	count  int
+}
+
+func newSnapshotAggregates(defns []*common.IndexAggregate, desc []bool, gen uint64,
+	ts *common.TsVbuuid) *snapshotAggregates {
+
+	sa := &snapshotAggregates{
+		defns:  defns,
+		desc:   desc,
+		gen:    gen,
+		ts:     ts.Copy(),
+		groups: make([]map[string]*aggrGroup, len(defns)),
+	}
+	for i := range sa.groups {
+		sa.groups[i] = make(map[string]*aggrGroup)
+	}
+	return sa
+}
+
+// compute adds all the entries of the snapshot to the aggregates.
+func (sa *snapshotAggregates) compute(ctx IndexReaderContext, snap Snapshot) error {
+	var key []byte
+	err := snap.All(ctx, func(entry []byte) error {
+		var err error
+		if key, err = sa.decodeEntry(entry, key); err == nil {
+			sa.add(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logging.Infof("snapshotAggregates::compute computed aggregates of %v entries from snapshot", sa.count)
+	return nil
+}
+
+// decodeEntry returns the collatejson encoded index key of the storage
+// encoded entry.
+func (sa *snapshotAggregates) decodeEntry(entry []byte, buf []byte) ([]byte, error) {
+	orig := append(buf[:0], entry...)
+	if sa.desc != nil {
+		if _, err := jsonEncoder.ReverseCollate(orig, sa.desc); err != nil {
+			return nil, err
+		}
+	}
+
+	e := secondaryIndexEntry(orig)
+	return orig[:e.lenKey()], nil
+}
+
+// add adds the index key to the groups of all aggregates.
+func (sa *snapshotAggregates) add(key []byte) {
+	buf := make([]byte, 0, 3*len(key)+collatejson.MinBufferSize)
+	keys, err := jsonEncoder.ExplodeArray(key, buf)
+	if err != nil {
+		return
+	}
+	sa.count++
+
+	var sb strings.Builder
+	for i, defn := range sa.defns {
+		if !validAggregateKeys(defn, keys) {
+			continue
+		}
+
+		sb.Reset()
+		for _, pos := range defn.Group {
+			sb.Write(keys[pos])
+		}
+
+		groupKey := sb.String()
+		g, ok := sa.groups[i][groupKey]
+		if !ok {
+			g = &aggrGroup{aggrs: make([]*aggrState, len(defn.Aggrs))}
+			for _, pos := range defn.Group {
+				g.keys = append(g.keys, append([]byte(nil), keys[pos]...))
+			}
+			for j := range g.aggrs {
+				g.aggrs[j] = new(aggrState)
+			}
+			sa.groups[i][groupKey] = g
+		}
+
+		g.count++
+		for j, ak := range defn.Aggrs {
+			g.aggrs[j].apply(ak.AggrFunc, keys[ak.KeyPos], buf)
+		}
+	}
+}
+
+func validAggregateKeys(defn *common.IndexAggregate, keys [][]byte) bool {
+	for _, pos := range defn.Group {
+		if int(pos) >= len(keys) {
+			return false
+		}
+	}
+	for _, ak := range defn.Aggrs {
+		if int(ak.KeyPos) >= len(keys) {
+			return false
+		}
+	}
+	return true
+}
+
+// summary returns the groups of aggregate defn, keyed by the concatenated
+// group keys, or false if the aggregate is not computed.
+func (sa *snapshotAggregates) summary(defn *common.IndexAggregate) (map[string]*aggrGroupSummary, bool) {
+
+	for i, d := range sa.defns {
+		if d.String() != defn.String() {
+			continue
+		}
+
+		groups := make(map[string]*aggrGroupSummary, len(sa.groups[i]))
+		for groupKey, g := range sa.groups[i] {
+			gs := &aggrGroupSummary{keys: g.keys, aggrs: make([]aggrSummary, len(g.aggrs))}
+			for j, a := range g.aggrs {
+				gs.aggrs[j] = a.summary()
+			}
+			groups[groupKey] = gs
+		}
+		return groups, true
+	}
+
+	return nil, false
+}
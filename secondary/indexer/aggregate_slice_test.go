@@ -0,0 +1,145 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestIndexAggregates(t *testing.T) {
+	defn := &common.IndexAggregate{
+		Name:  "byType",
+		Group: []int32{0},
+		Aggrs: []common.IndexAggregateKey{
+			{AggrFunc: common.AGG_COUNT, KeyPos: 1},
+			{AggrFunc: common.AGG_SUM, KeyPos: 1},
+			{AggrFunc: common.AGG_MIN, KeyPos: 1},
+			{AggrFunc: common.AGG_MAX, KeyPos: 1},
+		},
+	}
+
+	sa := newSnapshotAggregates([]*common.IndexAggregate{defn}, nil, 0, nil)
+
+	for i := 0; i < 10; i++ {
+		key, err := encodeValue([]interface{}{i%2 == 0, i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sa.add(key)
+	}
+	key, _ := encodeValue([]interface{}{true, nil})
+	sa.add(key)
+
+	groups, ok := sa.summary(defn)
+	if !ok || len(groups) != 2 {
+		t.Fatalf("Unexpected groups %v", groups)
+	}
+
+	check := func(group bool, count int64, sum int64, min, max int) {
+		key, err := encodeValue(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, ok := groups[string(key)]
+		if !ok {
+			t.Fatalf("Group %v not found", group)
+		}
+
+		a := g.aggrs
+		if a[0].count != count || a[1].sum.value() != sum {
+			t.Errorf("Group %v expected count %v sum %v, received %v %v", group, count, sum,
+				a[0].count, a[1].sum.value())
+		}
+
+		mn, _ := encodeValue(min)
+		mx, _ := encodeValue(max)
+		if !bytes.Equal(a[2].min, mn) || !bytes.Equal(a[3].max, mx) {
+			t.Errorf("Group %v expected min %v max %v, received %v %v", group, min, max, a[2].min, a[3].max)
+		}
+	}
+	check(true, 5, 20, 0, 8)
+	check(false, 5, 25, 1, 9)
+
+	// Aggregates that are not computed are not served
+	if _, ok := sa.summary(&common.IndexAggregate{Name: "other"}); ok {
+		t.Errorf("Expected aggregate to be unavailable")
+	}
+}
+
+func TestIndexAggregatesCache(t *testing.T) {
+	defns := []*common.IndexAggregate{{Name: "count", Aggrs: []common.IndexAggregateKey{
+		{AggrFunc: common.AGG_COUNT, KeyPos: 0}}}}
+
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos[0] = 10
+
+	ia := newIndexAggregates(nil)
+	ia.set(defns)
+
+	gen := ia.generation()
+	ia.cache(newSnapshotAggregates(defns, nil, gen, ts))
+
+	// Aggregates are reused for a snapshot of the same timestamp only
+	if ia.cached(gen, ts) == nil {
+		t.Errorf("Expected aggregates to be reused for the same timestamp")
+	}
+	next := ts.Copy()
+	next.Seqnos[0] = 11
+	if ia.cached(gen, next) != nil {
+		t.Errorf("Expected aggregates to be computed for a new timestamp")
+	}
+
+	// Rollback invalidates the aggregates
+	ia.reset()
+	if ia.cached(gen, ts) != nil || ia.cached(ia.generation(), ts) != nil {
+		t.Errorf("Expected aggregates to be invalidated by rollback")
+	}
+
+	// Aggregates computed before rollback are not cached
+	ia.cache(newSnapshotAggregates(defns, nil, gen, ts))
+	if ia.cached(ia.generation(), ts) != nil {
+		t.Errorf("Expected stale aggregates to not be cached")
+	}
+}
+
+func TestExactSum(t *testing.T) {
+	var s exactSum
+	for i := 0; i < 10; i++ {
+		s.add([]byte("0.1"))
+	}
+	if v := s.value(); v != float64(1) {
+		t.Errorf("Expected 1, received %v", v)
+	}
+
+	// Sum does not depend on the order of values
+	var a, b exactSum
+	for _, v := range []string{"1e16", "1", "-1e16"} {
+		a.add([]byte(v))
+	}
+	for _, v := range []string{"1e16", "-1e16", "1"} {
+		b.add([]byte(v))
+	}
+	if a.value() != b.value() || a.value() != float64(1) {
+		t.Errorf("Expected 1, received %v %v", a.value(), b.value())
+	}
+
+	// Integer overflow falls back to exact sum
+	var c exactSum
+	c.add([]byte(fmt.Sprint(int64(math.MaxInt64))))
+	c.add([]byte("1"))
+	c.add([]byte("-2"))
+	if v := c.value(); v != float64(math.MaxInt64-1) {
+		t.Errorf("Expected %v, received %v", float64(math.MaxInt64-1), v)
+	}
+
+	// Merge does not modify the merged sums
+	var d exactSum
+	d.merge(a)
+	d.merge(c)
+	if a.value() != float64(1) || d.value() != float64(math.MaxInt64) {
+		t.Errorf("Unexpected merge %v %v", a.value(), d.value())
+	}
+}
@@ -1,7 +1,9 @@
 package indexer
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"fmt"
@@ -28,7 +30,8 @@ type target struct {
 }
 
 type restServer struct {
-	statsMgr *statsManager
+	statsMgr  *statsManager
+	scanCoord ScanCoordinator
 }
 
 type request struct {
@@ -51,11 +54,13 @@ func initHandlers(api *restServer) {
 	staticRoutes = make(map[string]reqHandler)
 	staticRoutes["stats"] = api.statsHandler
 	staticRoutes["bucket"] = bucketHandler
+	staticRoutes["slowScans"] = api.slowScansHandler
+	staticRoutes["scans"] = api.activeScansHandler
 }
 
-func NewRestServer(cluster string, stMgr *statsManager) (*restServer, Message) {
+func NewRestServer(cluster string, stMgr *statsManager, scanCoord ScanCoordinator) (*restServer, Message) {
 	log.Infof("%v starting RESTful services", cluster)
-	restapi := &restServer{statsMgr: stMgr}
+	restapi := &restServer{statsMgr: stMgr, scanCoord: scanCoord}
 	initHandlers(restapi)
 	mux := GetHTTPMux()
 	mux.HandleFunc("/api/", restapi.routeRequest)
@@ -222,6 +227,102 @@ func (api *restServer) statsHandler(req request) {
 	}
 }
 
+func (api *restServer) slowScansHandler(req request) {
+	// Example: _/api/v1/slowScans (_ is a blank)
+	if req.r.Method != "GET" {
+		http.Error(req.w, "Unsupported method", 405)
+		return
+	}
+
+	if req.version != "v1" || req.url != "/api/slowScans" {
+		http.Error(req.w, req.r.URL.Path, 404)
+		return
+	}
+
+	if !c.IsAllAllowed(req.creds, []string{"cluster.n1ql.meta!read"}, req.r, req.w,
+		"restServer::slowScansHandler") {
+		return
+	}
+
+	var bytes []byte
+	var err error
+	if req.r.URL.Query().Get("pretty") == "true" {
+		bytes, err = json.MarshalIndent(api.scanCoord.SlowScans(), "", "   ")
+	} else {
+		bytes, err = json.Marshal(api.scanCoord.SlowScans())
+	}
+	if err != nil {
+		http.Error(req.w, err.Error(), 500)
+		return
+	}
+
+	req.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	req.w.WriteHeader(200)
+	req.w.Write(bytes)
+}
+
+func (api *restServer) activeScansHandler(req request) {
+	// Example: _/api/v1/scans, _/api/v1/scans/<scanId> (_ is a blank)
+	if req.version != "v1" {
+		http.Error(req.w, req.r.URL.Path, 404)
+		return
+	}
+
+	switch {
+	case req.r.Method == "GET" && req.url == "/api/scans":
+		if !c.IsAllAllowed(req.creds, []string{"cluster.n1ql.meta!read"}, req.r, req.w,
+			"restServer::activeScansHandler") {
+			return
+		}
+
+		// Only the scans on the keyspaces whose indexes can be listed
+		permissionsCache := c.NewSessionPermissionsCache(req.creds)
+		scans := api.scanCoord.ActiveScans(func(bucket, scope, collection string) bool {
+			return bucket != "" && permissionsCache.IsAllowed(bucket, scope, collection, "list")
+		})
+
+		var bytes []byte
+		var err error
+		if req.r.URL.Query().Get("pretty") == "true" {
+			bytes, err = json.MarshalIndent(scans, "", "   ")
+		} else {
+			bytes, err = json.Marshal(scans)
+		}
+		if err != nil {
+			http.Error(req.w, err.Error(), 500)
+			return
+		}
+
+		req.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		req.w.WriteHeader(200)
+		req.w.Write(bytes)
+
+	case req.r.Method == "DELETE" && strings.HasPrefix(req.url, "/api/scans/"):
+		if !c.IsAllAllowed(req.creds, []string{"cluster.admin.internal.index!write"}, req.r, req.w,
+			"restServer::activeScansHandler") {
+			return
+		}
+
+		scanId, err := strconv.ParseUint(strings.TrimPrefix(req.url, "/api/scans/"), 10, 64)
+		if err != nil {
+			api.writeError(req.w, fmt.Errorf("Invalid scan id: %v", err))
+			return
+		}
+
+		if !api.scanCoord.CancelScan(scanId) {
+			http.Error(req.w, fmt.Sprintf("Scan %v not found", scanId), 404)
+			return
+		}
+		req.w.WriteHeader(200)
+
+	case req.r.Method == "GET" || req.r.Method == "DELETE":
+		http.Error(req.w, req.r.URL.Path, 404)
+
+	default:
+		http.Error(req.w, "Unsupported method", 405)
+	}
+}
+
 // Dont use this function for indexer level stats. For indexer level stats
 // we must check permissions for every index.
 func (api *restServer) authorizeStats(req request, t *target) bool {
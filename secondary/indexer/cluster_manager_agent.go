@@ -500,7 +500,7 @@ func (c *clustMgrAgent) handleGetGlobalTopology(cmd Message) {
 				shardIds[i] = []common.ShardId(partn.ShardIds)
 			}
 			pc := c.metaNotifier.makeDefaultPartitionContainer(partitions, versions, shardIds,
-				inst.NumPartitions, idxDefn.PartitionScheme, idxDefn.HashScheme)
+				inst.NumPartitions, idxDefn.PartitionScheme, idxDefn.HashScheme, idxDefn.PartitionRanges)
 
 			// create index instance
 			idxInst := common.IndexInst{
@@ -760,7 +760,7 @@ func (meta *metaNotifier) OnIndexCreate(indexDefn *common.IndexDefn, instId comm
 		" instId %v, indexDefn %+v, reqCtx %+v, partitions %v",
 		_OnIndexCreate, instId, indexDefn, reqCtx, partitions)
 
-	pc := meta.makeDefaultPartitionContainer(partitions, versions, nil, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme)
+	pc := meta.makeDefaultPartitionContainer(partitions, versions, nil, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme, indexDefn.PartitionRanges)
 
 	idxInst := common.IndexInst{InstId: instId,
 		Defn:       *indexDefn,
@@ -827,7 +827,7 @@ func (meta *metaNotifier) OnIndexRecover(indexDefn *common.IndexDefn, instId com
 
 	// The shardIds in partition container will be updated after
 	// index is completely recovered into slice
-	pc := meta.makeDefaultPartitionContainer(partitions, versions, nil, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme)
+	pc := meta.makeDefaultPartitionContainer(partitions, versions, nil, numPartitions, indexDefn.PartitionScheme, indexDefn.HashScheme, indexDefn.PartitionRanges)
 
 	idxInst := common.IndexInst{InstId: instId,
 		Defn:       *indexDefn,
@@ -1081,6 +1081,50 @@ func (meta *metaNotifier) OnPartitionPrune(instId common.IndexInstId, partitions
 	return nil
 }
 
+func (meta *metaNotifier) OnAggregateUpdate(defnId common.IndexDefnId, aggregates []*common.IndexAggregate, reqCtx *common.MetadataRequestContext) error {
+
+	logging.Infof("clustMgrAgent::OnAggregateUpdate Notification "+
+		"Received for Update Aggregates DefnId %v %v %v", defnId, aggregates, reqCtx)
+
+	respCh := make(MsgChannel)
+
+	meta.adminCh <- &MsgClustMgrUpdateAggregates{
+		defnId:     defnId,
+		aggregates: aggregates,
+		respCh:     respCh}
+
+	//wait for response
+	if res, ok := <-respCh; ok {
+
+		switch res.GetMsgType() {
+
+		case MSG_SUCCESS:
+			logging.Infof("clustMgrAgent::OnAggregateUpdate Success "+
+				"for DefnId %v", defnId)
+			return nil
+
+		case MSG_ERROR:
+			logging.Errorf("clustMgrAgent::OnAggregateUpdate Error "+
+				"for DefnId %v. Error %v", defnId, res)
+			err := res.(*MsgError).GetError()
+			return &common.IndexerError{Reason: err.String(), Code: err.convertError()}
+
+		default:
+			logging.Fatalf("clustMgrAgent::OnAggregateUpdate Unknown Response "+
+				"Received for DefnId %v. Response %v", defnId, res)
+			common.CrashOnError(errors.New("Unknown Response"))
+
+		}
+
+	} else {
+		logging.Fatalf("clustMgrAgent::OnAggregateUpdate Unexpected Channel Close "+
+			"for DefnId %v", defnId)
+		common.CrashOnError(errors.New("Unknown Response"))
+	}
+
+	return nil
+}
+
 func (meta *metaNotifier) OnFetchStats() error {
 
 	go meta.fetchStats()
@@ -1109,9 +1153,9 @@ func (meta *metaNotifier) fetchStats() {
 
 func (meta *metaNotifier) makeDefaultPartitionContainer(partitions []common.PartitionId, versions []int,
 	shardIds [][]common.ShardId, numPartitions uint32,
-	scheme common.PartitionScheme, hash common.HashScheme) common.PartitionContainer {
+	scheme common.PartitionScheme, hash common.HashScheme, ranges []string) common.PartitionContainer {
 
-	pc := common.NewKeyPartitionContainer(int(numPartitions), scheme, hash)
+	pc := common.NewKeyPartitionContainer(int(numPartitions), scheme, hash, ranges)
 
 	//Add one partition for now
 	addr := net.JoinHostPort("", meta.config["streamMaintPort"].String())
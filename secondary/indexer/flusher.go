@@ -11,6 +11,7 @@ package indexer
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
@@ -485,7 +486,24 @@ func (f *flusher) flush(mutk *MutationKeys, streamId common.StreamId) {
 		default:
 			logging.Errorf("Flusher::flush Unknown mutation type received. Skipped %v",
 				logging.TagUD(mut.key))
+			continue
 		}
+
+		f.updateRecvToIndexLatency(mut.uuid, mutk.meta)
+		gRangeWatches.publish(mut, mutk.docid, mutk.meta)
+	}
+}
+
+// updateRecvToIndexLatency records the time from receipt of a sampled mutation
+// from projector to its being indexed in the latency distribution of the index
+func (f *flusher) updateRecvToIndexLatency(instId common.IndexInstId, meta *MutationMeta) {
+
+	if meta.recvTime == 0 || f.stats == nil {
+		return
+	}
+
+	if idxStats, ok := f.stats.indexes[instId]; ok {
+		idxStats.recvToIndexLatDist.Add(time.Now().UnixNano() - meta.recvTime)
 	}
 }
 
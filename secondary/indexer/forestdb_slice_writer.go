@@ -1669,6 +1669,165 @@ handle_err:
 	return snapList, errors.New("Failed to retrieve snapshots list -" + err.Error())
 }
 
+// Scrub reads the main index and back index at the latest persisted
+// snapshot, for forestdb to verify the checksums of their blocks. For a
+// secondary index, the main index entries of every back index entry are
+// also looked up in the main index, and are to be all of the main index. If
+// the slice is corrupted, it is marked to be rebuilt from DCP on the next
+// bootstrap.
+func (fdb *fdbSlice) Scrub(throttle *scrubThrottle) (int64, error) {
+	infos, err := fdb.getSnapshotsMeta()
+	if err != nil {
+		return 0, err
+	}
+
+	latest := NewSnapshotInfoContainer(infos).GetLatest()
+	if latest == nil {
+		return 0, nil
+	}
+
+	count, err := fdb.scrubSnapshot(latest.(*fdbSnapshotInfo), throttle)
+	if err == errScrubMismatch || isFdbCorruption(err) {
+		logging.Errorf("ForestDBSlice::Scrub SliceId %v IndexInstId %v found corrupted "+
+			"data (error=%v), marking the slice to be rebuilt", fdb.id, fdb.idxInstId, err)
+		if err := markScrubCorrupted(fdb.path); err != nil {
+			logging.Errorf("ForestDBSlice::Scrub SliceId %v IndexInstId %v failed to "+
+				"mark the slice (error=%v)", fdb.id, fdb.idxInstId, err)
+			return count, err
+		}
+		return count, errStorageCorrupted
+	}
+
+	return count, err
+}
+
+func (fdb *fdbSlice) scrubSnapshot(info *fdbSnapshotInfo, throttle *scrubThrottle) (int64, error) {
+	main, err := fdb.main[0].SnapshotOpen(info.MainSeq)
+	if err != nil {
+		return 0, err
+	}
+	defer main.Close()
+
+	count, err := fdb.scrubKVStore(main, throttle, nil)
+	if err != nil || fdb.isPrimary {
+		return count, err
+	}
+
+	back, err := fdb.back[0].SnapshotOpen(info.BackSeq)
+	if err != nil {
+		return count, err
+	}
+	defer back.Close()
+
+	// Main index entries of the back index entries, which are the entries of
+	// the elements of the array key for an array index
+	var entries int64
+	lookup := func(docid, entry []byte) error {
+		if _, err := main.GetKV(entry); err == forestdb.FDB_RESULT_KEY_NOT_FOUND {
+			logging.Errorf("ForestDBSlice::Scrub SliceId %v IndexInstId %v docid %s of "+
+				"back index not found in main index", fdb.id, fdb.idxInstId, logging.TagStrUD(docid))
+			return errScrubMismatch
+		} else if err != nil {
+			return err
+		}
+		entries++
+		return nil
+	}
+
+	verify := func(docid, key []byte) error {
+		if !fdb.idxDefn.IsArrayIndex {
+			return lookup(docid, key)
+		}
+
+		// The back index stores the whole array key, reverse collated as on
+		// insert to be exploded
+		key = append([]byte(nil), key...)
+		if fdb.idxDefn.Desc != nil {
+			if _, err := jsonEncoder.ReverseCollate(key, fdb.idxDefn.Desc); err != nil {
+				return errScrubMismatch
+			}
+		}
+		items, counts, _, err := ArrayIndexItems(key, fdb.arrayExprPosition, make([]byte, 0, len(key)*3),
+			fdb.isArrayDistinct, fdb.isArrayFlattened, false, fdb.keySzConf)
+		if err != nil {
+			logging.Errorf("ForestDBSlice::Scrub SliceId %v IndexInstId %v docid %s of back "+
+				"index has invalid array key (error=%v)", fdb.id, fdb.idxInstId, logging.TagStrUD(docid), err)
+			return errScrubMismatch
+		}
+		for i, item := range items {
+			entry, err := GetIndexEntryBytes3(item, docid, false, false, counts[i], fdb.idxDefn.Desc,
+				make([]byte, 0, len(item)+MAX_KEY_EXTRABYTES_LEN), nil, fdb.keySzConf)
+			if err != nil {
+				return errScrubMismatch
+			} else if entry == nil {
+				continue
+			}
+			if err := lookup(docid, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	backCount, err := fdb.scrubKVStore(back, throttle, verify)
+	if err == nil && entries != count {
+		logging.Errorf("ForestDBSlice::Scrub SliceId %v IndexInstId %v main index has %v "+
+			"items, back index has %v items of %v main index entries", fdb.id, fdb.idxInstId,
+			count, backCount, entries)
+		err = errScrubMismatch
+	}
+
+	return count + backCount, err
+}
+
+// scrubKVStore reads all the documents of kvstore, calling verify with the
+// key and body of each of them.
+func (fdb *fdbSlice) scrubKVStore(kvstore *forestdb.KVStore, throttle *scrubThrottle,
+	verify func(key, body []byte) error) (int64, error) {
+
+	iter, err := kvstore.IteratorInit(nil, nil, forestdb.ITR_NO_DELETES)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	var count int64
+	for {
+		doc, err := iter.Get()
+		if err == forestdb.FDB_RESULT_ITERATOR_FAIL {
+			// Empty kvstore
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+
+		key, body := doc.KeyNoCopy(), doc.BodyNoCopy()
+		if verify != nil {
+			err = verify(key, body)
+		}
+		if err == nil {
+			err = throttle.wait(len(key) + len(body))
+		}
+		doc.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+
+		if err := iter.Next(); err == forestdb.FDB_RESULT_ITERATOR_FAIL {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+	}
+}
+
+func isFdbCorruption(err error) bool {
+	return err == forestdb.FDB_CORRUPTION_ERR ||
+		err == forestdb.FDB_RESULT_CHECKSUM_ERROR ||
+		err == forestdb.FDB_RESULT_FILE_CORRUPTION
+}
+
 func tryDeleteFdbSlice(fdb *fdbSlice) {
 	logging.Infof("ForestDBSlice::Destroy Destroying Slice Id %v, IndexInstId %v, "+
 		"IndexDefnId %v", fdb.id, fdb.idxInstId, fdb.idxDefnId)
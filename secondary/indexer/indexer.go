@@ -799,6 +799,36 @@ func (idx *indexer) initFromConfig() {
 		common.SetDcpMemcachedTimeout(uint32(mcdTimeout.Int()))
 		logging.Infof("memcachedTimeout set to %v\n", uint32(mcdTimeout.Int()))
 	}
+
+	if err := updateAtRestKeyProvider(idx.config); err != nil {
+		logging.Fatalf("Indexer::initFromConfig Failed to set key provider of encryption at rest: %v", err)
+		common.CrashOnError(err)
+	}
+}
+
+// updateAtRestKeyProvider sets the key provider of encryption at rest as per
+// config. Files written from then on are encrypted with its active key.
+func updateAtRestKeyProvider(config common.Config) error {
+	migrate := config["encryption.atRest.migratePlaintext"].Bool()
+	security.SetAtRestMigration(migrate)
+	if migrate {
+		logging.Infof("Indexer: Files in plaintext are read for migration to encryption at rest")
+	}
+
+	keyFile := config["encryption.atRest.keyFile"].String()
+	if keyFile == "" {
+		security.SetAtRestKeyProvider(nil)
+		logging.Infof("Indexer: Encryption at rest is disabled")
+		return nil
+	}
+
+	provider, err := security.NewKeyFileProvider(keyFile)
+	if err != nil {
+		return err
+	}
+	security.SetAtRestKeyProvider(provider)
+	logging.Infof("Indexer: Encryption at rest is enabled with keyfile %v", keyFile)
+	return nil
 }
 
 func GetHTTPMux() *http.ServeMux {
@@ -1778,6 +1808,14 @@ func (idx *indexer) handleConfigUpdate(msg Message) {
 		}
 	}
 
+	if keyFile := newConfig["encryption.atRest.keyFile"].String(); keyFile != oldConfig["encryption.atRest.keyFile"].String() ||
+		newConfig["encryption.atRest.migratePlaintext"].Bool() != oldConfig["encryption.atRest.migratePlaintext"].Bool() {
+		if err := updateAtRestKeyProvider(newConfig); err != nil {
+			logging.Errorf("Indexer::handleConfigUpdate Failed to set keyfile %v of encryption at rest, "+
+				"continuing with the previous keys: %v", keyFile, err)
+		}
+	}
+
 	memdb.Debug(newConfig["settings.moi.debug"].Bool())
 	idx.setProfilerOptions(newConfig)
 	throttleVal := newConfig["cpu.throttle.target"].Float64()
@@ -1870,6 +1908,10 @@ func (idx *indexer) handleAdminMsgs(msg Message) (resp Message) {
 	case CLUST_MGR_PRUNE_PARTITION:
 		resp = idx.handlePrunePartition(msg)
 
+	case CLUST_MGR_UPDATE_AGGREGATES:
+		idx.handleUpdateAggregates(msg)
+		resp = &MsgSuccess{}
+
 	case MSG_ERROR:
 
 		logging.Fatalf("Indexer::handleAdminMsgs Fatal Error On Admin Channel %+v", msg)
@@ -3091,6 +3133,55 @@ func (idx *indexer) handlePrunePartition(msg Message) (resp Message) {
 	return
 }
 
+// handleUpdateAggregates updates the precomputed aggregates of all local
+// instances of an index definition.  The aggregates are already persisted
+// with the index definition in metadata.  Slices compute the aggregates
+// from their snapshot on the next matching scan.  Slices of an index that
+// had no aggregates when opened serve the aggregates only after the slices
+// are opened again (see isAggregateEligible); until then, matching scans
+// scan the index.
+func (idx *indexer) handleUpdateAggregates(msg Message) {
+
+	defnId := msg.(*MsgClustMgrUpdateAggregates).GetDefnId()
+	aggregates := msg.(*MsgClustMgrUpdateAggregates).GetAggregates()
+	respch := msg.(*MsgClustMgrUpdateAggregates).GetRespCh()
+
+	var instIds []common.IndexInstId
+	for instId, inst := range idx.indexInstMap {
+		if inst.Defn.DefnId != defnId {
+			continue
+		}
+
+		inst.Defn.Aggregates = common.CloneIndexAggregates(aggregates)
+		idx.indexInstMap[instId] = inst
+		instIds = append(instIds, instId)
+
+		for _, partnInst := range idx.indexPartnMap[instId] {
+			for _, slice := range partnInst.Sc.GetAllSlices() {
+				if s, ok := slice.(*aggregateSlice); ok {
+					s.setAggregates(inst.Defn.Aggregates)
+				} else if len(aggregates) != 0 {
+					logging.Infof("Indexer::handleUpdateAggregates Aggregates of index %v instance %v "+
+						"are served after the index is opened again", defnId, instId)
+				}
+			}
+		}
+
+		logging.Infof("Indexer::handleUpdateAggregates Updated aggregates for index %v instance %v: %v",
+			defnId, instId, aggregates)
+	}
+
+	if len(instIds) != 0 {
+		msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
+		msgUpdateIndexInstMap.AppendUpdatedInsts(idx.getInsts(instIds))
+		if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+			common.CrashOnError(err)
+		}
+	}
+
+	respch <- &MsgSuccess{}
+}
+
 // Prune partition is for updating indexer's state after a partition is
 // removed from an index instance.    When indexer handles this request,
 // the index inst metadata is already updated with the partitioned removed.
@@ -6140,6 +6231,10 @@ func (idx *indexer) initPartnInstance(indexInst common.IndexInst,
 		if shardRebalance {
 			shardIds = indexInst.Defn.ShardIdsForDest[partnId]
 		}
+		if bootstrapPhase && !shardRebalance {
+			// Partitions found corrupted by the scrubber are rebuilt from DCP
+			idx.resetScrubCorruptedPartition(&indexInst, partnId)
+		}
 		slice, err = NewSlice(SliceId(0), &indexInst, &partnInst, idx.config, idx.stats, ephemeral, !bootstrapPhase,
 			idx.meteringMgr, numVBuckets, shardIds)
 		if err != nil {
@@ -6364,7 +6459,8 @@ func (idx *indexer) checkDuplicateIndex(indexInst common.IndexInst,
 	}
 
 	//if the index name already exists for the same bucket,
-	//return error
+	//return error. The shadow index of ALTER INDEX REBUILD has
+	//the same name as the rebuilt index.
 	if !common.IsPartitioned(indexInst.Defn.PartitionScheme) {
 		for _, index := range idx.indexInstMap {
 
@@ -6372,6 +6468,8 @@ func (idx *indexer) checkDuplicateIndex(indexInst common.IndexInst,
 				index.Defn.Bucket == indexInst.Defn.Bucket &&
 				index.Defn.Scope == indexInst.Defn.Scope &&
 				index.Defn.Collection == indexInst.Defn.Collection &&
+				index.Defn.DefnId != indexInst.Defn.RebuildOf &&
+				index.Defn.RebuildOf != indexInst.Defn.DefnId &&
 				index.State != common.INDEX_STATE_DELETED {
 
 				logging.Errorf("Indexer::checkDuplicateIndex Duplicate Index Name. "+
@@ -6916,6 +7014,17 @@ func (idx *indexer) processBuildDoneCatchup(streamId common.StreamId,
 
 }
 
+// setBuildDoneTime records the completion of the build of the indexes, from
+// which an index that is not scanned is reported as unused by planner
+func (idx *indexer) setBuildDoneTime(indexList []common.IndexInst) {
+	now := time.Now().UnixNano()
+	for _, index := range indexList {
+		if idxStats, ok := idx.stats.indexes[index.InstId]; ok {
+			idxStats.setBuildDoneTime(now)
+		}
+	}
+}
+
 func (idx *indexer) processBuildDoneNoCatchup(streamId common.StreamId,
 	keyspaceId string, sessionId uint64, flushTs *common.TsVbuuid) {
 
@@ -6952,6 +7061,7 @@ func (idx *indexer) processBuildDoneNoCatchup(streamId common.StreamId,
 	}
 
 	idx.updateRStateForPendingReset(indexList)
+	idx.setBuildDoneTime(indexList)
 
 	//send updated maps to all workers
 	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
@@ -7173,6 +7283,7 @@ func (idx *indexer) handleMergeInitStream(msg Message) {
 	}
 
 	idx.updateRStateForPendingReset(indexList)
+	idx.setBuildDoneTime(indexList)
 
 	//send updated maps to all workers
 	msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
@@ -8349,7 +8460,7 @@ func (idx *indexer) handleStorageWarmupDone(msg Message) {
 	logging.Infof("Indexer::NewIndexer Status %v", idx.getIndexerState())
 
 	// Initialize the public REST API server after indexer bootstrap is completed
-	NewRestServer(idx.config["clusterAddr"].String(), idx.statsMgr)
+	NewRestServer(idx.config["clusterAddr"].String(), idx.statsMgr, idx.scanCoord)
 
 	go idx.monitorMemUsage()
 	go idx.logMemstats()
@@ -8974,6 +9085,37 @@ func (idx *indexer) backupCorruptIndexDataFiles(indexInst *common.IndexInst,
 	return
 }
 
+// Remove the data files of an index partition marked corrupted by the
+// scrubber, so that the partition is recovered without any snapshot and
+// is rebuilt from DCP. This needs to be called only during bootstrap.
+func (idx *indexer) resetScrubCorruptedPartition(indexInst *common.IndexInst,
+	partnId common.PartitionId) {
+
+	storageDir := idx.config["storage_dir"].String()
+	path := filepath.Join(storageDir, IndexPath(indexInst, partnId, SliceId(0)))
+	if !isScrubCorrupted(path) {
+		return
+	}
+
+	logMsg := "Rebuilding index %v, partition id %v, due to storage corruption detected by scrubber."
+	common.Console(idx.config["clusterAddr"].String(), logMsg, indexInst.Defn.Name, partnId)
+	logging.Warnf("Indexer::resetScrubCorruptedPartition %v %v Cleaning up data files",
+		indexInst.InstId, partnId)
+
+	// backup the corrupt index data files, if enabled
+	needsDataCleanup := true
+	if idx.config["settings.enable_corrupt_index_backup"].Bool() {
+		needsDataCleanup = idx.backupCorruptIndexDataFiles(indexInst, partnId, SliceId(0))
+	}
+
+	if needsDataCleanup {
+		if err := idx.forceCleanupPartitionData(indexInst, partnId, SliceId(0)); err != nil {
+			logging.Errorf("Indexer::resetScrubCorruptedPartition Error (%v) in cleaning up data files for %v %v",
+				err, indexInst.InstId, partnId)
+		}
+	}
+}
+
 // Force cleanup on index partition.
 // This needs to be called only during bootstrap.
 func (idx *indexer) forceCleanupIndexPartition(indexInst *common.IndexInst,
@@ -10308,6 +10450,12 @@ func NewSlice(id SliceId, indInst *common.IndexInst, partnInst *PartitionInst,
 			stats.GetPartitionStats(indInst.InstId, partitionId), stats, isNew, isInitialBuild(), meteringMgr, numVBuckets, indInst.ReplicaId, shardIds)
 	}
 
+	if err == nil && slice != nil && indInst.Defn.VectorMeta != nil {
+		slice = newVectorSlice(slice, indInst.Defn, isNew, getKeySizeConfig(conf))
+	} else if err == nil && slice != nil && isAggregateEligible(&indInst.Defn) {
+		slice = newAggregateSlice(slice, indInst.Defn)
+	}
+
 	return
 }
 
@@ -1592,7 +1592,10 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		protobuf.ExprType_value[strings.ToUpper(string(indexDefn.ExprType))]).Enum()
 	partnScheme := protobuf.PartitionScheme(
 		protobuf.PartitionScheme_value[string(c.SINGLE)]).Enum()
-	if c.IsPartitioned(indexDefn.PartitionScheme) {
+	if indexDefn.PartitionScheme == c.RANGE {
+		partnScheme = protobuf.PartitionScheme(
+			protobuf.PartitionScheme_value[string(c.RANGE)]).Enum()
+	} else if c.IsPartitioned(indexDefn.PartitionScheme) {
 		partnScheme = protobuf.PartitionScheme(
 			protobuf.PartitionScheme_value[string(c.KEY)]).Enum()
 	}
@@ -1608,6 +1611,7 @@ func convertIndexDefnToProtobuf(indexDefn c.IndexDefn) *protobuf.IndexDefn {
 		SecExpressions:         secExprs,
 		PartitionScheme:        partnScheme,
 		PartnExpressions:       indexDefn.PartitionKeys,
+		PartnRanges:            indexDefn.PartitionRanges,
 		HashScheme:             protobuf.HashScheme(indexDefn.HashScheme).Enum(),
 		WhereExpression:        proto.String(indexDefn.WhereExpr),
 		RetainDeletedXATTR:     proto.Bool(indexDefn.RetainDeletedXATTR),
@@ -0,0 +1,230 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// histogramCache holds the leading key histogram of index partitions. A
+// histogram is built from the snapshot on first request and is rebuilt
+// once the mutations indexed in the partition since it was built cross
+// settings.histogram.refresh_percent of its entries.
+type histogramCache struct {
+	mutex   sync.Mutex
+	entries map[histogramKey]*histogramEntry
+}
+
+type histogramKey struct {
+	instId  common.IndexInstId
+	partnId common.PartitionId
+}
+
+type histogramEntry struct {
+	mutex   sync.Mutex // serializes builds of the partition histogram
+	hist    *common.Histogram
+	numBins int
+	indexed int64 // mutations indexed in the partition when built
+}
+
+func (c *histogramCache) get(key histogramKey) *histogramEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[histogramKey]*histogramEntry)
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &histogramEntry{}
+		c.entries[key] = e
+	}
+	return e
+}
+
+// prune removes histograms of instances that are not in instMap.
+func (c *histogramCache) prune(instMap common.IndexInstMap) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.entries {
+		if _, ok := instMap[key.instId]; !ok {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// isStale returns true if the histogram has to be rebuilt, given the
+// mutations indexed in the partition so far.
+func (e *histogramEntry) isStale(numBins int, indexed int64, cfg common.Config) bool {
+	if e.hist == nil || e.numBins != numBins || indexed < e.indexed {
+		return true
+	}
+
+	threshold := e.hist.NumDocs * int64(cfg["settings.histogram.refresh_percent"].Int()) / 100
+	if minMutations := int64(cfg["settings.histogram.refresh_min_mutations"].Int()); threshold < minMutations {
+		threshold = minMutations
+	}
+	return indexed-e.indexed >= threshold
+}
+
+func (s *scanCoordinator) handleHistogramRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot, t0 time.Time) {
+
+	var err error
+	var snapshots []SliceSnapshot
+
+	waitTime := time.Since(t0)
+	defer func() {
+		s.recordSlowScan(req, 1, 0, 0, waitTime, time.Since(t0), err)
+	}()
+
+	cfg := s.config.Load()
+	numBins := req.histogramBins
+	if numBins <= 0 {
+		numBins = cfg["settings.histogram.num_bins"].Int()
+	}
+
+	stopch := make(StopChannel)
+	cancelCb := NewCancelCallback(req, func(e error) {
+		err = e
+		close(stopch)
+	})
+	cancelCb.Run()
+	defer cancelCb.Done()
+
+	var hists []*common.Histogram
+	if is != nil && !is.IsEpoch() {
+		if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+			for i, ss := range snapshots {
+				var hist *common.Histogram
+				hist, err = s.partitionHistogram(req, i, ss.Snapshot(), numBins, stopch, cfg)
+				if err != nil {
+					break
+				}
+				hists = append(hists, hist)
+			}
+		}
+	}
+
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	hist := common.MergeHistograms(hists, numBins)
+
+	logging.Verbosef("%s RESPONSE histogram docs:%d bins:%d status:ok",
+		req.LogPrefix, hist.NumDocs, len(hist.Bins))
+	err = w.Histogram(hist)
+	s.handleError(req.LogPrefix, err)
+}
+
+// partitionHistogram returns the cached histogram of the i-th partition of
+// the request, building it from snap if it is stale.
+func (s *scanCoordinator) partitionHistogram(req *ScanRequest, i int, snap Snapshot,
+	numBins int, stopch StopChannel, cfg common.Config) (*common.Histogram, error) {
+
+	partnId := req.PartitionIds[i]
+
+	var indexed int64
+	if req.Stats != nil {
+		if ps := req.Stats.getPartitionStats(partnId); ps != nil {
+			indexed = ps.numDocsIndexed.Value()
+		}
+	}
+
+	e := s.histograms.get(histogramKey{instId: req.IndexInstId, partnId: partnId})
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.isStale(numBins, indexed, cfg) {
+		return e.hist, nil
+	}
+
+	t0 := time.Now()
+	hist, err := buildLeadKeyHistogram(req, req.Ctxs[i], snap, numBins, stopch)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Infof("%v built histogram of partition %v docs %v bins %v in %v",
+		req.LogPrefix, partnId, hist.NumDocs, len(hist.Bins), time.Since(t0))
+
+	e.hist, e.numBins, e.indexed = hist, numBins, indexed
+	return hist, nil
+}
+
+// buildLeadKeyHistogram builds the histogram of the leading key from all
+// entries of the snapshot. Entries are visited in index order, so bins
+// are built in one pass without holding the keys.
+func buildLeadKeyHistogram(req *ScanRequest, ctx IndexReaderContext, snap Snapshot,
+	numBins int, stopch StopChannel) (*common.Histogram, error) {
+
+	total, err := snap.StatCountTotal()
+	if err != nil {
+		if total, err = snap.CountTotal(ctx, stopch); err != nil {
+			return nil, err
+		}
+	}
+
+	desc := req.IndexInst.Defn.Desc
+	builder := common.NewHistogramBuilder(int64(total), numBins)
+
+	var key, tmp []byte
+	err = snap.All(ctx, func(entry []byte) error {
+		select {
+		case <-stopch:
+			return common.ErrClientCancel
+		default:
+		}
+
+		if req.isPrimary {
+			k, err := encodeValue(string(entry))
+			if err != nil {
+				return err
+			}
+			builder.Add(k)
+			return nil
+		}
+
+		key = append(key[:0], entry...)
+		if desc != nil {
+			if _, err := jsonEncoder.ReverseCollate(key, desc); err != nil {
+				return err
+			}
+		}
+
+		e := secondaryIndexEntry(key)
+		key = key[:e.lenKey()]
+
+		if cap(tmp) < 3*len(key)+collatejson.MinBufferSize {
+			tmp = make([]byte, 0, 3*len(key)+collatejson.MinBufferSize)
+		}
+		keys, err := jsonEncoder.ExplodeArray(key, tmp[:0])
+		if err != nil {
+			return err
+		}
+		if len(keys) != 0 {
+			builder.Add(keys[0])
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return builder.Histogram(), nil
+}
@@ -44,6 +44,7 @@ const (
 )
 
 const tmpDirName = ".tmp"
+const incrDirName = ".incr"
 
 type indexMutation struct {
 	op    int
@@ -186,6 +187,14 @@ type memdbSlice struct {
 
 	// Used to request copy of item from storage instead of actual item
 	exposeItemCopy bool
+
+	// Set once the main and back index are found to disagree on a snapshot,
+	// for the scrubber to mark the slice to be rebuilt
+	mainBackMismatch int32
+
+	// Main index entry to continue checking against the back index from on
+	// the next snapshot, nil to start from the first
+	mainBackCursor []byte
 }
 
 // NewMemDBSlice is the constructor for memdbSlice.
@@ -317,6 +326,8 @@ func (mdb *memdbSlice) initStores() {
 	mdb.confLock.RLock()
 	useMemMgmt := mdb.sysconf["moi.useMemMgmt"].Bool()
 	useDeltaInterleaving := mdb.sysconf["moi.useDeltaInterleaving"].Bool()
+	useIncrementalPersistence := mdb.sysconf["moi.useIncrementalPersistence"].Bool()
+	maxDeltas := mdb.sysconf["moi.incrementalPersistence.maxDeltas"].Int()
 	ioConcurrency := mdb.sysconf["moi.persistence.io_concurrency"].Float64()
 	mdb.confLock.RUnlock()
 
@@ -329,6 +340,10 @@ func (mdb *memdbSlice) initStores() {
 		cfg.UseDeltaInterleaving()
 	}
 
+	if useIncrementalPersistence {
+		cfg.UseIncrementalPersistence(filepath.Join(mdb.path, incrDirName), maxDeltas)
+	}
+
 	cfg.SetExposeItemCopy(mdb.exposeItemCopy)
 	cfg.SetIOConcurrency(ioConcurrency)
 
@@ -1072,7 +1087,13 @@ func (mdb *memdbSlice) doPersistSnapshot(s *memdbSnapshot) {
 			defer func() {
 				<-moiWriterSemaphoreCh
 			}()
-			err := mdb.mainstore.StoreToDisk(tmpdir, s.info.MainSnap, concurrency, nil)
+			// With incremental persistence, only the changes since the
+			// latest disk snapshot are written
+			var baseDir string
+			if infos, _, _ := mdb.getSnapshots(); len(infos) > 0 {
+				baseDir = infos[0].(*memdbSnapshotInfo).dataPath
+			}
+			err := mdb.mainstore.StoreToDiskIncremental(tmpdir, baseDir, s.info.MainSnap, concurrency)
 			if err == nil {
 				// Add details to snapshot info
 				s.info.Version = SNAPSHOT_META_VERSION_MOI_1
@@ -1245,6 +1266,147 @@ func (mdb *memdbSlice) getSnapshots() ([]SnapshotInfo, []string, error) {
 	return infos, outfiles, nil
 }
 
+// Scrub verifies the checksums of the disk snapshots of the slice. If the
+// main and back index in memory have been found to disagree by
+// checkMainBack, the slice is marked to be rebuilt from DCP on the next
+// bootstrap instead. Only the main index is persisted, the back index is
+// rebuilt from it on recovery. The data in memory is not affected by a
+// corrupted disk snapshot, so the snapshot is removed, for recovery to use
+// an older snapshot, or to rebuild the slice from DCP if none is left, and
+// errScrubRepaired is returned. The next disk snapshot is persisted in full.
+func (mdb *memdbSlice) Scrub(throttle *scrubThrottle) (int64, error) {
+	if atomic.LoadInt32(&mdb.mainBackMismatch) == 1 {
+		if err := markScrubCorrupted(mdb.path); err != nil {
+			logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v failed to "+
+				"mark the slice (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, err)
+			return 0, err
+		}
+		return 0, errStorageCorrupted
+	}
+
+	infos, _, err := mdb.getSnapshots()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	var corrupted []string
+	for _, info := range infos {
+		dataPath := info.(*memdbSnapshotInfo).dataPath
+		n, err := mdb.mainstore.VerifyDiskSnapshot(dataPath, throttle.wait)
+		count += n
+		if err == memdb.ErrCorruptSnapshot {
+			logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v found "+
+				"corrupted disk snapshot %v", mdb.id, mdb.idxInstId, mdb.idxPartnId, dataPath)
+			corrupted = append(corrupted, dataPath)
+		} else if err != nil && !os.IsNotExist(err) {
+			// Snapshots removed by cleanup while verified are skipped
+			return count, err
+		}
+	}
+
+	if len(corrupted) == 0 {
+		return count, nil
+	}
+
+	// Disk snapshots are not written or cleaned up while removed
+	for !atomic.CompareAndSwapInt32(&mdb.isPersistorActive, 0, 1) {
+		if err := throttle.pause(time.Second); err != nil {
+			return count, err
+		}
+	}
+	defer atomic.StoreInt32(&mdb.isPersistorActive, 0)
+
+	for _, dataPath := range corrupted {
+		if err := iowrap.Os_RemoveAll(dataPath); err != nil {
+			logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v failed to "+
+				"remove corrupted disk snapshot %v (error=%v), marking the slice to be rebuilt",
+				mdb.id, mdb.idxInstId, mdb.idxPartnId, dataPath, err)
+			if err := markScrubCorrupted(mdb.path); err != nil {
+				logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v failed to "+
+					"mark the slice (error=%v)", mdb.id, mdb.idxInstId, mdb.idxPartnId, err)
+				return count, err
+			}
+			return count, errStorageCorrupted
+		}
+	}
+
+	return count, errScrubRepaired
+}
+
+// memdbMainBackCheckBatch is the number of main index entries checked
+// against the back index on a snapshot.
+const memdbMainBackCheckBatch = 1000
+
+// checkMainBack checks that the main index of snap agrees with the back
+// index. It is called on every snapshot, once the writers are done, for the
+// back index to be as of snap. The back index has an entry for every
+// document, or at least one for an array index, so the number of entries is
+// checked on every snapshot. Every entry of the main index is to be the one
+// of its document in the back index, or one of them for an array index. To
+// be cheap enough, the entries are checked memdbMainBackCheckBatch at a
+// time, continuing from the last one checked on the next snapshot.
+func (mdb *memdbSlice) checkMainBack(snap *memdb.Snapshot) error {
+	var backCount int64
+	for i := range mdb.back {
+		backCount += mdb.back[i].ItemsCount()
+	}
+
+	mainCount := snap.Count()
+	if mainCount != backCount && !(mdb.idxDefn.IsArrayIndex && mainCount > backCount) {
+		logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v main index has "+
+			"%v items, back index has %v items", mdb.id, mdb.idxInstId, mdb.idxPartnId, mainCount, backCount)
+		return errScrubMismatch
+	}
+
+	itr := snap.NewIterator()
+	if itr == nil {
+		return nil
+	}
+	defer itr.Close()
+
+	if mdb.mainBackCursor == nil {
+		itr.SeekFirst()
+	} else {
+		itr.Seek(mdb.mainBackCursor)
+	}
+	for n := 0; n < memdbMainBackCheckBatch && itr.Valid(); n++ {
+		if !mdb.hasBackNode(itr.GetNode()) {
+			logging.Errorf("MemDBSlice::Scrub Slice Id %v, IndexInstId %v, PartitionId %v docid %s "+
+				"of main index entry not found in back index", mdb.id, mdb.idxInstId, mdb.idxPartnId,
+				logging.TagStrUD(docIdFromEntryBytes(itr.Get())))
+			return errScrubMismatch
+		}
+		itr.Next()
+	}
+
+	mdb.mainBackCursor = nil
+	if itr.Valid() {
+		mdb.mainBackCursor = append([]byte(nil), itr.Get()...)
+	}
+	return nil
+}
+
+// hasBackNode returns true if the back index entry of the document of the
+// main index node is node, or a list of nodes including node for an array
+// index.
+func (mdb *memdbSlice) hasBackNode(node *skiplist.Node) bool {
+	entry := (*memdb.Item)(node.Item()).Bytes()
+	for i := range mdb.back {
+		head := (*skiplist.Node)(mdb.back[i].Get(entry))
+		if head == nil {
+			continue
+		}
+		for n := head; n != nil; n = n.GetLink() {
+			if n == node {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
 func (mdb *memdbSlice) setCommittedCount() {
 	prev := atomic.LoadUint64(&mdb.committedCount)
 	curr := mdb.mainstore.ItemsCount()
@@ -1559,6 +1721,12 @@ func (mdb *memdbSlice) NewSnapshot(ts *common.TsVbuuid, commit bool) (SnapshotIn
 		os.Exit(0)
 	}
 
+	if err == nil && !mdb.isPrimary && atomic.LoadInt32(&mdb.mainBackMismatch) == 0 {
+		if mdb.checkMainBack(snap) != nil {
+			atomic.StoreInt32(&mdb.mainBackMismatch, 1)
+		}
+	}
+
 	newSnapshotInfo := &memdbSnapshotInfo{
 		Ts:        ts,
 		MainSnap:  snap,
@@ -174,3 +174,64 @@ func TestMemDBInsertionPerf(t *testing.T) {
 		}
 	}
 }
+
+func TestMemDBCheckMainBack(t *testing.T) {
+	stats := &IndexStats{}
+	stats.Init()
+	cfg := common.SystemConfig.SectionConfig("indexer.", true)
+	cfg.SetValue("numSliceWriters", 1)
+	idxDefn := common.IndexDefn{DefnId: common.IndexDefnId(1)}
+	slice, err := NewMemDBSlice(t.TempDir(), SliceId(0), idxDefn, common.IndexInstId(1),
+		common.PartitionId(0), false, false, 1, cfg, stats, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slice.Close()
+
+	n := memdbMainBackCheckBatch*2 + memdbMainBackCheckBatch/2
+	for i := 0; i < n; i++ {
+		meta := NewMutationMeta()
+		slice.Insert([]byte(fmt.Sprintf("[\"key-%d\"]", i)), []byte(fmt.Sprintf("docid-%d", i)), meta)
+		meta.Free()
+	}
+
+	// Each snapshot checks a batch of entries, the cursor is reset once
+	// all the entries are checked
+	snapshot := func() {
+		info, err := slice.NewSnapshot(nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info.(*memdbSnapshotInfo).MainSnap.Close()
+	}
+	for i := 0; i < 3; i++ {
+		snapshot()
+		if (slice.mainBackCursor == nil) != (i == 2) {
+			t.Fatalf("Unexpected cursor %s after snapshot %v", slice.mainBackCursor, i)
+		}
+	}
+	if slice.mainBackMismatch != 0 {
+		t.Fatalf("Unexpected mismatch of main and back index")
+	}
+
+	// Back index entry of a document points to the node of another one,
+	// which keeps the counts equal
+	other := slice.back[0].Get(entryBytesFromDocId([]byte("docid-7")))
+	if updated, _ := slice.back[0].Update(entryBytesFromDocId([]byte("docid-2000")), other); !updated {
+		t.Fatalf("Failed to update back index")
+	}
+	for i := 0; i < 3 && slice.mainBackMismatch == 0; i++ {
+		snapshot()
+	}
+	if slice.mainBackMismatch != 1 {
+		t.Fatalf("Expected mismatch of main and back index")
+	}
+
+	throttle := &scrubThrottle{start: time.Now(), stopch: make(chan struct{})}
+	if _, err := slice.Scrub(throttle); err != errStorageCorrupted {
+		t.Fatalf("Expected %v, received %v", errStorageCorrupted, err)
+	}
+	if !isScrubCorrupted(slice.path) {
+		t.Fatalf("Expected slice to be marked corrupted")
+	}
+}
@@ -111,6 +111,7 @@ const (
 	CLUST_MGR_CLEANUP_PARTITION
 	CLUST_MGR_MERGE_PARTITION
 	CLUST_MGR_PRUNE_PARTITION
+	CLUST_MGR_UPDATE_AGGREGATES
 	CLUST_MGR_RECOVER_INDEX
 	CLUST_MGR_BUILD_RECOVERED_INDEXES
 	CLUST_MGR_INST_ASYNC_RECOVERY_DONE
@@ -1378,6 +1379,38 @@ func (m *MsgClustMgrPrunePartition) GetString() string {
 	return str
 }
 
+// CLUST_MGR_UPDATE_AGGREGATES
+type MsgClustMgrUpdateAggregates struct {
+	defnId     common.IndexDefnId
+	aggregates []*common.IndexAggregate
+	respCh     MsgChannel
+}
+
+func (m *MsgClustMgrUpdateAggregates) GetMsgType() MsgType {
+	return CLUST_MGR_UPDATE_AGGREGATES
+}
+
+func (m *MsgClustMgrUpdateAggregates) GetDefnId() common.IndexDefnId {
+	return m.defnId
+}
+
+func (m *MsgClustMgrUpdateAggregates) GetAggregates() []*common.IndexAggregate {
+	return m.aggregates
+}
+
+func (m *MsgClustMgrUpdateAggregates) GetRespCh() MsgChannel {
+	return m.respCh
+}
+
+func (m *MsgClustMgrUpdateAggregates) GetString() string {
+
+	str := "\n\tMessage: MsgClustMgrUpdateAggregates"
+	str += fmt.Sprintf("\n\tType: %v", CLUST_MGR_UPDATE_AGGREGATES)
+	str += fmt.Sprintf("\n\tdefn Id: %v", m.defnId)
+	str += fmt.Sprintf("\n\taggregates: %v", m.aggregates)
+	return str
+}
+
 // INDEXER_CANCEL_MERGE_PARTITION
 // CLUST_MGR_BUILD_INDEX_DDL
 // CLUST_MGR_BUILD_RECOVERED_INDEXES
@@ -3359,6 +3392,8 @@ func (m MsgType) String() string {
 		return "CLUST_MGR_MERGE_PARTITION"
 	case CLUST_MGR_PRUNE_PARTITION:
 		return "CLUST_MGR_PRUNE_PARTITION"
+	case CLUST_MGR_UPDATE_AGGREGATES:
+		return "CLUST_MGR_UPDATE_AGGREGATES"
 	case CLUST_MGR_RESET_INDEX_ON_UPGRADE:
 		return "CLUST_MGR_RESET_INDEX_ON_UPGRADE"
 	case CLUST_MGR_RESET_INDEX_ON_ROLLBACK:
@@ -24,6 +24,7 @@ type MutationMeta struct {
 	firstSnap  bool    //belongs to first DCP snapshot
 	projVer    c.ProjectorVersion
 	opaque     uint64
+	recvTime   int64 //time a sampled mutation was received from projector, in unix nanoseconds
 }
 
 var mutMetaPool = sync.Pool{New: newMutationMeta}
@@ -53,6 +54,7 @@ func (m *MutationMeta) Reset() {
 	m.firstSnap = false
 	m.projVer = c.ProjVer_5_1_0
 	m.opaque = 0
+	m.recvTime = 0
 }
 
 func (m *MutationMeta) Clone() *MutationMeta {
@@ -64,13 +66,14 @@ func (m *MutationMeta) Clone() *MutationMeta {
 	meta.firstSnap = m.firstSnap
 	meta.projVer = m.projVer
 	meta.opaque = m.opaque
+	meta.recvTime = m.recvTime
 	return meta
 }
 
 func (m *MutationMeta) Size() int64 {
 
 	size := int64(len(m.keyspaceId))
-	size += 8 + 4 + 8 + 8 + 8 //fixed cost of members
+	size += 8 + 4 + 8 + 8 + 8 + 8 //fixed cost of members
 	return size
 
 }
@@ -1064,6 +1064,9 @@ func (m *mutationMgr) persistMutationQueue(q IndexerMutationQueue,
 
 		//send the response to supervisor
 		if msg.GetMsgType() == MSG_SUCCESS {
+			// Range watches are notified before the snapshot of the flush is created
+			gRangeWatches.boundary(streamId, keyspaceId, ts, m.indexInstMap.Get())
+
 			m.supvRespch <- &MsgMutMgrFlushDone{mType: MUT_MGR_FLUSH_DONE,
 				streamId:   streamId,
 				keyspaceId: keyspaceId,
@@ -9,11 +9,19 @@
 package indexer
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/iowrap"
 	"github.com/couchbase/indexing/secondary/logging"
 )
@@ -26,20 +34,55 @@ import (
 //   o az:// - Azure cloud storage
 //   o gs:// - Google cloud storage
 //
-// Eventually this will use the tools-common/objstore/objutil/ library for all of these, however
-// that library's initial implementation only handles AWS S3, so initially the PauseObjutil class
-// will implement support for local filesystem directly.
+// Local filesystem and AWS S3, or an S3-compatible store at indexer.pause_resume.blob_storage_endpoint
+// such as a local stand-in for testing, are supported; Azure and Google cloud storage are not yet.
 //
-// kjc This class is implementing local FS support first; cloud storage work not yet started.
+// S3 access uses the AWS SDK. Files larger than indexer.pause_resume.blob_storage_part_size are
+// written by multipart upload, failed requests are retried by the SDK with exponential backoff up
+// to indexer.pause_resume.blob_storage_max_retries times, and the Content-MD5 of every request
+// body is sent for S3 to verify on receipt. An upload that fails is aborted so that its parts are
+// not left behind in the bucket.
+//
+// Pause-Resume and shard transfer stage index data in cloud storage with the plasma file copier,
+// which is given the same endpoint. CheckAccess verifies an s3:// target before such a transfer is
+// started.
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
+const (
+	S3_MIN_PART_SIZE = 5 * 1024 * 1024 // S3 minimum size of all but the last part of an upload
+	S3_MAX_PARTS     = 10000           // S3 maximum number of parts of an upload
+
+	s3DefaultRegion = "us-east-1"
+)
+
 // PauseObjutil object holds the state for a session of file transfer activity.
 type PauseObjutil struct {
+	region string // region of the archive storage bucket, for cloud storage
+
+	// S3 settings
+	endpoint      string        // custom S3 endpoint, or "" for AWS
+	partSize      int64         // size of the parts of multipart uploads
+	maxRetries    int           // retries of a failed request
+	accessTimeout time.Duration // timeout of CheckAccess
 }
 
-// NewPauseObjutil is the constructor for the PauseObjutil class.
-func NewPauseObjutil() *PauseObjutil {
-	return &PauseObjutil{}
+// NewPauseObjutil is the constructor for the PauseObjutil class. region is the region of the archive
+// storage bucket, if any.
+func NewPauseObjutil(region string, config common.Config) *PauseObjutil {
+	this := &PauseObjutil{region: region}
+	if val, ok := config["pause_resume.blob_storage_endpoint"]; ok {
+		this.endpoint = val.String()
+	}
+	if val, ok := config["pause_resume.blob_storage_part_size"]; ok {
+		this.partSize = int64(val.Int())
+	}
+	if val, ok := config["pause_resume.blob_storage_max_retries"]; ok {
+		this.maxRetries = val.Int()
+	}
+	if val, ok := config["pause_resume.blob_storage_access_timeout"]; ok {
+		this.accessTimeout = time.Duration(val.Int()) * time.Second
+	}
+	return this
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -91,13 +134,13 @@ type ReadAtSeeker interface {
 // PauseObjutil APIs
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// Upload wraps the tools-common/objstore/objutil/upload.go Upload method. It writes a single file
-// to archive storage.
-//   remotePath -- path to target "directory" (with xx:// prefix; no prefix = local FS)
-//   fileName -- target file name to write in archiveDir
-//   body -- reader for the source data
-// kjc Eventually this will delegate to objutil.Upload but for now it is local impl of local FS only
-func Upload(remotePath, fileName string, body ReadAtSeeker) error {
+// Upload writes a single file to archive storage.
+//
+//	remotePath -- path to target "directory" (with xx:// prefix; no prefix = local FS)
+//	fileName -- target file name to write in archiveDir
+//	body -- reader for the source data
+func (this *PauseObjutil) Upload(ctx context.Context, remotePath, fileName string,
+	body ReadAtSeeker) error {
 	const _Upload = "PauseObjutil::Upload:"
 
 	// Verify archive type is supported
@@ -106,17 +149,27 @@ func Upload(remotePath, fileName string, body ReadAtSeeker) error {
 		logging.Errorf("%v ArchiveInfoFromRemotePath error: %v", _Upload, err)
 		return err
 	}
-	if archiveType != Archive_FILE {
+	switch archiveType {
+	case Archive_FILE:
+		return uploadFile(archiveDir, fileName, body)
+	case Archive_S3:
+		return this.uploadS3(ctx, archiveDir, fileName, body)
+	default:
 		err = fmt.Errorf("%v Unsupported archive type %v", _Upload, archiveType.String())
 		logging.Errorf("%v", err.Error())
 		return err
 	}
+}
+
+// uploadFile writes a single file to the local filesystem directory archiveDir.
+func uploadFile(archiveDir, fileName string, body ReadAtSeeker) error {
+	const _uploadFile = "PauseObjutil::uploadFile:"
 
 	// Create target directory if needed
 	var fileMode os.FileMode = 0700
-	err = iowrap.Os_MkdirAll(archiveDir, fileMode)
+	err := iowrap.Os_MkdirAll(archiveDir, fileMode)
 	if err != nil {
-		err = fmt.Errorf("%v Os_MkdirAll(%v, %#o) error: %v", _Upload, archiveDir, fileMode, err)
+		err = fmt.Errorf("%v Os_MkdirAll(%v, %#o) error: %v", _uploadFile, archiveDir, fileMode, err)
 		logging.Errorf("%v", err.Error())
 		return err
 	}
@@ -125,7 +178,7 @@ func Upload(remotePath, fileName string, body ReadAtSeeker) error {
 	fullPath := archiveDir + fileName
 	fileHandle, err := iowrap.Os_Create(fullPath)
 	if err != nil {
-		err = fmt.Errorf("%v Os_Create(%v) error: %v", _Upload, fullPath, err)
+		err = fmt.Errorf("%v Os_Create(%v) error: %v", _uploadFile, fullPath, err)
 		logging.Errorf("%v", err.Error())
 		return err
 	}
@@ -135,7 +188,7 @@ func Upload(remotePath, fileName string, body ReadAtSeeker) error {
 	fileMode = 0600
 	err = iowrap.File_Chmod(fileHandle, fileMode)
 	if err != nil {
-		err = fmt.Errorf("%v File_Chmod(fileHandle, %#o) error: %v", _Upload, fileMode, err)
+		err = fmt.Errorf("%v File_Chmod(fileHandle, %#o) error: %v", _uploadFile, fileMode, err)
 		logging.Errorf("%v", err.Error())
 		return err
 	}
@@ -147,15 +200,204 @@ func Upload(remotePath, fileName string, body ReadAtSeeker) error {
 		bytesRead, err = iowrap.Io_Read(body, buffer)
 		_, err2 := iowrap.File_Write(fileHandle, buffer[:bytesRead]) // process bytesRead before err
 		if err != nil && err != io.EOF {
-			err = fmt.Errorf("%v Io_Read error: %v", _Upload, err)
+			err = fmt.Errorf("%v Io_Read error: %v", _uploadFile, err)
 			logging.Errorf("%v", err.Error())
 			return err
 		}
 		if err2 != nil {
-			err = fmt.Errorf("%v File_Write error: %v", _Upload, err2)
+			err = fmt.Errorf("%v File_Write error: %v", _uploadFile, err2)
+			logging.Errorf("%v", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadS3 writes a single file to the S3 archiveDir. A file no larger than the part size is
+// written by a single PutObject, a larger one by a multipart upload of its parts in order.
+func (this *PauseObjutil) uploadS3(ctx context.Context, archiveDir, fileName string,
+	body ReadAtSeeker) error {
+	const _uploadS3 = "PauseObjutil::uploadS3:"
+
+	bucket, prefix, err := S3BucketAndPrefix(archiveDir)
+	if err != nil {
+		logging.Errorf("%v %v", _uploadS3, err)
+		return err
+	}
+	key := prefix + fileName
+
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		err = fmt.Errorf("%v Seek error: %v", _uploadS3, err)
+		logging.Errorf("%v", err.Error())
+		return err
+	}
+
+	client, err := this.newS3Client()
+	if err != nil {
+		err = fmt.Errorf("%v Failed to create S3 client: %v", _uploadS3, err)
+		logging.Errorf("%v", err.Error())
+		return err
+	}
+
+	partSize := this.s3PartSize(size)
+	if size <= partSize {
+		part := io.NewSectionReader(body, 0, size)
+		md5sum, err := s3ContentMD5(part)
+		if err == nil {
+			_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket:        aws.String(bucket),
+				Key:           aws.String(key),
+				Body:          part,
+				ContentLength: aws.Int64(size),
+				ContentMD5:    aws.String(md5sum),
+			})
+		}
+		if err != nil {
+			err = fmt.Errorf("%v PutObject(%v, %v) error: %v", _uploadS3, bucket, key, err)
 			logging.Errorf("%v", err.Error())
 			return err
 		}
+		return nil
+	}
+
+	created, err := client.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		err = fmt.Errorf("%v CreateMultipartUpload(%v, %v) error: %v", _uploadS3, bucket, key, err)
+		logging.Errorf("%v", err.Error())
+		return err
+	}
+	uploadId := created.UploadId
+
+	// abort removes the parts uploaded so far. It is not bound by ctx, which may be the cause of
+	// the failure.
+	abort := func(err error) error {
+		logging.Errorf("%v", err.Error())
+		_, aerr := client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      aws.String(key),
+			UploadId: uploadId,
+		})
+		if aerr != nil {
+			logging.Warnf("%v AbortMultipartUpload(%v, %v) error: %v", _uploadS3, bucket, key, aerr)
+		}
+		return err
+	}
+
+	var parts []*s3.CompletedPart
+	partNum := int64(1)
+	for offset := int64(0); offset < size; offset += partSize {
+		partLen := partSize
+		if size-offset < partLen {
+			partLen = size - offset
+		}
+		part := io.NewSectionReader(body, offset, partLen)
+		md5sum, err := s3ContentMD5(part)
+		if err != nil {
+			return abort(fmt.Errorf("%v Read of part %v error: %v", _uploadS3, partNum, err))
+		}
+
+		uploaded, err := client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadId,
+			PartNumber:    aws.Int64(partNum),
+			Body:          part,
+			ContentLength: aws.Int64(part.Size()),
+			ContentMD5:    aws.String(md5sum),
+		})
+		if err != nil {
+			return abort(fmt.Errorf("%v UploadPart(%v, %v) part %v error: %v", _uploadS3,
+				bucket, key, partNum, err))
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: uploaded.ETag, PartNumber: aws.Int64(partNum)})
+		partNum++
+	}
+
+	_, err = client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(fmt.Errorf("%v CompleteMultipartUpload(%v, %v) error: %v", _uploadS3,
+			bucket, key, err))
+	}
+	return nil
+}
+
+// s3PartSize returns the size of the parts of a multipart upload of size bytes: the configured part
+// size, raised to the S3 minimum and to as much as keeps the upload within the S3 maximum parts.
+func (this *PauseObjutil) s3PartSize(size int64) int64 {
+	partSize := this.partSize
+	if partSize < S3_MIN_PART_SIZE {
+		partSize = S3_MIN_PART_SIZE
+	}
+	if minSize := (size + S3_MAX_PARTS - 1) / S3_MAX_PARTS; partSize < minSize {
+		partSize = minSize
+	}
+	return partSize
+}
+
+// s3ContentMD5 returns the base64 encoded MD5 digest of part, for the Content-MD5 header which S3
+// verifies the body of the request against, and rewinds part for the request to read.
+func s3ContentMD5(part io.ReadSeeker) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return "", err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// AccessTimeout returns the configured timeout of CheckAccess, to bound the context given to it.
+func (this *PauseObjutil) AccessTimeout() time.Duration {
+	return this.accessTimeout
+}
+
+// CheckAccess verifies that remotePath can be reached with the credentials of this node, so that a
+// transfer that cannot stage data there fails before it is started. For Archive_S3 it lists at most
+// one object under the prefix of remotePath, which needs only read access to the bucket; no probe
+// object is written, so permission to write is verified by the transfer itself. Other archive
+// types are not checked.
+func (this *PauseObjutil) CheckAccess(ctx context.Context, remotePath string) error {
+	const _CheckAccess = "PauseObjutil::CheckAccess:"
+
+	archiveType, archiveDir, err := ArchiveInfoFromRemotePath(remotePath)
+	if err != nil {
+		return err
+	}
+	if archiveType != Archive_S3 {
+		return nil
+	}
+
+	bucket, prefix, err := S3BucketAndPrefix(archiveDir)
+	if err != nil {
+		logging.Errorf("%v %v", _CheckAccess, err)
+		return err
+	}
+	client, err := this.newS3Client()
+	if err != nil {
+		err = fmt.Errorf("%v Failed to create S3 client: %v", _CheckAccess, err)
+		logging.Errorf("%v", err.Error())
+		return err
+	}
+
+	_, err = client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		err = fmt.Errorf("%v Failed to list %v: %v", _CheckAccess, remotePath, err)
+		logging.Errorf("%v", err.Error())
+		return err
 	}
 	return nil
 }
@@ -224,3 +466,41 @@ func ArchiveInfoFromRemotePath(remotePath string) (
 
 	return archiveType, archiveDir, nil
 }
+
+// newS3Client returns a client of AWS S3, or of the S3-compatible store at the custom endpoint using
+// path-style addressing. Credentials are looked up by the default chain of the AWS SDK, i.e. the
+// environment, the shared credentials file and the IAM role of the EC2 instance.
+func (this *PauseObjutil) newS3Client() (*s3.S3, error) {
+	cfg := aws.NewConfig().WithRegion(this.region)
+	if this.region == "" {
+		cfg.WithRegion(s3DefaultRegion)
+	}
+	if this.endpoint != "" {
+		cfg.WithEndpoint(this.endpoint).WithS3ForcePathStyle(true)
+	}
+
+	if this.maxRetries > 0 {
+		cfg.WithMaxRetries(this.maxRetries)
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// S3BucketAndPrefix splits an Archive_S3 archiveDir from ArchiveInfoFromRemotePath, of the form
+// "s3://bucket/prefix/", into its bucket and key prefix.
+func S3BucketAndPrefix(archiveDir string) (bucket, prefix string, err error) {
+	path := strings.TrimPrefix(archiveDir, "s3://")
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		bucket, prefix = path[:idx], path[idx+1:]
+	} else {
+		bucket = path
+	}
+	if bucket == "" {
+		return "", "", fmt.Errorf("Missing bucket in S3 archive path '%v'", archiveDir)
+	}
+	return bucket, prefix, nil
+}
@@ -0,0 +1,279 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// testS3Server is an S3-compatible stand-in holding a single bucket in memory, which fails the first
+// request with SlowDown and an upload of part failPart, if set, with AccessDenied.
+type testS3Server struct {
+	sync.Mutex
+	bucket   string
+	failPart string
+	methods  []string
+	objects  map[string][]byte
+	uploads  map[string]map[string][]byte // parts by part number by upload id
+}
+
+func (s *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<Error><Code>%v</Code><Message>%v</Message></Error>", code, code)
+	}
+
+	s.methods = append(s.methods, r.Method)
+	if len(s.methods) == 1 {
+		fail(http.StatusServiceUnavailable, "SlowDown")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		fail(http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if r.URL.Path != "/"+s.bucket && !strings.HasPrefix(r.URL.Path, "/"+s.bucket+"/") {
+		fail(http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+		s.uploads = make(map[string]map[string][]byte)
+	}
+
+	query := r.URL.Query()
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket+"/")
+	uploadId := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/"+s.bucket && query.Get("list-type") == "2":
+		fmt.Fprintf(w, "<ListBucketResult><Name>%v</Name><Prefix>%v</Prefix><KeyCount>0</KeyCount>"+
+			"<MaxKeys>1</MaxKeys><IsTruncated>false</IsTruncated></ListBucketResult>",
+			s.bucket, query.Get("prefix"))
+
+	case r.Method == http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			fail(http.StatusBadRequest, "BadDigest")
+			return
+		}
+		if uploadId == "" {
+			s.objects[key] = body
+		} else if parts, ok := s.uploads[uploadId]; !ok {
+			fail(http.StatusNotFound, "NoSuchUpload")
+			return
+		} else if query.Get("partNumber") == s.failPart {
+			fail(http.StatusForbidden, "AccessDenied")
+			return
+		} else {
+			parts[query.Get("partNumber")] = body
+		}
+		w.Header().Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(sum[:])))
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadId = fmt.Sprintf("upload_%v", len(s.methods))
+		s.uploads[uploadId] = make(map[string][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key>"+
+			"<UploadId>%v</UploadId></InitiateMultipartUploadResult>", s.bucket, key, uploadId)
+
+	case r.Method == http.MethodPost && uploadId != "":
+		var complete struct {
+			Parts []struct {
+				ETag       string
+				PartNumber string
+			} `xml:"Part"`
+		}
+		parts, ok := s.uploads[uploadId]
+		if !ok {
+			fail(http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			fail(http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var object []byte
+		for _, part := range complete.Parts {
+			sum := md5.Sum(parts[part.PartNumber])
+			if part.ETag != fmt.Sprintf("%q", hex.EncodeToString(sum[:])) {
+				fail(http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			object = append(object, parts[part.PartNumber]...)
+		}
+		s.objects[key] = object
+		delete(s.uploads, uploadId)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%v</Bucket><Key>%v</Key>"+
+			"</CompleteMultipartUploadResult>", s.bucket, key)
+
+	case r.Method == http.MethodDelete && uploadId != "":
+		delete(s.uploads, uploadId)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		fail(http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// newTestS3Objutil returns a PauseObjutil of an S3 stand-in at url, with credentials it accepts.
+func newTestS3Objutil(t *testing.T, url string) *PauseObjutil {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("pause_resume.blob_storage_endpoint", url)
+	return NewPauseObjutil("us-west-2", config)
+}
+
+func TestPauseObjutilCheckAccess(t *testing.T) {
+	s3srv := &testS3Server{bucket: "bucket"}
+	srv := httptest.NewServer(s3srv)
+	defer srv.Close()
+
+	objutil := newTestS3Objutil(t, srv.URL)
+	ctx := context.Background()
+
+	// Throttled requests are retried
+	if err := objutil.CheckAccess(ctx, "s3://bucket/prefix/node_1"); err != nil {
+		t.Errorf("CheckAccess failed: %v", err)
+	}
+
+	// Access is checked without writing to the bucket
+	for _, method := range s3srv.methods {
+		if method != http.MethodGet {
+			t.Errorf("Unexpected %v request", method)
+		}
+	}
+
+	if err := objutil.CheckAccess(ctx, "s3://other/prefix"); err == nil {
+		t.Errorf("Expected CheckAccess of a missing bucket to fail")
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "other")
+	if err := objutil.CheckAccess(ctx, "s3://bucket/prefix/node_1"); err == nil {
+		t.Errorf("Expected CheckAccess without access to fail")
+	}
+
+	// Local filesystem is not checked
+	if err := objutil.CheckAccess(ctx, "file://"+t.TempDir()); err != nil {
+		t.Errorf("CheckAccess of local filesystem failed: %v", err)
+	}
+}
+
+func TestPauseObjutilUpload(t *testing.T) {
+	s3srv := &testS3Server{bucket: "bucket"}
+	srv := httptest.NewServer(s3srv)
+	defer srv.Close()
+
+	objutil := newTestS3Objutil(t, srv.URL)
+	objutil.partSize = S3_MIN_PART_SIZE
+	ctx := context.Background()
+
+	small := []byte("small file")
+	large := make([]byte, 2*S3_MIN_PART_SIZE+1024)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	// Throttled requests are retried with their body
+	if err := objutil.Upload(ctx, "s3://bucket/prefix/node_1", "small", bytes.NewReader(small)); err != nil {
+		t.Fatalf("Upload of small file failed: %v", err)
+	}
+	if !bytes.Equal(s3srv.objects["prefix/node_1/small"], small) {
+		t.Errorf("Unexpected small object %q", s3srv.objects["prefix/node_1/small"])
+	}
+
+	// Larger files are uploaded in parts
+	if err := objutil.Upload(ctx, "s3://bucket/prefix/node_1", "large", bytes.NewReader(large)); err != nil {
+		t.Fatalf("Upload of large file failed: %v", err)
+	}
+	if !bytes.Equal(s3srv.objects["prefix/node_1/large"], large) {
+		t.Errorf("Unexpected large object of %v bytes", len(s3srv.objects["prefix/node_1/large"]))
+	}
+	if n := len(s3srv.methods); n != 7 {
+		t.Errorf("Expected 7 requests, received %v: %v", n, s3srv.methods)
+	}
+
+	// A failed upload is aborted
+	s3srv.failPart = "2"
+	if err := objutil.Upload(ctx, "s3://bucket/prefix/node_1", "failed", bytes.NewReader(large)); err == nil {
+		t.Errorf("Expected Upload to fail")
+	}
+	if _, ok := s3srv.objects["prefix/node_1/failed"]; ok || len(s3srv.uploads) != 0 {
+		t.Errorf("Expected no object and no pending upload, received %v uploads", len(s3srv.uploads))
+	}
+
+	// Local filesystem
+	dir := t.TempDir()
+	if err := objutil.Upload(ctx, "file://"+dir, "small", bytes.NewReader(small)); err != nil {
+		t.Fatalf("Upload to local filesystem failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "small")); err != nil || !bytes.Equal(data, small) {
+		t.Errorf("Unexpected file %q error %v", data, err)
+	}
+
+	if err := objutil.Upload(ctx, "gs://bucket", "small", bytes.NewReader(small)); err == nil {
+		t.Errorf("Expected Upload to Google cloud storage to fail")
+	}
+}
+
+func TestS3PartSize(t *testing.T) {
+	const MiB = 1024 * 1024
+
+	objutil := &PauseObjutil{}
+	for _, tc := range []struct {
+		partSize, size, expected int64
+	}{
+		{16 * MiB, 1024, 16 * MiB},
+		{1024, 1024 * MiB, S3_MIN_PART_SIZE},
+		{16 * MiB, S3_MAX_PARTS * 16 * MiB, 16 * MiB},
+		{16 * MiB, S3_MAX_PARTS * 20 * MiB, 20 * MiB},
+		{16 * MiB, S3_MAX_PARTS*20*MiB + 1, 20*MiB + 1},
+	} {
+		objutil.partSize = tc.partSize
+		if partSize := objutil.s3PartSize(tc.size); partSize != tc.expected {
+			t.Errorf("Part size %v of %v: expected %v, received %v", tc.partSize, tc.size,
+				tc.expected, partSize)
+		}
+	}
+}
+
+func TestS3BucketAndPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		archiveDir, bucket, prefix string
+	}{
+		{"s3://bucket/", "bucket", ""},
+		{"s3://bucket/prefix/node_1/", "bucket", "prefix/node_1/"},
+	} {
+		bucket, prefix, err := S3BucketAndPrefix(tc.archiveDir)
+		if err != nil || bucket != tc.bucket || prefix != tc.prefix {
+			t.Errorf("%v: expected %v %v, received %v %v %v", tc.archiveDir, tc.bucket, tc.prefix,
+				bucket, prefix, err)
+		}
+	}
+	if _, _, err := S3BucketAndPrefix("s3:///prefix/"); err == nil {
+		t.Errorf("Expected error for missing bucket")
+	}
+}
@@ -1140,7 +1140,20 @@ func (m *PauseServiceManager) PreparePause(params service.PauseParams) (err erro
 		return err
 	}
 
-	// TODO: Check remotePath access?
+	// Check remotePath access, so that a pause that cannot upload fails at prepare time
+	if archiveType, _, err := ArchiveInfoFromRemotePath(params.RemotePath); err != nil {
+		return err
+	} else if archiveType == Archive_S3 {
+		objutil := NewPauseObjutil(params.BlobStorageRegion, m.config.Load())
+		ctx, cancel := context.WithTimeout(context.Background(), objutil.AccessTimeout())
+		err = objutil.CheckAccess(ctx, params.RemotePath)
+		cancel()
+		if err != nil {
+			logging.Errorf("PauseServiceManager::PreparePause: No access to remotePath[%v]: err[%v]",
+				params.RemotePath, err)
+			return err
+		}
+	}
 
 	// Set PauseResumeRunning flag
 	if err := m.initPreparePhasePauseResume(PauseTokenPause, params.Bucket, params.ID); err != nil {
@@ -0,0 +1,611 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+// Errors of range watches
+var (
+	ErrWatchUnsupported = errors.New("Range watch is not supported on primary, array, vector, partitioned or descending index")
+	ErrWatchLimit       = errors.New("Too many range watches on the indexer. Please retry the request later.")
+	ErrWatchKeysLimit   = errors.New("Too many entries in the range of the watch")
+	ErrWatchOverflow    = errors.New("Range watch client is too slow to receive the changes")
+)
+
+// Maximum size of the keys of the events sent in a WatchResponse
+const watchMaxResponseSize = 256 * 1024
+
+// gRangeWatches is the registry of range watches, published to by the flusher
+var gRangeWatches rangeWatchRegistry
+
+// rangeWatchRegistry keeps track of the range watches by index instance, for
+// the flusher to publish the changes to the entries of the index.
+type rangeWatchRegistry struct {
+	mutex   sync.RWMutex
+	watches map[common.IndexInstId][]*rangeWatch
+	count   int64 // number of watches, checked by the flusher without lock
+
+	maxWatches int
+	maxEvents  int
+	maxKeys    int
+	keySzCfg   keySizeConfig // for the entries of the changes, as in storage
+}
+
+// rangeWatch is a watch on the entries of an index in a key range. It keeps
+// the docids of the entries in the range, to tell inserts and updates apart
+// and to detect the documents that leave the range.
+//
+// The changes received while the snapshot of the watch is being scanned are
+// held as pending, and only the ones more recent than the snapshot are applied
+// once the watch is live.
+type rangeWatch struct {
+	instId    common.IndexInstId
+	low, high IndexKey
+	incl      Inclusion
+	maxEvents int
+	maxKeys   int
+
+	mutex   sync.Mutex
+	live    bool
+	members map[string][]byte // docid to JSON encoded key of the entries in the range
+	pending []watchChange
+	events  []*protobuf.WatchEvent
+	err     error
+
+	// Boundary upto which the changes have been applied, and the one last
+	// sent to the client
+	seqnos  []uint64
+	vbuuids []uint64
+	sent    []uint64
+
+	notifych chan struct{}
+}
+
+type watchChange struct {
+	docid   string
+	key     []byte // nil if the document is not in the range
+	vbucket Vbucket
+	seqno   uint64
+}
+
+// configure sets the limits of the watches from the indexer config. Limits
+// apply to the watches added after.
+func (reg *rangeWatchRegistry) configure(config common.Config) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	reg.maxWatches = config["scan.watch.max_watches"].Int()
+	reg.maxEvents = config["scan.watch.max_buffered_events"].Int()
+	reg.maxKeys = config["scan.watch.max_keys"].Int()
+	reg.keySzCfg = getKeySizeConfig(config)
+}
+
+// add registers a watch on the range of the request. The watch must be added
+// before the snapshot of the request is taken, so that no change after the
+// snapshot is missed.
+func (reg *rangeWatchRegistry) add(req *ScanRequest) (*rangeWatch, error) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	if int(atomic.LoadInt64(&reg.count)) >= reg.maxWatches {
+		return nil, ErrWatchLimit
+	}
+
+	w := &rangeWatch{
+		instId:    req.IndexInstId,
+		low:       req.Low,
+		high:      req.High,
+		incl:      req.Incl,
+		maxEvents: reg.maxEvents,
+		maxKeys:   reg.maxKeys,
+		members:   make(map[string][]byte),
+		notifych:  make(chan struct{}, 1),
+	}
+
+	if reg.watches == nil {
+		reg.watches = make(map[common.IndexInstId][]*rangeWatch)
+	}
+	reg.watches[w.instId] = append(reg.watches[w.instId], w)
+	atomic.AddInt64(&reg.count, 1)
+
+	return w, nil
+}
+
+func (reg *rangeWatchRegistry) remove(w *rangeWatch) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	// Watches of an index are copied on remove, as the flusher reads them
+	// without lock
+	watches := reg.watches[w.instId]
+	for i, w1 := range watches {
+		if w1 == w {
+			if len(watches) == 1 {
+				delete(reg.watches, w.instId)
+			} else {
+				reg.watches[w.instId] = append(watches[:i:i], watches[i+1:]...)
+			}
+			atomic.AddInt64(&reg.count, -1)
+			return
+		}
+	}
+}
+
+// publish applies the mutation of a document, as flushed to the index, to
+// the watches on the index.
+func (reg *rangeWatchRegistry) publish(mut *Mutation, docid []byte, meta *MutationMeta) {
+
+	if atomic.LoadInt64(&reg.count) == 0 {
+		return
+	}
+
+	reg.mutex.RLock()
+	watches := reg.watches[mut.uuid]
+	keySzCfg := reg.keySzCfg
+	reg.mutex.RUnlock()
+
+	if len(watches) == 0 {
+		return
+	}
+
+	// Entry of the document, nil if the document is deleted or not indexed.
+	// Storage skips the keys it fails to make an entry of, e.g. as too long,
+	// and deletes the previous entry of the document, and so do the watches.
+	var entry *secondaryIndexEntry
+	if mut.command == common.Upsert {
+		e, err := NewSecondaryIndexEntry(mut.key, docid, false, 1, nil, nil, meta, keySzCfg)
+		if err == nil {
+			entry = &e
+		}
+	}
+
+	var key []byte
+	id := string(docid)
+	for _, w := range watches {
+		change := watchChange{docid: id, vbucket: meta.vbucket, seqno: meta.seqno}
+
+		if entry != nil && w.inRange(entry) {
+			if key == nil {
+				var err error
+				if key, err = entry.ReadSecKey(nil); err != nil {
+					logging.Errorf("RangeWatch::publish Error decoding key %v of docid %v: %v",
+						logging.TagUD(mut.key), logging.TagStrUD(docid), err)
+					return
+				}
+			}
+			change.key = key
+		}
+
+		w.apply(change)
+	}
+}
+
+// boundary notifies the watches of the indexes of the keyspace in the stream
+// that all changes upto ts have been flushed.
+func (reg *rangeWatchRegistry) boundary(streamId common.StreamId, keyspaceId string,
+	ts *common.TsVbuuid, indexInstMap common.IndexInstMap) {
+
+	if atomic.LoadInt64(&reg.count) == 0 || ts == nil {
+		return
+	}
+
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	for instId, watches := range reg.watches {
+		inst, ok := indexInstMap[instId]
+		if !ok || inst.Stream != streamId || inst.Defn.KeyspaceId(streamId) != keyspaceId {
+			continue
+		}
+
+		for _, w := range watches {
+			w.setBoundary(ts)
+		}
+	}
+}
+
+// abort fails the watches of the indexes of the keyspace in the stream with
+// err, as on rollback the changes already sent to the client are undone.
+func (reg *rangeWatchRegistry) abort(streamId common.StreamId, keyspaceId string,
+	indexInstMap common.IndexInstMap, err error) {
+
+	if atomic.LoadInt64(&reg.count) == 0 {
+		return
+	}
+
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	for instId, watches := range reg.watches {
+		inst, ok := indexInstMap[instId]
+		if !ok || inst.Stream != streamId || inst.Defn.KeyspaceId(streamId) != keyspaceId {
+			continue
+		}
+
+		for _, w := range watches {
+			w.fail(err)
+		}
+	}
+}
+
+func (w *rangeWatch) inRange(entry IndexEntry) bool {
+
+	if c := comparePrefix(w.low, entry); c > 0 || (c == 0 && (w.incl == Neither || w.incl == High)) {
+		return false
+	}
+
+	if c := comparePrefix(w.high, entry); c < 0 || (c == 0 && (w.incl == Neither || w.incl == Low)) {
+		return false
+	}
+
+	return true
+}
+
+func (w *rangeWatch) apply(change watchChange) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return
+	}
+
+	if !w.live {
+		w.pending = append(w.pending, change)
+		if len(w.pending) > w.maxEvents {
+			w.failLocked(ErrWatchOverflow)
+		}
+		return
+	}
+
+	w.applyLocked(change)
+}
+
+func (w *rangeWatch) applyLocked(change watchChange) {
+
+	old, member := w.members[change.docid]
+
+	var typ common.WatchEventType
+	switch {
+	case change.key != nil && !member:
+		if len(w.members) >= w.maxKeys {
+			w.failLocked(ErrWatchKeysLimit)
+			return
+		}
+		typ = common.WatchInsert
+	case change.key != nil:
+		if bytes.Equal(old, change.key) {
+			return
+		}
+		typ = common.WatchUpdate
+	case member:
+		typ = common.WatchDelete
+	default:
+		return
+	}
+
+	event := &protobuf.WatchEvent{
+		Type:       proto.Uint32(uint32(typ)),
+		PrimaryKey: []byte(change.docid),
+		Vbucket:    proto.Uint32(uint32(change.vbucket)),
+		Seqno:      proto.Uint64(change.seqno),
+	}
+	if typ == common.WatchDelete {
+		delete(w.members, change.docid)
+	} else {
+		w.members[change.docid] = change.key
+		event.EntryKey = change.key
+	}
+
+	w.events = append(w.events, event)
+	if len(w.events) > w.maxEvents {
+		w.failLocked(ErrWatchOverflow)
+		return
+	}
+	w.notify()
+}
+
+// addMember adds an entry of the snapshot of the watch.
+func (w *rangeWatch) addMember(docid string, key []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if len(w.members) >= w.maxKeys {
+		w.failLocked(ErrWatchKeysLimit)
+		return w.err
+	}
+	w.members[docid] = key
+	return nil
+}
+
+// start makes the watch live once its snapshot, with timestamp seqnos and
+// vbuuids, has been scanned. It returns the boundary of the snapshot, which
+// is empty for a snapshot without any mutation.
+func (w *rangeWatch) start(seqnos, vbuuids []uint64) (*protobuf.TsConsistency, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	w.seqnos = append([]uint64(nil), seqnos...)
+	w.vbuuids = append([]uint64(nil), vbuuids...)
+	boundary := w.boundaryLocked()
+	if boundary == nil {
+		boundary = &protobuf.TsConsistency{}
+	}
+	w.live = true
+
+	for _, change := range w.pending {
+		if int(change.vbucket) < len(seqnos) && change.seqno <= seqnos[change.vbucket] {
+			continue
+		}
+		w.applyLocked(change)
+	}
+	w.pending = nil
+
+	return boundary, w.err
+}
+
+func (w *rangeWatch) setBoundary(ts *common.TsVbuuid) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.live || w.err != nil {
+		return
+	}
+
+	w.seqnos = append(w.seqnos[:0], ts.Seqnos...)
+	w.vbuuids = append(w.vbuuids[:0], ts.Vbuuids...)
+	w.notify()
+}
+
+// take returns the changes since the last call, along with the vbuckets of
+// the boundary that moved since.
+func (w *rangeWatch) take() ([]*protobuf.WatchEvent, *protobuf.TsConsistency, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return nil, nil, w.err
+	}
+
+	events := w.events
+	w.events = nil
+	return events, w.boundaryLocked(), nil
+}
+
+// boundaryLocked returns the vbuckets of the boundary not yet sent to the
+// client, nil if there are none.
+func (w *rangeWatch) boundaryLocked() *protobuf.TsConsistency {
+
+	if len(w.sent) != len(w.seqnos) {
+		w.sent = make([]uint64, len(w.seqnos))
+	}
+
+	var vbnos []uint16
+	var seqnos, vbuuids []uint64
+	for vb, seqno := range w.seqnos {
+		if seqno != w.sent[vb] {
+			vbnos = append(vbnos, uint16(vb))
+			seqnos = append(seqnos, seqno)
+			vbuuids = append(vbuuids, w.vbuuids[vb])
+			w.sent[vb] = seqno
+		}
+	}
+
+	if len(vbnos) == 0 {
+		return nil
+	}
+	return protobuf.NewTsConsistency(vbnos, seqnos, vbuuids, 0)
+}
+
+func (w *rangeWatch) fail(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err == nil {
+		w.failLocked(err)
+	}
+}
+
+func (w *rangeWatch) failLocked(err error) {
+	w.err = err
+	w.members, w.pending, w.events = nil, nil, nil
+	w.notify()
+}
+
+func (w *rangeWatch) notify() {
+	select {
+	case w.notifych <- struct{}{}:
+	default:
+	}
+}
+
+// isSupportedWatchIndex returns true if the changes to the entries of the
+// index can be watched.
+func isSupportedWatchIndex(defn *common.IndexDefn) bool {
+	return !defn.IsPrimary && !defn.IsArrayIndex && defn.VectorMeta == nil &&
+		!common.IsPartitioned(defn.PartitionScheme) && !defn.HasDescending()
+}
+
+// handleWatchRequest sends the entries in the range of the watch as of the
+// snapshot, followed by the changes applied by the flusher after the
+// snapshot, until the client ends the stream or the watch fails.
+func (s *scanCoordinator) handleWatchRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot) {
+
+	err := s.sendWatchSnapshot(req, w, is)
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	logging.Verbosef("%s watch is live", req.LogPrefix)
+
+	// A live watch is not bound by the scan timeout
+	if req.Timeout != nil {
+		req.Timeout.Stop()
+	}
+
+	cfg := s.config.Load()
+	keepAlive := time.NewTicker(time.Duration(cfg["scan.watch.keepalive_interval"].Int()) * time.Millisecond)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.watch.notifych:
+			events, boundary, err := req.watch.take()
+			if s.tryRespondWithError(w, req, err) {
+				return
+			}
+			if err := sendWatchEvents(w, events, boundary, false); err != nil {
+				s.handleError(req.LogPrefix, err)
+				return
+			}
+
+		case <-keepAlive.C:
+			if !s.isIndexInstActive(req.IndexInstId) {
+				s.tryRespondWithError(w, req, common.ErrIndexNotFound)
+				return
+			}
+
+			// Let the client know that the watch is alive while the range is idle
+			if err := w.Watch(nil, nil, false); err != nil {
+				s.handleError(req.LogPrefix, err)
+				return
+			}
+
+		case <-req.CancelCh:
+			return
+
+		case <-req.adminCancelCh:
+			s.tryRespondWithError(w, req, common.ErrAdminCancel)
+			return
+		}
+	}
+}
+
+// sendWatchSnapshot sends the entries in the range of the watch as insert
+// events, and makes the watch live from the snapshot.
+func (s *scanCoordinator) sendWatchSnapshot(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot) error {
+
+	var seqnos, vbuuids []uint64
+	var events []*protobuf.WatchEvent
+	var size int
+
+	if is != nil && !is.IsEpoch() {
+		if ts := is.Timestamp(); ts != nil {
+			seqnos, vbuuids = ts.Seqnos, ts.Vbuuids
+		}
+
+		snapshots, err := GetSliceSnapshots(is, req.PartitionIds)
+		if err != nil {
+			return err
+		}
+
+		for i, ss := range snapshots {
+			err = ss.Snapshot().Range(req.Ctxs[i], req.Low, req.High, req.Incl, func(b []byte) error {
+				select {
+				case <-req.CancelCh:
+					return common.ErrClientCancel
+				case <-req.getTimeoutCh():
+					return common.ErrScanTimedOut
+				case <-req.adminCancelCh:
+					return common.ErrAdminCancel
+				default:
+				}
+
+				entry, _ := BytesToSecondaryIndexEntry(b)
+				docid, _ := entry.ReadDocId(nil)
+				key, err := entry.ReadSecKey(nil)
+				if err != nil {
+					return err
+				}
+				if err := req.watch.addMember(string(docid), key); err != nil {
+					return err
+				}
+
+				events = append(events, &protobuf.WatchEvent{
+					Type:       proto.Uint32(uint32(common.WatchInsert)),
+					PrimaryKey: docid,
+					EntryKey:   key,
+				})
+				size += len(docid) + len(key)
+				if size >= watchMaxResponseSize {
+					err = w.Watch(events, nil, true)
+					events, size = nil, 0
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The last response of the snapshot has its timestamp as the boundary
+	boundary, err := req.watch.start(seqnos, vbuuids)
+	if err != nil {
+		return err
+	}
+	return sendWatchEvents(w, events, boundary, true)
+}
+
+// sendWatchEvents sends the events in responses of bounded size, with the
+// boundary in the last one.
+func sendWatchEvents(w ScanResponseWriter, events []*protobuf.WatchEvent,
+	boundary *protobuf.TsConsistency, snapshot bool) error {
+
+	if len(events) == 0 && boundary == nil {
+		return nil
+	}
+
+	for {
+		n, size := 0, 0
+		for n < len(events) && size < watchMaxResponseSize {
+			size += len(events[n].GetPrimaryKey()) + len(events[n].GetEntryKey())
+			n++
+		}
+
+		if n == len(events) {
+			return w.Watch(events, boundary, snapshot)
+		}
+
+		if err := w.Watch(events[:n], nil, snapshot); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+}
+
+// isIndexInstActive returns true if the index instance is still served by
+// the scan coordinator.
+func (s *scanCoordinator) isIndexInstActive(instId common.IndexInstId) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	inst, ok := s.indexInstMap[instId]
+	return ok && inst.State == common.INDEX_STATE_ACTIVE
+}
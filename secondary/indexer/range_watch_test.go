@@ -0,0 +1,127 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestRangeWatch(maxEvents, maxKeys int) *rangeWatch {
+	return &rangeWatch{
+		maxEvents: maxEvents,
+		maxKeys:   maxKeys,
+		members:   make(map[string][]byte),
+		notifych:  make(chan struct{}, 1),
+	}
+}
+
+func TestRangeWatch(t *testing.T) {
+	w := newTestRangeWatch(100, 100)
+
+	// Snapshot of the watch has doc1 and doc2, as of seqno 10 in vb 0
+	w.addMember("doc1", []byte(`["a"]`))
+	w.addMember("doc2", []byte(`["b"]`))
+
+	// Changes while the snapshot is scanned are applied only if they are
+	// more recent than the snapshot
+	w.apply(watchChange{docid: "doc1", key: nil, vbucket: 0, seqno: 5})
+	w.apply(watchChange{docid: "doc3", key: []byte(`["c"]`), vbucket: 0, seqno: 11})
+
+	boundary, err := w.start([]uint64{10, 0}, []uint64{1234, 0})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if vbnos := boundary.GetVbnos(); len(vbnos) != 1 || vbnos[0] != 0 || boundary.GetSeqnos()[0] != 10 {
+		t.Fatalf("Unexpected snapshot boundary %v", boundary)
+	}
+
+	w.apply(watchChange{docid: "doc2", key: []byte(`["b"]`), vbucket: 1, seqno: 1})
+	w.apply(watchChange{docid: "doc2", key: []byte(`["bb"]`), vbucket: 1, seqno: 2})
+	w.apply(watchChange{docid: "doc1", key: nil, vbucket: 0, seqno: 12})
+	w.apply(watchChange{docid: "doc4", key: nil, vbucket: 0, seqno: 13})
+
+	events, boundary, err := w.take()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if boundary != nil {
+		t.Errorf("Expected no boundary before flush, got %v", boundary)
+	}
+
+	expected := []struct {
+		typ   common.WatchEventType
+		docid string
+	}{
+		{common.WatchInsert, "doc3"},
+		{common.WatchUpdate, "doc2"},
+		{common.WatchDelete, "doc1"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v events, got %v", len(expected), events)
+	}
+	for i, e := range expected {
+		if typ := common.WatchEventType(events[i].GetType()); typ != e.typ || string(events[i].GetPrimaryKey()) != e.docid {
+			t.Errorf("Expected %v of %v, got %v of %s", e.typ, e.docid, typ, events[i].GetPrimaryKey())
+		}
+	}
+
+	// Only the vbuckets that moved are sent as boundary
+	w.setBoundary(&common.TsVbuuid{Seqnos: []uint64{13, 2}, Vbuuids: []uint64{1234, 5678}})
+	_, boundary, _ = w.take()
+	if vbnos := boundary.GetVbnos(); len(vbnos) != 2 {
+		t.Fatalf("Expected boundary of 2 vbuckets, got %v", boundary)
+	}
+	w.setBoundary(&common.TsVbuuid{Seqnos: []uint64{13, 3}, Vbuuids: []uint64{1234, 5678}})
+	_, boundary, _ = w.take()
+	if vbnos := boundary.GetVbnos(); len(vbnos) != 1 || vbnos[0] != 1 {
+		t.Fatalf("Expected boundary of vbucket 1, got %v", boundary)
+	}
+
+	// A watch fails once its buffered events or keys exceed the limits
+	w = newTestRangeWatch(1, 100)
+	w.start(nil, nil)
+	w.apply(watchChange{docid: "doc1", key: []byte(`["a"]`)})
+	w.apply(watchChange{docid: "doc2", key: []byte(`["b"]`)})
+	if _, _, err := w.take(); err != ErrWatchOverflow {
+		t.Errorf("Expected %v, got %v", ErrWatchOverflow, err)
+	}
+
+	w = newTestRangeWatch(100, 1)
+	w.addMember("doc1", []byte(`["a"]`))
+	if err := w.addMember("doc2", []byte(`["b"]`)); err != ErrWatchKeysLimit {
+		t.Errorf("Expected %v, got %v", ErrWatchKeysLimit, err)
+	}
+}
+
+func TestRangeWatchPublish(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("settings.allow_large_keys", false)
+	config.SetValue("settings.max_seckey_size", 16)
+
+	var reg rangeWatchRegistry
+	reg.configure(config)
+
+	w := newTestRangeWatch(100, 100)
+	w.instId, w.low, w.high, w.incl = 1, MinIndexKey, MaxIndexKey, Both
+	w.start(nil, nil)
+	reg.watches = map[common.IndexInstId][]*rangeWatch{1: {w}}
+	reg.count = 1
+
+	publish := func(key string, seqno uint64) {
+		mut := &Mutation{uuid: 1, command: common.Upsert, key: []byte(key)}
+		reg.publish(mut, []byte("doc1"), &MutationMeta{seqno: seqno})
+	}
+
+	// A key too long to be indexed by storage deletes the entry of the document
+	publish(`["a"]`, 1)
+	publish(`["aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"]`, 2)
+
+	events, _, err := w.take()
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(events) != 2 || common.WatchEventType(events[0].GetType()) != common.WatchInsert ||
+		common.WatchEventType(events[1].GetType()) != common.WatchDelete {
+		t.Fatalf("Expected insert and delete of doc1, got %v", events)
+	}
+}
@@ -3595,7 +3595,7 @@ func (m *RebalanceServiceManager) generateTransferTokenForMoveIndex(req *IndexRe
 				var instList []*c.IndexInst
 				for _, inst := range insts {
 
-					pc := c.NewKeyPartitionContainer(int(inst.NumPartitions), index.PartitionScheme, index.HashScheme)
+					pc := c.NewKeyPartitionContainer(int(inst.NumPartitions), index.PartitionScheme, index.HashScheme, index.PartitionRanges)
 					for _, partition := range inst.Partitions {
 						partnDefn := c.KeyPartitionDefn{Id: c.PartitionId(partition.PartId), Version: int(partition.Version)}
 						pc.AddPartition(c.PartitionId(partition.PartId), partnDefn)
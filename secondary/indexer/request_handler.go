@@ -281,6 +281,7 @@ func RegisterRequestHandler(mgr *manager.IndexManager, mux *http.ServeMux, confi
 			"/getCachedIndexerNodeUUIDs", handlerContext.handleCachedIndexerNodeUUIDsRequest)
 		mux.HandleFunc("/restoreIndexMetadata", handlerContext.handleRestoreIndexMetadataRequest)
 		mux.HandleFunc("/planIndex", handlerContext.handleIndexPlanRequest)
+		mux.HandleFunc("/getRedundantIndexes", handlerContext.handleRedundantIndexRequest)
 		mux.HandleFunc("/settings/storageMode", handlerContext.handleIndexStorageModeRequest)
 		mux.HandleFunc("/settings/planner", handlerContext.handlePlannerRequest)
 		mux.HandleFunc("/listReplicaCount", handlerContext.handleListLocalReplicaCountRequest)
@@ -2374,6 +2375,47 @@ func (m *requestHandlerContext) getIndexPlan(r *http.Request) (string, error) {
 	return planner.CreateIndexDDL(solution), nil
 }
 
+// handleRedundantIndexRequest reports the equivalent, prefix-overlapping and unused indexes
+// of the cluster. Indexes not scanned in unusedDays (default 30) days since they were built
+// are reported as unused.
+func (m *requestHandlerContext) handleRedundantIndexRequest(w http.ResponseWriter, r *http.Request) {
+	const method string = "RequestHandler::handleRedundantIndexRequest" // for logging
+
+	creds, ok := doAuth(r, w, method)
+	if !ok {
+		return
+	}
+
+	if !isAllowed(creds, []string{"cluster.admin.internal.index!read"}, r, w, method) {
+		return
+	}
+
+	unusedDays := 30
+	if value := r.FormValue("unusedDays"); len(value) != 0 {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			rhSendHttpError(w, "unusedDays must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		unusedDays = days
+	}
+
+	plan, err := planner.RetrievePlanFromCluster(m.clusterUrl, nil, false)
+	if err != nil {
+		logging.Errorf("%v: Fail to retrieve index information from cluster. err: %v", method, err)
+		rhSendHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := planner.ExecuteRedundantIndexReport(plan, unusedDays, "")
+	if err != nil {
+		rhSendHttpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rhSend(http.StatusOK, w, report)
+}
+
 func (m *requestHandlerContext) convertIndexPlanRequest(r *http.Request) ([]*planner.IndexSpec, error) {
 
 	var specs []*planner.IndexSpec
@@ -17,6 +17,7 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/iowrap"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/security"
 )
 
 const TEMP_FILE_SUFFIX string = ".tmp" // suffix for temporary filename to be atomically renamed
@@ -236,6 +237,13 @@ func (this *requestHandlerCache) saveLocalIndexMetadataToDisk(metaToCache *workC
 		return err
 	}
 
+	content, err = security.EncryptAtRest(content)
+	if err != nil {
+		logging.Errorf("%v Failed to encrypt metadata to file %v, error: %v",
+			method, filepath, err)
+		return err
+	}
+
 	err = common.WriteFileWithSync(temp, content, 0755)
 	if err != nil {
 		logging.Errorf("%v Failed to save metadata to file %v, error: %v", method, temp, err)
@@ -271,6 +279,13 @@ func (this *requestHandlerCache) saveStatsToDisk(statsToCache *workChEntry_stats
 		return err
 	}
 
+	content, err = security.EncryptAtRest(content)
+	if err != nil {
+		logging.Errorf("%v Failed to encrypt stats to file %v, error: %v",
+			method, filepath, err)
+		return err
+	}
+
 	err = common.WriteFileWithSync(temp, content, 0755)
 	if err != nil {
 		logging.Errorf("%v Failed to save stats to file %v, error: %v", method, temp, err)
@@ -430,6 +445,9 @@ func (this *requestHandlerCache) populateMetaMemCacheFromDisk() {
 			if err != nil {
 				logging.Errorf("%v Failed to read metadata from file %v, error: %v",
 					_populateMetaMemCacheFromDisk, filepath, err)
+			} else if content, err = security.DecryptAtRest(content); err != nil {
+				logging.Errorf("%v Failed to decrypt metadata from file %v, error: %v",
+					_populateMetaMemCacheFromDisk, filepath, err)
 			}
 
 			localMeta := new(manager.LocalIndexMetadata)
@@ -468,6 +486,9 @@ func (this *requestHandlerCache) populateStatsMemCacheFromDisk() {
 			if err != nil {
 				logging.Errorf("%v Failed to read stats from file %v, error: %v",
 					_populateStatsMemCacheFromDisk, filepath, err)
+			} else if content, err = security.DecryptAtRest(content); err != nil {
+				logging.Errorf("%v Failed to decrypt stats from file %v, error: %v",
+					_populateStatsMemCacheFromDisk, filepath, err)
 			}
 
 			stats := new(common.Statistics)
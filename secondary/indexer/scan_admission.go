@@ -0,0 +1,236 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// ScanPriority is the priority class of a scan request. Scans of each class
+// are admitted up to the concurrency limit of the class, so that batch and
+// background scans cannot starve interactive ones.
+type ScanPriority int
+
+const (
+	ScanPriorityInteractive ScanPriority = iota
+	ScanPriorityBatch
+	ScanPriorityBackground
+
+	numScanPriorities
+)
+
+func (p ScanPriority) String() string {
+	switch p {
+	case ScanPriorityInteractive:
+		return "interactive"
+	case ScanPriorityBatch:
+		return "batch"
+	case ScanPriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// parseScanPriority returns the priority class of name, or def if name is
+// empty or unknown.
+func parseScanPriority(name string, def ScanPriority) ScanPriority {
+	for p := ScanPriority(0); p < numScanPriorities; p++ {
+		if strings.EqualFold(name, p.String()) {
+			return p
+		}
+	}
+	return def
+}
+
+// scanAdmission bounds the number of concurrent scans of each priority class.
+// A scan beyond the limit of its class waits in a bounded FIFO queue, and is
+// rejected with ErrIndexerBusy if the queue is full or it is not admitted
+// within the queue timeout, so that the client can retry on a replica.
+//
+// Default limits are relative to GOMAXPROCS, and the limits of batch and
+// background scans are halved under memory pressure and again under cpu
+// pressure, which is sampled by the cpu collector of the indexer process.
+type scanAdmission struct {
+	mu sync.Mutex
+
+	enabled      bool
+	queueSize    int
+	queueTimeout time.Duration
+	memPressure  bool
+	cpuPressure  bool
+
+	classes [numScanPriorities]scanAdmissionClass
+}
+
+type scanAdmissionClass struct {
+	concurrency int // configured limit, 0 for default based on cpus
+	limit       int
+	running     int
+	waiters     []chan struct{}
+}
+
+// configure sets the limits from the indexer config, with default concurrency
+// of 8, 2 and 1/2 scans per cpu for interactive, batch and background scans.
+func (a *scanAdmission) configure(config common.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.enabled = config["scan.admission.enabled"].Bool()
+	a.queueSize = config["scan.admission.queue_size"].Int()
+	a.queueTimeout = time.Duration(config["scan.admission.queue_timeout"].Int()) * time.Millisecond
+	a.classes[ScanPriorityInteractive].concurrency = config["scan.admission.interactive.concurrency"].Int()
+	a.classes[ScanPriorityBatch].concurrency = config["scan.admission.batch.concurrency"].Int()
+	a.classes[ScanPriorityBackground].concurrency = config["scan.admission.background.concurrency"].Int()
+
+	a.updateLimits()
+
+	// Admit the waiting scans if admission control is disabled
+	if !a.enabled {
+		for p := range a.classes {
+			class := &a.classes[p]
+			class.limit = class.running + len(class.waiters)
+			a.dispatch(class)
+		}
+	}
+}
+
+// setMemoryPressure halves the concurrency of batch and background scans while
+// the indexer is under memory pressure.
+func (a *scanAdmission) setMemoryPressure(memPressure bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.memPressure != memPressure {
+		logging.Infof("ScanCoordinator: scan admission memory pressure %v", memPressure)
+		a.memPressure = memPressure
+		a.updateLimits()
+	}
+}
+
+// setCpuPressure halves the concurrency of batch and background scans while
+// the cpu usage of the indexer is high.
+func (a *scanAdmission) setCpuPressure(cpuPressure bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cpuPressure != cpuPressure {
+		logging.Infof("ScanCoordinator: scan admission cpu pressure %v", cpuPressure)
+		a.cpuPressure = cpuPressure
+		a.updateLimits()
+	}
+}
+
+func (a *scanAdmission) updateLimits() {
+	cpus := runtime.GOMAXPROCS(0)
+	defaults := [numScanPriorities]int{8 * cpus, 2 * cpus, cpus / 2}
+
+	for p := range a.classes {
+		class := &a.classes[p]
+
+		class.limit = class.concurrency
+		if class.limit <= 0 {
+			class.limit = defaults[p]
+		}
+		if a.memPressure && ScanPriority(p) != ScanPriorityInteractive {
+			class.limit /= 2
+		}
+		if a.cpuPressure && ScanPriority(p) != ScanPriorityInteractive {
+			class.limit /= 2
+		}
+		if class.limit < 1 {
+			class.limit = 1
+		}
+
+		a.dispatch(class)
+	}
+}
+
+// admit waits for the priority class to admit the scan, and returns the function
+// to call once the scan is done. cancelCh and timeoutCh are the cancellation and
+// timeout of the scan request.
+func (a *scanAdmission) admit(priority ScanPriority, cancelCh <-chan bool,
+	adminCancelCh <-chan struct{}, timeoutCh <-chan time.Time) (func(), error) {
+
+	a.mu.Lock()
+	if !a.enabled {
+		a.mu.Unlock()
+		return func() {}, nil
+	}
+
+	class := &a.classes[priority]
+	release := func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+
+		class.running--
+		a.dispatch(class)
+	}
+
+	if class.running < class.limit && len(class.waiters) == 0 {
+		class.running++
+		a.mu.Unlock()
+		return release, nil
+	}
+
+	if len(class.waiters) >= a.queueSize {
+		a.mu.Unlock()
+		return nil, common.ErrIndexerBusy
+	}
+
+	admitCh := make(chan struct{})
+	class.waiters = append(class.waiters, admitCh)
+	queueTimer := time.NewTimer(a.queueTimeout)
+	defer queueTimer.Stop()
+	a.mu.Unlock()
+
+	var err error
+	select {
+	case <-admitCh:
+		return release, nil
+	case <-queueTimer.C:
+		err = common.ErrIndexerBusy
+	case <-timeoutCh:
+		err = common.ErrScanTimedOut
+	case <-cancelCh:
+		err = common.ErrClientCancel
+	case <-adminCancelCh:
+		err = common.ErrAdminCancel
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, ch := range class.waiters {
+		if ch == admitCh {
+			class.waiters = append(class.waiters[:i], class.waiters[i+1:]...)
+			return nil, err
+		}
+	}
+
+	// Admitted concurrently with the error, hand over the slot
+	class.running--
+	a.dispatch(class)
+	return nil, err
+}
+
+// dispatch admits the waiting scans of the class up to its limit.
+func (a *scanAdmission) dispatch(class *scanAdmissionClass) {
+	for class.running < class.limit && len(class.waiters) != 0 {
+		class.running++
+		close(class.waiters[0])
+		class.waiters = class.waiters[1:]
+	}
+}
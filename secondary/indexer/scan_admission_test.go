@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanAdmission(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	config.SetValue("scan.admission.interactive.concurrency", 2)
+	config.SetValue("scan.admission.batch.concurrency", 4)
+	config.SetValue("scan.admission.queue_size", 1)
+	config.SetValue("scan.admission.queue_timeout", 50)
+
+	var a scanAdmission
+	a.configure(config)
+
+	admit := func(priority ScanPriority, cancelCh <-chan bool) (func(), error) {
+		return a.admit(priority, cancelCh, nil, nil)
+	}
+
+	release1, err := admit(ScanPriorityInteractive, nil)
+	if err != nil {
+		t.Fatalf("Expected scan to be admitted, got %v", err)
+	}
+	release2, _ := admit(ScanPriorityInteractive, nil)
+
+	// Beyond the limit, a scan waits for a slot, one at a time
+	admitted := make(chan error)
+	go func() {
+		release, err := admit(ScanPriorityInteractive, nil)
+		if err == nil {
+			defer release()
+		}
+		admitted <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := admit(ScanPriorityInteractive, nil); err != common.ErrIndexerBusy {
+		t.Fatalf("Expected %v with full queue, got %v", common.ErrIndexerBusy, err)
+	}
+	release1()
+	if err := <-admitted; err != nil {
+		t.Fatalf("Expected queued scan to be admitted, got %v", err)
+	}
+
+	// Other classes are not affected by the interactive scans
+	releaseBatch, err := admit(ScanPriorityBatch, nil)
+	if err != nil {
+		t.Fatalf("Expected batch scan to be admitted, got %v", err)
+	}
+	releaseBatch()
+
+	// A queued scan is rejected as busy on queue timeout, or on cancel
+	release3, _ := admit(ScanPriorityInteractive, nil)
+	if _, err := admit(ScanPriorityInteractive, nil); err != common.ErrIndexerBusy {
+		t.Fatalf("Expected %v on queue timeout, got %v", common.ErrIndexerBusy, err)
+	}
+	cancelCh := make(chan bool)
+	close(cancelCh)
+	if _, err := admit(ScanPriorityInteractive, cancelCh); err != common.ErrClientCancel {
+		t.Fatalf("Expected %v on cancel, got %v", common.ErrClientCancel, err)
+	}
+	adminCancelCh := make(chan struct{})
+	close(adminCancelCh)
+	if _, err := a.admit(ScanPriorityInteractive, nil, adminCancelCh, nil); err != common.ErrAdminCancel {
+		t.Fatalf("Expected %v on admin cancel, got %v", common.ErrAdminCancel, err)
+	}
+	release2()
+	release3()
+	if running := a.classes[ScanPriorityInteractive].running; running != 0 {
+		t.Fatalf("Expected no running scans, found %v", running)
+	}
+
+	// Memory pressure halves batch and background concurrency
+	a.setMemoryPressure(true)
+	if limit := a.classes[ScanPriorityBatch].limit; limit != 2 {
+		t.Errorf("Expected batch limit 2 under memory pressure, got %v", limit)
+	}
+	if limit := a.classes[ScanPriorityInteractive].limit; limit != 2 {
+		t.Errorf("Expected interactive limit 2 under memory pressure, got %v", limit)
+	}
+	if limit, expected := a.classes[ScanPriorityBackground].limit, runtime.GOMAXPROCS(0)/4; limit != expected && expected > 0 {
+		t.Errorf("Expected background limit %v under memory pressure, got %v", expected, limit)
+	}
+
+	// Cpu pressure halves them again
+	a.setCpuPressure(true)
+	if limit := a.classes[ScanPriorityBatch].limit; limit != 1 {
+		t.Errorf("Expected batch limit 1 under memory and cpu pressure, got %v", limit)
+	}
+	if limit := a.classes[ScanPriorityInteractive].limit; limit != 2 {
+		t.Errorf("Expected interactive limit 2 under cpu pressure, got %v", limit)
+	}
+	a.setMemoryPressure(false)
+	a.setCpuPressure(false)
+	if limit := a.classes[ScanPriorityBatch].limit; limit != 4 {
+		t.Errorf("Expected batch limit 4 without pressure, got %v", limit)
+	}
+
+	if p := parseScanPriority("Background", ScanPriorityInteractive); p != ScanPriorityBackground {
+		t.Errorf("Expected %v, got %v", ScanPriorityBackground, p)
+	}
+	if p := parseScanPriority("", ScanPriorityBatch); p != ScanPriorityBatch {
+		t.Errorf("Expected %v, got %v", ScanPriorityBatch, p)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -25,6 +26,7 @@ import (
 	p "github.com/couchbase/indexing/secondary/pipeline"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/queryport"
+	"github.com/couchbase/indexing/secondary/transport"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -44,6 +46,9 @@ var secKeyBufPool *common.BytesBufPool
 
 type ScanCoordinator interface {
 	SetMeteringMgr(mtMgr *MeteringThrottlingMgr)
+	SlowScans() []*SlowScan
+	ActiveScans(allowed func(bucket, scope, collection string) bool) []*ActiveScan
+	CancelScan(scanId uint64) bool
 }
 
 type scanCoordinator struct {
@@ -79,6 +84,18 @@ type scanCoordinator struct {
 
 	//maintains bucket->bucketStateEnum mapping for pause state
 	bucketPauseState map[string]bucketStateEnum
+
+	// most recent scans slower than settings.scan.slow_threshold
+	slowScans slowScanLog
+
+	// leading key histograms of index partitions
+	histograms histogramCache
+
+	// Admission control of scans by priority class
+	admission scanAdmission
+
+	// In-flight scans, for listing and cancellation by admin
+	activeScans activeScanRegistry
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -107,6 +124,8 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 	}
 
 	s.config.Store(config)
+	s.admission.configure(config)
+	gRangeWatches.configure(config)
 	s.initRollbackInProgress()
 	s.lastSnapshot.Init()
 	s.bucketNameNumVBucketsMapHolder.Init()
@@ -189,6 +208,9 @@ func (s *scanCoordinator) listenSnapshot(index int) {
 					DestroyIndexSnapshot(oldSnap)
 				}
 
+				retention := s.config.Load()["scan.cursor_snapshot_retention"].Int()
+				snapContainer.expireCursorSnapshot(time.Duration(retention) * time.Second)
+
 				if !snapContainer.deleted {
 					if ss.Timestamp() != nil {
 						snapContainer.snap = ss
@@ -285,6 +307,17 @@ func (s *scanCoordinator) gatherStats() {
 		}
 		atomic.StoreInt64(&s.totalMaintDocsQueued, totalQueued)
 		atomic.StoreInt64(&s.numKeyspaces, numKeyspaces)
+
+		// update memory and cpu pressure for scan admission
+		if cfg := s.config.Load(); cfg != nil {
+			threshold := int64(cfg["scan.admission.mem_pressure_percent"].Int())
+			quota := stats.memoryQuota.Value()
+			s.admission.setMemoryPressure(quota > 0 && stats.memoryUsed.Value()*100 > quota*threshold)
+
+			cpuThreshold := float64(cfg["scan.admission.cpu_pressure_percent"].Int())
+			cpus := float64(runtime.GOMAXPROCS(0))
+			s.admission.setCpuPressure(cpuThreshold > 0 && getCpuPercent() > cpus*cpuThreshold)
+		}
 	}
 }
 
@@ -313,9 +346,12 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 
 	req, err := NewScanRequest(protoReq, ctx, cancelCh, s)
 	atime := time.Now()
-	w := NewProtoWriter(req.ScanType, conn)
+	w := NewProtoWriter(req.ScanType, conn, req.connCtx.GetCompression())
 	var readUnits uint64 = 0
 	defer func() {
+		if req.trace.isRequested() {
+			w.Trace(req.trace.toProto(time.Since(ttime)))
+		}
 		s.handleError(req.LogPrefix, w.Done(readUnits, clientVersion))
 		req.Done()
 	}()
@@ -345,6 +381,13 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		return
 	}
 
+	var clientAddr string
+	if conn != nil {
+		clientAddr = conn.RemoteAddr().String()
+	}
+	s.activeScans.add(req, clientAddr)
+	defer s.activeScans.remove(req.ScanId)
+
 	if req.Stats != nil {
 		req.Stats.scanReqAllocDuration.Add(time.Now().Sub(atime).Nanoseconds())
 	}
@@ -395,9 +438,26 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 		}
 	}
 
+	// Partitions marked to be rebuilt by the scrubber are not scanned, for
+	// the client to scan a replica instead
+	if isScrubCorruptedPartition(req.IndexInstId, req.PartitionIds) {
+		s.tryRespondWithError(w, req, common.ErrIndexCorrupted)
+		return
+	}
+
+	// Register the watch before the snapshot is taken, so that the changes
+	// after the snapshot are not missed
+	if req.ScanType == WatchReq {
+		if req.watch, err = gRangeWatches.add(req); s.tryRespondWithError(w, req, err) {
+			return
+		}
+		defer gRangeWatches.remove(req.watch)
+	}
+
 	// Pre-scan checks passed, so get a snapshot for the scan
 	t0 := time.Now()
 	is, err := s.getRequestedIndexSnapshot(req)
+	req.trace.add(traceWait, time.Since(t0))
 	if err != nil {
 		logging.Infof("%s Error in getRequestedIndexSnapshot %v", req.LogPrefix, err)
 
@@ -428,6 +488,21 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	}
 	defer DestroyIndexSnapshot(is)
 
+	// Wait for admission at the priority of the scan. Admission follows the
+	// snapshot, so that scans waiting for consistency do not hold the slots
+	// of scans ready to run. Watches are long-lived, and bounded by the watch
+	// limits instead.
+	if req.ScanType != WatchReq {
+		release, err := s.admission.admit(req.priority, req.CancelCh, req.adminCancelCh, req.getTimeoutCh())
+		if s.tryRespondWithError(w, req, err) {
+			return
+		}
+		defer release()
+	}
+
+	s.retainCursorSnapshot(req, is)
+	req.setCursorSnapshot(is.Timestamp())
+
 	logging.LazyVerbose(func() string {
 		return fmt.Sprintf("%s snapshot timestamp: %s",
 			req.LogPrefix, ScanTStoString(is.Timestamp()))
@@ -468,6 +543,16 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 					err = common.ErrClientCancel
 					close(donech)
 				}
+			case <-req.adminCancelCh:
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				select {
+				case <-donech:
+				default:
+					err = common.ErrAdminCancel
+					close(donech)
+				}
 			case <-donech:
 			}
 		}()
@@ -516,20 +601,39 @@ func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
 
 	switch req.ScanType {
 	case ScanReq, ScanAllReq:
-		s.handleScanRequest(req, w, is, t0)
+		if req.vectorScan != nil {
+			s.handleVectorScanRequest(req, w, is, t0)
+		} else {
+			s.handleScanRequest(req, w, is, t0)
+		}
 	case CountReq:
 		s.handleCountRequest(req, w, is, t0)
 	case MultiScanCountReq:
 		s.handleMultiScanCountRequest(req, w, is, t0)
 	case StatsReq:
-		s.handleStatsRequest(req, w, is)
+		s.handleStatsRequest(req, w, is, t0)
 	case FastCountReq:
 		s.handleFastCountRequest(req, w, is, t0)
+	case HistogramReq:
+		s.handleHistogramRequest(req, w, is, t0)
+	case WatchReq:
+		s.handleWatchRequest(req, w, is)
 	}
 }
 
 func (s *scanCoordinator) handleHeloRequest(req *ScanRequest, w ScanResponseWriter) {
-	err := w.Helo()
+	// Accept requested compression only if it is supported, subsequent
+	// responses on this connection will be compressed.
+	compression := transport.CompressionNone
+	if transport.IsSupportedCompression(req.compression) {
+		compression = req.compression
+	} else {
+		logging.Warnf("%v Unsupported compression %v requested, using %v", req.LogPrefix,
+			req.compression, transport.CompressionName(compression))
+	}
+	req.connCtx.SetCompression(compression)
+
+	err := w.Helo(compression)
 	s.handleError(req.LogPrefix, err)
 }
 
@@ -538,6 +642,7 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 	waitTime := time.Now().Sub(t0)
 
 	scanPipeline := NewScanPipeline(req, w, is, s.config.Load())
+	s.activeScans.setPipeline(req.ScanId, scanPipeline)
 	cancelCb := NewCancelCallback(req, func(e error) {
 		scanPipeline.Cancel(e)
 	})
@@ -547,6 +652,9 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 	err := scanPipeline.Execute()
 	scanTime := time.Now().Sub(t0)
 
+	s.recordSlowScan(req, scanPipeline.RowsReturned(), scanPipeline.RowsScanned(),
+		scanPipeline.BytesRead(), waitTime, scanTime, err)
+
 	stats := s.stats.Get()
 
 	if req.Stats != nil {
@@ -603,6 +711,11 @@ func (s *scanCoordinator) handleCountRequest(req *ScanRequest, w ScanResponseWri
 	var err error
 	var snapshots []SliceSnapshot
 
+	waitTime := time.Since(t0)
+	defer func() {
+		s.recordSlowScan(req, 1, rows, 0, waitTime, time.Since(t0), err)
+	}()
+
 	stopch := make(StopChannel)
 	cancelCb := NewCancelCallback(req, func(e error) {
 		err = e
@@ -630,6 +743,11 @@ func (s *scanCoordinator) handleMultiScanCountRequest(req *ScanRequest, w ScanRe
 	var err error
 	var snapshots []SliceSnapshot
 
+	waitTime := time.Since(t0)
+	defer func() {
+		s.recordSlowScan(req, 1, rows, 0, waitTime, time.Since(t0), err)
+	}()
+
 	stopch := make(StopChannel)
 	cancelCb := NewCancelCallback(req, func(e error) {
 		err = e
@@ -671,6 +789,11 @@ func (s *scanCoordinator) handleFastCountRequest(req *ScanRequest, w ScanRespons
 	var err error
 	var snapshots []SliceSnapshot
 
+	waitTime := time.Since(t0)
+	defer func() {
+		s.recordSlowScan(req, 1, rows, 0, waitTime, time.Since(t0), err)
+	}()
+
 	stopch := make(StopChannel)
 	cancelCb := NewCancelCallback(req, func(e error) {
 		err = e
@@ -717,11 +840,17 @@ func (s *scanCoordinator) handleFastCountRequest(req *ScanRequest, w ScanRespons
 }
 
 func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWriter,
-	is IndexSnapshot) {
-	var rows uint64
+	is IndexSnapshot, t0 time.Time) {
+	var stats spanStats
+	var min, max []byte
 	var err error
 	var snapshots []SliceSnapshot
 
+	waitTime := time.Since(t0)
+	defer func() {
+		s.recordSlowScan(req, 1, stats.count, stats.size, waitTime, time.Since(t0), err)
+	}()
+
 	stopch := make(StopChannel)
 	cancelCb := NewCancelCallback(req, func(e error) {
 		err = e
@@ -731,18 +860,51 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 	defer cancelCb.Done()
 
 	if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
-		rows, err = scatterStats(req, snapshots, stopch)
+		stats, err = scatterStats(req, snapshots, stopch, s.config.Load())
+	}
+
+	if err == nil {
+		if min, err = statsKeyToJson(req, stats.min); err == nil {
+			max, err = statsKeyToJson(req, stats.max)
+		}
 	}
 
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
 
-	logging.Verbosef("%s RESPONSE status:ok", req.LogPrefix)
-	err = w.Stats(rows, 0, nil, nil)
+	logging.Verbosef("%s RESPONSE count:%d distinct:%d size:%d status:ok",
+		req.LogPrefix, stats.count, stats.distinct, stats.size)
+	err = w.Stats(stats.count, stats.distinct, stats.size, min, max)
 	s.handleError(req.LogPrefix, err)
 }
 
+// statsKeyToJson converts a storage encoded key, as collected by
+// scatterStats, to the JSON encoded secondary key expected by the client.
+func statsKeyToJson(req *ScanRequest, key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, nil
+	}
+
+	if req.isPrimary {
+		return json.Marshal([]string{string(key)})
+	}
+
+	if req.IndexInst.Defn.HasDescending() {
+		key = append([]byte(nil), key...)
+		if _, err := jsonEncoder.ReverseCollate(key, req.IndexInst.Defn.Desc); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, 0, len(key)*3)
+	buf, err := jsonEncoder.Decode(key, buf)
+	if err != nil {
+		return nil, fmt.Errorf("Collatejson decode error: %v", err)
+	}
+	return buf, nil
+}
+
 /////////////////////////////////////////////////////////////////////////
 //
 //  scan helpers
@@ -759,16 +921,37 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 // This mechanism can be used to implement RYOW.
 func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexSnapshot, err error) {
 
+	if *r.Consistency == common.BoundedStalenessConsistency {
+		if err := s.waitForSnapshotAsOf(r); err != nil {
+			return nil, err
+		}
+	}
+
 	snapshot, err := func() (IndexSnapshot, error) {
 
 		lastSnapshot := s.lastSnapshot.Get()
 
 		sc, ok := lastSnapshot[r.IndexInstId]
 		cons := *r.Consistency
+
+		// Get the snapshot from storage manager, as the last snapshot here can
+		// lag the freshness tracked for the keyspace
+		if cons == common.BoundedStalenessConsistency {
+			return nil, nil
+		}
+
 		if ok && sc != nil {
 			sc.Lock()
 			defer sc.Unlock()
 
+			// Resume the scan against the snapshot of the cursor, if it
+			// is still available
+			if r.resumeCursor != nil {
+				if rs := sc.resumeSnapshot(r); rs != nil {
+					return rs, nil
+				}
+			}
+
 			ss := sc.snap
 			if ss == nil {
 				return nil, nil
@@ -854,6 +1037,9 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 	case <-r.CancelCh:
 		go readDeallocSnapshot(snapResch)
 		msg = common.ErrClientCancel
+	case <-r.adminCancelCh:
+		go readDeallocSnapshot(snapResch)
+		msg = common.ErrAdminCancel
 	}
 
 	switch msg.(type) {
@@ -866,6 +1052,44 @@ func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexS
 	return
 }
 
+// waitForSnapshotAsOf waits until the snapshots of the index have all the
+// mutations received by the indexer as of r.minSnapAsOf. The freshness of
+// the snapshots is tracked per keyspace by timekeeper, which notifies the
+// waiting scans through gSnapAsOfWaiters. The staleness is bounded against
+// the mutations received from the projector, not against KV, so mutations
+// yet to be received by the indexer are not waited for.
+func (s *scanCoordinator) waitForSnapshotAsOf(r *ScanRequest) error {
+
+	streamId := r.IndexInst.Stream
+	keyspaceId := r.IndexInst.Defn.KeyspaceId(streamId)
+
+	isSnapshotAsOf := func() bool {
+		keyspaceStats := s.stats.GetKeyspaceStats(streamId, keyspaceId)
+		return keyspaceStats != nil && keyspaceStats.lastSnapAsOf.Value() >= r.minSnapAsOf.UnixNano()
+	}
+
+	for {
+		waitCh := gSnapAsOfWaiters.waitCh(streamId, keyspaceId)
+		if isSnapshotAsOf() {
+			return nil
+		}
+
+		if s.isBootstrapMode() {
+			return common.ErrIndexNotReady
+		}
+
+		select {
+		case <-waitCh:
+		case <-r.getTimeoutCh():
+			return common.ErrScanTimedOut
+		case <-r.CancelCh:
+			return common.ErrClientCancel
+		case <-r.adminCancelCh:
+			return common.ErrAdminCancel
+		}
+	}
+}
+
 func readDeallocSnapshot(ch chan interface{}) {
 	msg := <-ch
 	if msg == nil {
@@ -902,7 +1126,7 @@ func isSnapshotConsistent(
 			// in receiving a rollback.
 			// return nil, ErrVbuuidMismatch
 			return false
-		} else if cons == common.AnyConsistency {
+		} else if cons == common.AnyConsistency || cons == common.BoundedStalenessConsistency {
 			return true
 		}
 	}
@@ -1034,6 +1258,14 @@ func (s *scanCoordinator) respondWithError(conn net.Conn, req *ScanRequest, err
 		res = &protobuf.StatisticsResponse{
 			Err: protoErr,
 		}
+	case HistogramReq:
+		res = &protobuf.HistogramResponse{
+			Err: protoErr,
+		}
+	case WatchReq:
+		res = &protobuf.WatchResponse{
+			Err: protoErr,
+		}
 	case CountReq:
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
@@ -1069,7 +1301,7 @@ func (s *scanCoordinator) tryRespondWithError(w ScanResponseWriter, req *ScanReq
 		if err == common.ErrIndexNotFound {
 			stats := s.stats.Get()
 			stats.notFoundError.Add(1)
-		} else if err == common.ErrIndexerInBootstrap {
+		} else if err == common.ErrIndexerInBootstrap || err == common.ErrIndexerBusy {
 			logging.Verbosef("%s REQUEST %s", req.LogPrefix, req)
 			logging.Verbosef("%s RESPONSE status:(error = %s), requestId: %v", req.LogPrefix, err, req.RequestId)
 		} else {
@@ -1093,6 +1325,8 @@ func (s *scanCoordinator) updateErrStats(req *ScanRequest, err error) {
 			req.Stats.numScanTimeouts.Add(1)
 		case common.ErrIndexNotReady:
 			req.Stats.notReadyError.Add(1)
+		case common.ErrIndexerBusy:
+			req.Stats.numScansRejected.Add(1)
 		default:
 			req.Stats.numScanErrors.Add(1)
 		}
@@ -1206,6 +1440,7 @@ func (s *scanCoordinator) handleUpdateIndexInstMap(cmd Message) {
 	}
 
 	s.updateLastSnapshotMap()
+	s.histograms.prune(s.indexInstMap)
 
 	if len(req.GetRollbackTimes()) != 0 {
 		logging.Infof("ScanCoordinator::initialize rollback times on new index inst map: %v", req.GetRollbackTimes())
@@ -1229,6 +1464,8 @@ func (s *scanCoordinator) handleUpdateIndexPartnMap(cmd Message) {
 func (s *scanCoordinator) handleConfigUpdate(cmd Message) {
 	cfgUpdate := cmd.(*MsgConfigUpdate)
 	s.config.Store(cfgUpdate.GetConfig())
+	s.admission.configure(cfgUpdate.GetConfig())
+	gRangeWatches.configure(cfgUpdate.GetConfig())
 	s.supvCmdch <- &MsgSuccess{}
 }
 
@@ -1459,10 +1696,11 @@ func (s *scanCoordinator) updateItemsCount(instId common.IndexInstId, idxStats *
 /////////////////////////////////////////////////////////////////////////
 
 type CancelCb struct {
-	done    chan struct{}
-	timeout <-chan time.Time
-	cancel  <-chan bool
-	callb   func(error)
+	done        chan struct{}
+	timeout     <-chan time.Time
+	cancel      <-chan bool
+	adminCancel <-chan struct{}
+	callb       func(error)
 }
 
 func (c *CancelCb) Run() {
@@ -1471,6 +1709,8 @@ func (c *CancelCb) Run() {
 		case <-c.done:
 		case <-c.cancel:
 			c.callb(common.ErrClientCancel)
+		case <-c.adminCancel:
+			c.callb(common.ErrAdminCancel)
 		case <-c.timeout:
 			c.callb(common.ErrScanTimedOut)
 		}
@@ -1483,10 +1723,11 @@ func (c *CancelCb) Done() {
 
 func NewCancelCallback(req *ScanRequest, callb func(error)) *CancelCb {
 	cb := &CancelCb{
-		done:    make(chan struct{}),
-		cancel:  req.CancelCh,
-		timeout: req.getTimeoutCh(),
-		callb:   callb,
+		done:        make(chan struct{}),
+		cancel:      req.CancelCh,
+		adminCancel: req.adminCancelCh,
+		timeout:     req.getTimeoutCh(),
+		callb:       callb,
 	}
 
 	return cb
@@ -1582,6 +1823,10 @@ func (s *scanCoordinator) updateLastSnapshotMap() {
 					DestroyIndexSnapshot(sc.snap)
 					sc.snap = nil
 				}
+				if sc.cursorSnap != nil {
+					DestroyIndexSnapshot(sc.cursorSnap)
+					sc.cursorSnap = nil
+				}
 
 				//set sc.deleted to true to indicate to concurrent readers
 				//that this snap container should no longer be used to
@@ -0,0 +1,313 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+var (
+	ErrInvalidScanCursor       = errors.New("Invalid scan cursor")
+	ErrScanCursorNotSupported  = errors.New("Scan cursor is not supported for distinct, aggregate or unsorted scans")
+	ErrScanCursorIndexMismatch = errors.New("Scan cursor does not belong to the index")
+)
+
+const scanCursorVersion = byte(2)
+
+// scanCursor is the continuation token returned to the client along with
+// every batch of rows, for scans that request it. It identifies the last
+// row sent to the client and the snapshot that was scanned, so that a
+// failed scan can be resumed right after that row instead of being
+// restarted.
+//
+// Rows of a partitioned index are merged in the order of their keys, and
+// rows of different partitions having the same key are merged in the
+// order of partition id. So the last row is identified by its key, its
+// partition and its storage entry, which orders the rows of a partition
+// having the same key by docid.
+//
+// Cursor is opaque to the client and is encoded as:
+//
+//	version | defnId | scanPos | rows | partnId | len(entry) | entry | snapshot seqnos
+//
+// where integers are encoded as uvarints. Seqnos of the snapshot are the
+// same for all rows of a scan, and are appended only to the cursor sent
+// with a batch of rows.
+type scanCursor struct {
+	defnId  common.IndexDefnId
+	scanPos int                // Position of scan in ScanRequest.Scans
+	rows    uint64             // Rows returned so far, counted against the limit
+	partnId common.PartitionId // Partition of the last row
+	entry   []byte             // Storage encoded entry of the last row
+	seqnos  []uint64           // Timestamp of the snapshot that was scanned
+}
+
+// encode encodes the cursor without the snapshot seqnos, which are encoded
+// once per scan by encodeCursorSnapshot.
+func (c *scanCursor) encode(buf []byte) []byte {
+	buf = append(buf[:0], scanCursorVersion)
+	buf = binary.AppendUvarint(buf, uint64(c.defnId))
+	buf = binary.AppendUvarint(buf, uint64(c.scanPos))
+	buf = binary.AppendUvarint(buf, c.rows)
+	buf = binary.AppendUvarint(buf, uint64(c.partnId))
+	buf = binary.AppendUvarint(buf, uint64(len(c.entry)))
+	return append(buf, c.entry...)
+}
+
+func encodeCursorSnapshot(ts *common.TsVbuuid) []byte {
+	if ts == nil {
+		return binary.AppendUvarint(nil, 0)
+	}
+
+	buf := make([]byte, 0, binary.MaxVarintLen32*(len(ts.Seqnos)+1))
+	buf = binary.AppendUvarint(buf, uint64(len(ts.Seqnos)))
+	for _, seqno := range ts.Seqnos {
+		buf = binary.AppendUvarint(buf, seqno)
+	}
+	return buf
+}
+
+func decodeScanCursor(b []byte) (*scanCursor, error) {
+	if len(b) == 0 || b[0] != scanCursorVersion {
+		return nil, ErrInvalidScanCursor
+	}
+	b = b[1:]
+
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, false
+		}
+		b = b[n:]
+		return v, true
+	}
+
+	var vals [5]uint64
+	for i := range vals {
+		var ok bool
+		if vals[i], ok = next(); !ok {
+			return nil, ErrInvalidScanCursor
+		}
+	}
+
+	if vals[4] == 0 || uint64(len(b)) < vals[4] {
+		return nil, ErrInvalidScanCursor
+	}
+	entry := append([]byte(nil), b[:vals[4]]...)
+	b = b[vals[4]:]
+
+	numVbs, ok := next()
+	if !ok || numVbs > uint64(len(b)) {
+		return nil, ErrInvalidScanCursor
+	}
+	var seqnos []uint64
+	if numVbs > 0 {
+		seqnos = make([]uint64, numVbs)
+	}
+	for i := range seqnos {
+		if seqnos[i], ok = next(); !ok {
+			return nil, ErrInvalidScanCursor
+		}
+	}
+
+	return &scanCursor{
+		defnId:  common.IndexDefnId(vals[0]),
+		scanPos: int(vals[1]),
+		rows:    vals[2],
+		partnId: common.PartitionId(vals[3]),
+		entry:   entry,
+		seqnos:  seqnos,
+	}, nil
+}
+
+// isSnapshot returns true if the cursor was returned by a scan of the
+// snapshot with timestamp ts.
+func (c *scanCursor) isSnapshot(ts *common.TsVbuuid) bool {
+	if ts == nil || len(ts.Seqnos) != len(c.seqnos) {
+		return false
+	}
+	for i, seqno := range ts.Seqnos {
+		if seqno != c.seqnos[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *ScanRequest) setScanCursor(needCursor bool, cursor []byte, distinct bool) (err error) {
+	if !needCursor && len(cursor) == 0 {
+		return nil
+	}
+
+	// Rows are resumed in storage order, which is not the order in
+	// which distinct, aggregate or unsorted partitioned scans return them.
+	if distinct || r.Distinct || r.GroupAggr != nil || (!r.Sorted && len(r.PartitionIds) > 1) {
+		return ErrScanCursorNotSupported
+	}
+
+	r.needCursor = needCursor
+	if len(cursor) == 0 {
+		return nil
+	}
+
+	if r.resumeCursor, err = decodeScanCursor(cursor); err != nil {
+		return err
+	}
+	return r.applyScanCursor()
+}
+
+// applyScanCursor positions the request right after the row identified by
+// the cursor. Scans that were already completed are dropped and the scan
+// that was in progress restarts from the key of the last row. Rows having
+// the same key are skipped by the scan source using skipEntry.
+func (r *ScanRequest) applyScanCursor() error {
+	c := r.resumeCursor
+	if c.defnId != r.IndexInst.Defn.DefnId {
+		return ErrScanCursorIndexMismatch
+	}
+	if c.scanPos >= len(r.Scans) {
+		return ErrInvalidScanCursor
+	}
+
+	r.cursorScanPos = c.scanPos
+	r.cursorRows = c.rows
+
+	if r.Limit > 0 && c.rows >= uint64(r.Limit) {
+		// All rows were already returned
+		r.Scans = nil
+		return nil
+	}
+	if r.Limit > 0 {
+		r.Limit -= int64(c.rows)
+	}
+
+	var key IndexKey
+	if r.isPrimary {
+		key, _ = NewPrimaryKey(c.entry)
+	} else {
+		entry := secondaryIndexEntry(c.entry)
+		k := secondaryKey(c.entry[:entry.lenKey()])
+		key = &k
+	}
+
+	scan := r.Scans[c.scanPos]
+	switch scan.ScanType {
+	case AllReq:
+		scan.ScanType = RangeReq
+		scan.High = MaxIndexKey
+		scan.Incl = Both
+		scan.Low = key
+	case RangeReq, FilterRangeReq:
+		scan.Low = key
+		scan.Incl = Low | (scan.Incl & High)
+	}
+
+	r.Scans = append([]Scan{scan}, r.Scans[c.scanPos+1:]...)
+
+	// Offset was already applied before the first row was returned
+	r.Offset = 0
+
+	return nil
+}
+
+// skipEntry returns true if storage entry of partition partnId is at or
+// before the cursor, in the order in which rows are returned.
+func (c *scanCursor) skipEntry(entry []byte, partnId common.PartitionId, isPrimary bool) bool {
+	key, cursorKey := entry, c.entry
+	if !isPrimary {
+		e, ce := secondaryIndexEntry(entry), secondaryIndexEntry(c.entry)
+		key, cursorKey = entry[:e.lenKey()], c.entry[:ce.lenKey()]
+	}
+
+	if cmp := bytes.Compare(key, cursorKey); cmp != 0 {
+		return cmp < 0
+	}
+	if partnId != c.partnId {
+		return partnId < c.partnId
+	}
+	return bytes.Compare(entry, c.entry) <= 0
+}
+
+// setCursorSnapshot records the timestamp of the snapshot that is scanned,
+// to be returned with the cursor.
+func (r *ScanRequest) setCursorSnapshot(ts *common.TsVbuuid) {
+	if !r.needCursor {
+		return
+	}
+
+	r.cursorSnapshot = encodeCursorSnapshot(ts)
+
+	if r.resumeCursor != nil && !r.resumeCursor.isSnapshot(ts) {
+		// Rows that changed between the two snapshots may be returned
+		// twice or not at all
+		logging.Infof("%v resuming scan against a different snapshot, the snapshot "+
+			"of the cursor is no longer available", r.LogPrefix)
+	}
+}
+
+// resumeSnapshot returns the snapshot scanned before the cursor of the
+// request, if it is still available and consistent with the request.
+// Caller holds the lock of the container.
+func (sc *IndexSnapshotContainer) resumeSnapshot(r *ScanRequest) IndexSnapshot {
+	for _, ss := range []IndexSnapshot{sc.cursorSnap, sc.snap} {
+		if ss != nil && r.resumeCursor.isSnapshot(ss.Timestamp()) &&
+			isSnapshotConsistent(ss, *r.Consistency, r.Ts) {
+
+			if ss == sc.cursorSnap {
+				sc.cursorSnapTime = time.Now()
+			}
+			return CloneIndexSnapshot(ss)
+		}
+	}
+	return nil
+}
+
+// retainCursorSnapshot retains the snapshot of a scan returning a cursor,
+// so that the scan can be resumed against it. A snapshot is retained
+// per index, until it is not used by a scan for the retention period.
+func (s *scanCoordinator) retainCursorSnapshot(r *ScanRequest, is IndexSnapshot) {
+	if !r.needCursor || is == nil || is.Timestamp() == nil {
+		return
+	}
+	if s.config.Load()["scan.cursor_snapshot_retention"].Int() <= 0 {
+		return
+	}
+
+	sc, ok := s.lastSnapshot.Get()[r.IndexInstId]
+	if !ok || sc == nil {
+		return
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+
+	if sc.deleted {
+		return
+	}
+	if sc.cursorSnap != is {
+		DestroyIndexSnapshot(sc.cursorSnap)
+		sc.cursorSnap = CloneIndexSnapshot(is)
+	}
+	sc.cursorSnapTime = time.Now()
+}
+
+// expireCursorSnapshot destroys the retained snapshot if it was not used
+// for the retention period. Caller holds the lock of the container.
+func (sc *IndexSnapshotContainer) expireCursorSnapshot(retention time.Duration) {
+	if sc.cursorSnap != nil && time.Since(sc.cursorSnapTime) > retention {
+		DestroyIndexSnapshot(sc.cursorSnap)
+		sc.cursorSnap = nil
+	}
+}
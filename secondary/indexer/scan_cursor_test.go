@@ -0,0 +1,112 @@
+package indexer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanCursorEncodeDecode(t *testing.T) {
+	e, err := newSKEntry([]byte(`["abc",10]`), []byte("doc1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := scanCursor{defnId: 1234, scanPos: 2, rows: 100, partnId: 3, entry: e.Bytes()}
+	ts := common.NewTsVbuuid("default", 4)
+	ts.Seqnos = []uint64{10, 0, 300000, 7}
+	buf := append(c.encode(nil), encodeCursorSnapshot(ts)...)
+
+	c2, err := decodeScanCursor(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2.defnId != c.defnId || c2.scanPos != c.scanPos || c2.rows != c.rows ||
+		c2.partnId != c.partnId || !bytes.Equal(c2.entry, c.entry) {
+		t.Errorf("Expected %v, received %v", c, *c2)
+	}
+	if !c2.isSnapshot(ts) {
+		t.Errorf("Expected snapshot %v, received %v", ts.Seqnos, c2.seqnos)
+	}
+	ts.Seqnos[2]++
+	if c2.isSnapshot(ts) {
+		t.Errorf("Expected snapshot %v to not match %v", ts.Seqnos, c2.seqnos)
+	}
+
+	if _, err := decodeScanCursor(buf[:3]); err != ErrInvalidScanCursor {
+		t.Errorf("Expected %v, received %v", ErrInvalidScanCursor, err)
+	}
+	if _, err := decodeScanCursor(nil); err != ErrInvalidScanCursor {
+		t.Errorf("Expected %v, received %v", ErrInvalidScanCursor, err)
+	}
+}
+
+func TestScanCursorApply(t *testing.T) {
+	e1, _ := newSKEntry([]byte(`["abc",10]`), []byte("doc1"))
+	e2, _ := newSKEntry([]byte(`["abc",10]`), []byte("doc2"))
+	e3, _ := newSKEntry([]byte(`["abd",10]`), []byte("doc0"))
+
+	r := &ScanRequest{
+		IndexInst: common.IndexInst{Defn: common.IndexDefn{DefnId: 1234}},
+		Scans:     []Scan{{ScanType: AllReq}, {ScanType: AllReq}},
+		Limit:     10,
+		Offset:    5,
+	}
+	r.resumeCursor = &scanCursor{defnId: 1234, scanPos: 1, rows: 4, entry: e1.Bytes()}
+	if err := r.applyScanCursor(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Scans) != 1 || r.Scans[0].ScanType != RangeReq || r.Scans[0].Incl != Both {
+		t.Errorf("Unexpected scans %v", r.Scans)
+	}
+	if r.Limit != 6 || r.Offset != 0 || r.cursorScanPos != 1 || r.cursorRows != 4 {
+		t.Errorf("Unexpected limit %v offset %v", r.Limit, r.Offset)
+	}
+	if !r.resumeCursor.skipEntry(e1.Bytes(), 0, false) {
+		t.Errorf("Expected entry at cursor to be skipped")
+	}
+	if r.resumeCursor.skipEntry(e2.Bytes(), 0, false) || r.resumeCursor.skipEntry(e3.Bytes(), 0, false) {
+		t.Errorf("Expected entries after cursor to be scanned")
+	}
+
+	r.resumeCursor.defnId = 1
+	if err := r.applyScanCursor(); err != ErrScanCursorIndexMismatch {
+		t.Errorf("Expected %v, received %v", ErrScanCursorIndexMismatch, err)
+	}
+}
+
+func TestScanCursorSkipPartitions(t *testing.T) {
+	entry := func(key, docid string) []byte {
+		e, _ := newSKEntry([]byte(key), []byte(docid))
+		return e.Bytes()
+	}
+
+	// Rows having the same key are returned in the order of partition
+	c := &scanCursor{partnId: 2, entry: entry(`["abc"]`, "doc5")}
+
+	for _, tc := range []struct {
+		entry   []byte
+		partnId common.PartitionId
+		skip    bool
+	}{
+		{entry(`["abb"]`, "doc9"), 3, true},
+		{entry(`["abc"]`, "doc9"), 1, true},
+		{entry(`["abc"]`, "doc1"), 2, true},
+		{entry(`["abc"]`, "doc5"), 2, true},
+		{entry(`["abc"]`, "doc6"), 2, false},
+		{entry(`["abc"]`, "doc1"), 3, false},
+		{entry(`["abd"]`, "doc0"), 1, false},
+	} {
+		if skip := c.skipEntry(tc.entry, tc.partnId, false); skip != tc.skip {
+			t.Errorf("Expected skip %v for entry %q of partition %v", tc.skip, tc.entry, tc.partnId)
+		}
+	}
+
+	// Primary keys are docids, unique across partitions
+	c = &scanCursor{partnId: 2, entry: []byte("doc5")}
+	if !c.skipEntry([]byte("doc4"), 3, true) || c.skipEntry([]byte("doc6"), 1, true) {
+		t.Errorf("Expected primary keys to be skipped by docid")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbase/indexing/secondary/collatejson"
@@ -54,16 +55,16 @@ func (p *ScanPipeline) Execute() error {
 	return p.object.Execute()
 }
 
-func (p ScanPipeline) RowsReturned() uint64 {
-	return p.rowsReturned
+func (p *ScanPipeline) RowsReturned() uint64 {
+	return atomic.LoadUint64(&p.rowsReturned)
 }
 
-func (p ScanPipeline) BytesRead() uint64 {
-	return p.bytesRead
+func (p *ScanPipeline) BytesRead() uint64 {
+	return atomic.LoadUint64(&p.bytesRead)
 }
 
-func (p ScanPipeline) RowsScanned() uint64 {
-	return p.rowsScanned
+func (p *ScanPipeline) RowsScanned() uint64 {
+	return atomic.LoadUint64(&p.rowsScanned)
 }
 
 func (p ScanPipeline) CacheHitRatio() int {
@@ -138,6 +139,7 @@ func (s *IndexScanSource) Routine() error {
 
 	r := s.p.req
 	var currentScan Scan
+	var currentScanPos int
 	currOffset := int64(0)
 	count := 1
 	checkDistinct := r.Distinct && !r.isPrimary
@@ -187,13 +189,24 @@ func (s *IndexScanSource) Routine() error {
 
 	}
 
+	var cursor scanCursor
+	var cursorBuf []byte
+	if r.needCursor {
+		cursor.defnId = r.IndexInst.Defn.DefnId
+	}
+
 	iterCount := 0
 	fn := func(entry []byte) error {
 		if iterCount%SCAN_ROLLBACK_ERROR_BATCHSIZE == 0 && r.hasRollback != nil && r.hasRollback.Load() == true {
 			return ErrIndexRollback
 		}
 		iterCount++
-		s.p.rowsScanned++
+		atomic.AddUint64(&s.p.rowsScanned, 1)
+
+		rawEntry := entry
+
+		var t0 time.Time
+		r.trace.mark(&t0)
 
 		skipRow := false
 		var ck [][]byte
@@ -228,6 +241,7 @@ func (s *IndexScanSource) Routine() error {
 		}
 
 		if skipRow {
+			r.trace.lap(traceFilter, &t0)
 			return nil
 		}
 
@@ -239,6 +253,8 @@ func (s *IndexScanSource) Routine() error {
 			}
 		}
 
+		r.trace.lap(traceFilter, &t0)
+
 		if r.GroupAggr != nil {
 
 			if buf == nil {
@@ -260,6 +276,7 @@ func (s *IndexScanSource) Routine() error {
 			}
 
 			err = computeGroupAggr(ck, dk, count, docid, entry, (*buf)[:0], s.p.aggrRes, r.GroupAggr, cktmp, dktmp, &cachedEntry, s.p)
+			r.trace.lap(traceAggr, &t0)
 			if err != nil {
 				return err
 			}
@@ -274,6 +291,7 @@ func (s *IndexScanSource) Routine() error {
 
 			if r.GroupAggr != nil {
 				entry, err = projectGroupAggr((*buf)[:0], r.Indexprojection, s.p.aggrRes, r.isPrimary)
+				r.trace.lap(traceAggr, &t0)
 				if entry == nil {
 					return err
 				}
@@ -283,6 +301,7 @@ func (s *IndexScanSource) Routine() error {
 				}
 
 				entry, err = projectKeys(ck, entry, (*buf)[:0], r, cktmp)
+				r.trace.lap(traceFilter, &t0)
 			}
 			if err != nil {
 				return err
@@ -300,8 +319,18 @@ func (s *IndexScanSource) Routine() error {
 				break
 			}
 			if currOffset >= r.Offset {
-				s.p.rowsReturned++
-				wrErr := s.WriteItem(entry)
+				atomic.AddUint64(&s.p.rowsReturned, 1)
+				var wrErr error
+				if r.needCursor {
+					cursor.scanPos = r.cursorScanPos + currentScanPos
+					cursor.rows = r.cursorRows + s.p.rowsReturned
+					cursor.partnId = r.cursorPartnId
+					cursor.entry = rawEntry
+					cursorBuf = cursor.encode(cursorBuf)
+					wrErr = s.WriteItem(entry, cursorBuf)
+				} else {
+					wrErr = s.WriteItem(entry)
+				}
 				if wrErr != nil {
 					return wrErr
 				}
@@ -325,17 +354,26 @@ func (s *IndexScanSource) Routine() error {
 		return err1
 	}
 
+	scans := r.Scans
 	if r.GroupAggr != nil {
+		s.p.aggrRes.partial = r.GroupAggr.AllowPartialAggr
 		if r.GroupAggr.IsLeadingGroup {
 			s.p.aggrRes.SetMaxRows(1)
 		} else {
 			s.p.aggrRes.SetMaxRows(s.p.config["scan.partial_group_buffer_size"].Int())
 		}
+
+		// Precomputed aggregate is served without scanning the index
+		if rows, ok := r.indexAggregateRows(sliceSnapshots); ok {
+			s.p.aggrRes.rows = rows
+			scans = nil
+		}
 	}
 
 loop:
-	for _, scan := range r.Scans {
+	for i, scan := range scans {
 		currentScan = scan
+		currentScanPos = i
 		err = scatter(r, scan, sliceSnapshots, fn, s.p.config)
 		switch err {
 		case nil:
@@ -381,7 +419,7 @@ loop:
 						return nil
 					}
 
-					s.p.rowsReturned++
+					atomic.AddUint64(&s.p.rowsReturned, 1)
 					wrErr := s.WriteItem(entry)
 					if wrErr != nil {
 						s.CloseWithError(wrErr)
@@ -397,7 +435,7 @@ loop:
 			}
 
 			if currOffset >= r.Offset {
-				s.p.rowsReturned++
+				atomic.AddUint64(&s.p.rowsReturned, 1)
 				wrErr := s.WriteItem(entry)
 				if wrErr != nil {
 					s.CloseWithError(wrErr)
@@ -491,11 +529,20 @@ loop:
 			}
 		}
 
-		d.p.bytesRead += uint64(len(sk) + len(docid))
+		atomic.AddUint64(&d.p.bytesRead, uint64(len(sk)+len(docid)))
 		if !d.p.req.isPrimary && !d.p.req.projectPrimaryKey {
 			docid = nil
 		}
-		err = d.WriteItem(sk, docid)
+		if d.p.req.needCursor {
+			var cursor []byte
+			if cursor, err = d.ReadItem(); err != nil {
+				d.CloseWithError(err)
+				break loop
+			}
+			err = d.WriteItem(sk, docid, cursor)
+		} else {
+			err = d.WriteItem(sk, docid)
+		}
 		if err != nil {
 			break // TODO: Old code. Should it be ClosedWithError?
 		}
@@ -533,9 +580,20 @@ loop:
 			return err
 		}
 
+		var t0 time.Time
+		d.p.req.trace.mark(&t0)
 		if err = d.w.Row(pk, sk); err != nil {
 			return err
 		}
+		d.p.req.trace.lap(traceSend, &t0)
+
+		if d.p.req.needCursor {
+			var cursor []byte
+			if cursor, err = d.ReadItem(); err != nil {
+				return err
+			}
+			d.w.Cursor(cursor, d.p.req.cursorSnapshot)
+		}
 
 		/*
 		   TODO(sarath): Use block chunk send protocol
@@ -980,6 +1038,14 @@ func computeAggrVal(groupAggr *GroupAggr, ak *Aggregate,
 	if ak.KeyPos >= 0 {
 		if ak.AggrFunc == c.AGG_SUM && !groupAggr.IsPrimary {
 			a.decoded = decodedkeys[ak.KeyPos].ActualForIndex()
+		} else if ak.AggrFunc.NeedsDecode() && ak.AggrFunc != c.AGG_SUM {
+			//aggregate decoded value as n1ql value
+			if groupAggr.IsPrimary {
+				a.obj = value.NewValue(string(compositekeys[ak.KeyPos]))
+			} else {
+				a.obj = decodedkeys[ak.KeyPos]
+			}
+			a.n1qlValue = true
 		} else {
 			a.raw = compositekeys[ak.KeyPos]
 		}
@@ -1153,7 +1219,8 @@ func (ar *aggrRow) AddAggregate(aggrs []*aggrVal) error {
 			}
 		}
 		if agg.count > 1 && (agg.typ == c.AGG_SUM || agg.typ == c.AGG_COUNT ||
-			agg.typ == c.AGG_COUNTN) {
+			agg.typ == c.AGG_COUNTN || agg.typ == c.AGG_AVG || agg.typ == c.AGG_ARRAY_AGG ||
+			agg.typ == c.AGG_VARIANCE || agg.typ == c.AGG_STDDEV) {
 			for j := 1; j <= agg.count-1; j++ {
 				if agg.n1qlValue {
 					ar.aggrs[i].fn.AddDeltaObj(agg.obj)
@@ -1195,7 +1262,8 @@ func projectEmptyResult(buf []byte, projection *Projection, groupAggr *GroupAggr
 		aggrs := make([][]byte, len(groupAggr.Aggrs))
 
 		for i, ak := range groupAggr.Aggrs {
-			if ak.AggrFunc == c.AGG_COUNT || ak.AggrFunc == c.AGG_COUNTN {
+			if ak.AggrFunc == c.AGG_COUNT || ak.AggrFunc == c.AGG_COUNTN ||
+				ak.AggrFunc == c.AGG_COUNT_APPROX {
 				aggrs[i] = encodedZero
 			} else {
 				aggrs[i] = encodedNull
@@ -1267,6 +1335,19 @@ func projectGroupAggr(buf []byte, projection *Projection,
 					keysToJoin = append(keysToJoin, gk.raw)
 				}
 			}
+		} else if fn, ok := row.aggrs[projGroup.pos].fn.(c.PartialAggrFunc); ok {
+			//partial aggregates are merged by the client using their state
+			var val []byte
+			if aggrRes.partial {
+				val, err = encodeValue(fn.State())
+			} else {
+				val, err = encodeValue(fn.Value())
+			}
+			if err != nil {
+				l.Errorf("ScanPipeline::projectGroupAggr encodeValue error %v", err)
+				return nil, err
+			}
+			keysToJoin = append(keysToJoin, val)
 		} else {
 			if row.aggrs[projGroup.pos].fn.Type() == c.AGG_SUM ||
 				row.aggrs[projGroup.pos].fn.Type() == c.AGG_COUNT ||
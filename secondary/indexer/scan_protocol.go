@@ -20,12 +20,16 @@ import (
 
 type ScanResponseWriter interface {
 	Error(err error) error
-	Stats(rows, unique uint64, min, max []byte) error
+	Stats(rows, unique, size uint64, min, max []byte) error
 	Count(count uint64) error
 	RawBytes([]byte) error
 	Row(pk, sk []byte) error
+	Cursor(cursor, snapshot []byte)
+	Trace(trace *protobuf.ScanTrace)
 	Done(readUnits uint64, clientVersion uint32) error
-	Helo() error
+	Helo(compression byte) error
+	Histogram(hist *common.Histogram) error
+	Watch(events []*protobuf.WatchEvent, boundary *protobuf.TsConsistency, snapshot bool) error
 }
 
 type protoResponseWriter struct {
@@ -35,17 +39,33 @@ type protoResponseWriter struct {
 	rowBuf     *[]byte
 	rowEntries []*protobuf.IndexEntry
 	rowSize    int
+
+	// Cursor of the last row, cursor sent with a batch of rows is the
+	// cursor of the last row in that batch, followed by the timestamp
+	// of the scanned snapshot.
+	cursor         []byte
+	cursorSnapshot []byte
+
+	// Per-phase timing of a traced scan, sent with StreamEndResponse
+	trace *protobuf.ScanTrace
+
+	compression byte
 }
 
-func NewProtoWriter(t ScanReqType, conn net.Conn) *protoResponseWriter {
+func NewProtoWriter(t ScanReqType, conn net.Conn, compression byte) *protoResponseWriter {
 	return &protoResponseWriter{
-		scanType: t,
-		conn:     conn,
-		encBuf:   p.GetBlock(),
-		rowBuf:   p.GetBlock(),
+		scanType:    t,
+		conn:        conn,
+		encBuf:      p.GetBlock(),
+		rowBuf:      p.GetBlock(),
+		compression: compression,
 	}
 }
 
+func (w *protoResponseWriter) encodeAndWrite(res interface{}) error {
+	return protobuf.EncodeAndWriteCompressed(w.conn, *w.encBuf, res, w.compression)
+}
+
 func (w *protoResponseWriter) writeLen(l int) error {
 	binary.LittleEndian.PutUint16((*w.encBuf)[:2], uint16(l))
 	_, err := w.conn.Write((*w.rowBuf)[:2])
@@ -65,6 +85,14 @@ func (w *protoResponseWriter) Error(err error) error {
 		res = &protobuf.StatisticsResponse{
 			Err: protoErr,
 		}
+	case HistogramReq:
+		res = &protobuf.HistogramResponse{
+			Err: protoErr,
+		}
+	case WatchReq:
+		res = &protobuf.WatchResponse{
+			Err: protoErr,
+		}
 	case CountReq, MultiScanCountReq:
 		res = &protobuf.CountResponse{
 			Count: proto.Int64(0), Err: protoErr,
@@ -75,25 +103,53 @@ func (w *protoResponseWriter) Error(err error) error {
 		}
 	}
 
-	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	return w.encodeAndWrite(res)
 }
 
-func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte) error {
+func (w *protoResponseWriter) Stats(rows, unique, size uint64, min, max []byte) error {
 	res := &protobuf.StatisticsResponse{
 		Stats: &protobuf.IndexStatistics{
 			KeysCount:       proto.Uint64(rows),
 			UniqueKeysCount: proto.Uint64(unique),
 			KeyMin:          min,
 			KeyMax:          max,
+			KeysSize:        proto.Uint64(size),
 		},
 	}
 
-	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	return w.encodeAndWrite(res)
+}
+
+func (w *protoResponseWriter) Histogram(hist *common.Histogram) error {
+	res := &protobuf.HistogramResponse{
+		Histogram: protobuf.NewHistogram(hist),
+	}
+
+	return w.encodeAndWrite(res)
 }
 
-func (w *protoResponseWriter) Helo() error {
+// Watch sends the changes to the entries in the range of a watch. All the
+// changes upto boundary, if not nil, have been sent.
+func (w *protoResponseWriter) Watch(events []*protobuf.WatchEvent,
+	boundary *protobuf.TsConsistency, snapshot bool) error {
+
+	res := &protobuf.WatchResponse{
+		Events:   events,
+		Boundary: boundary,
+	}
+	if snapshot {
+		res.Snapshot = proto.Bool(true)
+	}
+
+	return w.encodeAndWrite(res)
+}
+
+// Helo response is always sent uncompressed, the accepted compression
+// applies to subsequent responses.
+func (w *protoResponseWriter) Helo(compression byte) error {
 	res := &protobuf.HeloResponse{
-		Version: proto.Uint32(common.INDEXER_CUR_VERSION),
+		Version:     proto.Uint32(common.INDEXER_CUR_VERSION),
+		Compression: proto.Uint32(uint32(compression)),
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
@@ -104,7 +160,7 @@ func (w *protoResponseWriter) Count(c uint64) error {
 		Count: proto.Int64(int64(c)),
 	}
 
-	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+	return w.encodeAndWrite(res)
 }
 
 func (w *protoResponseWriter) RawBytes(b []byte) error {
@@ -120,8 +176,8 @@ func (w *protoResponseWriter) RawBytes(b []byte) error {
 func (w *protoResponseWriter) Row(pk, sk []byte) error {
 
 	if w.rowSize != 0 && w.rowSize+len(pk)+len(sk) > len(*w.rowBuf) {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
-		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, Cursor: w.getCursor()}
+		err := w.encodeAndWrite(res)
 		if err != nil {
 			return err
 		}
@@ -153,13 +209,31 @@ func (w *protoResponseWriter) Row(pk, sk []byte) error {
 	return nil
 }
 
+// Cursor is called after Row, with cursor of that row and the encoded
+// timestamp of the scanned snapshot.
+func (w *protoResponseWriter) Cursor(cursor, snapshot []byte) {
+	w.cursor = append(w.cursor[:0], cursor...)
+	w.cursorSnapshot = snapshot
+}
+
+func (w *protoResponseWriter) getCursor() []byte {
+	if len(w.cursor) == 0 {
+		return nil
+	}
+	return append(w.cursor, w.cursorSnapshot...)
+}
+
+func (w *protoResponseWriter) Trace(trace *protobuf.ScanTrace) {
+	w.trace = trace
+}
+
 func (w *protoResponseWriter) Done(readUnits uint64, clientVersion uint32) error {
 	defer p.PutBlock(w.encBuf)
 	defer p.PutBlock(w.rowBuf)
 
 	if (w.scanType == ScanReq || w.scanType == ScanAllReq || w.scanType == FastCountReq) && w.rowSize > 0 {
-		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries}
-		err := protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+		res := &protobuf.ResponseStream{IndexEntries: w.rowEntries, Cursor: w.getCursor()}
+		err := w.encodeAndWrite(res)
 		if err != nil {
 			return err
 		}
@@ -168,9 +242,10 @@ func (w *protoResponseWriter) Done(readUnits uint64, clientVersion uint32) error
 	if clientVersion >= common.INDEXER_72_VERSION {
 		res := &protobuf.StreamEndResponse{
 			ReadUnits: proto.Uint64(readUnits),
+			Trace:     w.trace,
 		}
 
-		return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+		return w.encodeAndWrite(res)
 	}
 
 	return nil
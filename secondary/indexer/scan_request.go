@@ -38,6 +38,8 @@ const (
 	HeloReq                       = "helo"
 	MultiScanCountReq             = "multiscancount"
 	FastCountReq                  = "fastcountreq" //generated internally
+	HistogramReq                  = "histogram"
+	WatchReq                      = "watch"
 )
 
 type ScanRequest struct {
@@ -98,6 +100,10 @@ type ScanRequest struct {
 	Timeout     *time.Timer
 	CancelCh    <-chan bool
 
+	// Closed when the scan is cancelled by admin, nil if the scan is not
+	// in the active scan registry
+	adminCancelCh chan struct{}
+
 	RequestId string
 	LogPrefix string
 
@@ -112,6 +118,38 @@ type ScanRequest struct {
 
 	connCtx *ConnectionContext
 
+	// Payload compression requested by client in HeloRequest
+	compression byte
+
+	// Resumable scans
+	needCursor     bool               // Return scan cursor with every batch of rows
+	resumeCursor   *scanCursor        // Resume scan after the row identified by cursor
+	cursorScanPos  int                // Position of Scans[0] in the original request
+	cursorRows     uint64             // Rows returned before the scan was resumed
+	cursorSnapshot []byte             // Encoded timestamp of the scanned snapshot
+	cursorPartnId  common.PartitionId // Partition of the row being returned
+
+	// Top-K nearest-neighbour scan of vector index
+	vectorScan *vectorScan
+
+	// Per-phase timing of the scan, see scanTrace
+	trace *scanTrace
+
+	// Number of bins of the leading key histogram requested by client
+	histogramBins int
+
+	// Range watch of a WatchReq, registered before its snapshot is taken
+	watch *rangeWatch
+
+	// Priority class of the scan for admission control
+	priority ScanPriority
+
+	// Staleness accepted by a scan with BoundedStalenessConsistency. The scan
+	// is served from a snapshot with all the mutations received by the indexer
+	// as of minSnapAsOf.
+	staleness   time.Duration
+	minSnapAsOf time.Time
+
 	dataEncFmt common.DataEncodingFormat
 	keySzCfg   keySizeConfig
 
@@ -320,16 +358,31 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 	switch req := protoReq.(type) {
 	case *protobuf.HeloRequest:
 		r.ScanType = HeloReq
+		r.compression = byte(req.GetCompression())
 	case *protobuf.StatisticsRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
+		r.User = req.GetUser()
+		r.rollbackTime = req.GetRollbackTime()
+		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
+		cons := common.Consistency(req.GetCons())
+		if cons == 0 {
+			// older clients do not send consistency for statistics
+			cons = common.AnyConsistency
+		}
+		vector := req.GetVector()
 		r.ScanType = StatsReq
 		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
 		r.Sorted = true
+		r.SkipReadMetering = req.GetSkipReadMetering()
 		if err = r.setIndexParams(); err != nil {
 			return
 		}
 
+		if err = r.setConsistency(cons, vector); err != nil {
+			return
+		}
+
 		err = r.fillRanges(
 			req.GetSpan().GetRange().GetLow(),
 			req.GetSpan().GetRange().GetHigh(),
@@ -338,6 +391,54 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 			return
 		}
 
+	case *protobuf.HistogramRequest:
+		r.DefnID = req.GetDefnID()
+		r.RequestId = req.GetRequestId()
+		r.User = req.GetUser()
+		r.rollbackTime = req.GetRollbackTime()
+		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
+		r.ScanType = HistogramReq
+		r.histogramBins = int(req.GetNumBins())
+		if err = r.setIndexParams(); err != nil {
+			return
+		}
+
+		if err = r.setConsistency(common.AnyConsistency, nil); err != nil {
+			return
+		}
+
+	case *protobuf.WatchRequest:
+		r.DefnID = req.GetDefnID()
+		r.RequestId = req.GetRequestId()
+		r.User = req.GetUser()
+		r.PartitionIds = []common.PartitionId{common.NON_PARTITION_ID}
+		r.ScanType = WatchReq
+		r.Incl = Inclusion(req.GetRange().GetInclusion())
+		r.Sorted = true
+		if err = r.setIndexParams(); err != nil {
+			return
+		}
+
+		if !isSupportedWatchIndex(&r.IndexInst.Defn) {
+			err = ErrWatchUnsupported
+			return
+		}
+
+		// A resumed watch starts from a snapshot no older than the last
+		// boundary received by the client
+		cons := common.AnyConsistency
+		if req.GetResumeTs() != nil {
+			cons = common.QueryConsistency
+		}
+		if err = r.setConsistency(cons, req.GetResumeTs()); err != nil {
+			return
+		}
+
+		err = r.fillRanges(req.GetRange().GetLow(), req.GetRange().GetHigh(), nil)
+		if err != nil {
+			return
+		}
+
 	case *protobuf.CountRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
@@ -350,6 +451,8 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
 		r.Sorted = true
 		r.SkipReadMetering = req.GetSkipReadMetering()
+		r.priority = parseScanPriority(req.GetPriority(), ScanPriorityInteractive)
+		r.staleness = time.Duration(req.GetStaleness())
 
 		if err = r.setIndexParams(); err != nil {
 			return
@@ -397,6 +500,8 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		}
 		r.Offset = req.GetOffset()
 		r.SkipReadMetering = req.GetSkipReadMetering()
+		r.priority = parseScanPriority(req.GetPriority(), ScanPriorityInteractive)
+		r.staleness = time.Duration(req.GetStaleness())
 
 		if err = r.setIndexParams(); err != nil {
 			return
@@ -438,6 +543,16 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		}
 		r.setExplodePositions()
 
+		if err = r.setScanCursor(req.GetNeedCursor(), req.GetResumeCursor(), req.GetDistinct()); err != nil {
+			return
+		}
+
+		if err = r.setVectorScan(req.GetVectorScan()); err != nil {
+			return
+		}
+
+		r.trace = newScanTrace(req.GetTrace())
+
 	case *protobuf.ScanAllRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
@@ -453,6 +568,9 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		r.Sorted = true
 		r.dataEncFmt = common.DataEncodingFormat(req.GetDataEncFmt())
 		r.SkipReadMetering = req.GetSkipReadMetering()
+		// full scans are batch, unless the client asks otherwise
+		r.priority = parseScanPriority(req.GetPriority(), ScanPriorityBatch)
+		r.staleness = time.Duration(req.GetStaleness())
 
 		if err = r.setIndexParams(); err != nil {
 			return
@@ -461,10 +579,18 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 		if err = r.setConsistency(cons, vector); err != nil {
 			return
 		}
+
+		r.trace = newScanTrace(req.GetTrace())
 	default:
 		err = ErrUnsupportedRequest
 	}
 
+	// Requests other than scans are not traced for the client, but their
+	// wait and iteration time is collected for the slow scan log
+	if r.trace == nil {
+		r.trace = newScanTrace(false)
+	}
+
 	return
 }
 
@@ -1197,6 +1323,11 @@ func (r *ScanRequest) setConsistency(cons common.Consistency, vector *protobuf.T
 		}
 		r.Ts.Crc64 = 0
 		r.Ts.Bucket = r.Bucket
+	} else if cons == common.BoundedStalenessConsistency {
+		if r.staleness < 0 {
+			r.staleness = 0
+		}
+		r.minSnapAsOf = time.Now().Add(-r.staleness)
 	}
 	return
 }
@@ -1443,7 +1574,7 @@ func (r *ScanRequest) unmarshallAggrs(protoGroupAggr *protobuf.GroupAggr) error
 				r.GroupAggr.exprContext = expression.NewIndexContext()
 			}
 		} else {
-			if aggr.AggrFunc == common.AGG_SUM {
+			if aggr.AggrFunc.NeedsDecode() {
 				r.GroupAggr.NeedDecode = true
 				if !r.isPrimary {
 					r.decodePositions[aggr.KeyPos] = true
@@ -1511,6 +1642,13 @@ outerloop:
 			logging.Errorf("ScanRequest::validateGroupAggr %v %v", ErrInvalidAggrFunc, a.AggrFunc)
 			return ErrInvalidAggrFunc
 		}
+		//distinct values cannot be tracked across partial states
+		if a.Distinct && r.GroupAggr.AllowPartialAggr && (a.AggrFunc == common.AGG_AVG ||
+			a.AggrFunc == common.AGG_VARIANCE || a.AggrFunc == common.AGG_STDDEV) {
+			err = fmt.Errorf("Partial Aggr Not Supported For Distinct %v", a.AggrFunc)
+			logging.Errorf("ScanRequest::validateGroupAggr %v", err)
+			return err
+		}
 		if int(a.KeyPos) >= len(r.IndexInst.Defn.SecExprs) {
 			err = fmt.Errorf("Invalid KeyPos In Aggr %v", a)
 			logging.Errorf("ScanRequest::validateGroupAggr %v", err)
@@ -1864,6 +2002,36 @@ func (r ScanRequest) String() string {
 	str := fmt.Sprintf("defnId:%v, instId:%v, index:%v/%v, type:%v, partitions:%v user:%v",
 		r.DefnID, r.IndexInstId, r.Bucket, r.IndexName, r.ScanType, r.PartitionIds, r.User)
 
+	if len(r.Scans) == 0 {
+		str += fmt.Sprintf(", span:%s", r.spansString())
+	} else {
+		str += fmt.Sprintf(", scans: %s", r.spansString())
+	}
+
+	if r.Limit > 0 {
+		str += fmt.Sprintf(", limit:%d", r.Limit)
+	}
+
+	if r.Consistency != nil {
+		str += fmt.Sprintf(", consistency:%s", strings.ToLower(r.Consistency.String()))
+		if *r.Consistency == common.BoundedStalenessConsistency {
+			str += fmt.Sprintf(", staleness:%v", r.staleness)
+		}
+	}
+
+	if r.RequestId != "" {
+		str += fmt.Sprintf(", requestId:%v", r.RequestId)
+	}
+
+	if r.GroupAggr != nil {
+		str += fmt.Sprintf(", groupaggr: %v", r.GroupAggr)
+	}
+
+	return str
+}
+
+// spansString returns the spans of the request, tagged as user data.
+func (r ScanRequest) spansString() string {
 	if len(r.Scans) == 0 {
 		var incl, span string
 
@@ -1892,28 +2060,10 @@ func (r ScanRequest) String() string {
 			span = span + ")"
 		}
 
-		str += fmt.Sprintf(", span:%s", logging.TagUD(span))
-	} else {
-		str += fmt.Sprintf(", scans: %+v", logging.TagUD(r.Scans))
-	}
-
-	if r.Limit > 0 {
-		str += fmt.Sprintf(", limit:%d", r.Limit)
-	}
-
-	if r.Consistency != nil {
-		str += fmt.Sprintf(", consistency:%s", strings.ToLower(r.Consistency.String()))
-	}
-
-	if r.RequestId != "" {
-		str += fmt.Sprintf(", requestId:%v", r.RequestId)
+		return fmt.Sprintf("%s", logging.TagUD(span))
 	}
 
-	if r.GroupAggr != nil {
-		str += fmt.Sprintf(", groupaggr: %v", r.GroupAggr)
-	}
-
-	return str
+	return fmt.Sprintf("%+v", logging.TagUD(r.Scans))
 }
 
 func (r *ScanRequest) getKeyBuffer(minSize int) []byte {
@@ -2068,6 +2218,9 @@ type ConnectionContext struct {
 	bufPool map[common.PartitionId]*common.BytesBufPool
 	cache   map[string]ConCacheObj
 	mutex   sync.RWMutex
+
+	// Payload compression negotiated for responses on this connection
+	compression byte
 }
 
 func createConnectionContext() interface{} {
@@ -2100,6 +2253,18 @@ func (c *ConnectionContext) Put(id string, obj ConCacheObj) {
 	c.cache[id] = obj
 }
 
+func (c *ConnectionContext) GetCompression() byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.compression
+}
+
+func (c *ConnectionContext) SetCompression(compression byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.compression = compression
+}
+
 func (c *ConnectionContext) ResetCache() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
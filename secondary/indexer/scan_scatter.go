@@ -14,6 +14,7 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
@@ -163,6 +164,11 @@ func scanOne(request *ScanRequest, scan Scan, snapshots []SliceSnapshot, partiti
 func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, snap SliceSnapshot, partitionId common.PartitionId,
 	queue *Queue, wg *sync.WaitGroup, errch chan error, cb EntryCallback) (count int) {
 
+	// Time spent downstream of storage iteration is excluded from the
+	// iteration time only for traced requests, as it is timed per row
+	begin := time.Now()
+	var downstream time.Duration
+
 	defer func() {
 		if wg != nil {
 			wg.Done()
@@ -171,8 +177,12 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 		request.Stats.updatePartitionStats(partitionId, func(ps *IndexStats) {
 			ps.numRowsScanned.Add(int64(count))
 		})
+
+		request.trace.addPartition(partitionId, time.Since(begin)-downstream, uint64(count))
 	}()
 
+	resumeCursor := request.resumeCursor
+
 	handler := func(entry []byte) error {
 		// Do not call enqueue when there is error.
 		if len(errch) != 0 {
@@ -181,6 +191,19 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 
 		count++
 
+		// Skip rows already returned before the scan was resumed
+		if resumeCursor != nil {
+			if resumeCursor.skipEntry(entry, partitionId, request.isPrimary) {
+				return nil
+			}
+			resumeCursor = nil
+		}
+
+		if request.trace.isRequested() {
+			t0 := time.Now()
+			defer func() { downstream += time.Since(t0) }()
+		}
+
 		if queue != nil {
 
 			var r Row
@@ -193,6 +216,7 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 			queue.Enqueue(&r)
 			return nil
 		} else {
+			request.cursorPartnId = partitionId
 			return cb(entry)
 		}
 	}
@@ -241,7 +265,7 @@ func scatterCount(request *ScanRequest, snapshots []SliceSnapshot, stop StopChan
 	// run scatter
 	for i, snap := range snapshots {
 		wg.Add(1)
-		go countSingleSlice(request, request.Ctxs[i], snap, &wg, errch, stop, &count)
+		go countSingleSlice(request, request.Ctxs[i], snap, getPartitionId(request, i), &wg, errch, stop, &count)
 	}
 
 	// wait for scatter to be done
@@ -254,15 +278,17 @@ func scatterCount(request *ScanRequest, snapshots []SliceSnapshot, stop StopChan
 	return
 }
 
-func countSingleSlice(request *ScanRequest, ctx IndexReaderContext, snap SliceSnapshot, wg *sync.WaitGroup, errch chan error, stopch StopChannel, count *uint64) {
+func countSingleSlice(request *ScanRequest, ctx IndexReaderContext, snap SliceSnapshot, partitionId common.PartitionId, wg *sync.WaitGroup, errch chan error, stopch StopChannel, count *uint64) {
+
+	begin := time.Now()
+	var err error
+	var cnt uint64
 
 	defer func() {
+		request.trace.addPartition(partitionId, time.Since(begin), cnt)
 		wg.Done()
 	}()
 
-	var err error
-	var cnt uint64
-
 	if len(request.Keys) > 0 {
 		cnt, err = snap.Snapshot().CountLookup(ctx, request.Keys, stopch)
 	} else if request.Low.Bytes() == nil && request.High.Bytes() == nil {
@@ -295,7 +321,7 @@ func scatterMultiCount(request *ScanRequest, scan Scan, snapshots []SliceSnapsho
 	// run scatter
 	for i, snap := range snapshots {
 		wg.Add(1)
-		go multiCountSingleSlice(request, scan, request.Ctxs[i], snap, previousRows[i], &wg, errch, stop, &count)
+		go multiCountSingleSlice(request, scan, request.Ctxs[i], snap, getPartitionId(request, i), previousRows[i], &wg, errch, stop, &count)
 	}
 
 	// wait for scatter to be done
@@ -308,16 +334,18 @@ func scatterMultiCount(request *ScanRequest, scan Scan, snapshots []SliceSnapsho
 	return
 }
 
-func multiCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, snap SliceSnapshot, previousRow []byte, wg *sync.WaitGroup,
+func multiCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, snap SliceSnapshot, partitionId common.PartitionId, previousRow []byte, wg *sync.WaitGroup,
 	errch chan error, stopch StopChannel, count *uint64) {
 
+	begin := time.Now()
+	var err error
+	var cnt uint64
+
 	defer func() {
+		request.trace.addPartition(partitionId, time.Since(begin), cnt)
 		wg.Done()
 	}()
 
-	var err error
-	var cnt uint64
-
 	if scan.ScanType == AllReq {
 		cnt, err = snap.Snapshot().MultiScanCount(ctx, MinIndexKey, MaxIndexKey, Both, scan, request.Distinct, stopch)
 	} else if scan.ScanType == LookupReq || scan.ScanType == RangeReq || scan.ScanType == FilterRangeReq {
@@ -335,12 +363,116 @@ func multiCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderConte
 // scatter stats
 //--------------------------
 
-func scatterStats(request *ScanRequest, snapshots []SliceSnapshot, stop StopChannel) (count uint64, err error) {
+// spanStats accumulates statistics for the entries of a span. min and max
+// hold the smallest and the largest storage encoded key in index order.
+type spanStats struct {
+	count    uint64
+	distinct uint64
+	size     uint64
+	min      []byte
+	max      []byte
+}
+
+// scatterStats computes the statistics of the requested span over all the
+// slice snapshots. Entries from multiple slices are gathered in index order,
+// so that distinct keys are counted exactly across partitions. At most
+// settings.statistics.sample_size entries are read. For a larger span, the
+// count is obtained from the slices without iterating the span, the distinct
+// count and size are extrapolated from the entries read, and max is not known.
+func scatterStats(request *ScanRequest, snapshots []SliceSnapshot, stop StopChannel,
+	config common.Config) (stats spanStats, err error) {
 
 	if len(snapshots) == 0 {
 		return
 	}
 
+	var scans []Scan
+	if len(request.Keys) > 0 {
+		for _, key := range request.Keys {
+			scans = append(scans, Scan{Equals: key, ScanType: LookupReq})
+		}
+	} else if request.Low.Bytes() == nil && request.High.Bytes() == nil {
+		scans = append(scans, Scan{ScanType: AllReq})
+	} else {
+		scans = append(scans, Scan{Low: request.Low, High: request.High,
+			Incl: request.Incl, ScanType: RangeReq})
+	}
+
+	sampleSize := uint64(config["settings.statistics.sample_size"].Int())
+
+	var last []byte
+	handler := func(entry []byte) error {
+		select {
+		case <-stop:
+			return common.ErrClientCancel
+		default:
+		}
+
+		if stats.count >= sampleSize {
+			return ErrLimitReached
+		}
+
+		key := entry
+		if !request.isPrimary {
+			key = secondaryIndexEntry(entry).ReadSecKeyCJson()
+		}
+
+		// Entries of a scan are in index order, but the lookup keys are not
+		if stats.min == nil || bytes.Compare(key, stats.min) < 0 {
+			stats.min = append(stats.min[:0], key...)
+		}
+		if stats.max == nil || bytes.Compare(key, stats.max) > 0 {
+			stats.max = append(stats.max[:0], key...)
+		}
+		if last == nil || !bytes.Equal(key, last) {
+			stats.distinct++
+			last = append(last[:0], key...)
+		}
+		stats.count++
+		stats.size += uint64(len(entry))
+		return nil
+	}
+
+	truncated := false
+	for _, scan := range scans {
+		// Every lookup key is a new scan
+		last = nil
+		if err = scatter(request, scan, snapshots, handler, config); err == ErrLimitReached {
+			truncated = true
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+	}
+
+	if !truncated {
+		return
+	}
+
+	sampled := stats.count
+	if stats.count, err = scatterStatsCount(request, snapshots, stop); err != nil {
+		return
+	}
+	if sampled > 0 && stats.count > sampled {
+		stats.distinct = uint64(float64(stats.distinct) * float64(stats.count) / float64(sampled))
+		stats.size = uint64(float64(stats.size) * float64(stats.count) / float64(sampled))
+	} else if stats.count < sampled {
+		stats.count = sampled
+	}
+
+	// Entries beyond the sample can be smaller only for unordered lookups
+	stats.max = nil
+	if len(request.Keys) > 1 {
+		stats.min = nil
+	}
+	return
+}
+
+// scatterStatsCount counts the entries of the requested span without
+// iterating it for a full span.
+func scatterStatsCount(request *ScanRequest, snapshots []SliceSnapshot, stop StopChannel) (count uint64, err error) {
+
 	var wg sync.WaitGroup
 
 	errch := make(chan error, len(snapshots))
@@ -348,7 +480,7 @@ func scatterStats(request *ScanRequest, snapshots []SliceSnapshot, stop StopChan
 	// run scatter
 	for i, snap := range snapshots {
 		wg.Add(1)
-		go statsSingleSlice(request, request.Ctxs[i], snap, &wg, errch, stop, &count)
+		go statsSingleSlice(request, request.Ctxs[i], snap, getPartitionId(request, i), &wg, errch, stop, &count)
 	}
 
 	// wait for scatter to be done
@@ -361,17 +493,21 @@ func scatterStats(request *ScanRequest, snapshots []SliceSnapshot, stop StopChan
 	return
 }
 
-func statsSingleSlice(request *ScanRequest, ctx IndexReaderContext, snap SliceSnapshot, wg *sync.WaitGroup,
+func statsSingleSlice(request *ScanRequest, ctx IndexReaderContext, snap SliceSnapshot, partitionId common.PartitionId, wg *sync.WaitGroup,
 	errch chan error, stopch StopChannel, count *uint64) {
 
+	begin := time.Now()
+	var err error
+	var cnt uint64
+
 	defer func() {
+		request.trace.addPartition(partitionId, time.Since(begin), cnt)
 		wg.Done()
 	}()
 
-	var err error
-	var cnt uint64
-
-	if request.Low.Bytes() == nil && request.High.Bytes() == nil {
+	if len(request.Keys) > 0 {
+		cnt, err = snap.Snapshot().CountLookup(ctx, request.Keys, stopch)
+	} else if request.Low.Bytes() == nil && request.High.Bytes() == nil {
 		cnt, err = snap.Snapshot().StatCountTotal()
 	} else {
 		cnt, err = snap.Snapshot().CountRange(ctx, request.Low, request.High, request.Incl, stopch)
@@ -401,7 +537,7 @@ func scatterFastCount(request *ScanRequest, scan Scan, snapshots []SliceSnapshot
 	// run scatter
 	for i, snap := range snapshots {
 		wg.Add(1)
-		go fastCountSingleSlice(request, scan, request.Ctxs[i], snap, &wg, errch, stop, &count)
+		go fastCountSingleSlice(request, scan, request.Ctxs[i], snap, getPartitionId(request, i), &wg, errch, stop, &count)
 	}
 
 	// wait for scatter to be done
@@ -414,15 +550,17 @@ func scatterFastCount(request *ScanRequest, scan Scan, snapshots []SliceSnapshot
 	return
 }
 
-func fastCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, snap SliceSnapshot, wg *sync.WaitGroup,
+func fastCountSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, snap SliceSnapshot, partitionId common.PartitionId, wg *sync.WaitGroup,
 	errch chan error, stopch StopChannel, count *uint64) {
 
+	begin := time.Now()
+	var err error
+	var cnt uint64
+
 	defer func() {
+		request.trace.addPartition(partitionId, time.Since(begin), cnt)
 		wg.Done()
 	}()
-
-	var err error
-	var cnt uint64
 	var nullCnt uint64
 
 	desc := false
@@ -487,7 +625,7 @@ func scan_sort(request *ScanRequest, queues []*Queue, rows []Row, sorted []int)
 
 			if rows[sorted[i]].last && !rows[sorted[j]].last ||
 				(!rows[sorted[i]].last && !rows[sorted[j]].last &&
-					compareKey(request, &rows[sorted[i]], &rows[sorted[j]]) > 0) {
+					compareRows(request, rows, sorted[i], sorted[j]) > 0) {
 				tmp := sorted[i]
 				sorted[i] = sorted[j]
 				sorted[j] = tmp
@@ -520,7 +658,7 @@ func scan_pick(request *ScanRequest, queues []*Queue, rows []Row, sorted []int)
 		// last value always sorted last
 		if rows[sorted[pos]].last && !rows[sorted[i]].last ||
 			(!rows[sorted[pos]].last && !rows[sorted[i]].last &&
-				compareKey(request, &rows[sorted[pos]], &rows[sorted[i]]) > 0) {
+				compareRows(request, rows, sorted[pos], sorted[i]) > 0) {
 
 			tmp := sorted[pos]
 			sorted[pos] = sorted[i]
@@ -600,6 +738,7 @@ func gather(request *ScanRequest, queues []*Queue, donech chan bool, notifych ch
 		}
 
 		if queues[id].Dequeue(&rows[id]) {
+			request.cursorPartnId = getPartitionId(request, id)
 			if err := cb(rows[id].key); err != nil {
 				errch <- err
 
@@ -676,6 +815,23 @@ func forward(request *ScanRequest, queues []*Queue, donech chan bool, notifych c
 	}
 }
 
+// compareRows compares rows of partitions at positions i and j. Rows having
+// the same key are ordered by partition id, so that the order of rows is
+// the same when a scan is resumed using a scan cursor.
+func compareRows(request *ScanRequest, rows []Row, i, j int) int {
+	if cmp := compareKey(request, &rows[i], &rows[j]); cmp != 0 {
+		return cmp
+	}
+
+	pi, pj := getPartitionId(request, i), getPartitionId(request, j)
+	if pi < pj {
+		return -1
+	} else if pi > pj {
+		return 1
+	}
+	return 0
+}
+
 func compareKey(request *ScanRequest, k1 *Row, k2 *Row) int {
 
 	if request.isPrimary {
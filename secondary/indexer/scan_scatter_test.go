@@ -0,0 +1,123 @@
+package indexer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// statsTestSnapshot is a primary index snapshot over sorted docids.
+type statsTestSnapshot struct {
+	Snapshot
+	entries [][]byte
+}
+
+type statsTestSliceSnapshot struct {
+	snap *statsTestSnapshot
+}
+
+func (s *statsTestSliceSnapshot) SliceId() SliceId {
+	return SliceId(0)
+}
+
+func (s *statsTestSliceSnapshot) Snapshot() Snapshot {
+	return s.snap
+}
+
+func (s *statsTestSnapshot) All(ctx IndexReaderContext, cb EntryCallback) error {
+	return s.Range(ctx, nil, nil, Both, cb)
+}
+
+func (s *statsTestSnapshot) Range(ctx IndexReaderContext, low, high IndexKey, incl Inclusion,
+	cb EntryCallback) error {
+
+	for _, entry := range s.entries {
+		if low != nil {
+			if c := bytes.Compare(entry, low.Bytes()); c < 0 || (c == 0 && incl != Low && incl != Both) {
+				continue
+			}
+		}
+		if high != nil {
+			if c := bytes.Compare(entry, high.Bytes()); c > 0 || (c == 0 && incl != High && incl != Both) {
+				continue
+			}
+		}
+		if err := cb(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *statsTestSnapshot) StatCountTotal() (uint64, error) {
+	return uint64(len(s.entries)), nil
+}
+
+func (s *statsTestSnapshot) CountRange(ctx IndexReaderContext, low, high IndexKey, incl Inclusion,
+	stopch StopChannel) (uint64, error) {
+
+	var count uint64
+	err := s.Range(ctx, low, high, incl, func([]byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (s *statsTestSnapshot) CountLookup(ctx IndexReaderContext, keys []IndexKey,
+	stopch StopChannel) (uint64, error) {
+
+	var count uint64
+	for _, key := range keys {
+		n, err := s.CountRange(ctx, key, key, Both, stopch)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func TestScatterStats(t *testing.T) {
+	snap := &statsTestSnapshot{}
+	for _, docid := range []string{"a", "b", "b", "c", "d", "e", "e", "f"} {
+		snap.entries = append(snap.entries, []byte(docid))
+	}
+
+	key := func(k string) IndexKey {
+		ik, _ := NewPrimaryKey([]byte(k))
+		return ik
+	}
+
+	check := func(req *ScanRequest, sampleSize int, count, distinct uint64, min, max string) {
+		t.Helper()
+
+		req.isPrimary = true
+		req.Stats = &IndexStats{}
+		req.Ctxs = []IndexReaderContext{nil}
+		config := common.Config{"settings.statistics.sample_size": common.ConfigValue{Value: sampleSize}}
+
+		stats, err := scatterStats(req, []SliceSnapshot{&statsTestSliceSnapshot{snap}}, make(StopChannel), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.count != count || stats.distinct != distinct ||
+			string(stats.min) != min || string(stats.max) != max {
+			t.Errorf("Expected count %v distinct %v min %q max %q, received %v %v %q %q",
+				count, distinct, min, max, stats.count, stats.distinct, stats.min, stats.max)
+		}
+	}
+
+	// Full span and range
+	check(&ScanRequest{Low: &NilIndexKey{}, High: &NilIndexKey{}}, 100, 8, 6, "a", "f")
+	check(&ScanRequest{Low: key("b"), High: key("e"), Incl: Low}, 100, 4, 3, "b", "d")
+
+	// Min and max of lookup keys are not the first and last keys looked up
+	check(&ScanRequest{Keys: []IndexKey{key("e"), key("a"), key("f"), key("b")}}, 100, 6, 4, "a", "f")
+
+	// The count of a span larger than the sample is not extrapolated, the
+	// distinct count is, and max is not known
+	check(&ScanRequest{Low: &NilIndexKey{}, High: &NilIndexKey{}}, 4, 8, 6, "a", "")
+	check(&ScanRequest{Keys: []IndexKey{key("e"), key("a"), key("f"), key("b")}}, 3, 6, 4, "", "")
+}
@@ -0,0 +1,140 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/golang/protobuf/proto"
+)
+
+type tracePhase int
+
+const (
+	traceWait tracePhase = iota
+	traceFilter
+	traceAggr
+	traceSend
+	numTracePhases
+)
+
+// scanTrace collects the time spent in each phase of a scan request.
+// Phases are updated concurrently by the scan pipeline and per-partition
+// scatter routines. The wait time and the per-partition iteration time,
+// which cost a clock read per request and per partition scan, are always
+// collected for the slow scan log. The filter, aggregate and send phases,
+// and the exclusion of downstream time from the iteration time, need a
+// clock read per row, and are collected only when the client asks for the
+// trace. All methods are no-op for a nil scanTrace.
+type scanTrace struct {
+	phases [numTracePhases]int64
+
+	// requested is true if the client asked for the trace
+	requested bool
+
+	mutex      sync.Mutex
+	partitions map[common.PartitionId]*protobuf.PartitionTrace
+}
+
+func newScanTrace(requested bool) *scanTrace {
+	return &scanTrace{
+		requested:  requested,
+		partitions: make(map[common.PartitionId]*protobuf.PartitionTrace),
+	}
+}
+
+// isRequested returns true if the client asked for the trace, in which case
+// per-row phases are timed and the trace is sent to the client.
+func (t *scanTrace) isRequested() bool {
+	return t != nil && t.requested
+}
+
+func (t *scanTrace) add(phase tracePhase, d time.Duration) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.phases[phase], int64(d))
+}
+
+// mark starts timing a per-row phase at t0.
+func (t *scanTrace) mark(t0 *time.Time) {
+	if !t.isRequested() {
+		return
+	}
+	*t0 = time.Now()
+}
+
+// lap adds time elapsed since t0 to a per-row phase and restarts t0.
+func (t *scanTrace) lap(phase tracePhase, t0 *time.Time) {
+	if !t.isRequested() {
+		return
+	}
+	now := time.Now()
+	atomic.AddInt64(&t.phases[phase], int64(now.Sub(*t0)))
+	*t0 = now
+}
+
+// addPartition adds storage iteration time and rows scanned of one scan
+// of a partition. A partition can be scanned once for every scan of the
+// request.
+func (t *scanTrace) addPartition(partnId common.PartitionId, iterate time.Duration, rows uint64) {
+	if t == nil {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	pt, ok := t.partitions[partnId]
+	if !ok {
+		pt = &protobuf.PartitionTrace{
+			PartitionId: proto.Uint64(uint64(partnId)),
+			IterateTime: proto.Int64(0),
+			RowsScanned: proto.Uint64(0),
+		}
+		t.partitions[partnId] = pt
+	}
+	*pt.IterateTime += int64(iterate)
+	*pt.RowsScanned += rows
+}
+
+func (t *scanTrace) toProto(total time.Duration) *protobuf.ScanTrace {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	res := &protobuf.ScanTrace{
+		WaitTime:   proto.Int64(atomic.LoadInt64(&t.phases[traceWait])),
+		FilterTime: proto.Int64(atomic.LoadInt64(&t.phases[traceFilter])),
+		AggrTime:   proto.Int64(atomic.LoadInt64(&t.phases[traceAggr])),
+		SendTime:   proto.Int64(atomic.LoadInt64(&t.phases[traceSend])),
+		TotalTime:  proto.Int64(int64(total)),
+	}
+
+	var iterate int64
+	for _, pt := range t.partitions {
+		iterate += pt.GetIterateTime()
+		res.Partitions = append(res.Partitions, pt)
+	}
+	res.IterateTime = proto.Int64(iterate)
+
+	sort.Slice(res.Partitions, func(i, j int) bool {
+		return res.Partitions[i].GetPartitionId() < res.Partitions[j].GetPartitionId()
+	})
+
+	return res
+}
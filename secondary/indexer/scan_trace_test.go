@@ -0,0 +1,48 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestScanTrace(t *testing.T) {
+	for _, requested := range []bool{false, true} {
+		trace := newScanTrace(requested)
+
+		trace.add(traceWait, time.Millisecond)
+		trace.addPartition(common.PartitionId(2), time.Millisecond, 10)
+		trace.addPartition(common.PartitionId(1), time.Millisecond, 5)
+		trace.addPartition(common.PartitionId(2), time.Millisecond, 10)
+
+		var t0 time.Time
+		trace.mark(&t0)
+		time.Sleep(time.Millisecond)
+		trace.lap(traceFilter, &t0)
+
+		res := trace.toProto(time.Second)
+
+		// Wait and iteration time are always collected
+		if res.GetWaitTime() != int64(time.Millisecond) {
+			t.Errorf("Expected wait time %v, received %v", time.Millisecond, res.GetWaitTime())
+		}
+		if res.GetIterateTime() != int64(3*time.Millisecond) {
+			t.Errorf("Expected iterate time %v, received %v", 3*time.Millisecond, res.GetIterateTime())
+		}
+		if len(res.Partitions) != 2 || res.Partitions[0].GetPartitionId() != 1 ||
+			res.Partitions[1].GetRowsScanned() != 20 {
+			t.Errorf("Unexpected partitions %v", res.Partitions)
+		}
+
+		// Per-row phases are collected only if the trace is requested
+		if filter := res.GetFilterTime(); (filter != 0) != requested {
+			t.Errorf("Expected filter time to be collected %v, received %v", requested, filter)
+		}
+	}
+
+	var trace *scanTrace
+	if trace.isRequested() || trace.toProto(time.Second) != nil {
+		t.Errorf("Expected nil trace to not be requested")
+	}
+}
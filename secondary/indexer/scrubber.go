@@ -0,0 +1,367 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Scrubber
+//
+// The scrubber verifies the persisted data of the memory optimized and
+// forestdb indexes in the background, so that silent corruption is found
+// before a scan returns wrong results or a recovery fails. Partitions are
+// scrubbed one at a time, reading at most settings.scrub.rate_limit MB/sec,
+// and a pass over all the partitions is started every
+// settings.scrub.interval seconds.
+//
+// A partition found corrupted is rebuilt from DCP. The scrubber marks it
+// with SCRUB_CORRUPTION_MARKER, and on the next bootstrap the data files of
+// the partition are removed, or backed up if enable_corrupt_index_backup is
+// set, before the slice is opened. As the partition has no snapshot, its
+// stream is restarted from 0. Until the indexer restarts, the scans of the
+// partition fail with ErrIndexCorrupted, for the client to scan a replica
+// instead, and the corruption is reported to the console for the admin to
+// restart the indexer. A corrupted disk snapshot of a memory optimized index
+// does not affect the data in memory, and is removed instead.
+//
+// The result of the last scrub of each partition is reported by the
+// last_scrub_time, num_items_scrubbed and num_scrub_corruptions stats.
+
+const SCRUB_CORRUPTION_MARKER = "scrub_corrupted"
+
+var (
+	errScrubAborted  = errors.New("Scrub aborted")
+	errScrubMismatch = errors.New("Main index and back index do not match")
+	errScrubRepaired = errors.New("Corrupted data removed")
+)
+
+// scrubbableSlice is implemented by the slices whose persisted data can be
+// verified by the scrubber.
+type scrubbableSlice interface {
+	// Scrub verifies the persisted data of the slice, and returns the number
+	// of items verified. If the data is corrupted, it returns
+	// errScrubRepaired once the corrupted data is removed without affecting
+	// the data served, or errStorageCorrupted once the slice is marked to be
+	// rebuilt.
+	Scrub(throttle *scrubThrottle) (int64, error)
+}
+
+// scrubbableOf returns the slice wrapped by vectorSlice and aggregateSlice
+// as a scrubbableSlice, if it is one.
+func scrubbableOf(slice Slice) (scrubbableSlice, bool) {
+	for {
+		switch s := slice.(type) {
+		case *vectorSlice:
+			slice = s.Slice
+		case *aggregateSlice:
+			slice = s.Slice
+		default:
+			scrubbable, ok := slice.(scrubbableSlice)
+			return scrubbable, ok
+		}
+	}
+}
+
+type scrubPartnKey struct {
+	instId  common.IndexInstId
+	partnId common.PartitionId
+}
+
+// gScrubCorrupted holds the partitions marked to be rebuilt by the scrubber,
+// whose scans fail until the indexer restarts.
+var gScrubCorrupted sync.Map // scrubPartnKey -> struct{}
+
+// isScrubCorruptedPartition returns true if any of the partitions of the
+// index instance is marked to be rebuilt by the scrubber.
+func isScrubCorruptedPartition(instId common.IndexInstId, partnIds []common.PartitionId) bool {
+	for _, partnId := range partnIds {
+		if _, ok := gScrubCorrupted.Load(scrubPartnKey{instId, partnId}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// markScrubCorrupted marks the slice in path to be rebuilt from DCP on the
+// next bootstrap.
+func markScrubCorrupted(path string) error {
+	msg := fmt.Sprintf("%v", errStorageCorrupted)
+	return common.WriteFileWithSync(filepath.Join(path, SCRUB_CORRUPTION_MARKER), []byte(msg), 0755)
+}
+
+func isScrubCorrupted(path string) bool {
+	_, err := iowrap.Os_Stat(filepath.Join(path, SCRUB_CORRUPTION_MARKER))
+	return err == nil
+}
+
+//////////////////////////////////////////////////////////////////
+// scrubThrottle
+//////////////////////////////////////////////////////////////////
+
+// scrubThrottle limits the rate at which a slice is read by the scrubber.
+type scrubThrottle struct {
+	rate   int64 // bytes per second, 0 if unlimited
+	start  time.Time
+	bytes  int64
+	stopch <-chan struct{}
+}
+
+const scrubThrottleMinSleep = 10 * time.Millisecond
+
+// wait accounts for n bytes read, and sleeps while the reads are ahead of
+// the rate. It returns errScrubAborted once the scrubber is stopped.
+func (t *scrubThrottle) wait(n int) error {
+	t.bytes += int64(n)
+
+	var ahead time.Duration
+	if t.rate > 0 {
+		ahead = time.Duration(float64(t.bytes)/float64(t.rate)*float64(time.Second)) - time.Since(t.start)
+	}
+	if ahead < scrubThrottleMinSleep {
+		select {
+		case <-t.stopch:
+			return errScrubAborted
+		default:
+			return nil
+		}
+	}
+	return t.pause(ahead)
+}
+
+// pause sleeps for d, unless the scrubber is stopped.
+func (t *scrubThrottle) pause(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-t.stopch:
+		return errScrubAborted
+	case <-timer.C:
+		return nil
+	}
+}
+
+//////////////////////////////////////////////////////////////////
+// scrubber
+//////////////////////////////////////////////////////////////////
+
+type scrubber struct {
+	config        common.ConfigHolder
+	indexInstMap  *IndexInstMapHolder
+	indexPartnMap *IndexPartnMapHolder
+	stats         *IndexerStatsHolder
+
+	// console reports the corruptions to the admin
+	console func(clusterAddr string, format string, v ...interface{}) error
+
+	started  bool
+	configch chan struct{}
+	stopch   chan struct{}
+	donech   chan struct{}
+}
+
+func newScrubber(config common.Config, indexInstMap *IndexInstMapHolder,
+	indexPartnMap *IndexPartnMapHolder, stats *IndexerStatsHolder) *scrubber {
+
+	sc := &scrubber{
+		indexInstMap:  indexInstMap,
+		indexPartnMap: indexPartnMap,
+		stats:         stats,
+		console:       common.Console,
+		configch:      make(chan struct{}, 1),
+		stopch:        make(chan struct{}),
+		donech:        make(chan struct{}),
+	}
+	sc.config.Store(config)
+	return sc
+}
+
+// Start starts scrubbing, once the indexer has recovered the indexes.
+func (sc *scrubber) Start() {
+	if !sc.started {
+		sc.started = true
+		go sc.run()
+	}
+}
+
+// Stop stops scrubbing, aborting the scrub in progress.
+func (sc *scrubber) Stop() {
+	if sc.started {
+		close(sc.stopch)
+		<-sc.donech
+	}
+}
+
+func (sc *scrubber) ResetConfig(config common.Config) {
+	sc.config.Store(config)
+
+	select {
+	case sc.configch <- struct{}{}:
+	default:
+	}
+}
+
+func (sc *scrubber) run() {
+	defer close(sc.donech)
+
+	lastPass := time.Now()
+	for {
+		conf := sc.config.Load()
+		interval := time.Duration(conf["settings.scrub.interval"].Int()) * time.Second
+		timer := time.NewTimer(time.Until(lastPass.Add(interval)))
+
+		select {
+		case <-sc.stopch:
+			timer.Stop()
+			return
+
+		case <-sc.configch:
+			timer.Stop()
+
+		case <-timer.C:
+			lastPass = time.Now()
+			if sc.config.Load()["settings.scrub.enabled"].Bool() {
+				if err := sc.scrubAll(); err == errScrubAborted {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scrubAll scrubs all the partitions of the active indexes.
+func (sc *scrubber) scrubAll() error {
+	indexInstMap := sc.indexInstMap.Get()
+	indexPartnMap := sc.indexPartnMap.Get()
+
+	instIds := make([]common.IndexInstId, 0, len(indexPartnMap))
+	for instId := range indexPartnMap {
+		instIds = append(instIds, instId)
+	}
+	sort.Slice(instIds, func(i, j int) bool { return instIds[i] < instIds[j] })
+
+	// Forget the corrupted partitions of the dropped indexes
+	gScrubCorrupted.Range(func(key, _ interface{}) bool {
+		if _, ok := indexInstMap[key.(scrubPartnKey).instId]; !ok {
+			gScrubCorrupted.Delete(key)
+		}
+		return true
+	})
+
+	t0 := time.Now()
+	var total int64
+	for _, instId := range instIds {
+		inst, ok := indexInstMap[instId]
+		if !ok || inst.State != common.INDEX_STATE_ACTIVE || inst.IsProxy() {
+			continue
+		}
+
+		for partnId, partnInst := range indexPartnMap[instId] {
+			if !sc.config.Load()["settings.scrub.enabled"].Bool() {
+				return nil
+			}
+
+			count, err := sc.scrubPartition(inst, partnId, partnInst)
+			if err == errScrubAborted {
+				return err
+			}
+			total += count
+		}
+	}
+
+	logging.Infof("Scrubber::scrubAll Verified %v items of %v indexes in %v",
+		total, len(instIds), time.Since(t0))
+	return nil
+}
+
+func (sc *scrubber) scrubPartition(inst common.IndexInst, partnId common.PartitionId,
+	partnInst PartitionInst) (int64, error) {
+
+	conf := sc.config.Load()
+	rate := int64(conf["settings.scrub.rate_limit"].Int()) * 1024 * 1024
+
+	var total int64
+	for _, slice := range partnInst.Sc.GetAllSlices() {
+		scrubbable, ok := scrubbableOf(slice)
+		if !ok {
+			continue
+		}
+
+		// Increment the ref count so that the slice is not closed or
+		// deleted while it is scrubbed
+		if !slice.CheckAndIncrRef() {
+			continue
+		}
+
+		t0 := time.Now()
+		throttle := &scrubThrottle{rate: rate, start: t0, stopch: sc.stopch}
+		count, err := scrubbable.Scrub(throttle)
+		slice.DecrRef()
+
+		if err == errScrubAborted {
+			return total, err
+		}
+		total += count
+
+		var partnStats *IndexStats
+		if stats := sc.stats.Get(); stats != nil {
+			partnStats = stats.GetPartitionStats(inst.InstId, partnId)
+		}
+
+		switch err {
+		case nil:
+			logging.Infof("Scrubber::scrubPartition Verified %v items of IndexInstId %v PartitionId %v in %v",
+				count, inst.InstId, partnId, time.Since(t0))
+
+		case errScrubRepaired:
+			logMsg := "Scrubber detected storage corruption for index %v, partition id %v, " +
+				"and removed the corrupted disk snapshots."
+			sc.console(conf["clusterAddr"].String(), logMsg, inst.Defn.Name, partnId)
+			logging.Errorf("Scrubber::scrubPartition IndexInstId %v PartitionId %v has corrupted "+
+				"disk snapshots", inst.InstId, partnId)
+			if partnStats != nil {
+				partnStats.numScrubCorruptions.Add(1)
+			}
+
+		case errStorageCorrupted:
+			gScrubCorrupted.Store(scrubPartnKey{inst.InstId, partnId}, struct{}{})
+
+			logMsg := "Scrubber detected storage corruption for index %v, partition id %v. " +
+				"Scans of the partition fail until it is rebuilt on the next restart of the indexer."
+			sc.console(conf["clusterAddr"].String(), logMsg, inst.Defn.Name, partnId)
+			logging.Errorf("Scrubber::scrubPartition IndexInstId %v PartitionId %v is corrupted",
+				inst.InstId, partnId)
+			if partnStats != nil {
+				partnStats.numScrubCorruptions.Add(1)
+			}
+
+		default:
+			logging.Warnf("Scrubber::scrubPartition Failed to scrub IndexInstId %v PartitionId %v: %v",
+				inst.InstId, partnId, err)
+			continue
+		}
+
+		if partnStats != nil {
+			partnStats.lastScrubTime.Set(time.Now().UnixNano())
+			partnStats.numItemsScrubbed.Set(count)
+		}
+	}
+
+	return total, nil
+}
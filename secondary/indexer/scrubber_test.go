@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// fakeScrubSlice scrubs with the given error, other methods of Slice are
+// not implemented.
+type fakeScrubSlice struct {
+	Slice
+	err      error
+	scrubbed int
+}
+
+func (s *fakeScrubSlice) CheckAndIncrRef() bool { return true }
+
+func (s *fakeScrubSlice) DecrRef() {}
+
+func (s *fakeScrubSlice) Scrub(throttle *scrubThrottle) (int64, error) {
+	s.scrubbed++
+	return 10, s.err
+}
+
+func TestScrubThrottle(t *testing.T) {
+	stopch := make(chan struct{})
+
+	// Reads ahead of the rate sleep
+	throttle := &scrubThrottle{rate: 10 * 1024 * 1024, start: time.Now(), stopch: stopch}
+	t0 := time.Now()
+	if err := throttle.wait(1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(t0); d < 80*time.Millisecond {
+		t.Errorf("Expected to sleep for 100ms, slept for %v", d)
+	}
+
+	// Unlimited rate does not sleep
+	throttle = &scrubThrottle{start: time.Now(), stopch: stopch}
+	t0 = time.Now()
+	if err := throttle.wait(1024 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(t0); d >= scrubThrottleMinSleep {
+		t.Errorf("Expected not to sleep, slept for %v", d)
+	}
+
+	// Stop aborts the wait and the pause
+	close(stopch)
+	if err := throttle.wait(1); err != errScrubAborted {
+		t.Errorf("Expected %v, received %v", errScrubAborted, err)
+	}
+	throttle = &scrubThrottle{rate: 1, start: time.Now(), stopch: stopch}
+	if err := throttle.wait(1024 * 1024); err != errScrubAborted {
+		t.Errorf("Expected %v, received %v", errScrubAborted, err)
+	}
+	if err := throttle.pause(time.Hour); err != errScrubAborted {
+		t.Errorf("Expected %v, received %v", errScrubAborted, err)
+	}
+}
+
+func TestScrubberScrubPartition(t *testing.T) {
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	indexInstMap, indexPartnMap := &IndexInstMapHolder{}, &IndexPartnMapHolder{}
+	indexInstMap.Init()
+	indexPartnMap.Init()
+	sc := newScrubber(config, indexInstMap, indexPartnMap, &IndexerStatsHolder{})
+	var messages []string
+	sc.console = func(clusterAddr string, format string, v ...interface{}) error {
+		messages = append(messages, fmt.Sprintf(format, v...))
+		return nil
+	}
+
+	testcases := []struct {
+		name      string
+		err       error
+		corrupted bool // scans of the partition fail
+		reported  bool // reported to the console
+	}{
+		{"verified", nil, false, false},
+		{"repaired", errScrubRepaired, false, true},
+		{"corrupted", errStorageCorrupted, true, true},
+		{"failed", errors.New("read failed"), false, false},
+	}
+
+	for i, tc := range testcases {
+		for _, wrap := range []string{"aggregate", "vector"} {
+			inst := common.IndexInst{InstId: common.IndexInstId(i + 1)}
+			inst.Defn.Name = tc.name
+			partnId := common.PartitionId(1)
+			defer gScrubCorrupted.Delete(scrubPartnKey{inst.InstId, partnId})
+
+			// Slices wrapped for vector and aggregate indexes are scrubbed
+			fake := &fakeScrubSlice{err: tc.err}
+			var slice Slice = &aggregateSlice{Slice: fake}
+			if wrap == "vector" {
+				slice = &vectorSlice{Slice: fake}
+			}
+			partnInst := PartitionInst{Sc: NewHashedSliceContainer()}
+			partnInst.Sc.AddSlice(SliceId(0), slice)
+
+			messages = nil
+			count, err := sc.scrubPartition(inst, partnId, partnInst)
+			if err != nil || count != 10 || fake.scrubbed != 1 {
+				t.Errorf("%v %v: unexpected scrubbed %v count %v error %v", tc.name, wrap,
+					fake.scrubbed, count, err)
+			}
+			if corrupted := isScrubCorruptedPartition(inst.InstId,
+				[]common.PartitionId{0, partnId}); corrupted != tc.corrupted {
+				t.Errorf("%v %v: expected corrupted %v, received %v", tc.name, wrap,
+					tc.corrupted, corrupted)
+			}
+			if reported := len(messages) == 1; reported != tc.reported {
+				t.Errorf("%v %v: expected reported %v, received %v", tc.name, wrap,
+					tc.reported, messages)
+			}
+			if isScrubCorruptedPartition(inst.InstId, []common.PartitionId{0, 2}) {
+				t.Errorf("%v %v: unexpected corrupted partitions", tc.name, wrap)
+			}
+		}
+	}
+
+	// Corrupted partitions of dropped indexes are forgotten
+	if err := sc.scrubAll(); err != nil {
+		t.Fatal(err)
+	}
+	if isScrubCorruptedPartition(common.IndexInstId(3), []common.PartitionId{1}) {
+		t.Errorf("Expected corrupted partition of dropped index to be forgotten")
+	}
+}
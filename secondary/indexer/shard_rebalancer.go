@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -303,6 +304,11 @@ func (sr *ShardRebalancer) initRebalAsync() {
 						go sr.finishRebalance(err)
 						return
 					}
+					if err := checkDestinationAccess(destination, region, sr.config.Load()); err != nil {
+						l.Errorf("ShardRebalancer::initRebalAsync No access to destination: %v, err: %v", destination, err)
+						go sr.finishRebalance(err)
+						return
+					}
 					// Populate destination in transfer tokens
 					for _, token := range sr.transferTokens {
 						token.Destination = destination
@@ -383,6 +389,19 @@ func getDestinationFromConfig(cfg c.Config) (string, string, error) {
 	return destination, blobStorageRegion, nil
 }
 
+// checkDestinationAccess verifies that shards can be staged at an S3 destination, so that
+// rebalance fails before any transfer is started if they cannot.
+func checkDestinationAccess(destination, region string, cfg c.Config) error {
+	archiveType, _, err := ArchiveInfoFromRemotePath(destination)
+	if err != nil || archiveType != Archive_S3 {
+		return err
+	}
+	objutil := NewPauseObjutil(region, cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), objutil.AccessTimeout())
+	defer cancel()
+	return objutil.CheckAccess(ctx, destination)
+}
+
 // processTokens is invoked by observeRebalance() method
 // processTokens invokes processShardTokens of ShardRebalancer
 func (sr *ShardRebalancer) processShardTokens(kve metakv.KVEntry) error {
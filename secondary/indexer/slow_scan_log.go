@@ -0,0 +1,137 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// SlowScan is an entry of the slow scan log. Durations are in nanoseconds.
+// Spans are tagged as user data, so that they are redacted in log
+// collection.
+type SlowScan struct {
+	Time         time.Time            `json:"time"`
+	RequestId    string               `json:"requestId"`
+	Index        string               `json:"index"`
+	InstId       common.IndexInstId   `json:"instId"`
+	PartitionIds []common.PartitionId `json:"partitionIds,omitempty"`
+	Spans        string               `json:"spans"`
+	Consistency  string               `json:"consistency"`
+	RowsReturned uint64               `json:"rowsReturned"`
+	RowsScanned  uint64               `json:"rowsScanned"`
+	BytesRead    uint64               `json:"bytesRead"`
+	WaitTime     time.Duration        `json:"waitTime"`
+	ScanTime     time.Duration        `json:"scanTime"`
+	Error        string               `json:"error,omitempty"`
+
+	// Per-phase timing. Filter, aggregate and send time are available,
+	// and downstream time is excluded from iteration time, only if the
+	// client requested the trace.
+	Trace *common.ScanTrace `json:"trace,omitempty"`
+}
+
+// slowScanLog is a bounded ring buffer of the most recent slow scans.
+type slowScanLog struct {
+	mutex   sync.Mutex
+	entries []*SlowScan
+	next    int
+	size    int
+}
+
+// add records a slow scan, evicting the oldest entry once size entries
+// are recorded. The log is resized if size has changed.
+func (l *slowScanLog) add(e *SlowScan, size int) {
+	if size <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if size != l.size {
+		entries := l.listLOCKED()
+		if len(entries) > size {
+			entries = entries[len(entries)-size:]
+		}
+		l.entries = entries
+		l.next = len(entries) % size
+		l.size = size
+	}
+
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, e)
+	} else {
+		l.entries[l.next] = e
+	}
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns the recorded slow scans, oldest first.
+func (l *slowScanLog) list() []*SlowScan {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.listLOCKED()
+}
+
+func (l *slowScanLog) listLOCKED() []*SlowScan {
+	entries := make([]*SlowScan, 0, len(l.entries))
+	if len(l.entries) < l.size {
+		return append(entries, l.entries...)
+	}
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// recordSlowScan adds the scan to the slow scan log if the scan took
+// longer than indexer.settings.scan.slow_threshold. It is called for every
+// request type that reads the index snapshot, except range watches, which
+// stay open until the client closes them.
+func (s *scanCoordinator) recordSlowScan(req *ScanRequest, rowsReturned, rowsScanned,
+	bytesRead uint64, waitTime, scanTime time.Duration, err error) {
+
+	cfg := s.config.Load()
+	threshold := time.Duration(cfg["settings.scan.slow_threshold"].Int()) * time.Millisecond
+	if threshold <= 0 || scanTime < threshold {
+		return
+	}
+
+	defn := &req.IndexInst.Defn
+	e := &SlowScan{
+		Time:         time.Now(),
+		RequestId:    req.RequestId,
+		Index:        strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection, defn.Name}, ":"),
+		InstId:       req.IndexInstId,
+		PartitionIds: req.PartitionIds,
+		Spans:        req.spansString(),
+		RowsReturned: rowsReturned,
+		RowsScanned:  rowsScanned,
+		BytesRead:    bytesRead,
+		WaitTime:     waitTime,
+		ScanTime:     scanTime,
+		Trace:        req.trace.toProto(scanTime).ToScanTrace(),
+	}
+	if req.Consistency != nil {
+		e.Consistency = strings.ToLower(req.Consistency.String())
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	s.slowScans.add(e, cfg["settings.scan.slow_log_size"].Int())
+}
+
+// SlowScans returns the slow scan log, oldest scan first.
+func (s *scanCoordinator) SlowScans() []*SlowScan {
+	return s.slowScans.list()
+}
@@ -0,0 +1,34 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSlowScanLog(t *testing.T) {
+	var l slowScanLog
+
+	for i := 0; i < 5; i++ {
+		l.add(&SlowScan{RequestId: fmt.Sprintf("req%v", i)}, 3)
+	}
+
+	check := func(expected ...string) {
+		entries := l.list()
+		if len(entries) != len(expected) {
+			t.Fatalf("Expected %v entries, received %v", len(expected), len(entries))
+		}
+		for i, e := range entries {
+			if e.RequestId != expected[i] {
+				t.Fatalf("Expected %v at %v, received %v", expected[i], i, e.RequestId)
+			}
+		}
+	}
+	check("req2", "req3", "req4")
+
+	// Shrink the log, keeping the most recent scans
+	l.add(&SlowScan{RequestId: "req5"}, 2)
+	check("req4", "req5")
+
+	l.add(&SlowScan{RequestId: "req6"}, 4)
+	check("req4", "req5", "req6")
+}
@@ -0,0 +1,58 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// gSnapAsOfWaiters is notified by timekeeper when the snapshots of a keyspace
+// get fresher, to wake up the bounded staleness scans waiting for them.
+var gSnapAsOfWaiters snapAsOfWaiters
+
+// snapAsOfWaiters is a broadcast per stream and keyspace. A channel is created
+// only when a scan waits, and is closed to wake up all its waiters at once.
+type snapAsOfWaiters struct {
+	mutex sync.Mutex
+	chs   map[common.StreamId]map[string]chan struct{}
+}
+
+// waitCh returns the channel closed on the next notify of the keyspace. It
+// must be taken before checking the freshness, so a notify is not missed.
+func (w *snapAsOfWaiters) waitCh(streamId common.StreamId, keyspaceId string) <-chan struct{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.chs == nil {
+		w.chs = make(map[common.StreamId]map[string]chan struct{})
+	}
+	if w.chs[streamId] == nil {
+		w.chs[streamId] = make(map[string]chan struct{})
+	}
+
+	ch, ok := w.chs[streamId][keyspaceId]
+	if !ok {
+		ch = make(chan struct{})
+		w.chs[streamId][keyspaceId] = ch
+	}
+	return ch
+}
+
+// notify wakes up the scans waiting on the keyspace, if any.
+func (w *snapAsOfWaiters) notify(streamId common.StreamId, keyspaceId string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if ch, ok := w.chs[streamId][keyspaceId]; ok {
+		close(ch)
+		delete(w.chs[streamId], keyspaceId)
+	}
+}
@@ -0,0 +1,101 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestSnapAsOfWaiters(t *testing.T) {
+	var w snapAsOfWaiters
+
+	// Notify without waiters is a no-op
+	w.notify(common.MAINT_STREAM, "b1")
+
+	ch1 := w.waitCh(common.MAINT_STREAM, "b1")
+	ch2 := w.waitCh(common.MAINT_STREAM, "b1")
+	other := w.waitCh(common.INIT_STREAM, "b1")
+
+	w.notify(common.MAINT_STREAM, "b1")
+	for _, ch := range []<-chan struct{}{ch1, ch2} {
+		select {
+		case <-ch:
+		default:
+			t.Errorf("Expected waiters to be notified")
+		}
+	}
+	select {
+	case <-other:
+		t.Errorf("Expected waiters of other streams to not be notified")
+	default:
+	}
+
+	// Waiters after the notify wait for the next one
+	select {
+	case <-w.waitCh(common.MAINT_STREAM, "b1"):
+		t.Errorf("Expected new waiter to not be notified")
+	default:
+	}
+}
+
+func TestWaitForSnapshotAsOf(t *testing.T) {
+	stats := &IndexerStats{}
+	stats.Init()
+	stats.AddKeyspaceStats(common.MAINT_STREAM, "b1")
+
+	s := &scanCoordinator{}
+	s.stats.Set(stats)
+	s.setIndexerState(common.INDEXER_ACTIVE)
+
+	tk := &timekeeper{}
+	tk.stats.Set(stats)
+
+	asOf := time.Now()
+	tk.updateSnapAsOf(common.MAINT_STREAM, "b1", asOf.Add(-time.Second))
+
+	newReq := func(minSnapAsOf time.Time) *ScanRequest {
+		r := &ScanRequest{minSnapAsOf: minSnapAsOf}
+		r.IndexInst.Stream = common.MAINT_STREAM
+		r.IndexInst.Defn = common.IndexDefn{Bucket: "b1", Scope: "_default", Collection: "_default"}
+		return r
+	}
+
+	// Snapshots within the staleness do not wait
+	if err := s.waitForSnapshotAsOf(newReq(asOf.Add(-time.Minute))); err != nil {
+		t.Fatalf("Expected no wait for a fresh enough snapshot, got %v", err)
+	}
+
+	// Fresher snapshots wake up the waiting scan
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.waitForSnapshotAsOf(newReq(asOf))
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("Expected scan to wait for a fresher snapshot, got %v", err)
+	default:
+	}
+
+	tk.updateSnapAsOf(common.MAINT_STREAM, "b1", asOf.Add(-time.Millisecond))
+	tk.updateSnapAsOf(common.MAINT_STREAM, "b1", asOf)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Expected scan to get a fresher snapshot, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected scan to be notified of a fresher snapshot")
+	}
+
+	// A waiting scan can be cancelled
+	req := newReq(asOf.Add(time.Second))
+	cancelCh := make(chan bool)
+	req.CancelCh = cancelCh
+	close(cancelCh)
+	if err := s.waitForSnapshotAsOf(req); err != common.ErrClientCancel {
+		t.Errorf("Expected %v, got %v", common.ErrClientCancel, err)
+	}
+}
@@ -3,6 +3,7 @@ package indexer
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/couchbase/indexing/secondary/common"
@@ -14,6 +15,11 @@ type IndexSnapshotContainer struct {
 	sync.Mutex
 	snap    IndexSnapshot
 	deleted bool
+
+	// Snapshot of the last scan that returned a scan cursor, retained
+	// so that the scan can be resumed against the same snapshot
+	cursorSnap     IndexSnapshot
+	cursorSnapTime time.Time
 }
 
 type IndexSnapMapHolder struct {
@@ -35,8 +35,10 @@ import (
 	commonjson "github.com/couchbase/indexing/secondary/common/json"
 	"github.com/couchbase/indexing/secondary/iowrap"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/security"
 	"github.com/couchbase/indexing/secondary/stats"
 	"github.com/couchbase/indexing/secondary/stubs/nitro/mm"
+	"github.com/couchbase/indexing/secondary/transport"
 	"github.com/couchbase/logstats/logstats"
 	"github.com/golang/snappy"
 
@@ -88,6 +90,7 @@ type KeyspaceStats struct {
 	flushLatDist       stats.Histogram
 	snapLatDist        stats.Histogram
 	lastSnapDone       stats.Int64Val
+	lastSnapAsOf       stats.Int64Val // snapshots have all mutations received as of this time
 	numForceInMemSnap  stats.Int64Val
 	throttleLat        stats.Int64Val
 	numThrottles       stats.Uint64Val
@@ -107,6 +110,7 @@ func (s *KeyspaceStats) Init(keyspaceId string) {
 	s.flushLatDist.InitLatency(latencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.snapLatDist.InitLatency(snapLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.lastSnapDone.Init()
+	s.lastSnapAsOf.Init()
 	s.numForceInMemSnap.Init()
 	s.throttleLat.Init()
 	s.numThrottles.Init()
@@ -124,6 +128,7 @@ func (s *KeyspaceStats) addKeyspaceStatsToStatsMap(statMap *StatsMap) {
 	statMap.AddStatValueFiltered("flush_latency_dist", &s.flushLatDist)
 	statMap.AddStatValueFiltered("snapshot_latency_dist", &s.snapLatDist)
 	statMap.AddStatValueFiltered("last_snapshot_done", &s.lastSnapDone)
+	statMap.AddStatValueFiltered("last_snapshot_as_of", &s.lastSnapAsOf)
 	statMap.AddStatValueFiltered("num_force_inmem_snap", &s.numForceInMemSnap)
 
 	if common.IsServerlessDeployment() {
@@ -200,6 +205,7 @@ type IndexStats struct {
 	numDocsProcessed          stats.Int64Val
 	numRequests               stats.Int64Val
 	lastScanTime              stats.Int64Val
+	buildDoneTime             stats.Int64Val // time the index was last built or added to this node
 	numCompletedRequests      stats.Int64Val
 	numRowsReturned           stats.Int64Val
 	numRequestsRange          stats.Int64Val
@@ -248,10 +254,14 @@ type IndexStats struct {
 	numItemsRestored          stats.Int64Val
 	diskSnapStoreDuration     stats.Int64Val
 	diskSnapLoadDuration      stats.Int64Val
+	lastScrubTime             stats.Int64Val
+	numItemsScrubbed          stats.Int64Val
+	numScrubCorruptions       stats.Int64Val
 	notReadyError             stats.Int64Val
 	clientCancelError         stats.Int64Val
 	numScanTimeouts           stats.Int64Val
 	numScanErrors             stats.Int64Val
+	numScansRejected          stats.Int64Val
 	avgScanRate               stats.Int64Val
 	avgMutationRate           stats.Int64Val
 	avgDrainRate              stats.Int64Val
@@ -313,6 +323,7 @@ type IndexStats struct {
 	scanReqWaitLatDist stats.Histogram
 	scanReqLatDist     stats.Histogram
 	snapGenLatDist     stats.Histogram
+	recvToIndexLatDist stats.Histogram
 
 	//serverless stats
 	lastMeteredWU      stats.Int64Val //Updated every stats interval with cumulative normalized metered WU. Reset every disk snapshot.
@@ -470,6 +481,7 @@ func (s *IndexStats) Init() {
 	s.numDocsProcessed.Init()
 	s.numRequests.Init()
 	s.lastScanTime.Init()
+	s.buildDoneTime.Init()
 	s.numCompletedRequests.Init()
 	s.numRowsReturned.Init()
 	s.numRequestsRange.Init()
@@ -518,10 +530,14 @@ func (s *IndexStats) Init() {
 	s.numItemsRestored.Init()
 	s.diskSnapStoreDuration.Init()
 	s.diskSnapLoadDuration.Init()
+	s.lastScrubTime.Init()
+	s.numItemsScrubbed.Init()
+	s.numScrubCorruptions.Init()
 	s.notReadyError.Init()
 	s.clientCancelError.Init()
 	s.numScanTimeouts.Init()
 	s.numScanErrors.Init()
+	s.numScansRejected.Init()
 	s.avgScanRate.Init()
 	s.avgMutationRate.Init()
 	s.avgDrainRate.Init()
@@ -582,6 +598,7 @@ func (s *IndexStats) Init() {
 	s.scanReqWaitLatDist.InitLatency(latencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.scanReqLatDist.InitLatency(scanReqLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 	s.snapGenLatDist.InitLatency(snapLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
+	s.recvToIndexLatDist.InitLatency(snapLatencyDist, func(v int64) string { return fmt.Sprintf("%vms", v/int64(time.Millisecond)) })
 
 	s.partitions = make(map[common.PartitionId]*IndexStats)
 
@@ -624,6 +641,9 @@ func (s *IndexStats) SetPlannerFilters() {
 	s.progressStatTime.AddFilter(stats.PlannerFilter)
 	s.indexState.AddFilter(stats.PlannerFilter)
 	s.avgUnitsUsage.AddFilter(stats.PlannerFilter)
+	s.numRequests.AddFilter(stats.PlannerFilter)
+	s.lastScanTime.AddFilter(stats.PlannerFilter)
+	s.buildDoneTime.AddFilter(stats.PlannerFilter)
 }
 
 func (s *IndexStats) SetIndexStatusFilters() {
@@ -655,10 +675,19 @@ func (s *IndexStats) addPartition(id common.PartitionId) {
 	if _, ok := s.partitions[id]; !ok {
 		partnStats := &IndexStats{isArrayIndex: s.isArrayIndex, useArrItemsCount: s.useArrItemsCount}
 		partnStats.Init()
+		partnStats.buildDoneTime.Set(time.Now().UnixNano())
 		s.partitions[id] = partnStats
 	}
 }
 
+// setBuildDoneTime sets the build done time of the index and its partitions
+func (s *IndexStats) setBuildDoneTime(t int64) {
+	s.buildDoneTime.Set(t)
+	for _, partnStats := range s.partitions {
+		partnStats.buildDoneTime.Set(t)
+	}
+}
+
 // IndexStats.Clone creates a new copy of the IndexStats object with a new
 // partitions map that points to the original stats objects.
 func (s *IndexStats) clone() *IndexStats {
@@ -821,6 +850,15 @@ type IndexerStats struct {
 	memoryTotal    stats.Uint64Val
 	pauseTotalNs   stats.Uint64Val
 
+	// Payload bytes before and after transport compression
+	sentUncompressedBytes stats.Uint64Val
+	sentCompressedBytes   stats.Uint64Val
+	recvCompressedBytes   stats.Uint64Val
+	recvUncompressedBytes stats.Uint64Val
+
+	// Failures to decrypt files encrypted at rest
+	decryptFailures stats.Uint64Val
+
 	numIndexes          stats.Int64Val
 	numStorageInstances stats.Int64Val
 	avgResidentPercent  stats.Int64Val
@@ -879,6 +917,11 @@ func (s *IndexerStats) Init() {
 	s.memoryTotal.Init()
 	s.indexerStateHolder.Init()
 	s.pauseTotalNs.Init()
+	s.sentUncompressedBytes.Init()
+	s.sentCompressedBytes.Init()
+	s.recvCompressedBytes.Init()
+	s.recvUncompressedBytes.Init()
+	s.decryptFailures.Init()
 
 	s.numIndexes.Init()
 	s.numStorageInstances.Init()
@@ -1097,6 +1140,7 @@ func (s *IndexerStats) addIndexStats(instId common.IndexInstId,
 			useArrItemsCount: useArrItemsCount,
 		}
 		idxStats.Init()
+		idxStats.buildDoneTime.Set(time.Now().UnixNano())
 		s.indexes[instId] = idxStats
 	}
 	return idxStats
@@ -1261,6 +1305,19 @@ func (is *IndexerStats) PopulateIndexerStats(statMap *StatsMap) {
 	is.memoryRss.Set(getRSS())
 	statMap.AddStatValueFiltered("memory_rss", &is.memoryRss)
 
+	cs := transport.GetCompressionStats()
+	is.sentUncompressedBytes.Set(cs.SentUncompressed)
+	statMap.AddStatValueFiltered("transport_sent_uncompressed_bytes", &is.sentUncompressedBytes)
+	is.sentCompressedBytes.Set(cs.SentCompressed)
+	statMap.AddStatValueFiltered("transport_sent_compressed_bytes", &is.sentCompressedBytes)
+	is.recvCompressedBytes.Set(cs.ReceivedCompressed)
+	statMap.AddStatValueFiltered("transport_recv_compressed_bytes", &is.recvCompressedBytes)
+	is.recvUncompressedBytes.Set(cs.ReceivedUncompressed)
+	statMap.AddStatValueFiltered("transport_recv_uncompressed_bytes", &is.recvUncompressedBytes)
+
+	is.decryptFailures.Set(iowrap.GetDecryptFailures())
+	statMap.AddStatValueFiltered("num_decrypt_failures", &is.decryptFailures)
+
 	is.memoryFree.Set(getMemFree())
 	statMap.AddStatValueFiltered("memory_free", &is.memoryFree)
 
@@ -1682,6 +1739,10 @@ func (s *IndexStats) constructIndexStats(skipEmpty bool, version string) common.
 		s.int64Stats(func(ss *IndexStats) int64 {
 			return ss.numScanErrors.Value()
 		}))
+	addStat("num_scans_rejected",
+		s.int64Stats(func(ss *IndexStats) int64 {
+			return ss.numScansRejected.Value()
+		}))
 
 	return indexStats
 }
@@ -1869,6 +1930,12 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.numScanErrors, s.int64Stats)
 
+	statMap.AddAggrStatFiltered("num_scans_rejected",
+		func(ss *IndexStats) int64 {
+			return ss.numScansRejected.Value()
+		},
+		&s.numScansRejected, s.int64Stats)
+
 	// ----------------------
 	// All partnInt64Stats
 	// ----------------------
@@ -1976,6 +2043,18 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.numItemsRestored, s.partnInt64Stats)
 
+	statMap.AddAggrStatFiltered("num_items_scrubbed",
+		func(ss *IndexStats) int64 {
+			return ss.numItemsScrubbed.Value()
+		},
+		&s.numItemsScrubbed, s.partnInt64Stats)
+
+	statMap.AddAggrStatFiltered("num_scrub_corruptions",
+		func(ss *IndexStats) int64 {
+			return ss.numScrubCorruptions.Value()
+		},
+		&s.numScrubCorruptions, s.partnInt64Stats)
+
 	statMap.AddAggrStatFiltered("avg_scan_rate",
 		func(ss *IndexStats) int64 {
 			return ss.avgScanRate.Value()
@@ -2054,6 +2133,7 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 	// -------------------------------
 	statMap.AddStatValueFiltered("num_requests", &s.numRequests)
 	statMap.AddStatValueFiltered("last_known_scan_time", &s.lastScanTime)
+	statMap.AddStatValueFiltered("build_done_time", &s.buildDoneTime)
 	statMap.AddStatValueFiltered("num_completed_requests", &s.numCompletedRequests)
 	statMap.AddStatValueFiltered("last_rollback_time", &s.lastRollbackTime)
 	statMap.AddStatValueFiltered("progress_stat_time", &s.progressStatTime)
@@ -2106,6 +2186,7 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 	statMap.AddStatValueFiltered("scan_req_wait_latency_dist", &s.scanReqWaitLatDist)
 	statMap.AddStatValueFiltered("scan_req_latency_dist", &s.scanReqLatDist)
 	statMap.AddStatValueFiltered("snapshot_gen_latency_dist", &s.snapGenLatDist)
+	statMap.AddStatValueFiltered("recv_to_index_latency_dist", &s.recvToIndexLatDist)
 
 	if !spec.essential {
 		statMap.AddStatValueFiltered("avg_scan_request_alloc_latency", &s.scanReqAllocLat)
@@ -2120,6 +2201,12 @@ func (s *IndexStats) addIndexStatsToMap(statMap *StatsMap, spec *statsSpec) {
 		},
 		&s.keySizeStatsSince, s.partnMaxInt64Stats)
 
+	statMap.AddAggrStatFiltered("last_scrub_time",
+		func(ss *IndexStats) int64 {
+			return ss.lastScrubTime.Value()
+		},
+		&s.lastScrubTime, s.partnMaxInt64Stats)
+
 	// -------------------------------
 	// All partnAvgInt64Stats
 	// -------------------------------
@@ -2462,6 +2549,102 @@ func (s *IndexStats) populateMetrics(st []byte) []byte {
 	return st
 }
 
+// indexLatencyHistograms are the per-index latency distributions exported as
+// Prometheus histograms, in seconds. Receive to index latency is measured on a
+// sample of mutations from their receipt from projector, so it does not include
+// the time spent in KV and projector.
+var indexLatencyHistograms = []struct {
+	name    string
+	buckets []int64
+	dist    func(*IndexStats) *stats.Histogram
+}{
+	{"scan_latency_seconds", scanReqLatencyDist,
+		func(s *IndexStats) *stats.Histogram { return &s.scanReqLatDist }},
+	{"scan_snapshot_wait_latency_seconds", latencyDist,
+		func(s *IndexStats) *stats.Histogram { return &s.scanReqWaitLatDist }},
+	{"recv_to_index_latency_seconds", snapLatencyDist,
+		func(s *IndexStats) *stats.Histogram { return &s.recvToIndexLatDist }},
+}
+
+// populateHistogramMetrics appends the latency distributions as Prometheus
+// histograms, per index and per keyspace, or aggregated for the node if perNode.
+// Series of a histogram are grouped after its TYPE line as Prometheus requires.
+func (is *IndexerStats) populateHistogramMetrics(st []byte, perNode bool) []byte {
+	for _, hist := range indexLatencyHistograms {
+		st = append(st, []byte(fmt.Sprintf("# TYPE %v%v histogram\n", METRICS_PREFIX, hist.name))...)
+
+		if perNode {
+			var node stats.Histogram
+			node.InitLatency(hist.buckets, nil)
+			for _, s := range is.indexes {
+				node.Merge(*hist.dist(s))
+			}
+			st = appendHistogramMetric(st, hist.name, "", &node)
+			continue
+		}
+
+		for _, s := range is.indexes {
+			scope := s.scope
+			if scope == "" {
+				scope = common.DEFAULT_SCOPE
+			}
+			collection := s.collection
+			if collection == "" {
+				collection = common.DEFAULT_COLLECTION
+			}
+			labels := fmt.Sprintf("bucket=\"%v\", scope=\"%v\", collection=\"%v\", index=\"%v\"",
+				s.bucket, scope, collection, s.dispName)
+			st = appendHistogramMetric(st, hist.name, labels, hist.dist(s))
+		}
+	}
+
+	// Flush latency is per keyspace of a stream
+	const flushLatency = "flush_latency_seconds"
+	st = append(st, []byte(fmt.Sprintf("# TYPE %v%v histogram\n", METRICS_PREFIX, flushLatency))...)
+
+	var node stats.Histogram
+	node.InitLatency(latencyDist, nil)
+	for streamId, keyspaceStatsMap := range is.GetKeyspaceStatsMap() {
+		for keyspaceId, ks := range keyspaceStatsMap {
+			if perNode {
+				node.Merge(ks.flushLatDist)
+				continue
+			}
+			labels := fmt.Sprintf("stream=\"%v\", keyspace=\"%v\"", streamId, keyspaceId)
+			st = appendHistogramMetric(st, flushLatency, labels, &ks.flushLatDist)
+		}
+	}
+	if perNode {
+		st = appendHistogramMetric(st, flushLatency, "", &node)
+	}
+
+	return st
+}
+
+// appendHistogramMetric appends the _bucket, _sum and _count series of histogram h
+// of latencies in nanoseconds as a Prometheus histogram in seconds.
+func appendHistogramMetric(st []byte, name, labels string, h *stats.Histogram) []byte {
+	seconds := func(ns int64) string {
+		return strconv.FormatFloat(float64(ns)/float64(time.Second), 'g', -1, 64)
+	}
+
+	bucketLabels, seriesLabels := "", ""
+	if labels != "" {
+		bucketLabels, seriesLabels = labels+", ", "{"+labels+"}"
+	}
+
+	bounds, counts, sum := h.Cumulative()
+	for i, bound := range bounds {
+		str := fmt.Sprintf("%v%v_bucket{%vle=\"%v\"} %v\n", METRICS_PREFIX, name, bucketLabels, seconds(bound), counts[i])
+		st = append(st, []byte(str)...)
+	}
+	count := counts[len(counts)-1]
+	str := fmt.Sprintf("%v%v_bucket{%vle=\"+Inf\"} %v\n", METRICS_PREFIX, name, bucketLabels, count)
+	str += fmt.Sprintf("%v%v_sum%v %v\n", METRICS_PREFIX, name, seriesLabels, seconds(sum))
+	str += fmt.Sprintf("%v%v_count%v %v\n", METRICS_PREFIX, name, seriesLabels, count)
+	return append(st, []byte(str)...)
+}
+
 func populatePlasmaTenantMetrics(st []byte) []byte {
 
 	if common.GetDeploymentModel() != common.SERVERLESS_DEPLOYMENT {
@@ -3250,6 +3433,12 @@ func (s *statsManager) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	out = append(out, []byte(fmt.Sprintf("# TYPE %vmemory_rss gauge\n", METRICS_PREFIX))...)
 	out = append(out, []byte(fmt.Sprintf("%vmemory_rss %v\n", METRICS_PREFIX, is.memoryRss.Value()))...)
 
+	is.decryptFailures.Set(iowrap.GetDecryptFailures())
+	out = append(out, []byte(fmt.Sprintf("# TYPE %vnum_decrypt_failures counter\n", METRICS_PREFIX))...)
+	out = append(out, []byte(fmt.Sprintf("%vnum_decrypt_failures %v\n", METRICS_PREFIX, is.decryptFailures.Value()))...)
+
+	out = is.populateHistogramMetrics(out, true)
+
 	// aggregated plasma stats
 	if common.GetStorageMode() == common.PLASMA {
 		aggregatedPlasmaStats := plasma.GetAggregatedStats(plasma.ListShards())
@@ -3400,6 +3589,7 @@ func (s *statsManager) handleMetricsHigh(w http.ResponseWriter, r *http.Request)
 	for _, s := range is.indexes {
 		out = s.populateMetrics(out)
 	}
+	out = is.populateHistogramMetrics(out, false)
 
 	if common.IsServerlessDeployment() {
 		func() {
@@ -4124,6 +4314,7 @@ func (s *statsManager) runStatsDumpLogger() {
 
 // Stats abbreviations used in persisted stats
 const last_known_scan_time = "lqt" //last_query_time
+const build_done_time = "bdt"
 const avg_scan_rate = "asr"
 const num_rows_scanned = "nrs"
 const last_num_rows_scanned = "lrs"
@@ -4177,6 +4368,7 @@ func getStatsToBePersistedMap(indexerStats *IndexerStats) (statsMap map[string]i
 		for k, indexStats := range indexerStats.indexes {
 			instdId := strconv.FormatUint(uint64(k), 10)
 			statsMap[instdId+":"+last_known_scan_time] = indexStats.lastScanTime.Value()
+			statsMap[instdId+":"+build_done_time] = indexStats.buildDoneTime.Value()
 
 			for pk, partnStats := range indexStats.partitions {
 				partnId := strconv.FormatUint(uint64(pk), 10)
@@ -4274,6 +4466,11 @@ func (s *statsManager) updateStatsFromPersistence(indexerStats *IndexerStats) {
 				if ok {
 					indexerStats.indexes[instdId].lastScanTime.Set(val)
 				}
+			case build_done_time:
+				val, ok := getInt64Val(value, statName)
+				if ok && val != 0 {
+					indexerStats.indexes[instdId].setBuildDoneTime(val)
+				}
 			}
 		}
 		if len(kstrs) == 3 { // partition level stat
@@ -4382,7 +4579,12 @@ func (fp *FlatFileStatsPersister) PersistStats(statsMap map[string]interface{})
 
 	// Write the stats to disk
 	if content != nil {
-		err := common.WriteFileWithSync(fp.newFilePath, content, 0755)
+		content, err := security.EncryptAtRest(content)
+		if err != nil {
+			return err
+		}
+
+		err = common.WriteFileWithSync(fp.newFilePath, content, 0755)
 		if err != nil {
 			return err
 		}
@@ -4403,6 +4605,11 @@ func (fp *FlatFileStatsPersister) ReadPersistedStats() (map[string]interface{},
 		return nil, err
 	}
 
+	content, err = security.DecryptAtRest(content)
+	if err != nil {
+		return nil, err
+	}
+
 	var statsJson []byte
 	header := content[0:8]
 
@@ -0,0 +1,36 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/stats"
+)
+
+func TestAppendHistogramMetric(t *testing.T) {
+	var h stats.Histogram
+	h.InitLatency([]int64{1, 10}, nil)
+	h.Add(int64(500 * time.Microsecond))
+	h.Add(int64(20 * time.Millisecond))
+
+	out := string(appendHistogramMetric(nil, "scan_latency_seconds", `index="idx"`, &h))
+	expected := []string{
+		METRICS_PREFIX + `scan_latency_seconds_bucket{index="idx", le="0.001"} 1`,
+		METRICS_PREFIX + `scan_latency_seconds_bucket{index="idx", le="0.01"} 1`,
+		METRICS_PREFIX + `scan_latency_seconds_bucket{index="idx", le="+Inf"} 2`,
+		METRICS_PREFIX + `scan_latency_seconds_sum{index="idx"} 0.0205`,
+		METRICS_PREFIX + `scan_latency_seconds_count{index="idx"} 2`,
+		"",
+	}
+	if out != strings.Join(expected, "\n") {
+		t.Errorf("Expected\n%v\nreceived\n%v", strings.Join(expected, "\n"), out)
+	}
+
+	// Series of the node are not labelled
+	out = string(appendHistogramMetric(nil, "scan_latency_seconds", "", &h))
+	if !strings.Contains(out, METRICS_PREFIX+`scan_latency_seconds_bucket{le="+Inf"} 2`) ||
+		!strings.Contains(out, METRICS_PREFIX+"scan_latency_seconds_count 2\n") {
+		t.Errorf("Unexpected node series\n%v", out)
+	}
+}
@@ -85,6 +85,9 @@ type storageMgr struct {
 	// A shard is added to the list when transfer is initiated and
 	// cleared when transfer is done
 	shardsInTransfer map[common.ShardId][]chan bool
+
+	// Verifies the persisted data of the indexes in the background
+	scrubber *scrubber
 }
 
 type snapshotWaiter struct {
@@ -149,6 +152,8 @@ func NewStorageManager(supvCmdch MsgChannel, supvRespch MsgChannel,
 
 	s.bucketNameNumVBucketsMapHolder.Init()
 
+	s.scrubber = newScrubber(config, &s.indexInstMap, &s.indexPartnMap, &s.stats)
+
 	//if manager is not enabled, create meta file
 	if config["enableManager"].Bool() == false {
 		fdbconfig := forestdb.DefaultConfig()
@@ -203,6 +208,7 @@ loop:
 					if s.stm != nil {
 						s.stm.ProcessCommand(cmd) // Shutdown storage manager cmdCh
 					}
+					s.scrubber.Stop()
 					s.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -963,8 +969,13 @@ func (sm *storageMgr) handleRollback(cmd Message) {
 		if rollbackToZero {
 			keyspaceStats.numRollbacksToZero.Add(1)
 		}
+		// Rolled back snapshots do not have the mutations received before
+		keyspaceStats.lastSnapAsOf.Set(0)
 	}
 
+	// Changes sent to range watches on the keyspace are undone by rollback
+	gRangeWatches.abort(streamId, keyspaceId, sm.indexInstMap.Get(), ErrIndexRollback)
+
 	if restartTs != nil {
 		//for pre 7.0 index snapshots, the manifestUID needs to be set to epoch
 		restartTs.SetEpochManifestUIDIfEmpty()
@@ -1876,6 +1887,8 @@ func (s *storageMgr) handleRecoveryDone() {
 	if common.GetStorageMode() == common.PLASMA {
 		RecoveryDone()
 	}
+
+	s.scrubber.Start()
 }
 
 func (s *storageMgr) handleConfigUpdate(cmd Message) {
@@ -1894,6 +1907,8 @@ func (s *storageMgr) handleConfigUpdate(cmd Message) {
 		s.stm.ProcessCommand(cmd)
 	}
 
+	s.scrubber.ResetConfig(s.config)
+
 	s.supvCmdch <- &MsgSuccess{}
 }
 
@@ -26,6 +26,10 @@ import (
 
 var transactionMutationPrefix = []byte("_txn:")
 
+// 1 in recvLatencySampleRate mutations is stamped with its time of receipt,
+// to measure the receive to index latency without a clock read per mutation
+const recvLatencySampleRate = 64
+
 //MutationStreamReader reads a Dataport and stores the incoming mutations
 //in mutation queue. This is the only component writing to a mutation queue.
 type MutationStreamReader interface {
@@ -625,6 +629,8 @@ type streamWorker struct {
 	snapStart    uint64
 	snapEnd      uint64
 
+	recvCount uint64 //mutations received, to sample receive to index latency
+
 	workerId int
 	streamId common.StreamId
 
@@ -719,6 +725,9 @@ func (w *streamWorker) handleSingleKeyVersion(keyspaceId string, vbucket Vbucket
 	meta.seqno = kv.GetSeqno()
 	meta.projVer = projVer
 	meta.opaque = opaque
+	if w.recvCount++; w.recvCount%recvLatencySampleRate == 0 {
+		meta.recvTime = time.Now().UnixNano()
+	}
 
 	defer meta.Reset()
 
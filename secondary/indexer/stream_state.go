@@ -43,6 +43,7 @@ type StreamState struct {
 	streamKeyspaceIdRestartVbTsMap map[common.StreamId]KeyspaceIdRestartVbTsMap
 
 	streamKeyspaceIdFlushInProgressTsMap map[common.StreamId]KeyspaceIdFlushInProgressTsMap
+	streamKeyspaceIdFlushInProgressAsOf  map[common.StreamId]KeyspaceIdFlushInProgressAsOf
 	streamKeyspaceIdAbortInProgressMap   map[common.StreamId]KeyspaceIdAbortInProgressMap
 	streamKeyspaceIdFlushEnabledMap      map[common.StreamId]KeyspaceIdFlushEnabledMap
 	streamKeyspaceIdDrainEnabledMap      map[common.StreamId]KeyspaceIdDrainEnabledMap
@@ -123,6 +124,7 @@ type KeyspaceIdNeedsLastRollbackReset map[string]bool
 
 type KeyspaceIdTsListMap map[string]*list.List
 type KeyspaceIdFlushInProgressTsMap map[string]*common.TsVbuuid
+type KeyspaceIdFlushInProgressAsOf map[string]time.Time
 type KeyspaceIdAbortInProgressMap map[string]bool
 type KeyspaceIdFlushEnabledMap map[string]bool
 type KeyspaceIdDrainEnabledMap map[string]bool
@@ -168,6 +170,7 @@ type KeyspaceIdNumVBucketsMap map[string]int
 type TsListElem struct {
 	ts       *common.TsVbuuid
 	osoCount []uint64
+	asOf     time.Time // ts has all mutations received as of this time
 }
 
 type RepairState byte
@@ -206,6 +209,7 @@ func InitStreamState(config common.Config) *StreamState {
 		streamKeyspaceIdNewTsReqdMap:              make(map[common.StreamId]KeyspaceIdNewTsReqdMap),
 		streamKeyspaceIdTsListMap:                 make(map[common.StreamId]KeyspaceIdTsListMap),
 		streamKeyspaceIdFlushInProgressTsMap:      make(map[common.StreamId]KeyspaceIdFlushInProgressTsMap),
+		streamKeyspaceIdFlushInProgressAsOf:       make(map[common.StreamId]KeyspaceIdFlushInProgressAsOf),
 		streamKeyspaceIdAbortInProgressMap:        make(map[common.StreamId]KeyspaceIdAbortInProgressMap),
 		streamKeyspaceIdLastFlushedTsMap:          make(map[common.StreamId]KeyspaceIdLastFlushedTsMap),
 		streamKeyspaceIdLastSnapAlignFlushedTsMap: make(map[common.StreamId]KeyspaceIdLastFlushedTsMap),
@@ -290,6 +294,9 @@ func (ss *StreamState) initNewStream(streamId common.StreamId) {
 	keyspaceIdFlushInProgressTsMap := make(KeyspaceIdFlushInProgressTsMap)
 	ss.streamKeyspaceIdFlushInProgressTsMap[streamId] = keyspaceIdFlushInProgressTsMap
 
+	keyspaceIdFlushInProgressAsOf := make(KeyspaceIdFlushInProgressAsOf)
+	ss.streamKeyspaceIdFlushInProgressAsOf[streamId] = keyspaceIdFlushInProgressAsOf
+
 	keyspaceIdAbortInProgressMap := make(KeyspaceIdAbortInProgressMap)
 	ss.streamKeyspaceIdAbortInProgressMap[streamId] = keyspaceIdAbortInProgressMap
 
@@ -423,6 +430,7 @@ func (ss *StreamState) initKeyspaceIdInStream(streamId common.StreamId,
 	ss.streamKeyspaceIdHasBuildCompTSMap[streamId][keyspaceId] = false
 	ss.streamKeyspaceIdNewTsReqdMap[streamId][keyspaceId] = false
 	ss.streamKeyspaceIdFlushInProgressTsMap[streamId][keyspaceId] = nil
+	ss.streamKeyspaceIdFlushInProgressAsOf[streamId][keyspaceId] = time.Time{}
 	ss.streamKeyspaceIdAbortInProgressMap[streamId][keyspaceId] = false
 	ss.streamKeyspaceIdTsListMap[streamId][keyspaceId] = list.New()
 	ss.streamKeyspaceIdLastFlushedTsMap[streamId][keyspaceId] = nil
@@ -492,6 +500,7 @@ func (ss *StreamState) cleanupKeyspaceIdFromStream(streamId common.StreamId,
 	delete(ss.streamKeyspaceIdNewTsReqdMap[streamId], keyspaceId)
 	delete(ss.streamKeyspaceIdTsListMap[streamId], keyspaceId)
 	delete(ss.streamKeyspaceIdFlushInProgressTsMap[streamId], keyspaceId)
+	delete(ss.streamKeyspaceIdFlushInProgressAsOf[streamId], keyspaceId)
 	delete(ss.streamKeyspaceIdAbortInProgressMap[streamId], keyspaceId)
 	delete(ss.streamKeyspaceIdLastFlushedTsMap[streamId], keyspaceId)
 	delete(ss.streamKeyspaceIdLastSnapAlignFlushedTsMap[streamId], keyspaceId)
@@ -562,6 +571,7 @@ func (ss *StreamState) resetStreamState(streamId common.StreamId) {
 	delete(ss.streamKeyspaceIdNewTsReqdMap, streamId)
 	delete(ss.streamKeyspaceIdTsListMap, streamId)
 	delete(ss.streamKeyspaceIdFlushInProgressTsMap, streamId)
+	delete(ss.streamKeyspaceIdFlushInProgressAsOf, streamId)
 	delete(ss.streamKeyspaceIdAbortInProgressMap, streamId)
 	delete(ss.streamKeyspaceIdLastFlushedTsMap, streamId)
 	delete(ss.streamKeyspaceIdLastSnapAlignFlushedTsMap, streamId)
@@ -1472,6 +1482,25 @@ func (ss *StreamState) checkNewTSDue(streamId common.StreamId, keyspaceId string
 	return newTsReqd
 }
 
+// checks if the last snapshot of this keyspaceId in this stream has all
+// the mutations received, i.e. there is no new TS due, no TS pending or in
+// flush, and the last flushed TS created a snapshot
+func (ss *StreamState) checkSnapCaughtUp(streamId common.StreamId,
+	keyspaceId string) bool {
+
+	if ss.checkNewTSDue(streamId, keyspaceId) ||
+		!ss.canFlushNewTS(streamId, keyspaceId) {
+		return false
+	}
+
+	lastFlushedTs := ss.streamKeyspaceIdLastFlushedTsMap[streamId][keyspaceId]
+	if lastFlushedTs == nil {
+		return false
+	}
+	snapType := lastFlushedTs.GetSnapType()
+	return snapType != common.NO_SNAP && snapType != common.NO_SNAP_OSO
+}
+
 func (ss *StreamState) checkCommitOverdue(streamId common.StreamId, keyspaceId string) bool {
 
 	snapPersistInterval := ss.getPersistInterval()
@@ -1513,7 +1542,8 @@ func (ss *StreamState) getNextStabilityTS(streamId common.StreamId,
 	tsVbuuid.SetSnapAligned(false)
 
 	tsElem := &TsListElem{
-		ts: tsVbuuid,
+		ts:   tsVbuuid,
+		asOf: time.Now(),
 	}
 
 	enableOSO := ss.streamKeyspaceIdEnableOSO[streamId][keyspaceId]
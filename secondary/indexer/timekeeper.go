@@ -698,6 +698,10 @@ func (tk *timekeeper) handleFlushDone(cmd Message) {
 			tk.ss.streamKeyspaceIdLastSnapAlignFlushedTsMap[streamId][keyspaceId] = fts.Copy()
 		}
 
+		if fts != nil && fts.GetSnapType() != common.NO_SNAP && fts.GetSnapType() != common.NO_SNAP_OSO {
+			tk.updateSnapAsOf(streamId, keyspaceId, tk.ss.streamKeyspaceIdFlushInProgressAsOf[streamId][keyspaceId])
+		}
+
 		//update internal map to reflect flush is done
 		keyspaceIdFlushInProgressTsMap[keyspaceId] = nil
 	} else {
@@ -2893,6 +2897,11 @@ func (tk *timekeeper) generateNewStabilityTS(streamId common.StreamId,
 		return
 	}
 
+	// Nothing received since the last snapshot, which is then as fresh as now
+	if tk.ss.checkSnapCaughtUp(streamId, keyspaceId) {
+		tk.updateSnapAsOf(streamId, keyspaceId, time.Now())
+	}
+
 	// Is it overdue to persist a snapshot for this streamId and keyspaceId? This path skips the
 	// mutation manager flush that is usually the first step and instead triggers just the snapshot
 	// creation which normally follows that, since no mutations have arrived to trigger a snapshot.
@@ -3069,6 +3078,7 @@ func (tk *timekeeper) sendNewStabilityTS(tsElem *TsListElem, keyspaceId string,
 	tk.setNeedsCommit(streamId, keyspaceId, flushTs)
 
 	tk.ss.streamKeyspaceIdFlushInProgressTsMap[streamId][keyspaceId] = flushTs
+	tk.ss.streamKeyspaceIdFlushInProgressAsOf[streamId][keyspaceId] = tsElem.asOf
 
 	var stopCh StopChannel
 	var doneCh DoneChannel
@@ -4559,6 +4569,19 @@ func (tk *timekeeper) checkPendingStreamMerge(streamId common.StreamId,
 
 }
 
+// updateSnapAsOf records that the snapshots of the keyspaceId have all the
+// mutations received in the stream as of asOf, and wakes up the bounded
+// staleness scans waiting for them.
+func (tk *timekeeper) updateSnapAsOf(streamId common.StreamId, keyspaceId string,
+	asOf time.Time) {
+
+	keyspaceStats := tk.stats.GetKeyspaceStats(streamId, keyspaceId)
+	if keyspaceStats != nil && asOf.UnixNano() > keyspaceStats.lastSnapAsOf.Value() {
+		keyspaceStats.lastSnapAsOf.Set(asOf.UnixNano())
+		gSnapAsOfWaiters.notify(streamId, keyspaceId)
+	}
+}
+
 // startTimer starts a per stream/keyspaceId timer to periodically check and
 // generate a new stability timestamp
 func (tk *timekeeper) startTimer(streamId common.StreamId,
@@ -0,0 +1,280 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+var (
+	ErrNotVectorIndex          = errors.New("Vector scan is supported only for vector index")
+	ErrInvalidVectorScan       = errors.New("Vector scan requires query vector of index dimension and a positive topK")
+	ErrVectorScanNotSupported  = errors.New("Vector scan is not supported with aggregates, distinct or scan cursor")
+	ErrVectorIndexNotAvailable = errors.New("Vector index is not available in the snapshot")
+)
+
+// vectorScan is the top-K nearest-neighbour scan of a vector index. Scans
+// of the request pre-filter the entries considered for the top-K.
+type vectorScan struct {
+	query      []float32
+	similarity common.VectorSimilarity
+	topK       int
+	nprobe     int
+}
+
+func (r *ScanRequest) setVectorScan(protoScan *protobuf.VectorScan) error {
+	if protoScan == nil {
+		return nil
+	}
+
+	meta := r.IndexInst.Defn.VectorMeta
+	if meta == nil {
+		return ErrNotVectorIndex
+	}
+
+	if r.GroupAggr != nil || r.Distinct || r.needCursor || r.resumeCursor != nil {
+		return ErrVectorScanNotSupported
+	}
+
+	vs := &vectorScan{
+		query:      protoScan.GetQueryVector(),
+		similarity: meta.Similarity,
+		topK:       int(protoScan.GetTopK()),
+		nprobe:     int(protoScan.GetNprobe()),
+	}
+
+	if len(vs.query) != meta.Dimension || vs.topK <= 0 {
+		return ErrInvalidVectorScan
+	}
+
+	if name := protoScan.GetSimilarity(); name != "" {
+		var err error
+		if vs.similarity, err = common.ParseVectorSimilarity(name); err != nil {
+			return err
+		}
+	}
+
+	r.vectorScan = vs
+	return nil
+}
+
+// acceptVectorEntry returns true if the storage encoded entry qualifies
+// any of the scans of the request.
+func (r *ScanRequest) acceptVectorEntry(entry []byte, revbuf, buf *[]byte) (bool, error) {
+
+	ie := secondaryIndexEntry(entry)
+
+	for _, scan := range r.Scans {
+		switch scan.ScanType {
+		case AllReq:
+			return true, nil
+
+		case LookupReq:
+			if scan.Equals.Compare(&ie) != 0 {
+				continue
+			}
+			return true, nil
+		}
+
+		if c := scan.Low.ComparePrefixFields(&ie); c > 0 ||
+			(c == 0 && scan.Incl != Low && scan.Incl != Both) {
+			continue
+		}
+		if c := scan.High.ComparePrefixFields(&ie); c < 0 ||
+			(c == 0 && scan.Incl != High && scan.Incl != Both) {
+			continue
+		}
+
+		if scan.ScanType == FilterRangeReq {
+			key := entry
+			if r.IndexInst.Defn.HasDescending() {
+				key = append((*revbuf)[:0], entry...)
+				if _, err := jsonEncoder.ReverseCollate(key, r.IndexInst.Defn.Desc); err != nil {
+					return false, err
+				}
+				*revbuf = key
+			}
+
+			if len(key) > cap(*buf) {
+				*buf = make([]byte, 0, len(key)+RESIZE_PAD)
+			}
+			skip, _, err := filterScanRow(key, scan, (*buf)[:0])
+			if err != nil {
+				return false, err
+			}
+			if skip {
+				continue
+			}
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// vectorResultRow returns docid and the projected secondary keys of the
+// result, with distance appended as the trailing key, in the data encoding
+// format of the request.
+func (r *ScanRequest) vectorResultRow(res vectorResult) ([]byte, []byte, error) {
+
+	entry := append([]byte(nil), res.entry...)
+	if r.IndexInst.Defn.HasDescending() {
+		if _, err := jsonEncoder.ReverseCollate(entry, r.IndexInst.Defn.Desc); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	e := secondaryIndexEntry(entry)
+	docid, err := e.ReadDocId(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := entry[:e.lenKey()]
+	js, err := jsonEncoder.Decode(key, make([]byte, 0, len(key)*3))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keys []interface{}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	if err := dec.Decode(&keys); err != nil {
+		return nil, nil, err
+	}
+
+	if proj := r.Indexprojection; proj != nil && proj.projectSecKeys {
+		projected := make([]interface{}, 0, len(keys)+1)
+		if !proj.entryKeysEmpty {
+			for i, key := range keys {
+				if i < len(proj.projectionKeys) && proj.projectionKeys[i] {
+					projected = append(projected, key)
+				}
+			}
+		}
+		keys = projected
+	}
+	keys = append(keys, float64(res.distance))
+
+	var sk []byte
+	switch r.dataEncFmt {
+	case common.DATA_ENC_COLLATEJSON:
+		sk, err = encodeValue(keys)
+	case common.DATA_ENC_JSON:
+		sk, err = json.Marshal(keys)
+	default:
+		err = common.ErrUnexpectedDataEncFmt
+	}
+
+	return docid, sk, err
+}
+
+// handleVectorScanRequest searches the IVF index of every partition in the
+// snapshot and returns the topK nearest entries over all partitions.
+func (s *scanCoordinator) handleVectorScanRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot, t0 time.Time) {
+
+	var err error
+	var snapshots []SliceSnapshot
+	var results []vectorResult
+	var scanned int
+
+	waitTime := time.Now().Sub(t0)
+	defer func() {
+		s.recordSlowScan(req, uint64(len(results)), uint64(scanned), 0, waitTime, time.Since(t0), err)
+	}()
+
+	stopch := make(StopChannel)
+	cancelCb := NewCancelCallback(req, func(e error) {
+		err = e
+		close(stopch)
+	})
+	cancelCb.Run()
+	defer cancelCb.Done()
+
+	vs := req.vectorScan
+
+	revbuf := secKeyBufPool.Get()
+	defer secKeyBufPool.Put(revbuf)
+	buf := secKeyBufPool.Get()
+	defer secKeyBufPool.Put(buf)
+
+	accept := func(entry []byte) (bool, error) {
+		return req.acceptVectorEntry(entry, revbuf, buf)
+	}
+
+	if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+		for i, ss := range snapshots {
+			snap, ok := ss.Snapshot().(*vectorSnapshot)
+			if !ok {
+				err = ErrVectorIndexNotAvailable
+				break
+			}
+
+			if err = snap.ivf.load(req.Ctxs[i], snap); err != nil {
+				break
+			}
+
+			res, n, err1 := snap.ivf.search(snap.version, vs.query, vs.similarity, vs.topK, vs.nprobe, accept, stopch)
+			scanned += n
+			if err1 != nil {
+				err = err1
+				break
+			}
+			results = append(results, res...)
+		}
+	}
+
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	sortVectorResults(results)
+	if len(results) > vs.topK {
+		results = results[:vs.topK]
+	}
+
+	for _, res := range results {
+		var pk, sk []byte
+		if pk, sk, err = req.vectorResultRow(res); err != nil {
+			break
+		}
+		if !req.projectPrimaryKey {
+			pk = nil
+		}
+		if err = w.Row(pk, sk); err != nil {
+			break
+		}
+	}
+
+	if req.Stats != nil {
+		scanTime := time.Now().Sub(t0)
+		req.Stats.numRowsReturned.Add(int64(len(results)))
+		req.Stats.numRowsReturnedRange.Add(int64(len(results)))
+		req.Stats.numRowsScannedRange.Add(int64(scanned))
+		req.Stats.scanDuration.Add(scanTime.Nanoseconds())
+		req.Stats.scanWaitDuration.Add(waitTime.Nanoseconds())
+	}
+
+	if err != nil {
+		s.tryRespondWithError(w, req, err)
+		return
+	}
+
+	logging.Verbosef("%s RESPONSE vector scan rows:%d, scanned:%d, status:ok",
+		req.LogPrefix, len(results), scanned)
+}
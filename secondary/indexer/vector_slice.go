@@ -0,0 +1,655 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package indexer
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+const (
+	// Number of IVF lists, if not specified while creating the index
+	ivfDefaultCentroids = 64
+
+	// Number of IVF lists searched, if not specified in the scan
+	ivfDefaultNProbe = 8
+
+	// Centroids are retrained every time the number of vectors doubles,
+	// until there are ivfTrainFactor vectors per centroid
+	ivfTrainFactor = 64
+
+	ivfTrainIterations = 10
+)
+
+// vectorSlice wraps the storage slice of a vector index and maintains an
+// IVF (inverted file) index of the vector key alongside the storage. The
+// vectors are partitioned into lists by k-means centroids, and a top-K scan
+// searches only the lists whose centroids are nearest to the query vector.
+//
+// The IVF index is held in memory. It is updated as mutations are flushed
+// to the slice and is rebuilt from the storage snapshot on first scan after
+// the slice is opened or rolled back. Entries of the IVF index are versioned
+// by the storage snapshots, so that a scan of a snapshot searches the
+// vectors of that snapshot.
+type vectorSlice struct {
+	Slice
+	ivf *ivfIndex
+}
+
+func newVectorSlice(slice Slice, defn common.IndexDefn, isNew bool,
+	keySzCfg keySizeConfig) *vectorSlice {
+
+	ivf := newIvfIndex(defn.VectorMeta, defn.Desc, keySzCfg)
+	ivf.reset(isNew)
+
+	logging.Infof("vectorSlice:: created IVF index for instance %v partition %v: %v",
+		slice.IndexInstId(), slice.IndexPartnId(), defn.VectorMeta)
+
+	return &vectorSlice{Slice: slice, ivf: ivf}
+}
+
+func (s *vectorSlice) Insert(key []byte, docid []byte, meta *MutationMeta) error {
+	if err := s.Slice.Insert(key, docid, meta); err != nil {
+		return err
+	}
+	s.ivf.insert(key, docid, meta)
+	return nil
+}
+
+func (s *vectorSlice) Delete(docid []byte, meta *MutationMeta) error {
+	if err := s.Slice.Delete(docid, meta); err != nil {
+		return err
+	}
+	s.ivf.delete(docid)
+	return nil
+}
+
+func (s *vectorSlice) OpenSnapshot(info SnapshotInfo) (Snapshot, error) {
+	snap, err := s.Slice.OpenSnapshot(info)
+	if err != nil {
+		return nil, err
+	}
+	return &vectorSnapshot{Snapshot: snap, ivf: s.ivf, version: s.ivf.openVersion(), refCount: 1}, nil
+}
+
+func (s *vectorSlice) Rollback(info SnapshotInfo) error {
+	err := s.Slice.Rollback(info)
+	s.ivf.reset(false)
+	return err
+}
+
+func (s *vectorSlice) RollbackToZero(initialBuild bool) error {
+	err := s.Slice.RollbackToZero(initialBuild)
+	s.ivf.reset(true)
+	return err
+}
+
+func (s *vectorSlice) Destroy() {
+	s.ivf.reset(false)
+	s.Slice.Destroy()
+}
+
+// vectorSnapshot is the storage snapshot of a vectorSlice, along with the
+// version of the IVF index that has the vectors of the snapshot.
+type vectorSnapshot struct {
+	Snapshot
+	ivf      *ivfIndex
+	version  uint64
+	refCount int32
+}
+
+func (s *vectorSnapshot) Open() error {
+	atomic.AddInt32(&s.refCount, 1)
+	return s.Snapshot.Open()
+}
+
+func (s *vectorSnapshot) Close() error {
+	if atomic.AddInt32(&s.refCount, -1) == 0 {
+		s.ivf.closeVersion(s.version)
+	}
+	return s.Snapshot.Close()
+}
+
+// ivfEntry is a version of the vector of a document. An entry is visible
+// to the snapshots from the version it was added in, up to the version it
+// was removed in.
+type ivfEntry struct {
+	vec   []float32
+	entry []byte // storage encoded secondary index entry
+	list  int
+	born  uint64 // version in which the entry was added
+	dead  uint64 // version in which the entry was removed, 0 while it is live
+}
+
+func (e *ivfEntry) visible(version uint64) bool {
+	return e.born <= version && (e.dead == 0 || e.dead > version)
+}
+
+type ivfIndex struct {
+	sync.RWMutex
+
+	meta     common.VectorMetadata
+	desc     []bool
+	keySzCfg keySizeConfig
+
+	centroids [][]float32
+	lists     []map[*ivfEntry]struct{}
+	entries   map[string]*ivfEntry // live entry of every docid
+	removed   []*ivfEntry          // removed entries, in the order of removal
+	trained   int                  // number of vectors when the centroids were trained
+	training  bool
+
+	// Mutations flushed after a snapshot is opened are added to the next
+	// version. Removed entries are purged once there is no open snapshot
+	// of a version that can see them.
+	version uint64
+	open    map[uint64]int // number of open snapshots of a version
+	base    uint64         // snapshots older than base can not be searched
+
+	loaded  bool
+	mutated map[string]uint64 // version in which a docid was first mutated, until loaded
+	gen     uint64            // incremented on every reset
+
+	loadLock sync.Mutex
+}
+
+func newIvfIndex(meta *common.VectorMetadata, desc []bool,
+	keySzCfg keySizeConfig) *ivfIndex {
+
+	ivf := &ivfIndex{
+		meta:     *meta,
+		desc:     desc,
+		keySzCfg: keySzCfg,
+		version:  1,
+		open:     make(map[uint64]int),
+	}
+	if ivf.meta.NumCentroids <= 0 {
+		ivf.meta.NumCentroids = ivfDefaultCentroids
+	}
+	ivf.reset(true)
+	return ivf
+}
+
+// reset empties the IVF index. If it is not loaded, it is loaded from the
+// snapshot on the next scan. Snapshots opened before reset can no longer be
+// searched.
+func (ivf *ivfIndex) reset(loaded bool) {
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	ivf.centroids = nil
+	ivf.lists = []map[*ivfEntry]struct{}{make(map[*ivfEntry]struct{})}
+	ivf.entries = make(map[string]*ivfEntry)
+	ivf.removed = nil
+	ivf.trained = 0
+	ivf.base = ivf.version
+	ivf.loaded = loaded
+	ivf.mutated = nil
+	if !loaded {
+		ivf.mutated = make(map[string]uint64)
+	}
+	ivf.gen++
+}
+
+// openVersion returns the version of a snapshot being opened, which has
+// all the mutations flushed so far.
+func (ivf *ivfIndex) openVersion() uint64 {
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	version := ivf.version
+	ivf.version++
+	ivf.open[version]++
+	return version
+}
+
+func (ivf *ivfIndex) closeVersion(version uint64) {
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	if ivf.open[version]--; ivf.open[version] <= 0 {
+		delete(ivf.open, version)
+	}
+	ivf.purge()
+}
+
+// purge removes the entries that are not visible to any open snapshot.
+func (ivf *ivfIndex) purge() {
+	min := ivf.version
+	for version := range ivf.open {
+		if version < min {
+			min = version
+		}
+	}
+
+	n := 0
+	for ; n < len(ivf.removed) && ivf.removed[n].dead <= min; n++ {
+		e := ivf.removed[n]
+		if e.list < len(ivf.lists) {
+			delete(ivf.lists[e.list], e)
+		}
+		ivf.removed[n] = nil
+	}
+	ivf.removed = ivf.removed[n:]
+}
+
+func (ivf *ivfIndex) insert(key []byte, docid []byte, meta *MutationMeta) {
+	// Documents without a valid vector are not part of the IVF index
+	e, err := ivf.newEntry(key, docid, meta)
+
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	ivf.remove(string(docid))
+	if err == nil {
+		ivf.add(string(docid), e)
+	}
+}
+
+func (ivf *ivfIndex) delete(docid []byte) {
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	ivf.remove(string(docid))
+}
+
+func (ivf *ivfIndex) newEntry(key []byte, docid []byte, meta *MutationMeta) (*ivfEntry, error) {
+	buf := resizeEncodeBuf(nil, 3*len(key)+len(docid), true)
+	entry, err := NewSecondaryIndexEntry2(key, docid, false, 0, ivf.desc, buf, false, meta, ivf.keySzCfg)
+	if err != nil {
+		return nil, err
+	}
+	return ivf.decodeEntry(entry)
+}
+
+// decodeEntry returns IVF entry for the storage encoded entry.
+func (ivf *ivfIndex) decodeEntry(entry []byte) (*ivfEntry, error) {
+	orig := entry
+	if ivf.desc != nil {
+		orig = append([]byte(nil), entry...)
+		if _, err := jsonEncoder.ReverseCollate(orig, ivf.desc); err != nil {
+			return nil, err
+		}
+	}
+
+	e := secondaryIndexEntry(orig)
+	key := orig[:e.lenKey()]
+	js, err := jsonEncoder.Decode(key, make([]byte, 0, len(key)*3))
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []interface{}
+	if err := json.Unmarshal(js, &keys); err != nil {
+		return nil, err
+	}
+	if ivf.meta.KeyPos >= len(keys) {
+		return nil, common.ErrInvalidVector
+	}
+
+	vec, err := common.ToVector(keys[ivf.meta.KeyPos], ivf.meta.Dimension)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ivfEntry{vec: vec, entry: append([]byte(nil), entry...)}, nil
+}
+
+// add adds the live entry of docid to the current version. Caller holds
+// the lock.
+func (ivf *ivfIndex) add(docid string, e *ivfEntry) {
+	e.born = ivf.version
+	e.list = ivf.nearestCentroid(e.vec)
+	ivf.entries[docid] = e
+	ivf.lists[e.list][e] = struct{}{}
+
+	// Centroids are trained in the background
+	n := len(ivf.entries)
+	if !ivf.training && n >= 2*ivf.trained && ivf.trained < ivfTrainFactor*ivf.meta.NumCentroids {
+		ivf.training = true
+		go ivf.train()
+	}
+}
+
+// remove removes the live entry of docid from the current version. Caller
+// holds the lock.
+func (ivf *ivfIndex) remove(docid string) {
+	if !ivf.loaded {
+		if _, ok := ivf.mutated[docid]; !ok {
+			ivf.mutated[docid] = ivf.version
+		}
+	}
+
+	e, ok := ivf.entries[docid]
+	if !ok {
+		return
+	}
+
+	delete(ivf.entries, docid)
+	if e.born == ivf.version {
+		// Not visible to any snapshot
+		delete(ivf.lists[e.list], e)
+		return
+	}
+	e.dead = ivf.version
+	ivf.removed = append(ivf.removed, e)
+}
+
+// train computes centroids using k-means over the live vectors and
+// reassigns all the entries to the lists of their nearest centroids.
+// Centroids are computed without holding the lock, so that flush and
+// scans are not blocked while training.
+func (ivf *ivfIndex) train() {
+	ivf.RLock()
+	gen := ivf.gen
+	live := make([]*ivfEntry, 0, len(ivf.entries))
+	for _, e := range ivf.entries {
+		live = append(live, e)
+	}
+	ivf.RUnlock()
+
+	var centroids [][]float32
+	var assign []int
+	if len(live) > 0 {
+		centroids, assign = ivf.kmeans(live)
+	}
+
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	ivf.training = false
+	if ivf.gen != gen || len(live) == 0 {
+		return
+	}
+
+	assigned := make(map[*ivfEntry]int, len(live))
+	for i, e := range live {
+		assigned[e] = assign[i]
+	}
+
+	lists := make([]map[*ivfEntry]struct{}, len(centroids))
+	for c := range lists {
+		lists[c] = make(map[*ivfEntry]struct{})
+	}
+	for _, list := range ivf.lists {
+		for e := range list {
+			if c, ok := assigned[e]; ok {
+				e.list = c
+			} else {
+				e.list = nearestVector(centroids, e.vec)
+			}
+			lists[e.list][e] = struct{}{}
+		}
+	}
+
+	ivf.centroids = centroids
+	ivf.lists = lists
+	ivf.trained = len(live)
+}
+
+// kmeans returns k-means centroids of the vectors of entries, and the
+// position of the nearest centroid of every entry.
+func (ivf *ivfIndex) kmeans(entries []*ivfEntry) ([][]float32, []int) {
+	n := len(entries)
+	k := ivf.meta.NumCentroids
+	if k > n {
+		k = n
+	}
+
+	// Initial centroids are evenly spaced samples of the vectors
+	centroids := make([][]float32, k)
+	for i := range centroids {
+		centroids[i] = append([]float32(nil), entries[i*n/k].vec...)
+	}
+
+	assign := make([]int, n)
+	sums := make([][]float64, k)
+	counts := make([]int, k)
+	for iter := 0; iter < ivfTrainIterations; iter++ {
+		for i, e := range entries {
+			assign[i] = nearestVector(centroids, e.vec)
+		}
+
+		for c := range sums {
+			sums[c] = make([]float64, ivf.meta.Dimension)
+			counts[c] = 0
+		}
+		for i, e := range entries {
+			c := assign[i]
+			for d, x := range e.vec {
+				sums[c][d] += float64(x)
+			}
+			counts[c]++
+		}
+
+		for c := range centroids {
+			// Empty list keeps its previous centroid
+			if counts[c] == 0 {
+				continue
+			}
+			for d := range centroids[c] {
+				centroids[c][d] = float32(sums[c][d] / float64(counts[c]))
+			}
+		}
+	}
+
+	for i, e := range entries {
+		assign[i] = nearestVector(centroids, e.vec)
+	}
+	return centroids, assign
+}
+
+func (ivf *ivfIndex) nearestCentroid(vec []float32) int {
+	return nearestVector(ivf.centroids, vec)
+}
+
+// nearestVector returns position of the vector in vecs nearest to vec.
+// Vectors are assigned to lists using L2 distance, irrespective of the
+// similarity of the index.
+func nearestVector(vecs [][]float32, vec []float32) int {
+	nearest := 0
+	min := float32(math.MaxFloat32)
+	for i, v := range vecs {
+		if d := common.VectorDistance(common.L2, v, vec); d < min {
+			nearest, min = i, d
+		}
+	}
+	return nearest
+}
+
+// load builds the IVF index from the snapshot, if it is not loaded yet.
+// Vectors of the documents mutated after the snapshot are visible only to
+// the versions before the mutation.
+func (ivf *ivfIndex) load(ctx IndexReaderContext, snap *vectorSnapshot) error {
+	ivf.loadLock.Lock()
+	defer ivf.loadLock.Unlock()
+
+	ivf.RLock()
+	loaded, gen, base := ivf.loaded, ivf.gen, ivf.base
+	ivf.RUnlock()
+
+	if snap.version < base {
+		return ErrVectorIndexNotAvailable
+	}
+	if loaded {
+		return nil
+	}
+
+	var entries []*ivfEntry
+	var docids []string
+	var docid []byte
+	err := snap.Snapshot.All(ctx, func(entry []byte) error {
+		e, err := ivf.decodeEntry(entry)
+		if err != nil {
+			return nil
+		}
+		if docid, err = secondaryIndexEntry(entry).ReadDocId(docid[:0]); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		docids = append(docids, string(docid))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ivf.Lock()
+	defer ivf.Unlock()
+
+	if ivf.gen != gen {
+		return ErrIndexRollback
+	}
+
+	var removed []*ivfEntry
+	for i, e := range entries {
+		first, mutated := ivf.mutated[docids[i]]
+		if mutated && first <= snap.version {
+			// Vector of the snapshot is already in the index
+			continue
+		}
+
+		e.born = 0
+		e.list = ivf.nearestCentroid(e.vec)
+		ivf.lists[e.list][e] = struct{}{}
+		if mutated {
+			e.dead = first
+			removed = append(removed, e)
+		} else {
+			ivf.entries[docids[i]] = e
+		}
+	}
+
+	ivf.removed = append(ivf.removed, removed...)
+	sort.SliceStable(ivf.removed, func(i, j int) bool {
+		return ivf.removed[i].dead < ivf.removed[j].dead
+	})
+
+	ivf.loaded = true
+	ivf.mutated = nil
+	ivf.base = snap.version
+
+	if !ivf.training {
+		ivf.training = true
+		go ivf.train()
+	}
+
+	logging.Infof("ivfIndex::load loaded %v vectors from snapshot", len(entries))
+	return nil
+}
+
+type vectorResult struct {
+	entry    []byte
+	distance float32
+}
+
+// vectorResultHeap is a max-heap of results on distance, used to keep the
+// nearest K results.
+type vectorResultHeap []vectorResult
+
+func (h vectorResultHeap) Len() int            { return len(h) }
+func (h vectorResultHeap) Less(i, j int) bool  { return h[i].distance > h[j].distance }
+func (h vectorResultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *vectorResultHeap) Push(x interface{}) { *h = append(*h, x.(vectorResult)) }
+func (h *vectorResultHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// search returns the topK entries nearest to query, in the order of
+// distance, from the nprobe lists whose centroids are nearest to query.
+// Entries for which accept returns false are skipped.
+func (ivf *ivfIndex) search(version uint64, query []float32, similarity common.VectorSimilarity,
+	topK int, nprobe int, accept func(entry []byte) (bool, error),
+	stopch StopChannel) ([]vectorResult, int, error) {
+
+	ivf.RLock()
+	defer ivf.RUnlock()
+
+	if version < ivf.base {
+		return nil, 0, ErrVectorIndexNotAvailable
+	}
+
+	if nprobe <= 0 {
+		nprobe = ivfDefaultNProbe
+	}
+
+	// Until the centroids are trained, all the vectors are in one list
+	order := make([]int, len(ivf.lists))
+	for c := range order {
+		order[c] = c
+	}
+	if len(ivf.centroids) == len(ivf.lists) {
+		dist := make([]float32, len(ivf.centroids))
+		for c, centroid := range ivf.centroids {
+			dist[c] = common.VectorDistance(common.L2, centroid, query)
+		}
+		sort.Slice(order, func(i, j int) bool { return dist[order[i]] < dist[order[j]] })
+		if nprobe < len(order) {
+			order = order[:nprobe]
+		}
+	}
+
+	h := make(vectorResultHeap, 0, topK+1)
+	scanned := 0
+	for _, c := range order {
+		select {
+		case <-stopch:
+			return nil, scanned, common.ErrClientCancel
+		default:
+		}
+
+		for e := range ivf.lists[c] {
+			if !e.visible(version) {
+				continue
+			}
+			scanned++
+
+			d := common.VectorDistance(similarity, e.vec, query)
+			if len(h) == topK && d >= h[0].distance {
+				continue
+			}
+
+			if ok, err := accept(e.entry); err != nil {
+				return nil, scanned, err
+			} else if !ok {
+				continue
+			}
+
+			heap.Push(&h, vectorResult{entry: e.entry, distance: d})
+			if len(h) > topK {
+				heap.Pop(&h)
+			}
+		}
+	}
+
+	sortVectorResults(h)
+	return h, scanned, nil
+}
+
+// sortVectorResults sorts results in the order of distance. Results with
+// the same distance are ordered by entry, so that the order is stable.
+func sortVectorResults(results []vectorResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].distance != results[j].distance {
+			return results[i].distance < results[j].distance
+		}
+		return bytes.Compare(results[i].entry, results[j].entry) < 0
+	})
+}
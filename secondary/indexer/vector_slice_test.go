@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestIvfIndexSearch(t *testing.T) {
+	meta := &common.VectorMetadata{KeyPos: 1, Dimension: 2, Similarity: common.L2, NumCentroids: 4}
+	ivf := newIvfIndex(meta, nil, keySizeConfig{})
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf(`["%v",[%v,%v]]`, i%2 == 0, i%20, i/20)
+		ivf.insert([]byte(key), []byte(fmt.Sprintf("doc%v", i)), nil)
+	}
+	ivf.insert([]byte(`["true","not a vector"]`), []byte("doc1000"), nil)
+	ivf.delete([]byte("doc0"))
+	version := ivf.openVersion()
+	trainIvfIndex(ivf)
+
+	if len(ivf.entries) != 199 || len(ivf.centroids) != 4 {
+		t.Fatalf("Unexpected entries %v centroids %v", len(ivf.entries), len(ivf.centroids))
+	}
+
+	all := func([]byte) (bool, error) { return true, nil }
+	res, _, err := ivf.search(version, []float32{0, 0}, common.L2, 3, len(ivf.centroids), all, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float32{1, 1, 2}
+	if len(res) != len(expected) {
+		t.Fatalf("Expected %v results, received %v", len(expected), len(res))
+	}
+	for i, r := range res {
+		if r.distance != expected[i] {
+			t.Errorf("Expected distance %v, received %v", expected[i], r.distance)
+		}
+	}
+
+	// Pre-filter on the scalar key
+	even, err := NewSecondaryKey([]byte(`["true"]`), make([]byte, 0, 100), true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	evenOnly := func(entry []byte) (bool, error) {
+		e := secondaryIndexEntry(entry)
+		return even.ComparePrefixFields(&e) == 0, nil
+	}
+	res, _, err = ivf.search(version, []float32{0, 0}, common.L2, 2, len(ivf.centroids), evenOnly, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].distance != 1 || res[1].distance != 4 {
+		t.Errorf("Unexpected filtered results %v", res)
+	}
+}
+
+// trainIvfIndex waits for the background training and trains the centroids
+// on all the vectors.
+func trainIvfIndex(ivf *ivfIndex) {
+	for {
+		ivf.Lock()
+		if !ivf.training {
+			ivf.training = true
+			ivf.Unlock()
+			break
+		}
+		ivf.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	ivf.train()
+}
+
+func TestIvfIndexSnapshotVersions(t *testing.T) {
+	meta := &common.VectorMetadata{KeyPos: 0, Dimension: 2, Similarity: common.L2, NumCentroids: 2}
+	ivf := newIvfIndex(meta, nil, keySizeConfig{})
+
+	vector := func(x int) []byte { return []byte(fmt.Sprintf(`[[%v,0]]`, x)) }
+	search := func(version uint64) []float32 {
+		t.Helper()
+		all := func([]byte) (bool, error) { return true, nil }
+		res, _, err := ivf.search(version, []float32{0, 0}, common.L2, 10, 2, all, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dists []float32
+		for _, r := range res {
+			dists = append(dists, r.distance)
+		}
+		return dists
+	}
+	check := func(version uint64, expected ...float32) {
+		t.Helper()
+		if got := search(version); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("Expected distances %v for version %v, received %v", expected, version, got)
+		}
+	}
+
+	ivf.insert(vector(1), []byte("doc1"), nil)
+	ivf.insert(vector(2), []byte("doc2"), nil)
+	v1 := ivf.openVersion()
+
+	// Mutations after the snapshot are not visible to it
+	ivf.insert(vector(3), []byte("doc1"), nil)
+	ivf.delete([]byte("doc2"))
+	ivf.insert(vector(4), []byte("doc4"), nil)
+	v2 := ivf.openVersion()
+	trainIvfIndex(ivf)
+
+	check(v1, 1, 4)
+	check(v2, 9, 16)
+
+	// Removed entries are purged once no snapshot can see them
+	if len(ivf.removed) != 2 {
+		t.Errorf("Expected 2 removed entries, received %v", len(ivf.removed))
+	}
+	ivf.closeVersion(v1)
+	if len(ivf.removed) != 0 {
+		t.Errorf("Expected removed entries to be purged, received %v", len(ivf.removed))
+	}
+	check(v2, 9, 16)
+
+	// Snapshots before reset can not be searched
+	ivf.reset(true)
+	if _, _, err := ivf.search(v2, []float32{0, 0}, common.L2, 1, 1,
+		func([]byte) (bool, error) { return true, nil }, nil); err != ErrVectorIndexNotAvailable {
+		t.Errorf("Expected %v, received %v", ErrVectorIndexNotAvailable, err)
+	}
+}
@@ -10,6 +10,7 @@ package iowrap
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"io"
 	"io/fs"
 	"io/ioutil"
@@ -47,6 +48,20 @@ func GetDiskFailures() uint64 {
 	return atomic.LoadUint64((*uint64)(diskFailures))
 }
 
+// decryptFailures counts failures to decrypt data encrypted at rest, read from disk.
+var decryptFailures uint64
+
+// GetDecryptFailures returns the number of failures to decrypt data encrypted at rest. Thread-safe.
+func GetDecryptFailures() uint64 {
+	return atomic.LoadUint64(&decryptFailures)
+}
+
+// CountDecryptFailure atomically increments decryptFailures. It is called for failures to
+// decrypt data encrypted at rest that are not detected by a wrapper, e.g. for want of the key.
+func CountDecryptFailure() {
+	atomic.AddUint64(&decryptFailures, 1)
+}
+
 // countDiskFailures atomically increments the diskFailures global if input err's message is
 // anything other than that of EINTR. This matches Data Service Autofailover disk failure tracking.
 func countDiskFailures(err error) {
@@ -68,6 +83,21 @@ func Buffer_ReadFrom(this *bytes.Buffer, r io.Reader) (n int64, err error) {
 	return n, err
 }
 
+////////////////////////////////////////////////////////////////////////////////////////////////////
+// cipher.AEAD METHOD wrappers -- add more as needed
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// AEAD_Open wraps Go-native METHOD cipher.AEAD.Open for decrypt failure tracking. Unlike the other
+// wrappers, failures are not counted as disk failures here, as the errors of reads of the data
+// decrypted are counted by the wrappers they are read through.
+func AEAD_Open(this cipher.AEAD, dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	plaintext, err := this.Open(dst, nonce, ciphertext, additionalData)
+	if err != nil {
+		CountDecryptFailure()
+	}
+	return plaintext, err
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 // os.File METHOD wrappers -- add more as needed
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -68,6 +68,9 @@ const (
 	OPCODE_REBALANCE_DONE                              = OPCODE_UPDATE_REBALANCE_PHASE + 1
 	OPCODE_INST_ASYNC_RECOVERY_DONE                    = OPCODE_REBALANCE_DONE + 1
 	OPCODE_RESUME_RECOVERED_INDEXES                    = OPCODE_INST_ASYNC_RECOVERY_DONE + 1
+	OPCODE_REBUILD_INDEX                               = OPCODE_RESUME_RECOVERED_INDEXES + 1
+	OPCODE_CREATE_AGGREGATE                            = OPCODE_REBUILD_INDEX + 1
+	OPCODE_DROP_AGGREGATE                              = OPCODE_CREATE_AGGREGATE + 1
 )
 
 func Op2String(op common.OpCode) string {
@@ -154,6 +157,12 @@ func Op2String(op common.OpCode) string {
 		return "OPCODE_ASYNC_RECOVERY_DONE"
 	case OPCODE_RESUME_RECOVERED_INDEXES:
 		return "OPCODE_RESUME_RECOVERED_INDEXES"
+	case OPCODE_REBUILD_INDEX:
+		return "OPCODE_REBUILD_INDEX"
+	case OPCODE_CREATE_AGGREGATE:
+		return "OPCODE_CREATE_AGGREGATE"
+	case OPCODE_DROP_AGGREGATE:
+		return "OPCODE_DROP_AGGREGATE"
 	}
 
 	return fmt.Sprintf("%v", op)
@@ -346,6 +355,31 @@ type CheckToken struct {
 	Flag   uint32        `json:"flag,omitempty"`
 }
 
+/////////////////////////////////////////////////////////////////////////
+// Rebuild Index
+////////////////////////////////////////////////////////////////////////
+
+// RebuildIndexRequest creates the shadow index Definition of ALTER INDEX
+// REBUILD.  InstIds maps each instance of the rebuilt index to the
+// instance id of its shadow instance.
+type RebuildIndexRequest struct {
+	Definition c.IndexDefn                     `json:"defn,omitempty"`
+	InstIds    map[c.IndexInstId]c.IndexInstId `json:"instIds,omitempty"`
+}
+
+/////////////////////////////////////////////////////////////////////////
+// Index Aggregate
+////////////////////////////////////////////////////////////////////////
+
+// AggregateRequest creates (OPCODE_CREATE_AGGREGATE) or drops
+// (OPCODE_DROP_AGGREGATE) a precomputed aggregate of index DefnId.
+// Aggregate is set for create, and Name for drop.
+type AggregateRequest struct {
+	DefnId    c.IndexDefnId     `json:"defnId,omitempty"`
+	Aggregate *c.IndexAggregate `json:"aggregate,omitempty"`
+	Name      string            `json:"name,omitempty"`
+}
+
 /////////////////////////////////////////////////////////////////////////
 // marshalling/unmarshalling
 ////////////////////////////////////////////////////////////////////////
@@ -615,6 +649,46 @@ func MarshallCheckToken(checkToken *CheckToken) ([]byte, error) {
 	return buf, nil
 }
 
+func UnmarshallRebuildIndexRequest(data []byte) (*RebuildIndexRequest, error) {
+
+	rebuildIndexRequest := new(RebuildIndexRequest)
+	if err := json.Unmarshal(data, rebuildIndexRequest); err != nil {
+		return nil, err
+	}
+
+	return rebuildIndexRequest, nil
+}
+
+func MarshallRebuildIndexRequest(rebuildIndexRequest *RebuildIndexRequest) ([]byte, error) {
+
+	buf, err := json.Marshal(&rebuildIndexRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func UnmarshallAggregateRequest(data []byte) (*AggregateRequest, error) {
+
+	aggregateRequest := new(AggregateRequest)
+	if err := json.Unmarshal(data, aggregateRequest); err != nil {
+		return nil, err
+	}
+
+	return aggregateRequest, nil
+}
+
+func MarshallAggregateRequest(aggregateRequest *AggregateRequest) ([]byte, error) {
+
+	buf, err := json.Marshal(&aggregateRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
 type ScheduleCreateRequest struct {
 	Definition c.IndexDefn            `json:"defn,omitempty"`
 	Plan       map[string]interface{} `json:"plan,omitempty"`
@@ -208,7 +208,8 @@ type watcherCallback func(string, c.IndexerId, c.IndexerId)
 var REQUEST_CHANNEL_COUNT = 1000
 
 var VALID_PARAM_NAMES = []string{"nodes", "defer_build", "retain_deleted_xattr",
-	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio"}
+	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio",
+	"partition_scheme", "partition_ranges", "hash_scheme", "dimension", "similarity", "vector_key", "num_centroids"}
 
 var ErrWaitScheduleTimeout = fmt.Errorf("Timeout in checking for schedule create token.")
 
@@ -2176,6 +2177,8 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	var nodes []string = nil
 	var numReplica int = 0
 	var numPartition int = 0
+	var partitionRanges []string = nil
+	var hashScheme c.HashScheme = c.CRC32
 	var retainDeletedXATTR = false
 	var numDoc uint64 = 0
 	var secKeySize uint64 = 0
@@ -2255,16 +2258,55 @@ func (o *MetadataProvider) PrepareIndexDefn(
 			}
 		}
 
+		partitionScheme, err, retry = o.getPartitionSchemeParam(partitionScheme, plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		partitionRanges, err, retry = o.getPartitionRangesParam(partitionScheme, plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		if partitionScheme == c.RANGE {
+			if clusterVersion < c.INDEXER_76_VERSION {
+				return nil,
+					errors.New("Fails to create index.  Range partitioned index is enabled only after cluster is fully upgraded and there is no failed node."),
+					false
+			}
+		}
+
 		err = o.validatePartitionKeys(partitionScheme, partitionKeys, secExprs, isPrimary)
 		if err != nil {
 			return nil, err, false
 		}
 
+		hashScheme, err, retry = o.getHashSchemeParam(partitionScheme, plan)
+		if err != nil {
+			return nil, err, retry
+		}
+
+		if hashScheme != c.CRC32 && clusterVersion < c.INDEXER_76_VERSION {
+			return nil,
+				errors.New("Fails to create index.  Parameter hash_scheme is enabled only after cluster is fully upgraded and there is no failed node."),
+				false
+		}
+
 		numPartition, err, retry = o.getNumPartitionParam(partitionScheme, plan, version)
 		if err != nil {
 			return nil, err, retry
 		}
 
+		if partitionScheme == c.RANGE {
+			// number of partitions is implied by the partition boundaries
+			if _, ok := plan["num_partition"]; ok && numPartition != len(partitionRanges)+1 {
+				return nil,
+					errors.New("Fails to create index.  Parameter num_partition must be one more than the number of partition_ranges."),
+					false
+			}
+			numPartition = len(partitionRanges) + 1
+		}
+
 		immutable, err, retry = o.getImmutableParam(partitionScheme, plan, whereExpr)
 		if err != nil {
 			return nil, err, retry
@@ -2414,6 +2456,21 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		}
 	}
 
+	//
+	// Vector key
+	//
+
+	vectorMeta, err, retry := o.getVectorParam(plan, secExprs, desc, isPrimary, isArrayIndex)
+	if err != nil {
+		return nil, err, retry
+	}
+
+	if vectorMeta != nil && clusterVersion < c.INDEXER_76_VERSION {
+		return nil,
+			errors.New("Fails to create index.  Vector index is enabled only after cluster is fully upgraded and there is no failed node."),
+			false
+	}
+
 	//
 	// Create Index Definition
 	//
@@ -2436,6 +2493,7 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		ExprType:               c.ExprType(exprType),
 		PartitionScheme:        partitionScheme,
 		PartitionKeys:          partitionKeys,
+		PartitionRanges:        partitionRanges,
 		WhereExpr:              whereExpr,
 		Deferred:               deferred,
 		Nodes:                  nodes,
@@ -2443,7 +2501,7 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		IsArrayIndex:           isArrayIndex,
 		IsArrayFlattened:       isArrayFlattened,
 		NumReplica:             uint32(numReplica),
-		HashScheme:             c.CRC32,
+		HashScheme:             hashScheme,
 		NumPartitions:          uint32(numPartition),
 		RetainDeletedXATTR:     retainDeletedXATTR,
 		NumDoc:                 numDoc,
@@ -2455,6 +2513,7 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		Collection:             collection,
 		HasArrItemsCount:       hasArrItemsCount,
 		IndexMissingLeadingKey: indexMissingLeadingKey,
+		VectorMeta:             vectorMeta,
 	}
 
 	idxDefn.NumReplica2.InitializeCounter(idxDefn.NumReplica)
@@ -2482,6 +2541,8 @@ func (o *MetadataProvider) prepareIndexSpec(defn *c.IndexDefn) *planner.IndexSpe
 	spec.PartitionScheme = string(defn.PartitionScheme)
 	spec.HashScheme = uint64(defn.HashScheme)
 	spec.PartitionKeys = defn.PartitionKeys
+	spec.PartitionRanges = defn.PartitionRanges
+	spec.VectorMeta = defn.VectorMeta
 	spec.Replica = uint64(defn.NumReplica) + 1
 	spec.RetainDeletedXATTR = defn.RetainDeletedXATTR
 	spec.ExprType = string(defn.ExprType)
@@ -2943,7 +3004,7 @@ func (o *MetadataProvider) getDeferredParam(plan map[string]interface{}) (bool,
 
 func (o *MetadataProvider) validatePartitionKeys(partitionScheme c.PartitionScheme, partitionKeys []string, secKeys []string, isPrimary bool) error {
 
-	if partitionScheme != c.SINGLE && partitionScheme != c.KEY {
+	if partitionScheme != c.SINGLE && partitionScheme != c.KEY && partitionScheme != c.RANGE {
 		return errors.New(fmt.Sprintf("Fails to create index.  Partition Scheme %v is not allowed.", partitionScheme))
 	}
 
@@ -2959,6 +3020,10 @@ func (o *MetadataProvider) validatePartitionKeys(partitionScheme c.PartitionSche
 		return errors.New(fmt.Sprintf("Fails to create index.  Must specify partition keys for partitioned index."))
 	}
 
+	if partitionScheme == c.RANGE && len(partitionKeys) != 1 {
+		return errors.New(fmt.Sprintf("Fails to create index.  Range partitioned index must have exactly one partition key."))
+	}
+
 	secExprs := make(expression.Expressions, 0, len(secKeys))
 	for _, key := range secKeys {
 		expr, err := parser.Parse(key)
@@ -3017,6 +3082,196 @@ func (o *MetadataProvider) validatePartitionKeys(partitionScheme c.PartitionSche
 	return nil
 }
 
+//
+// Parameter partition_scheme selects the partition scheme of a partitioned
+// index, "hash" (default) or "range".  N1QL creates every partitioned index
+// with PARTITION BY HASH, so a range partitioned index must ask for it
+// explicitly.
+//
+func (o *MetadataProvider) getPartitionSchemeParam(partitionScheme c.PartitionScheme,
+	plan map[string]interface{}) (c.PartitionScheme, error, bool) {
+
+	param, ok := plan["partition_scheme"]
+	if !ok {
+		return partitionScheme, nil, false
+	}
+
+	name, ok := param.(string)
+	if !ok {
+		return partitionScheme, errors.New("Fails to create index.  Parameter partition_scheme must be a string value."), false
+	}
+
+	if !c.IsPartitioned(partitionScheme) {
+		return partitionScheme, errors.New("Fails to create index.  Parameter partition_scheme is allowed only for partitioned index."), false
+	}
+
+	switch strings.ToLower(name) {
+	case "hash":
+		if partitionScheme != c.KEY {
+			return partitionScheme, errors.New(fmt.Sprintf("Fails to create index.  Parameter partition_scheme %v does not match partition scheme %v.", name, partitionScheme)), false
+		}
+		return c.KEY, nil, false
+	case "range":
+		if partitionScheme != c.KEY && partitionScheme != c.RANGE {
+			return partitionScheme, errors.New(fmt.Sprintf("Fails to create index.  Parameter partition_scheme %v does not match partition scheme %v.", name, partitionScheme)), false
+		}
+		return c.RANGE, nil, false
+	}
+
+	return partitionScheme, errors.New(fmt.Sprintf("Fails to create index.  Invalid partition_scheme %v.  Valid values are hash, range.", name)), false
+}
+
+//
+// Parameter partition_ranges holds the partition boundaries of a range
+// partitioned index.  Each element is a boundary value of the partition key,
+// and the boundaries must be in ascending order.
+//
+func (o *MetadataProvider) getPartitionRangesParam(partitionScheme c.PartitionScheme,
+	plan map[string]interface{}) ([]string, error, bool) {
+
+	param, ok := plan["partition_ranges"]
+	if !ok {
+		if partitionScheme == c.RANGE {
+			return nil, errors.New("Fails to create index.  Parameter partition_ranges is required for range partitioned index."), false
+		}
+		return nil, nil, false
+	}
+
+	if partitionScheme != c.RANGE {
+		return nil, errors.New(fmt.Sprintf("Fails to create index.  Parameter partition_ranges is not allowed for partition scheme %v.  "+
+			"Set partition_scheme to range for range partitioned index.", partitionScheme)), false
+	}
+
+	values, ok := param.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, errors.New("Fails to create index.  Parameter partition_ranges must be a non-empty array."), false
+	}
+
+	ranges := make([]string, 0, len(values))
+	for _, value := range values {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Invalid partition_ranges value %v.", value)), false
+		}
+		ranges = append(ranges, string(buf))
+	}
+
+	if err := c.ValidatePartitionRanges(ranges); err != nil {
+		return nil, errors.New(fmt.Sprintf("Fails to create index.  %v.", err)), false
+	}
+
+	return ranges, nil, false
+}
+
+//
+// Parameter hash_scheme selects the hash function used to route partition
+// keys of a hash partitioned index, e.g. "crc32" (default), "xxhash" or "jump".
+//
+func (o *MetadataProvider) getHashSchemeParam(partitionScheme c.PartitionScheme,
+	plan map[string]interface{}) (c.HashScheme, error, bool) {
+
+	param, ok := plan["hash_scheme"]
+	if !ok {
+		return c.CRC32, nil, false
+	}
+
+	name, ok := param.(string)
+	if !ok {
+		return c.CRC32, errors.New("Fails to create index.  Parameter hash_scheme must be a string value."), false
+	}
+
+	if partitionScheme != c.KEY {
+		return c.CRC32, errors.New("Fails to create index.  Parameter hash_scheme is allowed only for hash partitioned index."), false
+	}
+
+	hashScheme, err := c.ParseHashScheme(name)
+	if err != nil {
+		return c.CRC32, errors.New(fmt.Sprintf("Fails to create index.  Invalid hash_scheme %v.  Valid values are crc32, xxhash, jump.", name)), false
+	}
+
+	return hashScheme, nil, false
+}
+
+//
+// Parameter dimension turns the index into a vector index.  The key at
+// position vector_key (default 0) is indexed as a vector of dimension
+// numbers, compared using similarity (L2, COSINE or DOT).  Remaining keys
+// are scalar keys that can be used to pre-filter nearest-neighbour scans.
+//
+func (o *MetadataProvider) getVectorParam(plan map[string]interface{}, secExprs []string,
+	desc []bool, isPrimary bool, isArrayIndex bool) (*c.VectorMetadata, error, bool) {
+
+	if _, ok := plan["dimension"]; !ok {
+		for _, name := range []string{"similarity", "vector_key", "num_centroids"} {
+			if _, ok := plan[name]; ok {
+				return nil, errors.New(fmt.Sprintf("Fails to create index.  Parameter %v is allowed only with parameter dimension.", name)), false
+			}
+		}
+		return nil, nil, false
+	}
+
+	if isPrimary || isArrayIndex {
+		return nil, errors.New("Fails to create index.  Vector index is not supported for primary or array index."), false
+	}
+
+	getInt := func(name string, defaultVal int) (int, error) {
+		switch v := plan[name].(type) {
+		case nil:
+			return defaultVal, nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case int64:
+			return int(v), nil
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return int(n), nil
+			}
+		}
+		return 0, errors.New(fmt.Sprintf("Fails to create index.  Parameter %v must be a integer value.", name))
+	}
+
+	meta := &c.VectorMetadata{Similarity: c.L2}
+
+	var err error
+	if meta.Dimension, err = getInt("dimension", 0); err != nil {
+		return nil, err, false
+	}
+	if meta.Dimension <= 0 {
+		return nil, errors.New("Fails to create index.  Parameter dimension must be a positive value."), false
+	}
+
+	if meta.KeyPos, err = getInt("vector_key", 0); err != nil {
+		return nil, err, false
+	}
+	if meta.KeyPos < 0 || meta.KeyPos >= len(secExprs) {
+		return nil, errors.New("Fails to create index.  Parameter vector_key must be the position of an index key."), false
+	}
+	if len(desc) > meta.KeyPos && desc[meta.KeyPos] {
+		return nil, errors.New("Fails to create index.  Vector key cannot be in descending order."), false
+	}
+
+	if meta.NumCentroids, err = getInt("num_centroids", 0); err != nil {
+		return nil, err, false
+	}
+	if meta.NumCentroids < 0 {
+		return nil, errors.New("Fails to create index.  Parameter num_centroids must be a positive value."), false
+	}
+
+	if param, ok := plan["similarity"]; ok {
+		name, ok := param.(string)
+		if !ok {
+			return nil, errors.New("Fails to create index.  Parameter similarity must be a string value."), false
+		}
+		if meta.Similarity, err = c.ParseVectorSimilarity(name); err != nil {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Invalid similarity %v.  Valid values are L2, COSINE, DOT.", name)), false
+		}
+	}
+
+	return meta, nil, false
+}
+
 func (o *MetadataProvider) getNumPartitionParam(scheme c.PartitionScheme, plan map[string]interface{}, version uint64) (int, error, bool) {
 
 	if scheme == c.SINGLE {
@@ -3518,6 +3773,196 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId, bucketName string) er
 	return nil
 }
 
+// RebuildIndex rebuilds index defnID with new index keys and where clause
+// (ALTER INDEX REBUILD).  A shadow index of the same name is created and
+// built in background on every node hosting the index.  Once the shadow
+// index is built, scans are routed to the shadow index and the index is
+// dropped.  If the shadow index fails to build, the shadow index is dropped.
+func (o *MetadataProvider) RebuildIndex(defnID c.IndexDefnId, exprType, whereExpr string,
+	secExprs []string, desc []bool, indexMissingLeadingKey bool,
+	plan map[string]interface{}) (c.IndexDefnId, error) {
+
+	if o.GetClusterVersion() < c.INDEXER_76_VERSION {
+		return c.IndexDefnId(0), errors.New("Fails to rebuild index.  Rebuild index is enabled only after cluster is fully upgraded and there is no failed node.")
+	}
+
+	meta := o.findIndex(defnID)
+	if meta == nil {
+		return c.IndexDefnId(0), errors.New("Index does not exist.")
+	}
+	old := meta.Definition
+
+	if meta.State != c.INDEX_STATE_ACTIVE {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to rebuild index.  Index %v is not built.", old.Name))
+	}
+
+	if c.IsPartitioned(old.PartitionScheme) {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to rebuild index.  Rebuild is not supported for partitioned index %v.", old.Name))
+	}
+
+	if o.findRebuildIndex(defnID) != nil || (old.RebuildOf != 0 && o.findIndex(old.RebuildOf) != nil) {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fails to rebuild index.  Index %v is already being rebuilt.", old.Name))
+	}
+
+	defn, err, _ := o.PrepareIndexDefn(old.Name, old.Bucket, old.Scope, old.Collection,
+		string(old.Using), exprType, whereExpr, secExprs, desc, indexMissingLeadingKey,
+		old.IsPrimary, old.PartitionScheme, old.PartitionKeys, plan)
+	if err != nil {
+		return c.IndexDefnId(0), err
+	}
+
+	// The shadow index is placed on the nodes of the rebuilt index
+	defn.RebuildOf = defnID
+	defn.Deferred = false
+	defn.Nodes = nil
+	defn.NumReplica = old.NumReplica
+	defn.NumReplica2 = old.NumReplica2
+
+	req := &RebuildIndexRequest{
+		Definition: *defn,
+		InstIds:    make(map[c.IndexInstId]c.IndexInstId),
+	}
+	for _, inst := range meta.Instances {
+		if req.InstIds[inst.InstId], err = c.NewIndexInstId(); err != nil {
+			return c.IndexDefnId(0), errors.New("Fails to rebuild index.  Internal Error: Fail to create uuid for index instance.")
+		}
+	}
+
+	content, err := MarshallRebuildIndexRequest(req)
+	if err != nil {
+		return c.IndexDefnId(0), err
+	}
+
+	watchers, err := o.findWatchersByDefnIdIgnoreStatus(defnID)
+	if err != nil || len(watchers) == 0 {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Cannot locate cluster node hosting Index %s.", old.Name))
+	}
+
+	key := fmt.Sprintf("%d", defn.DefnId)
+	errMap := make(map[string]bool)
+	for _, watcher := range watchers {
+		if _, err := watcher.makeRequest(OPCODE_REBUILD_INDEX, key, content); err != nil {
+			errMap[err.Error()] = true
+		}
+	}
+
+	if len(errMap) != 0 {
+		errStr := ""
+		for msg, _ := range errMap {
+			errStr += msg + "\n"
+		}
+
+		// Drop the shadow index that has been created on other nodes.  The index is not affected.
+		o.DropIndex(defn.DefnId, defn.Bucket)
+
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Fail to rebuild index on some indexer nodes.  Error=%s.", errStr))
+	}
+
+	return defn.DefnId, nil
+}
+
+// findRebuildIndex returns the shadow index that rebuilds index defnID.
+func (o *MetadataProvider) findRebuildIndex(defnID c.IndexDefnId) *IndexMetadata {
+
+	indices, _ := o.repo.listDefnWithValidInst()
+	for _, meta := range indices {
+		if meta.Definition.RebuildOf == defnID {
+			return meta
+		}
+	}
+
+	return nil
+}
+
+// CreateAggregate creates a precomputed aggregate of index defnID on every
+// node hosting the index.  The indexer maintains the aggregate as the index
+// is updated, and serves group aggregate scans of the same name from it.
+func (o *MetadataProvider) CreateAggregate(defnID c.IndexDefnId, aggregate *c.IndexAggregate) error {
+
+	if o.GetClusterVersion() < c.INDEXER_76_VERSION {
+		return errors.New("Fails to create aggregate.  Index aggregate is enabled only after cluster is fully upgraded and there is no failed node.")
+	}
+
+	meta := o.findIndex(defnID)
+	if meta == nil {
+		return errors.New("Index does not exist.")
+	}
+	defn := meta.Definition
+
+	if defn.IsPrimary || defn.IsArrayIndex || defn.VectorMeta != nil {
+		return errors.New(fmt.Sprintf("Fails to create aggregate.  Aggregate is not supported for index %v.", defn.Name))
+	}
+
+	if err := aggregate.Validate(len(defn.SecExprs)); err != nil {
+		return err
+	}
+
+	if c.FindIndexAggregate(defn.Aggregates, aggregate.Name) != nil {
+		return errors.New(fmt.Sprintf("Fails to create aggregate.  Aggregate %v already exists for index %v.", aggregate.Name, defn.Name))
+	}
+
+	req := &AggregateRequest{DefnId: defnID, Aggregate: aggregate}
+	if err := o.makeAggregateRequest(OPCODE_CREATE_AGGREGATE, defn, req); err != nil {
+		// Drop the aggregate that has been created on other nodes.
+		o.makeAggregateRequest(OPCODE_DROP_AGGREGATE, defn, &AggregateRequest{DefnId: defnID, Name: aggregate.Name})
+		return errors.New(fmt.Sprintf("Fail to create aggregate on some indexer nodes.  Error=%s.", err))
+	}
+
+	return nil
+}
+
+// DropAggregate drops the precomputed aggregate name of index defnID.
+func (o *MetadataProvider) DropAggregate(defnID c.IndexDefnId, name string) error {
+
+	meta := o.findIndex(defnID)
+	if meta == nil {
+		return errors.New("Index does not exist.")
+	}
+	defn := meta.Definition
+
+	if c.FindIndexAggregate(defn.Aggregates, name) == nil {
+		return errors.New(fmt.Sprintf("Fails to drop aggregate.  Aggregate %v does not exist for index %v.", name, defn.Name))
+	}
+
+	req := &AggregateRequest{DefnId: defnID, Name: name}
+	if err := o.makeAggregateRequest(OPCODE_DROP_AGGREGATE, defn, req); err != nil {
+		return errors.New(fmt.Sprintf("Fail to drop aggregate on some indexer nodes.  Error=%s.", err))
+	}
+
+	return nil
+}
+
+func (o *MetadataProvider) makeAggregateRequest(op common.OpCode, defn *c.IndexDefn, req *AggregateRequest) error {
+
+	content, err := MarshallAggregateRequest(req)
+	if err != nil {
+		return err
+	}
+
+	watchers, err := o.findWatchersByDefnIdIgnoreStatus(defn.DefnId)
+	if err != nil || len(watchers) == 0 {
+		return errors.New(fmt.Sprintf("Cannot locate cluster node hosting Index %s.", defn.Name))
+	}
+
+	key := fmt.Sprintf("%d", defn.DefnId)
+	errMap := make(map[string]bool)
+	for _, watcher := range watchers {
+		if _, err := watcher.makeRequest(op, key, content); err != nil {
+			errMap[err.Error()] = true
+		}
+	}
+
+	if len(errMap) != 0 {
+		errStr := ""
+		for msg, _ := range errMap {
+			errStr += msg + "\n"
+		}
+		return errors.New(errStr)
+	}
+
+	return nil
+}
+
 func (o *MetadataProvider) BuildIndexes(defns map[c.IndexDefnId]*c.IndexDefn) error {
 
 	watcherIndexMap := make(map[c.IndexerId][]c.IndexDefnId)
@@ -573,6 +573,12 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		result, err = m.handleClientStats(content)
 	case client.OPCODE_INST_ASYNC_RECOVERY_DONE:
 		err = m.handleInstAsyncRecoveryDone(content)
+	case client.OPCODE_REBUILD_INDEX:
+		err = m.handleRebuildIndex(content, common.NewUserRequestContext())
+	case client.OPCODE_CREATE_AGGREGATE:
+		err = m.handleCreateAggregate(content, common.NewUserRequestContext())
+	case client.OPCODE_DROP_AGGREGATE:
+		err = m.handleDropAggregate(content, common.NewUserRequestContext())
 	}
 
 	logging.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d, op %d, len(result) %d", reqId, op, len(result))
@@ -2385,6 +2391,221 @@ func (m *LifecycleMgr) setScheduleFlagAndUpdateErr(defn *common.IndexDefn, inst
 
 }
 
+//-----------------------------------------------------------
+// Rebuild Index
+//-----------------------------------------------------------
+
+// handleRebuildIndex creates the shadow index of ALTER INDEX REBUILD
+// (OPCODE_REBUILD_INDEX).  The shadow index is created with the instance
+// id and replica id of the local instance of the rebuilt index, and is
+// built in background on the INIT stream.   Janitor replaces the rebuilt
+// index with the shadow index once the shadow index is built.
+func (m *LifecycleMgr) handleRebuildIndex(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	req, err := client.UnmarshallRebuildIndexRequest(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleRebuildIndex() : rebuildIndex fails. Unable to unmarshall request. Reason = %v", err)
+		return err
+	}
+
+	defn := &req.Definition
+	defn.SetCollectionDefaults()
+
+	old, err := m.repo.GetIndexDefnById(defn.RebuildOf)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleRebuildIndex() : rebuildIndex fails. Reason = %v", err)
+		return err
+	}
+	if old == nil {
+		return fmt.Errorf("Fails to rebuild index.  Index %v does not exist.", defn.Name)
+	}
+
+	if old.Bucket != defn.Bucket || old.Scope != defn.Scope || old.Collection != defn.Collection || old.Name != defn.Name {
+		return fmt.Errorf("Fails to rebuild index.  Index %v does not match the rebuilt index.", defn.Name)
+	}
+
+	if common.IsPartitioned(old.PartitionScheme) {
+		return fmt.Errorf("Fails to rebuild index.  Rebuild is not supported for partitioned index %v.", defn.Name)
+	}
+
+	shadow, err := m.findRebuildIndex(old)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleRebuildIndex() : rebuildIndex fails. Reason = %v", err)
+		return err
+	}
+	if shadow != nil {
+		if shadow.DefnId == defn.DefnId {
+			// request is retried
+			return nil
+		}
+		return fmt.Errorf("Fails to rebuild index.  Index %v is already being rebuilt.", defn.Name)
+	}
+
+	insts, err := m.findAllLocalIndexInst(old.Bucket, old.Scope, old.Collection, old.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleRebuildIndex() : rebuildIndex fails. Reason = %v", err)
+		return err
+	}
+
+	for _, inst := range insts {
+		if inst.State != uint32(common.INDEX_STATE_ACTIVE) || inst.RState != uint32(common.REBAL_ACTIVE) {
+			continue
+		}
+
+		instId, ok := req.InstIds[common.IndexInstId(inst.InstId)]
+		if !ok {
+			continue
+		}
+
+		defn.InstId = instId
+		defn.ReplicaId = int(inst.ReplicaId)
+		defn.Deferred = false
+
+		logging.Infof("LifecycleMgr.handleRebuildIndex() : rebuild index %v (%v, %v, %v, %v) as index %v instance %v",
+			old.DefnId, defn.Bucket, defn.Scope, defn.Collection, defn.Name, defn.DefnId, instId)
+
+		// CreateIndex drops the shadow index if the build cannot be started.
+		return m.CreateIndex(defn, true, reqCtx, false)
+	}
+
+	return fmt.Errorf("Fails to rebuild index.  Index %v is not active on this node.", defn.Name)
+}
+
+// findRebuildIndex returns the shadow index that rebuilds index defn.
+func (m *LifecycleMgr) findRebuildIndex(defn *common.IndexDefn) (*common.IndexDefn, error) {
+
+	iter, err := m.repo.NewIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	for _, shadow, err := iter.Next(); err == nil; _, shadow, err = iter.Next() {
+		if shadow.RebuildOf == defn.DefnId {
+			return shadow, nil
+		}
+	}
+
+	return nil, nil
+}
+
+//-----------------------------------------------------------
+// Index Aggregate
+//-----------------------------------------------------------
+
+// handleCreateAggregate adds a precomputed aggregate to the index
+// definition (OPCODE_CREATE_AGGREGATE).  The indexer starts maintaining
+// the aggregate once the definition is updated.  This function is
+// idempotent.
+func (m *LifecycleMgr) handleCreateAggregate(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	req, err := client.UnmarshallAggregateRequest(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleCreateAggregate() : createAggregate fails. Unable to unmarshall request. Reason = %v", err)
+		return err
+	}
+
+	if req.Aggregate == nil {
+		return fmt.Errorf("Fails to create aggregate.  Aggregate is not specified.")
+	}
+
+	defn, err := m.repo.GetIndexDefnById(req.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleCreateAggregate() : createAggregate fails. Reason = %v", err)
+		return err
+	}
+	if defn == nil {
+		return fmt.Errorf("Fails to create aggregate.  Index %v does not exist.", req.DefnId)
+	}
+
+	if defn.IsPrimary || defn.IsArrayIndex || defn.VectorMeta != nil {
+		return fmt.Errorf("Fails to create aggregate.  Aggregate is not supported for index %v.", defn.Name)
+	}
+
+	if err := req.Aggregate.Validate(len(defn.SecExprs)); err != nil {
+		return err
+	}
+
+	if existing := common.FindIndexAggregate(defn.Aggregates, req.Aggregate.Name); existing != nil {
+		if existing.String() == req.Aggregate.String() {
+			// request is retried
+			return nil
+		}
+		return fmt.Errorf("Fails to create aggregate.  Aggregate %v already exists for index %v.", req.Aggregate.Name, defn.Name)
+	}
+
+	aggregates := append(common.CloneIndexAggregates(defn.Aggregates), req.Aggregate)
+
+	logging.Infof("LifecycleMgr.handleCreateAggregate() : create aggregate for index %v (%v, %v, %v, %v): %v",
+		defn.DefnId, defn.Bucket, defn.Scope, defn.Collection, defn.Name, req.Aggregate)
+
+	return m.updateIndexAggregates(defn, aggregates, reqCtx)
+}
+
+// handleDropAggregate removes a precomputed aggregate from the index
+// definition (OPCODE_DROP_AGGREGATE).
+func (m *LifecycleMgr) handleDropAggregate(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	req, err := client.UnmarshallAggregateRequest(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleDropAggregate() : dropAggregate fails. Unable to unmarshall request. Reason = %v", err)
+		return err
+	}
+
+	defn, err := m.repo.GetIndexDefnById(req.DefnId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleDropAggregate() : dropAggregate fails. Reason = %v", err)
+		return err
+	}
+	if defn == nil {
+		return fmt.Errorf("Fails to drop aggregate.  Index %v does not exist.", req.DefnId)
+	}
+
+	if common.FindIndexAggregate(defn.Aggregates, req.Name) == nil {
+		return fmt.Errorf("Fails to drop aggregate.  Aggregate %v does not exist for index %v.", req.Name, defn.Name)
+	}
+
+	var aggregates []*common.IndexAggregate
+	for _, ia := range defn.Aggregates {
+		if ia.Name != req.Name {
+			aggregates = append(aggregates, ia.Clone())
+		}
+	}
+
+	logging.Infof("LifecycleMgr.handleDropAggregate() : drop aggregate %v for index %v (%v, %v, %v, %v)",
+		req.Name, defn.DefnId, defn.Bucket, defn.Scope, defn.Collection, defn.Name)
+
+	return m.updateIndexAggregates(defn, aggregates, reqCtx)
+}
+
+// updateIndexAggregates persists the aggregates of the index definition
+// and notifies the indexer.  The definition is restored if the indexer
+// fails to apply the aggregates.
+func (m *LifecycleMgr) updateIndexAggregates(existDefn *common.IndexDefn,
+	aggregates []*common.IndexAggregate, reqCtx *common.MetadataRequestContext) error {
+
+	defn := *existDefn
+	defn.Aggregates = aggregates
+	if err := m.repo.UpdateIndex(&defn); err != nil {
+		logging.Errorf("LifecycleMgr.updateIndexAggregates() : update fails for index %v. Reason = %v", defn.DefnId, err)
+		return err
+	}
+
+	if m.notifier != nil {
+		if err := m.notifier.OnAggregateUpdate(defn.DefnId, aggregates, reqCtx); err != nil {
+			logging.Errorf("LifecycleMgr.updateIndexAggregates() : indexer fails to update aggregates for index %v. Reason = %v",
+				defn.DefnId, err)
+
+			if err1 := m.repo.UpdateIndex(existDefn); err1 != nil {
+				logging.Errorf("LifecycleMgr.updateIndexAggregates() : fail to restore index %v. Reason = %v", defn.DefnId, err1)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
 //-----------------------------------------------------------
 // Delete Index
 //-----------------------------------------------------------
@@ -4093,6 +4314,11 @@ func (m *LifecycleMgr) UpdateIndexInstance(bucket, scope, collection string, def
 			logging.Errorf("LifecycleMgr.handleTopologyChange() : index instance update fails. Reason = %v", err)
 			return err
 		}
+
+		// switch over to the shadow index without waiting for the next janitor cleanup
+		if defn.RebuildOf != 0 && (state == common.INDEX_STATE_ACTIVE || len(errStr) != 0) {
+			m.janitor.runOnce()
+		}
 	}
 
 	return nil
@@ -4840,6 +5066,10 @@ func (m *janitor) cleanup() {
 			continue
 		}
 
+		if defn.RebuildOf != 0 {
+			m.cleanupRebuild(defn, insts)
+		}
+
 		for _, inst := range insts {
 			// Queue up the cleanup request.  The request wont' happen until bootstrap is ready.
 			// Do not clean up any proxy instance created due to rebalance.  These proxy instances could be left in metadata to
@@ -4872,6 +5102,49 @@ func (m *janitor) cleanup() {
 	}
 }
 
+// cleanupRebuild completes or rolls back ALTER INDEX REBUILD of shadow
+// index defn.  The rebuilt index is dropped once all the local instances of
+// the shadow index are active.  If the shadow index fails to build, the
+// shadow index is dropped and the rebuilt index continues to serve scans.
+func (m *janitor) cleanupRebuild(defn *common.IndexDefn, insts []IndexInstDistribution) {
+
+	old, err := m.manager.repo.GetIndexDefnById(defn.RebuildOf)
+	if err != nil || old == nil {
+		// rebuild is completed
+		return
+	}
+
+	active := len(insts) != 0
+	failed := false
+	for _, inst := range insts {
+		if inst.State != uint32(common.INDEX_STATE_ACTIVE) {
+			active = false
+		}
+
+		// build is not retried in background
+		if inst.State == uint32(common.INDEX_STATE_ERROR) ||
+			(inst.State == uint32(common.INDEX_STATE_READY) && !inst.Scheduled && len(inst.Error) != 0) {
+			failed = true
+		}
+	}
+
+	dropId := old.DefnId
+	if failed {
+		dropId = defn.DefnId
+	} else if !active {
+		return
+	}
+
+	if err := m.manager.requestServer.MakeRequest(client.OPCODE_DROP_INDEX, fmt.Sprintf("%v", dropId), nil); err != nil {
+		logging.Warnf("janitor: Failed to drop index %v upon rebuild of index (%v, %v).  Internal Error = %v.",
+			dropId, defn.Bucket, defn.Name, err)
+	} else if failed {
+		logging.Infof("janitor: Rollback rebuild of index (%v, %v, %v). Drop shadow index %v.", defn.Bucket, defn.Name, old.DefnId, dropId)
+	} else {
+		logging.Infof("janitor: Complete rebuild of index (%v, %v, %v). Drop rebuilt index.", defn.Bucket, defn.Name, old.DefnId)
+	}
+}
+
 func (m *janitor) deleteScheduleTokens(defnID common.IndexDefnId) error {
 
 	// TODO: Avoid these calls if these calls were successful once.
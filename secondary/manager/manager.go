@@ -113,6 +113,7 @@ type MetadataNotifier interface {
 	OnIndexBuild([]common.IndexInstId, []string, *common.MetadataRequestContext) map[common.IndexInstId]error
 	OnRecoveredIndexBuild([]common.IndexInstId, []string, *common.MetadataRequestContext) map[common.IndexInstId]error
 	OnPartitionPrune(common.IndexInstId, []common.PartitionId, *common.MetadataRequestContext) error
+	OnAggregateUpdate(common.IndexDefnId, []*common.IndexAggregate, *common.MetadataRequestContext) error
 	OnFetchStats() error
 }
 
@@ -1530,6 +1530,8 @@ func prepareIndexSpec(defn *common.IndexDefn) *planner.IndexSpec {
 	spec.PartitionScheme = string(defn.PartitionScheme)
 	spec.HashScheme = uint64(defn.HashScheme)
 	spec.PartitionKeys = defn.PartitionKeys
+	spec.PartitionRanges = defn.PartitionRanges
+	spec.VectorMeta = defn.VectorMeta
 	spec.Replica = uint64(defn.NumReplica) + 1
 	spec.RetainDeletedXATTR = defn.RetainDeletedXATTR
 	spec.ExprType = string(defn.ExprType)
@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"io"
 	"os"
 
 	"github.com/couchbase/indexing/secondary/fdb"
 	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/security"
 )
 
 const DiskBlockSize = 4 * 1024 // 4K is ok for page cache writes
@@ -67,17 +69,36 @@ type rawFileWriter struct {
 	buf      []byte
 	path     string
 	checksum uint32
+
+	// Encryption at rest. The key is fixed on first open, so that all the
+	// segments appended to the file use the same key, and a rotated key is
+	// used from the next snapshot.
+	key   *security.AtRestKey
+	keyed bool
+	enc   io.WriteCloser
 }
 
 func (f *rawFileWriter) Open() error {
 	var err error
+	if !f.keyed {
+		if f.key, err = security.ActiveAtRestKey(); err != nil {
+			return err
+		}
+		f.keyed = true
+	}
+
 	f.fd, err = iowrap.Os_OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
 	if err == nil {
 		if f.buf == nil {
 			f.buf = make([]byte, encodeBufSize)
 		}
 
-		f.w = bufio.NewWriterSize(f.fd, DiskBlockSize)
+		if f.key != nil {
+			f.enc = security.NewAtRestWriter(f.fd, f.key)
+			f.w = bufio.NewWriterSize(f.enc, DiskBlockSize)
+		} else {
+			f.w = bufio.NewWriterSize(f.fd, DiskBlockSize)
+		}
 	}
 	return err
 }
@@ -92,6 +113,14 @@ func (f *rawFileWriter) FlushAndClose(sync bool) (reterr error) {
 	}
 	f.w = nil
 
+	if f.enc != nil {
+		err := f.enc.Close()
+		if reterr == nil {
+			reterr = err
+		}
+	}
+	f.enc = nil
+
 	if f.fd != nil {
 		if sync {
 			err := iowrap.File_Sync(f.fd)
@@ -163,7 +192,7 @@ func (f *rawFileReader) Open(path string) error {
 	f.fd, err = iowrap.Os_Open(path)
 	if err == nil {
 		f.buf = make([]byte, encodeBufSize)
-		f.r = bufio.NewReaderSize(f.fd, DiskBlockSize)
+		f.r = bufio.NewReaderSize(security.NewAtRestReader(f.fd), DiskBlockSize)
 	}
 	return err
 }
@@ -0,0 +1,810 @@
+package memdb
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/memdb/skiplist"
+	"github.com/couchbase/indexing/secondary/security"
+)
+
+// Incremental persistence
+//
+// With incremental persistence, StoreToDisk starts a chain of disk
+// snapshots. Each later snapshot of the chain, written by
+// StoreToDiskIncremental, adds a generation holding the items added and
+// the items removed since the previous snapshot, and hard links the rest
+// of its files from the previous snapshot:
+//
+//	nitro.json           version, chain and generation
+//	data/                base, as written by StoreToDisk
+//	deltas/files.json    generations to be applied on the base, in order
+//	deltas/gen-N/del/    items removed by generation N
+//	deltas/gen-N/add/    items added by generation N
+//
+// The last persisted snapshot of a chain is held open till the next one is
+// persisted, so that the items removed in between are not collected and
+// are found in the skiplist. Items removed since the last persisted
+// snapshot are thus retained in memory till the next snapshot is
+// persisted.
+//
+// A chain is encrypted at rest with one key. A new chain is started once
+// the active key is rotated, so that files linked from the previous
+// snapshot are not left encrypted with a key that is no longer active.
+//
+// Once a chain has more than maxDeltas generations, the base and the
+// generations are merged into a new base in the background, which the
+// next snapshot of the chain links to.
+
+const (
+	deltasDirName  = "deltas"
+	compactDirName = "compact"
+)
+
+// incrementalState is the last snapshot persisted in the chain.
+type incrementalState struct {
+	sync.Mutex
+
+	chain      int    // 0 if the next snapshot is to be persisted in full
+	generation int    // generation of the last persisted snapshot
+	keyId      string // key the files of the chain are encrypted with
+	snap       *Snapshot
+
+	compacting bool
+	compacted  *compactedBase
+}
+
+// compactedBase is the base and generations of a chain up to generation,
+// merged into the data files in dir.
+type compactedBase struct {
+	chain      int
+	generation int
+	dir        string
+}
+
+func (m *MemDB) incremental() bool {
+	return m.incrDir != ""
+}
+
+// StoreToDiskIncremental writes an index snapshot to dir as a generation
+// of the items added and removed since the snapshot persisted in baseDir.
+// The snapshot is written in full by StoreToDisk if baseDir is not the
+// last snapshot persisted by this MemDB. As with StoreToDisk, it has to be
+// preceded by PreparePersistence.
+func (m *MemDB) StoreToDiskIncremental(dir, baseDir string, snap *Snapshot, concurr int) (err error) {
+
+	chain, generation, prev, gens, ok := m.getDeltaBase(baseDir)
+	if !ok {
+		return m.StoreToDisk(dir, snap, concurr, nil)
+	}
+
+	defer prev.Close()
+	defer snap.Close()
+
+	m.Lock()
+
+	if m.useMemoryMgmt {
+		defer m.shutdownWg1.Done()
+	}
+
+	if m.hasShutdown {
+		m.Unlock()
+		return ErrShutdown
+	}
+
+	m.Unlock()
+
+	defer func() {
+		if err != nil {
+			m.endChain()
+		}
+	}()
+
+	t0 := time.Now()
+	generation++
+	gen := fmt.Sprintf("gen-%d", generation)
+	adddir := filepath.Join(dir, deltasDirName, gen, "add")
+	deldir := filepath.Join(dir, deltasDirName, gen, "del")
+
+	// Items added since the previous snapshot
+	files, checksums, added, err := m.storeItems(adddir, snap, concurr, func(itm *Item) bool {
+		return itm.bornSn > prev.sn
+	})
+	if err == nil {
+		err = writeFileList(adddir, files, checksums)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Items removed since the previous snapshot. None of them is collected
+	// yet, as the previous snapshot is held open.
+	files, checksums, removed, err := m.storeItems(deldir, prev, concurr, func(itm *Item) bool {
+		deadSn := atomic.LoadUint32(&itm.deadSn)
+		return deadSn != 0 && deadSn <= snap.sn
+	})
+	if err == nil {
+		err = writeFileList(deldir, files, checksums)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Unchanged items, linked from the previous snapshot
+	if gens, err = m.linkDeltaBase(dir, baseDir, chain, gens); err != nil {
+		return err
+	}
+	gens = append(gens, gen)
+
+	bs, _ := json.Marshal(gens)
+	if err = common.WriteFileWithSync(filepath.Join(dir, deltasDirName, "files.json"), bs, 0660); err != nil {
+		return err
+	}
+
+	manifest, _ := json.Marshal(map[string]interface{}{"version": version,
+		"chain": chain, "generation": generation})
+	if err = common.WriteFileWithSync(filepath.Join(dir, "nitro.json"), manifest, 0660); err != nil {
+		return err
+	}
+
+	m.incr.Lock()
+	m.incr.generation = generation
+	m.setChainSnapshot(snap)
+	m.incr.Unlock()
+
+	logging.Infof("MemDB::StoreToDiskIncremental: Done dir [%v] chain [%v] generation [%v] "+
+		"added [%v] removed [%v] generations [%v] took [%v]", dir, chain, generation,
+		added, removed, len(gens), time.Since(t0))
+
+	if len(gens) > m.maxDeltas {
+		m.compact(dir, chain, generation, gens)
+	}
+
+	return nil
+}
+
+// getDeltaBase returns the chain, generation, snapshot and generations of
+// the snapshot persisted in baseDir, if it is the last snapshot persisted
+// by this MemDB. The snapshot returned is open, and has to be closed by
+// the caller.
+func (m *MemDB) getDeltaBase(baseDir string) (int, int, *Snapshot, []string, bool) {
+	if !m.incremental() || baseDir == "" {
+		return 0, 0, nil, nil, false
+	}
+
+	mMap := make(map[string]int)
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(baseDir, "nitro.json")); err != nil {
+		return 0, 0, nil, nil, false
+	} else if err := json.Unmarshal(bs, &mMap); err != nil {
+		return 0, 0, nil, nil, false
+	}
+
+	var gens []string
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(baseDir, deltasDirName, "files.json")); err == nil {
+		if err := json.Unmarshal(bs, &gens); err != nil {
+			return 0, 0, nil, nil, false
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, 0, nil, nil, false
+	}
+
+	keyId, err := activeKeyId()
+	if err != nil {
+		return 0, 0, nil, nil, false
+	}
+
+	m.incr.Lock()
+	defer m.incr.Unlock()
+
+	if m.incr.chain == 0 || m.incr.snap == nil ||
+		mMap["chain"] != m.incr.chain || mMap["generation"] != m.incr.generation {
+		return 0, 0, nil, nil, false
+	}
+
+	// Restart a chain encrypted with a key that is no longer active
+	if keyId != m.incr.keyId {
+		logging.Infof("MemDB::getDeltaBase: Active key changed, chain [%v] is restarted", m.incr.chain)
+		return 0, 0, nil, nil, false
+	}
+
+	// Restart a chain that grows faster than it is compacted
+	pending := len(gens)
+	if c := m.incr.compacted; c != nil && c.chain == m.incr.chain {
+		pending = m.incr.generation - c.generation
+	}
+	if pending >= 2*m.maxDeltas {
+		return 0, 0, nil, nil, false
+	}
+
+	if !m.incr.snap.Open() {
+		return 0, 0, nil, nil, false
+	}
+	return m.incr.chain, m.incr.generation, m.incr.snap, gens, true
+}
+
+// startChain makes snap the last persisted snapshot of a chain encrypted
+// with keyId.
+func (m *MemDB) startChain(chain, generation int, keyId string, snap *Snapshot) {
+	m.incr.Lock()
+	defer m.incr.Unlock()
+
+	m.incr.chain, m.incr.generation, m.incr.keyId = chain, generation, keyId
+	m.setChainSnapshot(snap)
+}
+
+// endChain makes the next snapshot be persisted in full, and releases the
+// last persisted snapshot.
+func (m *MemDB) endChain() {
+	m.incr.Lock()
+	defer m.incr.Unlock()
+
+	m.incr.chain = 0
+	m.setChainSnapshot(nil)
+}
+
+// setChainSnapshot holds snap open as the last persisted snapshot of the
+// chain, and releases the previous one. It is called with m.incr locked.
+func (m *MemDB) setChainSnapshot(snap *Snapshot) {
+	if prev := m.incr.snap; prev != nil {
+		prev.Close()
+	}
+
+	m.incr.snap = nil
+	if snap != nil {
+		if snap.Open() {
+			m.incr.snap = snap
+		} else {
+			m.incr.chain = 0
+		}
+	}
+}
+
+// activeKeyId returns the id of the key files are encrypted with, or "" if
+// encryption at rest is disabled.
+func activeKeyId() (string, error) {
+	key, err := security.ActiveAtRestKey()
+	if err != nil || key == nil {
+		return "", err
+	}
+	return key.Id, nil
+}
+
+// baseKeyId returns the id of the key the base in datadir is encrypted
+// with, or "" if it is not encrypted.
+func baseKeyId(datadir string, files []string) (string, error) {
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	fd, err := iowrap.Os_Open(filepath.Join(datadir, files[0]))
+	if err != nil {
+		return "", err
+	}
+	defer iowrap.File_Close(fd)
+
+	return security.AtRestKeyId(fd)
+}
+
+// storeItems writes the items of snap for which filter returns true to
+// dir, in a sorted file per shard.
+func (m *MemDB) storeItems(dir string, snap *Snapshot, concurr int,
+	filter func(*Item) bool) (files []string, checksums []uint32, count int64, err error) {
+
+	if err = iowrap.Os_MkdirAll(dir, 0755); err != nil {
+		return nil, nil, 0, err
+	}
+
+	shards := runtime.GOMAXPROCS(0)
+	writers := make([]FileWriter, shards)
+	files = make([]string, shards)
+	checksums = make([]uint32, shards)
+
+	defer func() {
+		for _, w := range writers {
+			if w != nil {
+				if err2 := w.Close(true); err == nil {
+					err = err2
+				}
+			}
+		}
+	}()
+
+	for shard := range writers {
+		files[shard] = fmt.Sprintf("shard-%d", shard)
+		w := m.newFileWriter(m.fileType, filepath.Join(dir, files[shard]))
+		if err = w.Open(); err != nil {
+			return nil, nil, 0, err
+		}
+		writers[shard] = w
+	}
+
+	err = m.Visitor(snap, func(itm *Item, shard int) error {
+		if m.hasShutdown {
+			return ErrShutdown
+		}
+		if !filter(itm) {
+			return nil
+		}
+		atomic.AddInt64(&count, 1)
+		return writers[shard].WriteItem(itm)
+	}, shards, concurr)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	for shard, w := range writers {
+		checksums[shard] = w.Checksum()
+	}
+	return files, checksums, count, nil
+}
+
+// linkDeltaBase links the base and the generations of the snapshot in
+// baseDir to dir, replacing them with the compacted base of the chain if
+// there is one, and returns the generations linked.
+func (m *MemDB) linkDeltaBase(dir, baseDir string, chain int, gens []string) ([]string, error) {
+	datadir := filepath.Join(baseDir, "data")
+
+	m.incr.Lock()
+	c := m.incr.compacted
+	m.incr.Unlock()
+
+	if c != nil && c.chain == chain {
+		datadir = c.dir
+
+		var pending []string
+		for _, gen := range gens {
+			var n int
+			if _, err := fmt.Sscanf(gen, "gen-%d", &n); err == nil && n > c.generation {
+				pending = append(pending, gen)
+			}
+		}
+		gens = pending
+	}
+
+	if err := linkDir(datadir, filepath.Join(dir, "data")); err != nil {
+		return nil, err
+	}
+	for _, gen := range gens {
+		if err := linkDir(filepath.Join(baseDir, deltasDirName, gen),
+			filepath.Join(dir, deltasDirName, gen)); err != nil {
+			return nil, err
+		}
+	}
+	return gens, nil
+}
+
+// linkDir hard links the files under src to dst. Files are copied if
+// they cannot be linked.
+func linkDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return iowrap.Os_MkdirAll(target, 0755)
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) (err error) {
+	in, err := iowrap.Os_Open(src)
+	if err != nil {
+		return err
+	}
+	defer iowrap.File_Close(in)
+
+	out, err := iowrap.Os_OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := iowrap.File_Close(out); err == nil {
+			err = err2
+		}
+	}()
+
+	if _, err = iowrap.Io_Copy(out, in); err != nil {
+		return err
+	}
+	return iowrap.File_Sync(out)
+}
+
+// writeFileList writes the list of files in dir and their checksums.
+func writeFileList(dir string, files []string, checksums []uint32) error {
+	bs, _ := json.Marshal(files)
+	if err := common.WriteFileWithSync(filepath.Join(dir, "files.json"), bs, 0660); err != nil {
+		return err
+	}
+
+	bs, _ = json.Marshal(checksums)
+	return common.WriteFileWithSync(filepath.Join(dir, "checksums.json"), bs, 0660)
+}
+
+// readFileList reads the list of files in dir and their checksums.
+func readFileList(dir string) ([]string, []uint32, error) {
+	var files []string
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(dir, "files.json")); err != nil {
+		return nil, nil, err
+	} else if err := json.Unmarshal(bs, &files); err != nil {
+		return nil, nil, err
+	}
+
+	checksums := make([]uint32, len(files))
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(dir, "checksums.json")); err == nil {
+		json.Unmarshal(bs, &checksums)
+	}
+	if len(checksums) != len(files) {
+		return nil, nil, ErrCorruptSnapshot
+	}
+	return files, checksums, nil
+}
+
+// restoreGeneration applies a generation to the store being loaded.
+func (m *MemDB) restoreGeneration(gendir string, ver int, concurr int) error {
+	if err := m.restoreRemoved(filepath.Join(gendir, "del"), ver); err != nil {
+		return err
+	}
+	return m.restoreDelta(filepath.Join(gendir, "add"), ver, concurr, nil)
+}
+
+// restoreRemoved removes the items listed in dir from the store being
+// loaded. Nobody else accesses the store yet, so nodes are freed right
+// away.
+func (m *MemDB) restoreRemoved(dir string, ver int) error {
+	files, checksums, err := readFileList(dir)
+	if err != nil {
+		return err
+	}
+
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+
+	for i, file := range files {
+		r := m.newFileReader(m.fileType, ver)
+		if err = r.Open(filepath.Join(dir, file)); err != nil {
+			return err
+		}
+
+		for {
+			var itm *Item
+			if itm, err = r.ReadItem(); err != nil || itm == nil {
+				break
+			}
+			m.removeNode(itm, buf)
+			m.freeItem(itm)
+		}
+		r.Close()
+
+		if err != nil {
+			return err
+		} else if checksums[i] != 0 && checksums[i] != r.Checksum() {
+			return ErrCorruptSnapshot
+		}
+	}
+
+	return nil
+}
+
+func (m *MemDB) removeNode(itm *Item, buf *skiplist.ActionBuffer) {
+	var n *skiplist.Node
+
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	if iter.SeekWithCmp(unsafe.Pointer(itm), m.insCmp, m.existCmp) {
+		n = iter.GetNode()
+	}
+	iter.Close()
+
+	if n != nil && m.store.DeleteNode(n, m.insCmp, buf, &m.store.Stats) {
+		m.freeItem((*Item)(n.Item()))
+		m.store.FreeNode(n, &m.store.Stats)
+	}
+}
+
+// visitRestored calls callb on all nodes of the store loaded, and returns
+// the number of items.
+func (m *MemDB) visitRestored(callb ItemCallback) int64 {
+	var count int64
+
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	defer iter.Close()
+
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		if callb != nil {
+			n := iter.GetNode()
+			callb(&ItemEntry{itm: (*Item)(n.Item()), n: n})
+		}
+		count++
+	}
+
+	return count
+}
+
+// compact merges the base and the generations of the snapshot in dir into
+// a new base in the background. Files are linked before returning, as dir
+// is renamed by the caller.
+func (m *MemDB) compact(dir string, chain, generation int, gens []string) {
+	m.incr.Lock()
+	if m.incr.compacting {
+		m.incr.Unlock()
+		return
+	}
+	m.incr.compacting = true
+	m.incr.Unlock()
+
+	m.Lock()
+	if m.hasShutdown {
+		m.Unlock()
+		m.incr.Lock()
+		m.incr.compacting = false
+		m.incr.Unlock()
+		return
+	}
+	m.shutdownWg1.Add(1)
+	m.Unlock()
+
+	workdir := filepath.Join(m.incrDir, fmt.Sprintf("%v-%v", compactDirName, time.Now().UnixNano()))
+	input := filepath.Join(workdir, "input")
+	output := filepath.Join(workdir, "data")
+
+	done := func(err error) {
+		defer m.shutdownWg1.Done()
+
+		iowrap.Os_RemoveAll(input)
+
+		m.incr.Lock()
+		defer m.incr.Unlock()
+
+		m.incr.compacting = false
+		if err == nil && m.incr.chain != chain {
+			err = fmt.Errorf("chain ended")
+		}
+		if err != nil {
+			logging.Errorf("MemDB::compact: Failed to compact chain [%v] generation [%v] (err=%v)",
+				chain, generation, err)
+			iowrap.Os_RemoveAll(workdir)
+			return
+		}
+
+		if c := m.incr.compacted; c != nil {
+			iowrap.Os_RemoveAll(filepath.Dir(c.dir))
+		}
+		m.incr.compacted = &compactedBase{chain: chain, generation: generation, dir: output}
+	}
+
+	if err := linkDir(dir, input); err != nil {
+		done(err)
+		return
+	}
+
+	go func() {
+		t0 := time.Now()
+		count, err := m.mergeGenerations(input, gens, output)
+		if err == nil {
+			logging.Infof("MemDB::compact: Done chain [%v] generation [%v] count [%v] took [%v]",
+				chain, generation, count, time.Since(t0))
+		}
+		done(err)
+	}()
+}
+
+// mergeRun is a sorted file being merged. Runs are ranked in the order
+// they are applied in, starting with the base.
+type mergeRun struct {
+	r        FileReader
+	itm      *Item
+	rank     int
+	removed  bool
+	checksum uint32
+}
+
+// advance reads the next item of the run, returns false at its end.
+func (run *mergeRun) advance() (bool, error) {
+	itm, err := run.r.ReadItem()
+	if err != nil {
+		return false, err
+	} else if itm == nil {
+		if run.checksum != 0 && run.checksum != run.r.Checksum() {
+			return false, ErrCorruptSnapshot
+		}
+		return false, nil
+	}
+
+	run.itm = itm
+	return true, nil
+}
+
+type mergeHeap struct {
+	runs   []*mergeRun
+	keyCmp KeyCompare
+}
+
+func (h *mergeHeap) Len() int { return len(h.runs) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	if v := h.keyCmp(h.runs[i].itm.Bytes(), h.runs[j].itm.Bytes()); v != 0 {
+		return v < 0
+	}
+	return h.runs[i].rank < h.runs[j].rank
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*mergeRun)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
+
+// mergeGenerations merges the base and the generations in dir into a new
+// base in outdir. An item is in the new base if the last run it is found
+// in, in the order of application, adds it.
+func (m *MemDB) mergeGenerations(dir string, gens []string, outdir string) (count int64, err error) {
+	var runs []*mergeRun
+	var size int64
+	h := &mergeHeap{keyCmp: m.keyCmp}
+
+	defer func() {
+		for _, run := range runs {
+			if run.itm != nil {
+				m.freeItem(run.itm)
+			}
+			run.r.Close()
+		}
+	}()
+
+	addRuns := func(rundir string, rank int, removed bool) error {
+		files, checksums, err := readFileList(rundir)
+		if err != nil {
+			return err
+		}
+
+		for i, file := range files {
+			path := filepath.Join(rundir, file)
+			if fi, err := iowrap.Os_Stat(path); err == nil && !removed {
+				size += fi.Size()
+			}
+
+			r := m.newFileReader(m.fileType, version)
+			if err := r.Open(path); err != nil {
+				return err
+			}
+
+			run := &mergeRun{r: r, rank: rank, removed: removed, checksum: checksums[i]}
+			runs = append(runs, run)
+			if ok, err := run.advance(); err != nil {
+				return err
+			} else if ok {
+				h.runs = append(h.runs, run)
+			}
+		}
+		return nil
+	}
+
+	if err = addRuns(filepath.Join(dir, "data"), 0, false); err != nil {
+		return 0, err
+	}
+	for i, gen := range gens {
+		gendir := filepath.Join(dir, deltasDirName, gen)
+		if err = addRuns(filepath.Join(gendir, "del"), 2*i+1, true); err != nil {
+			return 0, err
+		}
+		if err = addRuns(filepath.Join(gendir, "add"), 2*i+2, false); err != nil {
+			return 0, err
+		}
+	}
+	heap.Init(h)
+
+	if err = iowrap.Os_MkdirAll(outdir, 0755); err != nil {
+		return 0, err
+	}
+
+	// Items are written to files of similar size, one per shard
+	shardSize := size/int64(runtime.GOMAXPROCS(0)) + 1
+
+	var w FileWriter
+	var written int64
+	var files []string
+	var checksums []uint32
+
+	nextFile := func() error {
+		if w != nil {
+			checksums = append(checksums, w.Checksum())
+			err := w.Close(true)
+			if w = nil; err != nil {
+				return err
+			}
+		}
+
+		file := fmt.Sprintf("shard-%d", len(files))
+		fw := m.newFileWriter(m.fileType, filepath.Join(outdir, file))
+		if err := fw.Open(); err != nil {
+			return err
+		}
+		w, written = fw, 0
+		files = append(files, file)
+		return nil
+	}
+	defer func() {
+		if w != nil {
+			if err2 := w.Close(true); err == nil {
+				err = err2
+			}
+		}
+	}()
+
+	if err = nextFile(); err != nil {
+		return 0, err
+	}
+
+	var same []*mergeRun
+	for h.Len() > 0 {
+		if m.hasShutdown {
+			return 0, ErrShutdown
+		}
+
+		// Runs holding the smallest item, the last in rank decides if it
+		// is in the new base
+		same = append(same[:0], heap.Pop(h).(*mergeRun))
+		for h.Len() > 0 && m.keyCmp(h.runs[0].itm.Bytes(), same[0].itm.Bytes()) == 0 {
+			same = append(same, heap.Pop(h).(*mergeRun))
+		}
+
+		if last := same[len(same)-1]; !last.removed {
+			if written >= shardSize {
+				if err = nextFile(); err != nil {
+					return 0, err
+				}
+			}
+			if err = w.WriteItem(last.itm); err != nil {
+				return 0, err
+			}
+			written += int64(last.itm.dataLen) + 4
+			count++
+		}
+
+		for _, run := range same {
+			m.freeItem(run.itm)
+			run.itm = nil
+
+			if ok, err := run.advance(); err != nil {
+				return 0, err
+			} else if ok {
+				heap.Push(h, run)
+			}
+		}
+	}
+
+	checksums = append(checksums, w.Checksum())
+	err, w = w.Close(true), nil
+	if err != nil {
+		return 0, err
+	}
+	return count, writeFileList(outdir, files, checksums)
+}
@@ -398,6 +398,9 @@ type Config struct {
 	mallocFun     skiplist.MallocFn
 	freeFun       skiplist.FreeFn
 
+	incrDir   string
+	maxDeltas int
+
 	exposeItemCopy bool
 
 	ioConcurrency float64
@@ -441,6 +444,20 @@ func (cfg *Config) UseDeltaInterleaving() {
 	cfg.useDeltaFiles = true
 }
 
+// UseIncrementalPersistence makes StoreToDiskIncremental persist snapshots
+// as deltas of the previous one, compacted once there are more than
+// maxDeltas of them. dir holds the work files of the MemDB and is removed
+// on creation. Delta interleaving is not used with incremental persistence.
+// Items removed since the last persisted snapshot are retained in memory
+// till the next snapshot is persisted.
+func (cfg *Config) UseIncrementalPersistence(dir string, maxDeltas int) {
+	if maxDeltas < 1 {
+		maxDeltas = 1
+	}
+	cfg.incrDir = dir
+	cfg.maxDeltas = maxDeltas
+}
+
 func (cfg *Config) SetExposeItemCopy(exposeItemCopy bool) {
 	cfg.exposeItemCopy = exposeItemCopy
 }
@@ -475,6 +492,8 @@ type MemDB struct {
 	deltaFiles   []string
 	persistSnap  Snapshot
 
+	incr incrementalState
+
 	Config
 	restoreStats
 }
@@ -495,6 +514,10 @@ func NewWithConfig(cfg Config) *MemDB {
 	m.store = skiplist.NewWithConfig(m.newStoreConfig())
 	m.initSizeFuns()
 
+	if m.incremental() {
+		iowrap.Os_RemoveAll(m.incrDir)
+	}
+
 	buf := dbInstances.MakeBuf()
 	defer dbInstances.FreeBuf(buf)
 	dbInstances.Insert(unsafe.Pointer(m), CompareMemDB, buf, &dbInstances.Stats)
@@ -574,6 +597,11 @@ func (m *MemDB) Close() {
 }
 
 func (m *MemDB) Close2(concurr int) {
+	// Release the last snapshot persisted incrementally
+	if m.incremental() {
+		m.endChain()
+	}
+
 	// Wait until all snapshot iterators have finished
 	for s := m.snapshots.GetStats(); int(s.NodeCount) != 0; s = m.snapshots.GetStats() {
 		time.Sleep(time.Millisecond)
@@ -1198,7 +1226,7 @@ func (m *MemDB) PreparePersistence(dir string, snap *Snapshot) (err error) {
 	m.Unlock()
 
 	// Initialize and setup delta processing
-	if m.useDeltaFiles {
+	if m.useDeltaFiles && !m.incremental() {
 		m.deltaWriters = make([]FileWriter, m.numWriters())
 		m.deltaFiles = make([]string, m.numWriters())
 
@@ -1262,6 +1290,16 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	writers := make([]FileWriter, shards)
 	files := make([]string, shards)
 	checksums := make([]uint32, shards)
+
+	// Key the files are encrypted with, fetched before the files are
+	// opened, so that a key rotated meanwhile restarts the chain
+	var keyId string
+	if m.incremental() {
+		if keyId, err = activeKeyId(); err != nil {
+			return err
+		}
+	}
+
 	defer func() {
 		for _, w := range writers {
 			if w != nil {
@@ -1286,7 +1324,7 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	}
 
 	// Initialize and setup delta processing
-	if m.useDeltaFiles {
+	if m.useDeltaFiles && !m.incremental() {
 		defer func() {
 			for _, w := range m.deltaWriters {
 				if w != nil {
@@ -1379,7 +1417,17 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		return nil
 	}
 
-	manifest, _ := json.Marshal(map[string]interface{}{"version": version})
+	mMap := map[string]interface{}{"version": version}
+
+	// Start a chain of incrementally persisted snapshots
+	var chain int
+	if m.incremental() {
+		chain = int(time.Now().UnixNano())
+		mMap["chain"] = chain
+		mMap["generation"] = 1
+	}
+
+	manifest, _ := json.Marshal(mMap)
 	// This is the first non-deferred assignment to err so don't need err2 to preserve first error reporting
 	if err = common.WriteFileWithSync(filepath.Join(manifestdir, "nitro.json"), manifest, 0660); err == nil {
 		if err = m.Visitor(snap, visitorCallback, shards, concurr); err == nil {
@@ -1395,6 +1443,10 @@ func (m *MemDB) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		}
 	}
 
+	if err == nil && chain != 0 {
+		m.startChain(chain, 1, keyId, snap)
+	}
+
 	return err
 }
 
@@ -1404,7 +1456,8 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	var files []string
 	var checksums []uint32
 	manifestdir := dir
-	var version int
+	var version, chain, generation int
+	var gens []string
 
 	// Read file version
 	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(manifestdir, "nitro.json")); err == nil {
@@ -1413,6 +1466,15 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 			return nil, err
 		}
 		version = mMap["version"]
+		chain, generation = mMap["chain"], mMap["generation"]
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(dir, deltasDirName, "files.json")); err == nil {
+		if err = json.Unmarshal(bs, &gens); err != nil {
+			return nil, err
+		}
 	} else if !os.IsNotExist(err) {
 		return nil, err
 	}
@@ -1437,7 +1499,9 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	readers := make([]FileReader, len(files))
 	errors := make([]error, len(files))
 
-	if callb != nil {
+	// Nodes of a base can be removed by later generations, callb is
+	// called once all generations are applied
+	if callb != nil && len(gens) == 0 {
 		nodeCallb = func(n *skiplist.Node) {
 			callb(&ItemEntry{itm: (*Item)(n.Item()), n: n})
 		}
@@ -1525,104 +1589,137 @@ func (m *MemDB) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		m.DeltaRestoreFailed = 0
 		m.DeltaRestored = 0
 
-		wchan := make(chan int)
-		deltadir := filepath.Join(dir, "delta")
-		var files []string
-		if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(deltadir, "files.json")); err == nil {
-			json.Unmarshal(bs, &files)
+		if err := m.restoreDelta(filepath.Join(dir, "delta"), version, concurr, nodeCallb); err != nil {
+			return nil, err
 		}
+	}
 
-		readers := make([]FileReader, len(files))
-		errors := make([]error, len(files))
-		writers := make([]*Writer, concurr)
-		deltaChecksums := make([]uint32, len(files))
-		if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(deltadir, "checksums.json")); err == nil {
-			json.Unmarshal(bs, &deltaChecksums)
+	// Generations of an incrementally persisted snapshot
+	for _, gen := range gens {
+		if err := m.restoreGeneration(filepath.Join(dir, deltasDirName, gen), version, concurr); err != nil {
+			return nil, err
 		}
+	}
 
-		defer func() {
-			for _, r := range readers {
-				if r != nil {
-					r.Close()
-				}
-			}
-		}()
+	if len(gens) > 0 {
+		m.itemsCount = m.visitRestored(callb)
+	} else {
+		stats := m.store.GetStats()
+		m.itemsCount = int64(stats.NodeCount)
+	}
 
-		for i, file := range files {
-			r := m.newFileReader(m.fileType, version)
-			deltafile := filepath.Join(deltadir, file)
-			if err := r.Open(deltafile); err != nil {
-				return nil, err
+	snap, err := m.NewSnapshot()
+	if err == nil && m.incremental() && chain != 0 {
+		if keyId, err := baseKeyId(datadir, files); err != nil {
+			logging.Errorf("MemDB::LoadFromDisk: Failed to continue chain [%v] dir [%v], next snapshot "+
+				"will be persisted in full (err=%v)", chain, dir, err)
+		} else {
+			m.startChain(chain, generation, keyId, snap)
+		}
+	}
+	return snap, err
+}
+
+// restoreDelta inserts the items of the delta files in deltadir into the
+// store being loaded.
+func (m *MemDB) restoreDelta(deltadir string, version int, concurr int,
+	nodeCallb skiplist.NodeCallback) error {
+
+	var wg sync.WaitGroup
+	wchan := make(chan int)
+	var files []string
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(deltadir, "files.json")); err == nil {
+		json.Unmarshal(bs, &files)
+	}
+
+	readers := make([]FileReader, len(files))
+	errors := make([]error, len(files))
+	writers := make([]*Writer, concurr)
+	deltaChecksums := make([]uint32, len(files))
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(deltadir, "checksums.json")); err == nil {
+		json.Unmarshal(bs, &deltaChecksums)
+	}
+
+	defer func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
 			}
+		}
+	}()
 
-			readers[i] = r
+	for i, file := range files {
+		r := m.newFileReader(m.fileType, version)
+		deltafile := filepath.Join(deltadir, file)
+		if err := r.Open(deltafile); err != nil {
+			return err
 		}
 
-		for i := 0; i < concurr; i++ {
-			writers[i] = m.newWriter()
-			wg.Add(1)
-			go func(wg *sync.WaitGroup, id int) {
-				defer wg.Done()
-
-				for shard := range wchan {
-					r := readers[shard]
-				loop:
-					for {
-						itm, err := r.ReadItem()
-						if err != nil {
-							errors[shard] = err
-							return
-						}
+		readers[i] = r
+	}
 
-						if itm == nil {
-							break loop
-						}
+	for i := 0; i < concurr; i++ {
+		writers[i] = m.newWriter()
+		wg.Add(1)
+		go func(wg *sync.WaitGroup, id int) {
+			defer wg.Done()
+
+			for shard := range wchan {
+				r := readers[shard]
+			loop:
+				for {
+					itm, err := r.ReadItem()
+					if err != nil {
+						errors[shard] = err
+						return
+					}
+
+					if itm == nil {
+						break loop
+					}
 
-						w := writers[id]
-						if n, success := w.store.Insert2(unsafe.Pointer(itm),
-							w.insCmp, w.existCmp, w.buf, w.rand.Float32, &w.slSts1); success {
-
-							w.resSts.DeltaRestored += 1
-							if nodeCallb != nil {
-								nodeCallb(n)
-							}
-						} else {
-							w.freeItem(itm)
-							w.resSts.DeltaRestoreFailed += 1
+					w := writers[id]
+					if n, success := w.store.Insert2(unsafe.Pointer(itm),
+						w.insCmp, w.existCmp, w.buf, w.rand.Float32, &w.slSts1); success {
+
+						w.resSts.DeltaRestored += 1
+						if nodeCallb != nil {
+							nodeCallb(n)
 						}
+					} else {
+						w.freeItem(itm)
+						w.resSts.DeltaRestoreFailed += 1
 					}
 				}
+			}
 
-				// Aggregate stats
-				w := writers[id]
-				m.store.Stats.Merge(&w.slSts1)
-				atomic.AddUint64(&m.restoreStats.DeltaRestored, w.resSts.DeltaRestored)
-				atomic.AddUint64(&m.restoreStats.DeltaRestoreFailed, w.resSts.DeltaRestoreFailed)
-			}(&wg, i)
-		}
+			// Aggregate stats
+			w := writers[id]
+			m.store.Stats.Merge(&w.slSts1)
+			atomic.AddUint64(&m.restoreStats.DeltaRestored, w.resSts.DeltaRestored)
+			atomic.AddUint64(&m.restoreStats.DeltaRestoreFailed, w.resSts.DeltaRestoreFailed)
+		}(&wg, i)
+	}
 
-		for i, _ := range files {
-			wchan <- i
-		}
-		close(wchan)
-		wg.Wait()
+	for i, _ := range files {
+		wchan <- i
+	}
+	close(wchan)
+	wg.Wait()
 
-		for i, rdr := range readers {
-			if deltaChecksums[i] != 0 && deltaChecksums[i] != rdr.Checksum() {
-				return nil, ErrCorruptSnapshot
-			}
+	for i, rdr := range readers {
+		if deltaChecksums[i] != 0 && deltaChecksums[i] != rdr.Checksum() {
+			return ErrCorruptSnapshot
 		}
+	}
 
-		for _, err := range errors {
-			if err != nil {
-				return nil, err
-			}
+	for _, err := range errors {
+		if err != nil {
+			return err
 		}
 	}
 
-	stats := m.store.GetStats()
-	m.itemsCount = int64(stats.NodeCount)
-	return m.NewSnapshot()
+	return nil
 }
 
 func (m *MemDB) DumpStats() string {
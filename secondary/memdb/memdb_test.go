@@ -1,7 +1,9 @@
 package memdb
 
 import (
+	"bufio"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
@@ -12,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/couchbase/indexing/secondary/security"
 	"github.com/couchbase/indexing/secondary/stubs/nitro/mm"
 )
 
@@ -797,6 +800,322 @@ func TestLoadDeltaStoreDisk(t *testing.T) {
 	fmt.Println("RestoredFailed", db.DeltaRestoreFailed)
 }
 
+// incrementalTestDB is a MemDB persisted incrementally, whose n items are
+// updated to a new version by mutate.
+type incrementalTestDB struct {
+	t       *testing.T
+	conf    Config
+	db      *MemDB
+	writers []*Writer
+	n       int
+	version int
+}
+
+func newIncrementalTestDB(t *testing.T, maxDeltas int) *incrementalTestDB {
+	os.RemoveAll("db.incr")
+
+	conf := testConf
+	conf.UseIncrementalPersistence("db.incr", maxDeltas)
+	it := &incrementalTestDB{t: t, conf: conf, n: 10000}
+	it.n = it.n / runtime.GOMAXPROCS(0) * runtime.GOMAXPROCS(0)
+	it.open(NewWithConfig(conf))
+	return it
+}
+
+func (it *incrementalTestDB) open(db *MemDB) {
+	it.db, it.writers = db, nil
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		it.writers = append(it.writers, db.NewWriter())
+	}
+}
+
+func (it *incrementalTestDB) mutate() *Snapshot {
+	var wg sync.WaitGroup
+	it.version++
+	chunk := it.n / len(it.writers)
+	for i, w := range it.writers {
+		wg.Add(1)
+		go doUpdate(it.db, &wg, w, i*chunk, (i+1)*chunk, it.version)
+	}
+	wg.Wait()
+
+	snap, _ := it.db.NewSnapshot()
+	return snap
+}
+
+func (it *incrementalTestDB) store(dir, baseDir string, snap *Snapshot) error {
+	os.RemoveAll(dir)
+	if err := it.db.PreparePersistence(dir, snap); err != nil {
+		return err
+	}
+	return it.db.StoreToDiskIncremental(dir, baseDir, snap, 8)
+}
+
+// load returns a MemDB loaded from dir, after checking it holds the
+// current version of the items.
+func (it *incrementalTestDB) load(dir string, conf Config) *MemDB {
+	db := NewWithConfig(conf)
+	snap, err := db.LoadFromDisk(dir, 8, nil)
+	if err != nil {
+		it.t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	if count := CountItems(snap); count != it.n {
+		it.t.Errorf("Expected %v, got %v", it.n, count)
+	}
+	if count := int(snap.Count()); count != it.n {
+		it.t.Errorf("Count mismatch on snapshot. Expected %d, got %d", it.n, count)
+	}
+
+	itr := snap.NewIterator()
+	i := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		val := binary.BigEndian.Uint64(itr.Get())
+		if exp := uint64(i) + uint64(it.version)*10000000; val != exp {
+			it.t.Fatalf("expected %d, got %d", exp, val)
+		}
+		i++
+	}
+	itr.Close()
+	return db
+}
+
+func (it *incrementalTestDB) verify(dir string) {
+	it.load(dir, testConf).Close()
+}
+
+func (it *incrementalTestDB) waitCompaction() {
+	for {
+		it.db.incr.Lock()
+		compacting := it.db.incr.compacting
+		it.db.incr.Unlock()
+		if !compacting {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readGenerations(dir string) []string {
+	var gens []string
+	if bs, err := os.ReadFile(filepath.Join(dir, deltasDirName, "files.json")); err == nil {
+		json.Unmarshal(bs, &gens)
+	}
+	return gens
+}
+
+func removeDumps() {
+	dirs, _ := filepath.Glob("db.dump.*")
+	for _, dir := range dirs {
+		os.RemoveAll(dir)
+	}
+	os.RemoveAll("db.incr")
+}
+
+func TestIncrementalStoreDisk(t *testing.T) {
+	defer ValidateNoMemLeaks()
+	defer removeDumps()
+
+	it := newIncrementalTestDB(t, 2)
+
+	// Full snapshot starts the chain, followed by generations of the items
+	// replaced, some of which are collected in between
+	var dir, baseDir string
+	for x := 0; x < 6; x++ {
+		it.mutate().Close()
+		baseDir, dir = dir, fmt.Sprintf("db.dump.%d", x)
+		if err := it.store(dir, baseDir, it.mutate()); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		if x > 0 && len(readGenerations(dir)) == 0 {
+			t.Errorf("Expected generations in snapshot %v", dir)
+		}
+		it.verify(dir)
+		it.waitCompaction()
+	}
+
+	it.db.Close()
+
+	// Chain is continued after recovery
+	it.open(it.load(dir, it.conf))
+	baseDir, dir = dir, "db.dump.recovered"
+	if err := it.store(dir, baseDir, it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if len(readGenerations(dir)) == 0 {
+		t.Errorf("Expected chain to be continued after recovery")
+	}
+	it.waitCompaction()
+	it.db.Close()
+
+	it.verify(dir)
+}
+
+func TestIncrementalStoreDiskFailure(t *testing.T) {
+	defer ValidateNoMemLeaks()
+	defer removeDumps()
+
+	it := newIncrementalTestDB(t, 2)
+	if err := it.store("db.dump.0", "", it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	// A generation failing after its added items are written
+	snap := it.mutate()
+	os.RemoveAll("db.dump.1")
+	if err := it.db.PreparePersistence("db.dump.1", snap); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join("db.dump.1", deltasDirName, "gen-2"), 0755)
+	os.WriteFile(filepath.Join("db.dump.1", deltasDirName, "gen-2", "del"), nil, 0644)
+	if err := it.db.StoreToDiskIncremental("db.dump.1", "db.dump.0", snap, 8); err == nil {
+		t.Fatalf("Expected failure of the generation")
+	}
+
+	// ends the chain, and the previous snapshot is not held any longer
+	if it.db.incr.chain != 0 || it.db.incr.snap != nil {
+		t.Errorf("Expected chain to end on failure")
+	}
+	if len(it.db.GetSnapshots()) != 0 {
+		t.Errorf("Expected no open snapshots, got %v", len(it.db.GetSnapshots()))
+	}
+
+	// The next snapshot is persisted in full
+	if err := it.store("db.dump.2", "db.dump.0", it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if len(readGenerations("db.dump.2")) != 0 {
+		t.Errorf("Expected full snapshot after failure")
+	}
+	it.verify("db.dump.2")
+	it.db.Close()
+
+	// A crash in the middle of a generation leaves the previous snapshot,
+	// from which the chain is continued after recovery
+	it.open(it.load("db.dump.2", it.conf))
+	if err := it.store("db.dump.3", "db.dump.2", it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if len(readGenerations("db.dump.3")) != 1 {
+		t.Errorf("Expected chain to be continued after recovery")
+	}
+	it.db.Close()
+	it.verify("db.dump.3")
+}
+
+func TestIncrementalCompaction(t *testing.T) {
+	defer ValidateNoMemLeaks()
+	defer removeDumps()
+
+	maxDeltas := 2
+	it := newIncrementalTestDB(t, maxDeltas)
+
+	// Chain is compacted once it has more than maxDeltas generations, and
+	// the next snapshot links to the compacted base
+	var dir, baseDir string
+	for x := 0; x < 2*maxDeltas+2; x++ {
+		baseDir, dir = dir, fmt.Sprintf("db.dump.%d", x)
+		if err := it.store(dir, baseDir, it.mutate()); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		if gens := readGenerations(dir); len(gens) > maxDeltas+1 {
+			t.Errorf("Expected at most %v generations, got %v", maxDeltas+1, gens)
+		}
+		it.verify(dir)
+		it.waitCompaction()
+	}
+	if c := it.db.incr.compacted; c == nil || c.chain != it.db.incr.chain {
+		t.Errorf("Expected chain to be compacted")
+	}
+
+	// Chain is restarted once it grows faster than it is compacted
+	it.db.incr.Lock()
+	it.db.incr.compacting = true
+	it.db.incr.Unlock()
+
+	var full bool
+	for x := 0; x < 2*maxDeltas+1 && !full; x++ {
+		baseDir, dir = dir, fmt.Sprintf("db.dump.stalled.%d", x)
+		if err := it.store(dir, baseDir, it.mutate()); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		gens := readGenerations(dir)
+		if len(gens) > 2*maxDeltas {
+			t.Errorf("Expected at most %v generations, got %v", 2*maxDeltas, gens)
+		}
+		full = len(gens) == 0
+	}
+	if !full {
+		t.Errorf("Expected chain to be restarted")
+	}
+	it.verify(dir)
+
+	it.db.incr.Lock()
+	it.db.incr.compacting = false
+	it.db.incr.Unlock()
+	it.db.Close()
+}
+
+func TestIncrementalKeyRotation(t *testing.T) {
+	defer ValidateNoMemLeaks()
+	defer removeDumps()
+
+	provider := &testKeyProvider{active: "key1", keys: make(map[string]*security.AtRestKey)}
+	for _, id := range []string{"key1", "key2"} {
+		key, _ := security.NewAtRestKey(id, []byte(fmt.Sprintf("%032s", id)))
+		provider.keys[id] = key
+	}
+	security.SetAtRestKeyProvider(provider)
+	defer security.SetAtRestKeyProvider(nil)
+
+	it := newIncrementalTestDB(t, 4)
+	for x := 0; x < 2; x++ {
+		baseDir := ""
+		if x > 0 {
+			baseDir = fmt.Sprintf("db.dump.%d", x-1)
+		}
+		if err := it.store(fmt.Sprintf("db.dump.%d", x), baseDir, it.mutate()); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+	}
+
+	// A rotated key restarts the chain, no file is linked from the
+	// snapshot encrypted with the previous key
+	provider.active = "key2"
+	if err := it.store("db.dump.2", "db.dump.1", it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if len(readGenerations("db.dump.2")) != 0 {
+		t.Errorf("Expected full snapshot after key rotation")
+	}
+	filepath.Walk("db.dump.2", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) == ".json" {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if id, err := security.AtRestKeyId(f); err != nil || id != "key2" {
+			t.Errorf("Expected %v to be encrypted with key2, got %v %v", path, id, err)
+		}
+		return nil
+	})
+
+	// Chain continues with the new key
+	if err := it.store("db.dump.3", "db.dump.2", it.mutate()); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if len(readGenerations("db.dump.3")) != 1 {
+		t.Errorf("Expected chain to be continued with the new key")
+	}
+	it.waitCompaction()
+	it.db.Close()
+	it.verify("db.dump.3")
+}
+
 func TestExecuteConcurrGCWorkers(t *testing.T) {
 	db := NewWithConfig(testConf)
 	defer db.Close()
@@ -873,6 +1192,112 @@ func TestCloseWithActiveIterators(t *testing.T) {
 
 }
 
+type testKeyProvider struct {
+	active string
+	keys   map[string]*security.AtRestKey
+}
+
+func (p *testKeyProvider) ActiveKey() (*security.AtRestKey, error) {
+	return p.GetKey(p.active)
+}
+
+func (p *testKeyProvider) GetKey(id string) (*security.AtRestKey, error) {
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	return nil, security.ErrAtRestUnknownKey
+}
+
+func TestEncryptedStoreDisk(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	provider := &testKeyProvider{active: "key1", keys: make(map[string]*security.AtRestKey)}
+	for _, id := range []string{"key1", "key2"} {
+		key, _ := security.NewAtRestKey(id, []byte(fmt.Sprintf("%032s", id)))
+		provider.keys[id] = key
+	}
+	security.SetAtRestKeyProvider(provider)
+	defer security.SetAtRestKeyProvider(nil)
+
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	n := 100000
+	wg.Add(1)
+	go doInsert(db, &wg, n, true, true)
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	if err := db.PreparePersistence("db.dump", snap); err != nil {
+		t.Errorf("Error while preparing %v", err)
+	}
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Errorf("Expected no error. got=%v", err)
+	}
+	snap.Close()
+	db.Close()
+
+	files, _ := filepath.Glob(filepath.Join("db.dump", "data", "shard-*"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bufio.NewReader(f)
+		if magic, _ := r.Peek(5); string(magic[1:]) != "GSIE" {
+			t.Errorf("Expected %v to be encrypted", file)
+		}
+		f.Close()
+	}
+
+	// Data encrypted by a rotated key is readable
+	provider.active = "key2"
+	db = NewWithConfig(testConf)
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Errorf("Expected no error. got=%v", err)
+	} else {
+		if count := CountItems(snap); count != n {
+			t.Errorf("Expected %v, got %v", n, count)
+		}
+		snap.Close()
+	}
+	db.Close()
+
+	// Data is not readable without the key
+	delete(provider.keys, "key1")
+	db = NewWithConfig(testConf)
+	if snap, err = db.LoadFromDisk("db.dump", 4, nil); err == nil {
+		snap.Close()
+		t.Errorf("Expected error on load without the key")
+	}
+
+	// Files failing decryption fail verification as corrupted
+	key1, _ := security.NewAtRestKey("key1", []byte(fmt.Sprintf("%032s", "key1")))
+	provider.keys["key1"] = key1
+	if _, err := db.VerifyDiskSnapshot("db.dump", nil); err != nil {
+		t.Errorf("Expected no error on verify. got=%v", err)
+	}
+	shard0 := filepath.Join("db.dump", "data", "shard-0")
+	info, _ := os.Stat(shard0)
+	if cwr, err := os.OpenFile(shard0, os.O_WRONLY, 0755); err != nil {
+		t.Fatal(err)
+	} else {
+		cwr.WriteAt([]byte("corrupt"), info.Size()/2)
+		cwr.Close()
+	}
+	if _, err := db.VerifyDiskSnapshot("db.dump", nil); err != ErrCorruptSnapshot {
+		t.Errorf("Expected corrupted snapshot, got %v", err)
+	}
+	if err := os.Truncate(shard0, info.Size()/4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyDiskSnapshot("db.dump", nil); err != ErrCorruptSnapshot {
+		t.Errorf("Expected corrupted snapshot, got %v", err)
+	}
+	db.Close()
+}
+
 func TestDiskCorruption(t *testing.T) {
 	os.RemoveAll("db.dump")
 	var wg sync.WaitGroup
@@ -928,6 +1353,70 @@ func TestDiskCorruption(t *testing.T) {
 	fmt.Printf("Loading from disk took %v\n", time.Since(t0))
 }
 
+func TestVerifyDiskSnapshot(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+	n := 100000
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go doInsert(db, &wg, n/runtime.GOMAXPROCS(0), true, true)
+	}
+	wg.Wait()
+
+	snap, _ := db.NewSnapshot()
+	n = int(snap.Count())
+	if err := db.PreparePersistence("db.dump", snap); err != nil {
+		t.Fatalf("Error while preparing %v", err)
+	}
+	if err := db.StoreToDisk("db.dump", snap, 8, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap.Close()
+
+	var size int
+	count, err := db.VerifyDiskSnapshot("db.dump", func(itemSize int) error {
+		size += itemSize
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	if count != int64(n) || size == 0 {
+		t.Errorf("Expected %v items verified, got %v of size %v", n, count, size)
+	}
+
+	// Verification is aborted by the callback
+	errAbort := fmt.Errorf("abort")
+	if _, err := db.VerifyDiskSnapshot("db.dump", func(int) error {
+		return errAbort
+	}); err != errAbort {
+		t.Errorf("Expected %v, got %v", errAbort, err)
+	}
+
+	// Corrupted and truncated files fail verification
+	shard0 := filepath.Join("db.dump", "data", "shard-0")
+	info, _ := os.Stat(shard0)
+	if cwr, err := os.OpenFile(shard0, os.O_WRONLY, 0755); err != nil {
+		t.Fatal(err)
+	} else {
+		cwr.WriteAt([]byte("corrupt"), info.Size()/2)
+		cwr.Close()
+	}
+	if _, err := db.VerifyDiskSnapshot("db.dump", nil); err != ErrCorruptSnapshot {
+		t.Errorf("Expected corrupted snapshot, got %v", err)
+	}
+
+	if err := os.Truncate(shard0, info.Size()/4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyDiskSnapshot("db.dump", nil); err != ErrCorruptSnapshot {
+		t.Errorf("Expected corrupted snapshot, got %v", err)
+	}
+}
+
 func TestSnapshotStats(t *testing.T) {
 	db := NewWithConfig(testConf)
 	defer db.Close()
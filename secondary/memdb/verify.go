@@ -0,0 +1,150 @@
+package memdb
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/security"
+)
+
+// VerifyCallback is called with the size of every item verified. An error
+// returned by it aborts the verification.
+type VerifyCallback func(itemSize int) error
+
+// VerifyDiskSnapshot verifies the disk snapshot in dir, written by
+// StoreToDisk or StoreToDiskIncremental, without loading it. Every file of
+// the snapshot is read to verify its checksum, and the items of the files
+// written in sorted order are verified to be in order. It returns the number
+// of items verified, and ErrCorruptSnapshot if the snapshot is corrupted.
+//
+// The snapshot can be verified while the MemDB is in use, the items read
+// are not added to the store.
+func (m *MemDB) VerifyDiskSnapshot(dir string, callb VerifyCallback) (int64, error) {
+	var version int
+	var gens []string
+
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(dir, "nitro.json")); err == nil {
+		mMap := make(map[string]int)
+		if err = json.Unmarshal(bs, &mMap); err != nil {
+			return 0, ErrCorruptSnapshot
+		}
+		version = mMap["version"]
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if bs, err := iowrap.Ioutil_ReadFile(filepath.Join(dir, deltasDirName, "files.json")); err == nil {
+		if err = json.Unmarshal(bs, &gens); err != nil {
+			return 0, ErrCorruptSnapshot
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	count, err := m.verifyFiles(filepath.Join(dir, "data"), version, true, callb)
+	if err != nil {
+		return count, err
+	}
+
+	// Delta files are written as the items are collected, out of order
+	n, err := m.verifyFiles(filepath.Join(dir, "delta"), version, false, callb)
+	count += n
+	if err != nil && !os.IsNotExist(err) {
+		return count, err
+	}
+
+	for _, gen := range gens {
+		for _, sub := range []string{"del", "add"} {
+			n, err := m.verifyFiles(filepath.Join(dir, deltasDirName, gen, sub), version, true, callb)
+			count += n
+			if err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// verifyFiles verifies the files listed in dir against their checksums.
+func (m *MemDB) verifyFiles(dir string, version int, sorted bool, callb VerifyCallback) (int64, error) {
+	files, checksums, err := readFileList(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for i, file := range files {
+		n, checksum, err := m.verifyFile(filepath.Join(dir, file), version, sorted, callb)
+		count += n
+		if err != nil {
+			return count, err
+		} else if checksums[i] != 0 && checksums[i] != checksum {
+			return count, ErrCorruptSnapshot
+		}
+	}
+
+	return count, nil
+}
+
+// verifyFile reads the items of a file upto its terminal nil item, and
+// returns their number and checksum.
+func (m *MemDB) verifyFile(path string, version int, sorted bool,
+	callb VerifyCallback) (count int64, checksum uint32, err error) {
+
+	r := m.newFileReader(m.fileType, version)
+	if err = r.Open(path); err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	var prev *Item
+	defer func() {
+		if prev != nil {
+			m.freeItem(prev)
+		}
+	}()
+
+	for {
+		itm, err := r.ReadItem()
+		if err != nil {
+			if itm != nil {
+				m.freeItem(itm)
+			}
+			return count, 0, verifyError(err)
+		} else if itm == nil {
+			return count, r.Checksum(), nil
+		}
+
+		if sorted && prev != nil && m.keyCmp(prev.Bytes(), itm.Bytes()) > 0 {
+			m.freeItem(itm)
+			return count, 0, ErrCorruptSnapshot
+		}
+		if prev != nil {
+			m.freeItem(prev)
+		}
+		prev = itm
+		count++
+
+		if callb != nil {
+			if err := callb(len(itm.Bytes())); err != nil {
+				return count, 0, err
+			}
+		}
+	}
+}
+
+// verifyError returns ErrCorruptSnapshot for the errors reading a corrupted
+// file, including the file cut short, which has no terminal nil item, and the
+// file failing decryption at rest.
+func verifyError(err error) error {
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, security.ErrAtRestCorrupted,
+		security.ErrAtRestTruncated, security.ErrAtRestPlaintext:
+		return ErrCorruptSnapshot
+	}
+	return err
+}
@@ -133,6 +133,7 @@ type IndexSpec struct {
 	PartitionScheme    string             `json:"partitionScheme,omitempty"`
 	HashScheme         uint64             `json:"hashScheme,omitempty"`
 	PartitionKeys      []string           `json:"partitionKeys,omitempty"`
+	PartitionRanges    []string           `json:"partitionRanges,omitempty"`
 	Replica            uint64             `json:"replica,omitempty"`
 	Desc               []bool             `json:"desc,omitempty"`
 	Using              string             `json:"using,omitempty"`
@@ -140,6 +141,8 @@ type IndexSpec struct {
 
 	IndexMissingLeadingKey bool `json:"indexMissingLeadingKey,omitempty"`
 
+	VectorMeta *common.VectorMetadata `json:"vectorMeta,omitempty"`
+
 	// usage
 	NumDoc        uint64  `json:"numDoc,omitempty"`
 	DocKeySize    uint64  `json:"docKeySize,omitempty"`
@@ -3766,7 +3769,7 @@ func indexUsageFromSpec(sizing SizingMethod, spec *IndexSpec) ([]*IndexUsage, er
 			index.Instance.InstId = index.InstId
 			index.Instance.ReplicaId = i
 			index.Instance.Pc = common.NewKeyPartitionContainer(int(spec.NumPartition),
-				common.PartitionScheme(spec.PartitionScheme), common.HashScheme(spec.HashScheme))
+				common.PartitionScheme(spec.PartitionScheme), common.HashScheme(spec.HashScheme), spec.PartitionRanges)
 			index.Instance.State = common.INDEX_STATE_READY
 			index.Instance.Stream = common.NIL_STREAM
 			index.Instance.Error = ""
@@ -3790,6 +3793,8 @@ func indexUsageFromSpec(sizing SizingMethod, spec *IndexSpec) ([]*IndexUsage, er
 			index.Instance.Defn.NumReplica = uint32(spec.Replica) - 1
 			index.Instance.Defn.PartitionScheme = common.PartitionScheme(spec.PartitionScheme)
 			index.Instance.Defn.PartitionKeys = spec.PartitionKeys
+			index.Instance.Defn.PartitionRanges = spec.PartitionRanges
+			index.Instance.Defn.VectorMeta = spec.VectorMeta.Clone()
 			index.Instance.Defn.NumDoc = spec.NumDoc / uint64(spec.NumPartition)
 			index.Instance.Defn.DocKeySize = spec.DocKeySize
 			index.Instance.Defn.SecKeySize = spec.SecKeySize
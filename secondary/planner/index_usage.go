@@ -63,6 +63,13 @@ type IndexUsage struct {
 	ActualScanRate        uint64 `json:"actualScanRate"`
 	ActualMemMin          uint64 `json:"actualMemMin"`
 	ActualUnitsUsage      uint64 `json:"actualUnitsUsage"`
+	ActualNumRequests     uint64 `json:"actualNumRequests,omitempty"`
+
+	// Time of the last scan of the index in unix nanoseconds, 0 if never scanned
+	LastScanTime int64 `json:"lastScanTime,omitempty"`
+
+	// Time the index was last built or added to its node in unix nanoseconds, 0 if unknown
+	BuildDoneTime int64 `json:"buildDoneTime,omitempty"`
 
 	// Available from 7.6+ version of server
 	// This field captures the actual size of the index on disk (including fragmentation)
@@ -64,6 +64,7 @@ const (
 	CommandRepair                = "repair"
 	CommandDrop                  = "drop"
 	CommandRetrieve              = "retrieve"
+	CommandRedundant             = "redundant"
 )
 
 // constant - violation code
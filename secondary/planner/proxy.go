@@ -365,7 +365,7 @@ func ConvertToIndexUsage(config common.Config, defn *common.IndexDefn, localMeta
 				index.NeedsEstimate = index.NoUsageInfo
 
 				// update partition
-				pc := common.NewKeyPartitionContainer(int(inst.NumPartitions), defn.PartitionScheme, defn.HashScheme)
+				pc := common.NewKeyPartitionContainer(int(inst.NumPartitions), defn.PartitionScheme, defn.HashScheme, defn.PartitionRanges)
 
 				// Is the index being deleted by user?   This will read the delete token from metakv.  If unable read from metakv,
 				// pendingDelete is false (cannot assert index is to-be-delete).s
@@ -757,6 +757,19 @@ func SetStatsInIndexer(indexer *IndexerNode, statsMap map[string]interface{}, cl
 				}
 			}
 		}
+
+		// scan usage, used for reporting unused indexes
+		if numRequests, ok := GetIndexStat(index, "num_requests", statsMap, true, clusterVersion); ok {
+			index.ActualNumRequests = uint64(numRequests.(float64))
+		}
+
+		if lastScanTime, ok := GetIndexStat(index, "last_known_scan_time", statsMap, true, clusterVersion); ok {
+			index.LastScanTime = int64(lastScanTime.(float64))
+		}
+
+		if buildDoneTime, ok := GetIndexStat(index, "build_done_time", statsMap, true, clusterVersion); ok {
+			index.BuildDoneTime = int64(buildDoneTime.(float64))
+		}
 	}
 
 	// Compute the estimated memory usage for each index.  This also computes the aggregated indexer mem usage.
@@ -1212,7 +1225,7 @@ func processCreateToken(indexers []*IndexerNode, config common.Config) error {
 		makeIndexUsage := func(defn *common.IndexDefn, partition common.PartitionId, shardIds []common.ShardId) *IndexUsage {
 			index := makeIndexUsageFromDefn(defn, defn.InstId, partition, uint64(defn.NumPartitions), shardIds, nil)
 
-			pc := common.NewKeyPartitionContainer(int(defn.NumPartitions), defn.PartitionScheme, defn.HashScheme)
+			pc := common.NewKeyPartitionContainer(int(defn.NumPartitions), defn.PartitionScheme, defn.HashScheme, defn.PartitionRanges)
 
 			index.Instance = &common.IndexInst{
 				InstId:    defn.InstId,
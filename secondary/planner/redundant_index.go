@@ -0,0 +1,307 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package planner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//////////////////////////////////////////////////////////////
+// Redundant Index Report
+/////////////////////////////////////////////////////////////
+
+// Reasons an index is reported as redundant
+const (
+	RedundantEquivalent = "equivalent"
+	RedundantPrefix     = "prefix"
+	RedundantUnused     = "unused"
+)
+
+// RedundantIndex is an index that can be dropped, along with the index that makes it
+// redundant (if any) and the resources that would be saved across all its replicas
+// and partitions.
+type RedundantIndex struct {
+	DefnId        common.IndexDefnId `json:"defnId"`
+	Name          string             `json:"name"`
+	Bucket        string             `json:"bucket"`
+	Scope         string             `json:"scope"`
+	Collection    string             `json:"collection"`
+	Reason        string             `json:"reason"`
+	CoveredBy     string             `json:"coveredBy,omitempty"`
+	NumRequests   uint64             `json:"numRequests"`
+	LastScanTime  int64              `json:"lastScanTime"`
+	BuildDoneTime int64              `json:"buildDoneTime,omitempty"`
+	MemSavings    uint64             `json:"memSavings"`
+	DiskSavings   uint64             `json:"diskSavings"`
+}
+
+// RedundantIndexReport lists the redundant indexes of a plan and the total resources
+// that would be saved by dropping them. An index is reported once, for the first of
+// equivalent, prefix and unused that applies.
+type RedundantIndexReport struct {
+	UnusedDays       int               `json:"unusedDays"`
+	Indexes          []*RedundantIndex `json:"indexes"`
+	TotalMemSavings  uint64            `json:"totalMemSavings"`
+	TotalDiskSavings uint64            `json:"totalDiskSavings"`
+}
+
+// indexDefnUsage aggregates the usage of all the replicas and partitions of an index.
+type indexDefnUsage struct {
+	defn          *common.IndexDefn
+	name          string
+	bucket        string
+	scope         string
+	collection    string
+	numRequests   uint64
+	lastScanTime  int64
+	buildDoneTime int64
+	memUsage      uint64
+	diskUsage     uint64
+}
+
+func (d *indexDefnUsage) qualifiedName() string {
+	return fmt.Sprintf("%v:%v:%v:%v", d.bucket, d.scope, d.collection, d.name)
+}
+
+// ExecuteRedundantIndexReport reports the equivalent, prefix-overlapping and unused
+// indexes of the plan. An index is unused if it has not been scanned in unusedDays days
+// since it was last built. An index that was never scanned is not reported if the time
+// it was built is unknown. unusedDays <= 0 disables the check for unused indexes.
+func ExecuteRedundantIndexReport(plan *Plan, unusedDays int, output string) (*RedundantIndexReport, error) {
+
+	if plan == nil {
+		return nil, errors.New("Index layout is not available")
+	}
+
+	report := FindRedundantIndexes(plan, unusedDays, time.Now())
+
+	if output != "" {
+		buf, err := json.MarshalIndent(report, "", "	")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := iowrap.Ioutil_WriteFile(output, buf, 0644); err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to write redundant index report to %v. err = %s", output, err))
+		}
+	}
+
+	for _, index := range report.Indexes {
+		logging.Infof("Redundant index %v:%v:%v:%v reason %v coveredBy %v numRequests %v memSavings %v diskSavings %v",
+			index.Bucket, index.Scope, index.Collection, index.Name, index.Reason, index.CoveredBy,
+			index.NumRequests, index.MemSavings, index.DiskSavings)
+	}
+	logging.Infof("Redundant indexes %v totalMemSavings %v totalDiskSavings %v",
+		len(report.Indexes), report.TotalMemSavings, report.TotalDiskSavings)
+
+	return report, nil
+}
+
+// FindRedundantIndexes computes the redundant index report of the plan as of now.
+func FindRedundantIndexes(plan *Plan, unusedDays int, now time.Time) *RedundantIndexReport {
+
+	defns := groupIndexUsageByDefn(plan)
+	report := &RedundantIndexReport{UnusedDays: unusedDays, Indexes: make([]*RedundantIndex, 0)}
+	reported := make(map[common.IndexDefnId]bool)
+
+	add := func(d *indexDefnUsage, reason string, coveredBy *indexDefnUsage) {
+		index := &RedundantIndex{
+			DefnId:        d.defn.DefnId,
+			Name:          d.name,
+			Bucket:        d.bucket,
+			Scope:         d.scope,
+			Collection:    d.collection,
+			Reason:        reason,
+			NumRequests:   d.numRequests,
+			LastScanTime:  d.lastScanTime,
+			BuildDoneTime: d.buildDoneTime,
+			MemSavings:    d.memUsage,
+			DiskSavings:   d.diskUsage,
+		}
+		if coveredBy != nil {
+			index.CoveredBy = coveredBy.qualifiedName()
+		}
+
+		report.Indexes = append(report.Indexes, index)
+		report.TotalMemSavings += index.MemSavings
+		report.TotalDiskSavings += index.DiskSavings
+		reported[d.defn.DefnId] = true
+	}
+
+	// Among equivalent indexes, keep the most used one
+	for i, d1 := range defns {
+		if reported[d1.defn.DefnId] {
+			continue
+		}
+
+		keep := d1
+		var equivalent []*indexDefnUsage
+		for _, d2 := range defns[i+1:] {
+			if !reported[d2.defn.DefnId] && common.IsEquivalentIndex(d1.defn, d2.defn) {
+				equivalent = append(equivalent, d2)
+				if d2.numRequests > keep.numRequests {
+					keep = d2
+				}
+			}
+		}
+
+		for _, d := range append(equivalent, d1) {
+			if d != keep {
+				add(d, RedundantEquivalent, keep)
+			}
+		}
+	}
+
+	// An index is covered by another index with the same leading keys and a compatible WHERE
+	for _, d1 := range defns {
+		if reported[d1.defn.DefnId] {
+			continue
+		}
+
+		for _, d2 := range defns {
+			if d1 != d2 && !reported[d2.defn.DefnId] && isPrefixIndex(d1.defn, d2.defn) {
+				add(d1, RedundantPrefix, d2)
+				break
+			}
+		}
+	}
+
+	if unusedDays > 0 {
+		cutoff := now.Add(-time.Duration(unusedDays) * 24 * time.Hour).UnixNano()
+		for _, d := range defns {
+			lastUsed := d.lastScanTime
+			if d.buildDoneTime > lastUsed {
+				lastUsed = d.buildDoneTime
+			}
+			if !reported[d.defn.DefnId] && lastUsed != 0 && lastUsed < cutoff {
+				add(d, RedundantUnused, nil)
+			}
+		}
+	}
+
+	return report
+}
+
+// groupIndexUsageByDefn aggregates the index usages of the plan by index definition,
+// ordered by definition id.
+func groupIndexUsageByDefn(plan *Plan) []*indexDefnUsage {
+
+	defnMap := make(map[common.IndexDefnId]*indexDefnUsage)
+
+	addUsage := func(index *IndexUsage) {
+		if index.Instance == nil || index.PendingDelete {
+			return
+		}
+
+		d, ok := defnMap[index.DefnId]
+		if !ok {
+			d = &indexDefnUsage{
+				defn:       &index.Instance.Defn,
+				name:       index.Instance.Defn.Name,
+				bucket:     index.Bucket,
+				scope:      index.Scope,
+				collection: index.Collection,
+			}
+			if d.scope == "" {
+				d.scope = common.DEFAULT_SCOPE
+			}
+			if d.collection == "" {
+				d.collection = common.DEFAULT_COLLECTION
+			}
+			defnMap[index.DefnId] = d
+		}
+
+		d.numRequests += index.ActualNumRequests
+		if index.LastScanTime > d.lastScanTime {
+			d.lastScanTime = index.LastScanTime
+		}
+		if index.BuildDoneTime > d.buildDoneTime {
+			d.buildDoneTime = index.BuildDoneTime
+		}
+
+		// Fall back to the estimated sizing for a plan without stats
+		if index.ActualMemUsage != 0 {
+			d.memUsage += index.ActualMemUsage + index.ActualMemOverhead
+		} else {
+			d.memUsage += index.MemUsage + index.MemOverhead
+		}
+		if index.ActualDiskSize != 0 {
+			d.diskUsage += index.ActualDiskSize
+		} else {
+			d.diskUsage += index.DiskUsage
+		}
+	}
+
+	for _, indexer := range plan.Placement {
+		for _, index := range indexer.Indexes {
+			if index.IsShardProxy {
+				for _, grouped := range index.GroupedIndexes {
+					addUsage(grouped)
+				}
+			} else {
+				addUsage(index)
+			}
+		}
+	}
+
+	defns := make([]*indexDefnUsage, 0, len(defnMap))
+	for _, d := range defnMap {
+		defns = append(defns, d)
+	}
+	sort.Slice(defns, func(i, j int) bool {
+		return defns[i].defn.DefnId < defns[j].defn.DefnId
+	})
+
+	return defns
+}
+
+// isPrefixIndex returns true if the keys of d1 are a proper prefix of the keys of d2 and
+// d2 indexes every document that d1 does, so that d2 can serve any scan of d1.
+func isPrefixIndex(d1, d2 *common.IndexDefn) bool {
+
+	if d1.Bucket != d2.Bucket ||
+		d1.Scope != d2.Scope ||
+		d1.Collection != d2.Collection ||
+		d1.IsPrimary || d2.IsPrimary ||
+		d1.ExprType != d2.ExprType ||
+		d1.RetainDeletedXATTR != d2.RetainDeletedXATTR ||
+		d1.IndexMissingLeadingKey != d2.IndexMissingLeadingKey ||
+		d1.VectorMeta != nil || d2.VectorMeta != nil {
+
+		return false
+	}
+
+	if d2.WhereExpr != "" && d2.WhereExpr != d1.WhereExpr {
+		return false
+	}
+
+	if len(d1.SecExprs) == 0 || len(d1.SecExprs) >= len(d2.SecExprs) {
+		return false
+	}
+
+	for i, s1 := range d1.SecExprs {
+		if s1 != d2.SecExprs[i] || isDesc(d1, i) != isDesc(d2, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isDesc(defn *common.IndexDefn, pos int) bool {
+	return pos < len(defn.Desc) && defn.Desc[pos]
+}
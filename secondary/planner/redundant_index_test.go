@@ -0,0 +1,95 @@
+package planner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestFindRedundantIndexes(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Hour).UnixNano()
+	old := now.Add(-60 * 24 * time.Hour).UnixNano()
+
+	newUsage := func(defnId common.IndexDefnId, name string, secExprs []string, where string,
+		numRequests uint64, lastScanTime int64) *IndexUsage {
+
+		index := newIndexUsage(defnId, common.IndexInstId(defnId), 0, name, "b", "s", "c")
+		index.Instance = &common.IndexInst{
+			InstId: common.IndexInstId(defnId),
+			Defn: common.IndexDefn{
+				DefnId:     defnId,
+				Name:       name,
+				Bucket:     "b",
+				Scope:      "s",
+				Collection: "c",
+				SecExprs:   secExprs,
+				WhereExpr:  where,
+			},
+		}
+		index.ActualNumRequests = numRequests
+		index.LastScanTime = lastScanTime
+		index.ActualMemUsage = 100
+		index.ActualDiskSize = 1000
+		return index
+	}
+
+	indexer := &IndexerNode{}
+	indexer.Indexes = []*IndexUsage{
+		newUsage(1, "idx_a", []string{"a"}, "", 10, recent),
+		newUsage(2, "idx_a_dup", []string{"a"}, "", 20, recent),
+		newUsage(3, "idx_ab", []string{"a", "b"}, "", 30, recent),
+		newUsage(4, "idx_c_where", []string{"c"}, "(x = 1)", 5, recent),
+		newUsage(5, "idx_cd", []string{"c", "d"}, "(x = 2)", 5, recent),
+		newUsage(6, "idx_e", []string{"e"}, "", 0, old),
+		newUsage(7, "idx_f", []string{"f"}, "", 0, 0),
+		newUsage(9, "idx_h", []string{"h"}, "", 0, 0),
+		newUsage(10, "idx_i", []string{"i"}, "", 0, 0),
+		newUsage(11, "idx_j", []string{"j"}, "", 1, old),
+	}
+
+	// Indexes that are not scanned are unused only once they have been built for unusedDays
+	indexer.Indexes[6].BuildDoneTime = old
+	indexer.Indexes[7].BuildDoneTime = recent
+	indexer.Indexes[9].BuildDoneTime = recent
+	report := FindRedundantIndexes(&Plan{Placement: []*IndexerNode{indexer}}, 30, now)
+
+	expected := map[common.IndexDefnId]string{
+		1: RedundantEquivalent,
+		2: RedundantPrefix,
+		6: RedundantUnused,
+		7: RedundantUnused,
+	}
+	if len(report.Indexes) != len(expected) {
+		t.Fatalf("Expected %v redundant indexes, got %+v", len(expected), report.Indexes)
+	}
+	for _, index := range report.Indexes {
+		if reason, ok := expected[index.DefnId]; !ok || reason != index.Reason {
+			t.Errorf("Unexpected redundant index %+v", index)
+		}
+	}
+	if report.Indexes[0].CoveredBy != "b:s:c:idx_a_dup" || report.Indexes[1].CoveredBy != "b:s:c:idx_ab" {
+		t.Errorf("Unexpected covering indexes %+v %+v", report.Indexes[0], report.Indexes[1])
+	}
+	if report.TotalMemSavings != 400 || report.TotalDiskSavings != 4000 {
+		t.Errorf("Unexpected savings %v %v", report.TotalMemSavings, report.TotalDiskSavings)
+	}
+
+	report = FindRedundantIndexes(&Plan{Placement: []*IndexerNode{indexer}}, 0, now)
+	for _, index := range report.Indexes {
+		if index.Reason == RedundantUnused {
+			t.Errorf("Unexpected unused index %+v with unusedDays 0", index)
+		}
+	}
+
+	// Savings of an index include all its replicas
+	indexer.Indexes = []*IndexUsage{
+		newUsage(8, "idx_g", []string{"g"}, "", 1, old),
+		newUsage(8, "idx_g", []string{"g"}, "", 2, old),
+	}
+	report = FindRedundantIndexes(&Plan{Placement: []*IndexerNode{indexer}}, 30, now)
+	if len(report.Indexes) != 1 || report.Indexes[0].NumRequests != 3 || report.Indexes[0].MemSavings != 200 {
+		t.Fatalf("Unexpected report %+v", report.Indexes)
+	}
+}
@@ -0,0 +1,778 @@
+package projector
+
+// Record and replay of upstream DCP.
+//
+// When a keyspace is listed in `dcp.record.keyspaces`, every DcpEvent
+// received from KV for that keyspace, including stream begin/end, snapshot
+// markers, system events, seqno-advanced and OSO markers, is appended to a
+// recording under `dcp.record.dir`. A recording is a sequence of log files
+// (segments) named
+//
+//     <keyspaceId>_<topic>_<unix-nano>_<segment>.dcplog
+//
+// A new segment is started once a segment reaches `dcp.record.segment_size`
+// bytes. Recording stops once `dcp.record.max_segments` segments are
+// recorded. Older segments are not removed, as replay needs the recording
+// from its first stream-begin.
+//
+// When `dcp.replay.dir` is set, a keyspace having a recording for the topic
+// of the feed in that directory is fed from the latest such recording
+// instead of a live KV node. Events are replayed in the order they were
+// recorded. Replay of a vbucket waits for the vbucket to be requested,
+// events at or below the requested start seqno are skipped, events of
+// vbuckets that are ended by the downstream are skipped till the next
+// recorded stream-begin, and vbuckets absent from the recording are
+// started as idle streams.
+//
+// A log is the magic string followed by records, each record is an
+// uvarint length followed by the uvarint encoded fields of the event.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+const dcpLogMagic = "DCPLOG01"
+const dcpLogSuffix = ".dcplog"
+const dcpLogFlushInterval = time.Second
+
+var errDcpLogFormat = errors.New("malformed dcp log")
+
+// isRecordedKeyspace returns true if DCP of keyspaceId is to be recorded.
+func isRecordedKeyspace(config c.Config, keyspaceId string) bool {
+	keyspaces := config["dcp.record.keyspaces"].String()
+	for _, k := range strings.Split(keyspaces, ",") {
+		if k = strings.TrimSpace(k); k == "*" || k == keyspaceId {
+			return true
+		}
+	}
+	return false
+}
+
+//---- recorder
+
+// dcpRecorder is a BucketFeeder that records the events of the feeder it
+// wraps, as they are received by the projector.
+type dcpRecorder struct {
+	BucketFeeder
+	logPrefix   string
+	dir         string
+	prefix      string // <keyspaceId>_<topic>
+	start       int64
+	segment     int
+	segmentSize int64
+	maxSegments int
+
+	path string
+	fd   *os.File
+	w    *bufio.Writer
+	size int64 // bytes written to the current segment
+
+	mutch     chan *mc.DcpEvent
+	finch     chan bool
+	closeOnce sync.Once
+}
+
+func newDcpRecorder(
+	feeder BucketFeeder,
+	dir, keyspaceId, topic, logPrefix string, chsize int,
+	segmentSize int64, maxSegments int) (*dcpRecorder, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &dcpRecorder{
+		BucketFeeder: feeder,
+		logPrefix:    logPrefix,
+		dir:          dir,
+		prefix:       keyspaceId + "_" + topic,
+		start:        time.Now().UnixNano(),
+		segmentSize:  segmentSize,
+		maxSegments:  maxSegments,
+		mutch:        make(chan *mc.DcpEvent, chsize),
+		finch:        make(chan bool),
+	}
+	if err := r.openSegment(); err != nil {
+		return nil, err
+	}
+	go r.run(feeder.GetChannel())
+
+	return r, nil
+}
+
+// GetChannel implements Feeder{} interface.
+func (r *dcpRecorder) GetChannel() <-chan *mc.DcpEvent {
+	return r.mutch
+}
+
+// CloseFeed implements Feeder{} interface.
+func (r *dcpRecorder) CloseFeed() error {
+	err := r.BucketFeeder.CloseFeed()
+	r.closeOnce.Do(func() { close(r.finch) })
+	return err
+}
+
+func (r *dcpRecorder) run(inch <-chan *mc.DcpEvent) {
+	defer close(r.mutch)
+	defer r.closeLog()
+
+	tick := time.NewTicker(dcpLogFlushInterval)
+	defer tick.Stop()
+
+	var buf []byte
+	for {
+		select {
+		case m, ok := <-inch:
+			if !ok {
+				return
+			}
+			if r.w != nil {
+				buf = encodeDcpEvent(buf[:0], m)
+				if err := r.record(buf); err != nil {
+					fmsg := "%v recording to %v stopped: %v"
+					logging.Errorf(fmsg, r.logPrefix, r.path, err)
+					r.closeLog()
+				}
+			}
+			select {
+			case r.mutch <- m:
+			case <-r.finch:
+				return
+			}
+
+		case <-tick.C:
+			if r.w != nil {
+				if err := r.w.Flush(); err != nil {
+					fmsg := "%v recording to %v stopped: %v"
+					logging.Errorf(fmsg, r.logPrefix, r.path, err)
+					r.closeLog()
+				}
+			}
+
+		case <-r.finch:
+			return
+		}
+	}
+}
+
+// record appends a record to the current segment, starting a new segment
+// if the current segment is full.
+func (r *dcpRecorder) record(rec []byte) error {
+	if r.segmentSize > 0 && r.size > int64(len(dcpLogMagic)) &&
+		r.size+dcpLogRecordSize(rec) > r.segmentSize {
+
+		r.closeLog()
+		if r.maxSegments > 0 && r.segment+1 >= r.maxSegments {
+			fmsg := "%v recording stopped after %v segments of %v bytes"
+			logging.Warnf(fmsg, r.logPrefix, r.segment+1, r.segmentSize)
+			return nil
+		}
+		r.segment++
+		if err := r.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	n, err := writeDcpLogRecord(r.w, rec)
+	r.size += int64(n)
+	return err
+}
+
+func (r *dcpRecorder) openSegment() error {
+	path := filepath.Join(r.dir, dcpLogName(r.prefix, r.start, r.segment))
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(fd, 64*1024)
+	if _, err := w.WriteString(dcpLogMagic); err != nil {
+		fd.Close()
+		return err
+	}
+
+	r.path, r.fd, r.w, r.size = path, fd, w, int64(len(dcpLogMagic))
+	logging.Infof("%v recording dcp to %v", r.logPrefix, path)
+	return nil
+}
+
+func (r *dcpRecorder) closeLog() {
+	if r.w == nil {
+		return
+	}
+	if err := r.w.Flush(); err != nil {
+		logging.Errorf("%v flush %v: %v", r.logPrefix, r.path, err)
+	}
+	if err := r.fd.Close(); err != nil {
+		logging.Errorf("%v close %v: %v", r.logPrefix, r.path, err)
+	}
+	r.w, r.fd = nil, nil
+	logging.Infof("%v recording to %v closed", r.logPrefix, r.path)
+}
+
+//---- replayer
+
+// dcpReplayer is a BucketFeeder that replays a recording in place of a
+// live DCP feed.
+type dcpReplayer struct {
+	logPrefix string
+	paths     []string
+	// number of recorded stream-begins per vbucket
+	begins map[uint16]int
+
+	mutch     chan *mc.DcpEvent
+	reqch     chan *replayRequest
+	finch     chan bool
+	closeOnce sync.Once
+}
+
+type replayRequest struct {
+	start  bool
+	opaque uint16
+	ts     *protobuf.TsVbuuid
+}
+
+// replayStream is the state of a vbucket being replayed.
+type replayStream struct {
+	opaque    uint16
+	start     uint64 // events at or below the start seqno are skipped
+	requested bool   // requested by downstream, waiting for stream-begin
+	active    bool
+}
+
+// skip returns true if the event is at or below the start seqno of the
+// stream.
+func (s *replayStream) skip(m *mc.DcpEvent) bool {
+	switch m.Opcode {
+	case mcd.DCP_SNAPSHOT:
+		return m.SnapendSeq <= s.start
+	case mcd.DCP_MUTATION, mcd.DCP_DELETION, mcd.DCP_EXPIRATION,
+		mcd.DCP_SYSTEM_EVENT, mcd.DCP_SEQNO_ADVANCED:
+		return m.Seqno <= s.start
+	}
+	return false
+}
+
+// openDcpReplayer returns a replayer for the latest recording of
+// keyspaceId and topic in dir, returns an error satisfying os.IsNotExist()
+// if there is no recording.
+func openDcpReplayer(
+	dir, keyspaceId, topic, logPrefix string, chsize int) (*dcpReplayer, error) {
+
+	paths, err := findDcpLog(dir, keyspaceId+"_"+topic)
+	if err != nil {
+		return nil, err
+	}
+
+	begins := make(map[uint16]int)
+	err = readDcpLog(paths, nil, func(m *mc.DcpEvent) bool {
+		if m.Opcode == mcd.DCP_STREAMREQ {
+			begins[m.VBucket]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dcpReplayer{
+		logPrefix: logPrefix,
+		paths:     paths,
+		begins:    begins,
+		mutch:     make(chan *mc.DcpEvent, chsize),
+		reqch:     make(chan *replayRequest),
+		finch:     make(chan bool),
+	}
+
+	logch := make(chan *mc.DcpEvent, chsize)
+	go func() {
+		defer close(logch)
+		err := readDcpLog(paths, r.finch, func(m *mc.DcpEvent) bool {
+			select {
+			case logch <- m:
+				return true
+			case <-r.finch:
+				return false
+			}
+		})
+		if err != nil {
+			logging.Errorf("%v replay of %v stopped: %v", logPrefix, paths, err)
+		}
+	}()
+	go r.run(logch)
+
+	logging.Infof("%v replaying dcp from %v", logPrefix, paths)
+	return r, nil
+}
+
+// GetChannel implements Feeder{} interface.
+func (r *dcpReplayer) GetChannel() <-chan *mc.DcpEvent {
+	return r.mutch
+}
+
+// StartVbStreams implements Feeder{} interface.
+func (r *dcpReplayer) StartVbStreams(opaque uint16, ts *protobuf.TsVbuuid) error {
+	return r.request(&replayRequest{start: true, opaque: opaque, ts: ts})
+}
+
+// EndVbStreams implements Feeder{} interface.
+func (r *dcpReplayer) EndVbStreams(opaque uint16, ts *protobuf.TsVbuuid) (error, bool) {
+	return r.request(&replayRequest{start: false, opaque: opaque, ts: ts}), false
+}
+
+// CloseFeed implements Feeder{} interface.
+func (r *dcpReplayer) CloseFeed() error {
+	r.closeOnce.Do(func() { close(r.finch) })
+	return nil
+}
+
+// GetStats implements Feeder{} interface.
+func (r *dcpReplayer) GetStats() map[string]interface{} {
+	return nil
+}
+
+func (r *dcpReplayer) request(req *replayRequest) error {
+	select {
+	case r.reqch <- req:
+		return nil
+	case <-r.finch:
+		return c.ErrorClosed
+	}
+}
+
+func (r *dcpReplayer) run(logch <-chan *mc.DcpEvent) {
+	defer close(r.mutch)
+
+	streams := make(map[uint16]*replayStream)
+	stream := func(vbno uint16) *replayStream {
+		s, ok := streams[vbno]
+		if !ok {
+			s = &replayStream{}
+			streams[vbno] = s
+		}
+		return s
+	}
+
+	// events ready to be sent downstream, in order.
+	var outq []*mc.DcpEvent
+	// recorded event held back, till its vbucket is requested.
+	var next *mc.DcpEvent
+
+	replay := func(m *mc.DcpEvent) {
+		s := stream(m.VBucket)
+		switch m.Opcode {
+		case mcd.DCP_STREAMREQ:
+			if !s.requested {
+				next = m
+				return
+			}
+			r.begins[m.VBucket]--
+			s.requested, s.active = false, m.Status == mcd.SUCCESS
+
+		case mcd.DCP_STREAMEND:
+			if !s.active {
+				return
+			}
+			s.active = false
+
+		default:
+			if !s.active || s.skip(m) {
+				return
+			}
+		}
+		m.Opaque, m.Ctime = s.opaque, time.Now().UnixNano()
+		outq = append(outq, m)
+	}
+
+	handle := func(req *replayRequest) {
+		vbnos := c.Vbno32to16(req.ts.GetVbnos())
+		vbuuids, seqnos := req.ts.GetVbuuids(), req.ts.GetSeqnos()
+		for i, vbno := range vbnos {
+			s := stream(vbno)
+			if !req.start {
+				if s.active || s.requested {
+					outq = append(outq, &mc.DcpEvent{
+						Opcode:  mcd.DCP_STREAMEND,
+						Status:  mcd.SUCCESS,
+						VBucket: vbno,
+						Opaque:  s.opaque,
+						Ctime:   time.Now().UnixNano(),
+					})
+				}
+				s.requested, s.active = false, false
+				continue
+			}
+
+			s.opaque, s.start = req.opaque, seqnos[i]
+			if r.begins[vbno] > 0 {
+				s.requested = true
+				continue
+			}
+			// nothing more is recorded for this vbucket, start an idle stream.
+			flog := mc.FailoverLog{{vbuuids[i], seqnos[i]}}
+			outq = append(outq, &mc.DcpEvent{
+				Opcode:      mcd.DCP_STREAMREQ,
+				Status:      mcd.SUCCESS,
+				VBucket:     vbno,
+				Opaque:      s.opaque,
+				FailoverLog: &flog,
+				Ctime:       time.Now().UnixNano(),
+			})
+			s.active = true
+		}
+		if m := next; m != nil && stream(m.VBucket).requested {
+			next = nil
+			replay(m)
+		}
+	}
+
+	for {
+		var outch chan<- *mc.DcpEvent
+		var head *mc.DcpEvent
+		if len(outq) > 0 {
+			outch, head = r.mutch, outq[0]
+		}
+		var inch <-chan *mc.DcpEvent
+		if len(outq) == 0 && next == nil {
+			inch = logch
+		}
+
+		select {
+		case req := <-r.reqch:
+			handle(req)
+
+		case m, ok := <-inch:
+			if !ok {
+				logging.Infof("%v replay of %v done", r.logPrefix, r.paths)
+				logch = nil
+				continue
+			}
+			replay(m)
+
+		case outch <- head:
+			outq[0] = nil
+			outq = outq[1:]
+
+		case <-r.finch:
+			return
+		}
+	}
+}
+
+//---- log format
+
+// dcpLogName returns the name of a segment of a recording.
+func dcpLogName(prefix string, start int64, segment int) string {
+	return fmt.Sprintf("%v_%v_%06d%v", prefix, start, segment, dcpLogSuffix)
+}
+
+// parseDcpLogName returns the recording prefix, start time and segment of
+// a log name, or false if name is not a segment of a recording.
+func parseDcpLogName(name string) (prefix string, start int64, segment int, ok bool) {
+	if !strings.HasSuffix(name, dcpLogSuffix) {
+		return "", 0, 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, dcpLogSuffix), "_")
+	if len(parts) < 3 {
+		return "", 0, 0, false
+	}
+	n := len(parts)
+	start, err := strconv.ParseInt(parts[n-2], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	segment, err = strconv.Atoi(parts[n-1])
+	if err != nil {
+		return "", 0, 0, false
+	}
+	return strings.Join(parts[:n-2], "_"), start, segment, true
+}
+
+// findDcpLog returns the segments of the latest recording with prefix in
+// dir, in order. It returns an error satisfying os.IsNotExist() if there
+// is no recording.
+func findDcpLog(dir, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest int64
+	segments := make(map[int]string)
+	for _, e := range entries {
+		p, start, segment, ok := parseDcpLogName(e.Name())
+		if !ok || p != prefix || start < latest {
+			continue
+		}
+		if start > latest {
+			latest, segments = start, make(map[int]string)
+		}
+		segments[segment] = filepath.Join(dir, e.Name())
+	}
+
+	var paths []string
+	for i := 0; segments[i] != ""; i++ {
+		paths = append(paths, segments[i])
+	}
+	if len(paths) == 0 {
+		pattern := filepath.Join(dir, prefix+"_*"+dcpLogSuffix)
+		return nil, &os.PathError{Op: "open", Path: pattern, Err: os.ErrNotExist}
+	}
+	return paths, nil
+}
+
+// dcpLogSegments returns path, followed by the subsequent segments of its
+// recording if path is a segment of a recording.
+func dcpLogSegments(path string) []string {
+	dir, name := filepath.Split(path)
+	prefix, start, segment, ok := parseDcpLogName(name)
+	if !ok {
+		return []string{path}
+	}
+
+	paths := []string{path}
+	for {
+		segment++
+		next := filepath.Join(dir, dcpLogName(prefix, start, segment))
+		if _, err := os.Stat(next); err != nil {
+			return paths
+		}
+		paths = append(paths, next)
+	}
+}
+
+// ReadDcpLog calls callb for each event in the log at path, in the order
+// they were recorded, till callb returns false. If path is a segment of a
+// recording, the subsequent segments of the recording are read as well.
+func ReadDcpLog(path string, callb func(*mc.DcpEvent) bool) error {
+	return readDcpLog(dcpLogSegments(path), nil, callb)
+}
+
+// readDcpLog calls callb for each event in the logs at paths, till callb
+// returns false or finch is closed.
+func readDcpLog(paths []string, finch chan bool, callb func(*mc.DcpEvent) bool) error {
+	for _, path := range paths {
+		if ok, err := readDcpLogFile(path, finch, callb); err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDcpLogFile calls callb for each event in the log at path, and
+// returns false if callb returns false or finch is closed.
+func readDcpLogFile(path string, finch chan bool, callb func(*mc.DcpEvent) bool) (bool, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+
+	rd := bufio.NewReaderSize(fd, 64*1024)
+	magic := make([]byte, len(dcpLogMagic))
+	if _, err := io.ReadFull(rd, magic); err != nil || string(magic) != dcpLogMagic {
+		return false, errDcpLogFormat
+	}
+
+	for {
+		select {
+		case <-finch:
+			return false, nil
+		default:
+		}
+
+		n, err := binary.ReadUvarint(rd)
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return false, err
+		}
+		m, err := decodeDcpEvent(buf)
+		if err != nil {
+			return false, err
+		}
+		if !callb(m) {
+			return false, nil
+		}
+	}
+}
+
+// dcpLogRecordSize returns the size of a record in the log.
+func dcpLogRecordSize(rec []byte) int64 {
+	var hdr [binary.MaxVarintLen64]byte
+	return int64(binary.PutUvarint(hdr[:], uint64(len(rec))) + len(rec))
+}
+
+// writeDcpLogRecord writes a record, and returns the number of bytes
+// written.
+func writeDcpLogRecord(w *bufio.Writer, rec []byte) (int, error) {
+	var hdr [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(len(rec)))
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return 0, err
+	}
+	m, err := w.Write(rec)
+	return n + m, err
+}
+
+func encodeDcpEvent(buf []byte, m *mc.DcpEvent) []byte {
+	for _, v := range []uint64{
+		uint64(m.Opcode), uint64(m.Status), uint64(m.Datatype),
+		uint64(m.VBucket), uint64(m.Opaque), m.VBuuid, m.Cas,
+		m.Seqno, m.RevSeqno, uint64(m.Flags), uint64(m.Expiry),
+		uint64(m.LockTime), uint64(m.Nru),
+		m.SnapstartSeq, m.SnapendSeq, uint64(m.SnapshotType),
+		uint64(m.CollectionID), uint64(m.EventType), uint64(m.MaxTTL),
+	} {
+		buf = binary.AppendUvarint(buf, v)
+	}
+	buf = binary.AppendVarint(buf, m.Ctime)
+
+	for _, b := range [][]byte{m.Key, m.Value, m.OldValue, m.ManifestUID, m.ScopeID} {
+		buf = appendDcpLogBytes(buf, b)
+	}
+
+	var errstr string
+	if m.Error != nil {
+		errstr = m.Error.Error()
+	}
+	buf = appendDcpLogBytes(buf, []byte(errstr))
+
+	var flog mc.FailoverLog
+	if m.FailoverLog != nil {
+		flog = *m.FailoverLog
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(flog)))
+	for _, entry := range flog {
+		buf = binary.AppendUvarint(buf, entry[0])
+		buf = binary.AppendUvarint(buf, entry[1])
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(m.RawXATTR)))
+	for name, val := range m.RawXATTR {
+		buf = appendDcpLogBytes(buf, []byte(name))
+		buf = appendDcpLogBytes(buf, val)
+	}
+	return buf
+}
+
+func appendDcpLogBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func decodeDcpEvent(buf []byte) (*mc.DcpEvent, error) {
+	d := &dcpLogDecoder{buf: buf}
+	m := &mc.DcpEvent{
+		Opcode:       mcd.CommandCode(d.uint()),
+		Status:       mcd.Status(d.uint()),
+		Datatype:     uint8(d.uint()),
+		VBucket:      uint16(d.uint()),
+		Opaque:       uint16(d.uint()),
+		VBuuid:       d.uint(),
+		Cas:          d.uint(),
+		Seqno:        d.uint(),
+		RevSeqno:     d.uint(),
+		Flags:        uint32(d.uint()),
+		Expiry:       uint32(d.uint()),
+		LockTime:     uint32(d.uint()),
+		Nru:          byte(d.uint()),
+		SnapstartSeq: d.uint(),
+		SnapendSeq:   d.uint(),
+		SnapshotType: uint32(d.uint()),
+		CollectionID: uint32(d.uint()),
+		EventType:    mcd.CollectionEvent(d.uint()),
+		MaxTTL:       uint32(d.uint()),
+	}
+	m.Ctime = d.int()
+	m.Key, m.Value, m.OldValue = d.bytes(), d.bytes(), d.bytes()
+	m.ManifestUID, m.ScopeID = d.bytes(), d.bytes()
+	if errstr := d.bytes(); len(errstr) > 0 {
+		m.Error = errors.New(string(errstr))
+	}
+
+	if n := d.uint(); n > 0 && d.err == nil {
+		flog := make(mc.FailoverLog, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			flog = append(flog, [2]uint64{d.uint(), d.uint()})
+		}
+		m.FailoverLog = &flog
+	}
+
+	if n := d.uint(); n > 0 && d.err == nil {
+		m.RawXATTR = make(map[string][]byte)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			name := d.bytes()
+			m.RawXATTR[string(name)] = d.bytes()
+		}
+	}
+
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errDcpLogFormat
+	}
+	return m, d.err
+}
+
+type dcpLogDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *dcpLogDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errDcpLogFormat
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *dcpLogDecoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errDcpLogFormat
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *dcpLogDecoder) bytes() []byte {
+	n := d.uint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.err = errDcpLogFormat
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
@@ -0,0 +1,172 @@
+package projector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+)
+
+// testFeeder is a BucketFeeder sending the events pushed to its channel.
+type testFeeder struct {
+	mutch chan *mc.DcpEvent
+}
+
+func (f *testFeeder) GetChannel() <-chan *mc.DcpEvent {
+	return f.mutch
+}
+
+func (f *testFeeder) StartVbStreams(opaque uint16, ts *protobuf.TsVbuuid) error {
+	return nil
+}
+
+func (f *testFeeder) EndVbStreams(opaque uint16, ts *protobuf.TsVbuuid) (error, bool) {
+	return nil, false
+}
+
+func (f *testFeeder) CloseFeed() error {
+	return nil
+}
+
+func (f *testFeeder) GetStats() map[string]interface{} {
+	return nil
+}
+
+func TestDcpEventEncoding(t *testing.T) {
+	flog := mc.FailoverLog{{1234, 0}, {5678, 10}}
+	m := &mc.DcpEvent{
+		Opcode: mcd.DCP_MUTATION, Status: mcd.SUCCESS, Datatype: 3,
+		VBucket: 1023, Opaque: 7, VBuuid: 5678, Cas: 99,
+		Key: []byte("key"), Value: []byte(`{"a":1}`), OldValue: []byte("old"),
+		Seqno: 12, RevSeqno: 2, Flags: 4, Expiry: 5, LockTime: 6, Nru: 1,
+		SnapstartSeq: 10, SnapendSeq: 20, SnapshotType: 1,
+		ManifestUID: []byte("a"), ScopeID: []byte("8"), CollectionID: 9,
+		EventType: mcd.COLLECTION_CREATE, MaxTTL: 60,
+		FailoverLog: &flog,
+		Error:       errors.New("error"),
+		Ctime:       -1,
+		RawXATTR:    map[string][]byte{"_sync": []byte("x")},
+	}
+
+	out, err := decodeDcpEvent(encodeDcpEvent(nil, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Error == nil || out.Error.Error() != m.Error.Error() {
+		t.Errorf("Expected error %v, received %v", m.Error, out.Error)
+	}
+	out.Error, m.Error = nil, nil
+	if !reflect.DeepEqual(out, m) {
+		t.Errorf("Expected %+v, received %+v", m, out)
+	}
+
+	// Truncated and trailing bytes are malformed
+	buf := encodeDcpEvent(nil, m)
+	if _, err := decodeDcpEvent(buf[:len(buf)-1]); err == nil {
+		t.Errorf("Expected error for a truncated event")
+	}
+	if _, err := decodeDcpEvent(append(buf, 0)); err != errDcpLogFormat {
+		t.Errorf("Expected %v, received %v", errDcpLogFormat, err)
+	}
+}
+
+func TestDcpRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	// Record a stream of 2 snapshots into small segments
+	feeder := &testFeeder{mutch: make(chan *mc.DcpEvent, 100)}
+	recorder, err := newDcpRecorder(feeder, dir, "default", "MAINT", "test", 100, 256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flog := mc.FailoverLog{{1234, 0}}
+	events := []*mc.DcpEvent{{Opcode: mcd.DCP_STREAMREQ, Status: mcd.SUCCESS, FailoverLog: &flog}}
+	for seqno := uint64(1); seqno <= 10; seqno++ {
+		if seqno%5 == 1 {
+			events = append(events, &mc.DcpEvent{Opcode: mcd.DCP_SNAPSHOT,
+				SnapstartSeq: seqno, SnapendSeq: seqno + 4})
+		}
+		events = append(events, &mc.DcpEvent{Opcode: mcd.DCP_MUTATION, Seqno: seqno,
+			Key: []byte(fmt.Sprintf("doc-%v", seqno)), Value: []byte(`{"value":"0123456789"}`)})
+	}
+	for _, m := range events {
+		feeder.mutch <- m
+	}
+	close(feeder.mutch)
+
+	received := 0
+	for range recorder.GetChannel() {
+		received++
+	}
+	if received != len(events) {
+		t.Fatalf("Expected %v events through the recorder, received %v", len(events), received)
+	}
+	recorder.CloseFeed()
+	recorder.CloseFeed()
+
+	paths, err := findDcpLog(dir, "default_MAINT")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) < 2 {
+		t.Fatalf("Expected the recording to be segmented, received %v", paths)
+	}
+
+	recorded := 0
+	err = ReadDcpLog(paths[0], func(m *mc.DcpEvent) bool {
+		recorded++
+		return true
+	})
+	if err != nil || recorded != len(events) {
+		t.Fatalf("Expected %v recorded events, received %v %v", len(events), recorded, err)
+	}
+
+	// Recordings are replayed only for the topic they are recorded for
+	if _, err := openDcpReplayer(dir, "default", "INIT", "test", 100); !os.IsNotExist(err) {
+		t.Errorf("Expected no recording, received %v", err)
+	}
+
+	// Replay from seqno 5 skips the first snapshot
+	replayer, err := openDcpReplayer(dir, "default", "MAINT", "test", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := protobuf.NewTsVbuuid("default", "default", 1).Append(0, 5, 1234, 5, 5, "")
+	if err := replayer.StartVbStreams(1, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		opcode mcd.CommandCode
+		seqno  uint64
+	}
+	expected := []event{{mcd.DCP_STREAMREQ, 0}, {mcd.DCP_SNAPSHOT, 0}}
+	for seqno := uint64(6); seqno <= 10; seqno++ {
+		expected = append(expected, event{mcd.DCP_MUTATION, seqno})
+	}
+	for _, e := range expected {
+		select {
+		case m := <-replayer.GetChannel():
+			if m.Opcode != e.opcode || m.Seqno != e.seqno || m.Opaque != 1 {
+				t.Errorf("Expected %v seqno %v, received %v seqno %v opaque %v",
+					e.opcode, e.seqno, m.Opcode, m.Seqno, m.Opaque)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %v seqno %v", e.opcode, e.seqno)
+		}
+	}
+	select {
+	case m := <-replayer.GetChannel():
+		t.Errorf("Unexpected event %v seqno %v", m.Opcode, m.Seqno)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	replayer.CloseFeed()
+	replayer.CloseFeed()
+}
@@ -3,6 +3,7 @@ package projector
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -1687,6 +1688,19 @@ func (feed *Feed) openFeeder(
 	if ok {
 		return feeder, nil
 	}
+
+	chsize := feed.config["dcp.dataChanSize"].Int()
+	if dir := feed.config["dcp.replay.dir"].String(); dir != "" {
+		feeder, err := openDcpReplayer(dir, keyspaceId, feed.topic, feed.logPrefix, chsize)
+		if err == nil {
+			return feeder, nil
+		} else if !os.IsNotExist(err) {
+			fmsg := "%v ##%x openDcpReplayer(%q): %v"
+			logging.Errorf(fmsg, feed.logPrefix, opaque, keyspaceId, err)
+			return nil, projC.ErrorFeeder
+		}
+	}
+
 	bucket, err := feed.connectBucket(feed.cluster, pooln, bucketn, opaque)
 	if err != nil {
 		return nil, projC.ErrorFeeder
@@ -1741,6 +1755,20 @@ func (feed *Feed) openFeeder(
 		logging.Errorf(fmsg, feed.logPrefix, opaque, keyspaceId, err)
 		return nil, projC.ErrorFeeder
 	}
+
+	if isRecordedKeyspace(feed.config, keyspaceId) {
+		dir := feed.config["dcp.record.dir"].String()
+		segmentSize := int64(feed.config["dcp.record.segment_size"].Int())
+		maxSegments := feed.config["dcp.record.max_segments"].Int()
+		recorder, err := newDcpRecorder(feeder, dir, keyspaceId, feed.topic,
+			feed.logPrefix, chsize, segmentSize, maxSegments)
+		if err != nil { // record is best effort, continue with the live feed.
+			fmsg := "%v ##%x newDcpRecorder(%q): %v"
+			logging.Errorf(fmsg, feed.logPrefix, opaque, keyspaceId, err)
+		} else {
+			feeder = recorder
+		}
+	}
 	return feeder, nil
 }
 
@@ -1768,10 +1796,13 @@ func (feed *Feed) bucketFeed(
 	Having bucketDetails() here would ensure that the NOT_MY_VBUCKETS
 	status (seen while retrieving failover logs) is returned as error
 	to restartVBuckets request and indexer retries again without wait */
-	vbnos := c.Vbno32to16(reqTs.GetVbnos())
-	_ /*vbuuids*/, err := feed.bucketDetails(pooln, bucketn, opaque, vbnos)
-	if err != nil {
-		return projC.ErrorFeeder, true
+	var err error
+	if _, replay := feeder.(*dcpReplayer); !replay {
+		vbnos := c.Vbno32to16(reqTs.GetVbnos())
+		_ /*vbuuids*/, err = feed.bucketDetails(pooln, bucketn, opaque, vbnos)
+		if err != nil {
+			return projC.ErrorFeeder, true
+		}
 	}
 
 	// stop and start are mutually exclusive
@@ -2409,6 +2440,9 @@ func FeedConfigParams() []string {
 		"dcp.serverless.useMutationQueue",
 		"dcp.connection_buffer_size",
 		"dcp.mutation_queue.connection_buffer_size",
+		"dcp.record.keyspaces",
+		"dcp.record.dir",
+		"dcp.replay.dir",
 		// dataport
 		"dataport.remoteBlock",
 		"dataport.keyChanSize",
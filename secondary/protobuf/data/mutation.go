@@ -10,6 +10,10 @@ func (pl *Payload) Value() interface{} {
 		return pl.Vbkeys
 	} else if pl.AuthRequest != nil {
 		return pl.AuthRequest
+	} else if pl.HeloRequest != nil {
+		return pl.HeloRequest
+	} else if pl.HeloResponse != nil {
+		return pl.HeloResponse
 	}
 	return nil
 }
@@ -94,7 +94,9 @@ func (instance *IndexInst) GetPartitionObject() Partition {
 	case PartitionScheme_HASH:
 		// return instance.GetHashPartn()
 	case PartitionScheme_RANGE:
-		// return instance.GetRangePartn()
+		// range partitions share the topology of key partitions,
+		// only the routing of partition key differs.
+		return instance.GetKeyPartn()
 	}
 	return nil
 }
@@ -400,6 +402,19 @@ func (ie *IndexEvaluator) TransformRoute(
 	return newBuf, len(nkey), err
 }
 
+// SecondaryKey evaluates the secondary key of the document in event m as a
+// JSON array, the same way as TransformRoute does for the indexer. It returns
+// nil if the document is deleted, or is not indexed.
+func (ie *IndexEvaluator) SecondaryKey(
+	m *mc.DcpEvent, docval qvalue.AnnotatedValue, context qexpr.Context) ([]byte, error) {
+
+	_, _, nkey, _, _, where, opcode, err := ie.processEvent(m, nil, docval, context)
+	if err != nil || !where || opcode != mcd.DCP_MUTATION {
+		return nil, err
+	}
+	return nkey, nil
+}
+
 func (ie *IndexEvaluator) populateData(vbuuid uint64, m *mc.DcpEvent,
 	data map[string]interface{}, numIndexes int, npkey, opkey []byte,
 	nkey, okey []byte, where bool, opcode mcd.CommandCode, opaque2 uint64,
@@ -29,7 +29,7 @@ func (p *KeyPartition) Hosts(inst *IndexInst) []string {
 func (p *KeyPartition) UpsertEndpoints(
 	inst *IndexInst, m *mc.DcpEvent, partKey, key, oldKey []byte) []string {
 
-	return p.getPartitionEndpoint(partKey, inst.GetDefinition().GetHashScheme())
+	return p.getPartitionEndpoint(partKey, inst.GetDefinition())
 }
 
 // UpsertDeletionEndpoints implements Partition{} interface.
@@ -58,9 +58,15 @@ func (p *KeyPartition) DeletionEndpoints(
 //
 // Get endpoint of a specific partition
 //
-func (p *KeyPartition) getPartitionEndpoint(partKey []byte, scheme HashScheme) []string {
+func (p *KeyPartition) getPartitionEndpoint(partKey []byte, defn *IndexDefn) []string {
 
-	partitionId := uint64(common.HashKeyPartition(partKey, int(p.GetNumPartition()), common.HashScheme(scheme)))
+	var partitionId uint64
+	if defn.GetPartitionScheme() == PartitionScheme_RANGE {
+		partitionId = uint64(common.RangeKeyPartition(partKey, defn.GetPartnRanges()))
+	} else {
+		scheme := defn.GetHashScheme()
+		partitionId = uint64(common.HashKeyPartition(partKey, int(p.GetNumPartition()), common.HashScheme(scheme)))
+	}
 	for _, partnId := range p.Partitions {
 		if partnId == partitionId {
 			return p.GetEndpoints()
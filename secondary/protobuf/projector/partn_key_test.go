@@ -0,0 +1,57 @@
+package protoProjector
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+func TestRangePartitionKey(t *testing.T) {
+	cExprs, err := CompileN1QLExpression([]string{`age`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partition key as evaluated by IndexEvaluator.partitionKey
+	docval := qvalue.NewAnnotatedValue(qvalue.NewParsedValue(doc150, true))
+	context := qexpr.NewIndexContext()
+	partnKey, _, err := N1QLTransform([]byte("docid"), docval, context, cExprs, 0, nil, &stats, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(partnKey) != `[32]` {
+		t.Fatalf("Unexpected partition key %s", partnKey)
+	}
+
+	ranges := []string{`20`, `40`, `60`}
+	defn := &IndexDefn{
+		PartitionScheme: PartitionScheme_RANGE.Enum(),
+		PartnRanges:     ranges,
+	}
+
+	// Projector routes the key to the endpoint of partition 2 only
+	for partnId, routed := range map[uint64]bool{1: false, 2: true, 3: false, 4: false} {
+		p := NewKeyPartition(uint64(len(ranges)+1), []string{"endpoint"}, []uint64{partnId})
+		if endpoints := p.getPartitionEndpoint(partnKey, defn); (len(endpoints) != 0) != routed {
+			t.Errorf("Unexpected endpoints %v for partition %v", endpoints, partnId)
+		}
+	}
+
+	// Indexer flushes the key to partition 2
+	pc := common.NewKeyPartitionContainer(len(ranges)+1, common.RANGE, common.CRC32, ranges)
+	if partnId := pc.GetPartitionIdByPartitionKey(partnKey); partnId != 2 {
+		t.Errorf("Expected partition 2, got %v", partnId)
+	}
+
+	// Document without the partition key belongs to the first partition
+	cExprs, err = CompileN1QLExpression([]string{`height`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partnKey, _, _ = N1QLTransform([]byte("docid"), docval, context, cExprs, 0, nil, &stats, false)
+	if partnId := pc.GetPartitionIdByPartitionKey(partnKey); partnId != 1 {
+		t.Errorf("Expected partition 1, got %v", partnId)
+	}
+}
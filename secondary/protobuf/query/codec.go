@@ -44,6 +44,12 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *AuthRequest:
 		pl.AuthRequest = val
 
+	case *HistogramRequest:
+		pl.HistogramRequest = val
+
+	case *WatchRequest:
+		pl.WatchRequest = val
+
 	// response
 	case *StatisticsResponse:
 		pl.Statistics = val
@@ -66,6 +72,12 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *AuthResponse:
 		pl.AuthResponse = val
 
+	case *HistogramResponse:
+		pl.Histogram = val
+
+	case *WatchResponse:
+		pl.WatchResponse = val
+
 	default:
 		return nil, ErrorMissingPayload
 	}
@@ -105,6 +117,10 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetAuthRequest(); val != nil {
 		return val, nil
+	} else if val := pl.GetHistogramRequest(); val != nil {
+		return val, nil
+	} else if val := pl.GetWatchRequest(); val != nil {
+		return val, nil
 		// response
 	} else if val := pl.GetStatistics(); val != nil {
 		return val, nil
@@ -120,6 +136,10 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetAuthResponse(); val != nil {
 		return val, nil
+	} else if val := pl.GetHistogram(); val != nil {
+		return val, nil
+	} else if val := pl.GetWatchResponse(); val != nil {
+		return val, nil
 	}
 	return nil, ErrorMissingPayload
 }
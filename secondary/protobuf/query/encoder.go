@@ -12,12 +12,23 @@ const (
 )
 
 func EncodeAndWrite(conn net.Conn, buf []byte, r interface{}) (err error) {
+	return EncodeAndWriteCompressed(conn, buf, r, transport.CompressionNone)
+}
+
+// EncodeAndWriteCompressed encodes `r` and compresses the encoded payload
+// using `compression` before writing it to `conn`.
+func EncodeAndWriteCompressed(conn net.Conn, buf []byte, r interface{},
+	compression byte) (err error) {
+
 	var data []byte
 	data, err = ProtobufEncodeInBuf(r, buf[transport.MaxSendBufSize:][:0])
 	if err != nil {
 		return
 	}
-	flags := transport.TransportFlag(0).SetProtobuf()
+	if data, err = transport.Compress(compression, data); err != nil {
+		return
+	}
+	flags := transport.TransportFlag(0).SetProtobuf().SetCompression(compression)
 	err = transport.Send(conn, buf, flags, data, false)
 	return
 }
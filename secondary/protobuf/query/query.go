@@ -2,6 +2,7 @@ package protoQuery
 
 import (
 	"errors"
+	"time"
 
 	json "github.com/couchbase/indexing/secondary/common/json"
 
@@ -57,6 +58,11 @@ func (r *ResponseStream) GetReadUnits() uint64 {
 	return 0
 }
 
+// GetScanTrace implements queryport.client.ResponseReader{} method.
+func (r *ResponseStream) GetScanTrace() *c.ScanTrace {
+	return nil
+}
+
 // GetEntries implements queryport.client.ResponseReader{} method.
 func (r *StreamEndResponse) GetEntries(dataEncFmt c.DataEncodingFormat) (*c.ScanResultEntries, [][]byte, error) {
 	var results c.ScanResultEntries
@@ -73,6 +79,35 @@ func (r *StreamEndResponse) Error() error {
 	return nil
 }
 
+// GetScanTrace implements queryport.client.ResponseReader{} method.
+func (r *StreamEndResponse) GetScanTrace() *c.ScanTrace {
+	return r.GetTrace().ToScanTrace()
+}
+
+// ToScanTrace converts the trace returned by indexer to common.ScanTrace.
+func (pt *ScanTrace) ToScanTrace() *c.ScanTrace {
+	if pt == nil {
+		return nil
+	}
+
+	trace := &c.ScanTrace{
+		Wait:      time.Duration(pt.GetWaitTime()),
+		Iterate:   time.Duration(pt.GetIterateTime()),
+		Filter:    time.Duration(pt.GetFilterTime()),
+		Aggregate: time.Duration(pt.GetAggrTime()),
+		Send:      time.Duration(pt.GetSendTime()),
+		Total:     time.Duration(pt.GetTotalTime()),
+	}
+	for _, p := range pt.GetPartitions() {
+		trace.Partitions = append(trace.Partitions, c.PartitionScanTrace{
+			PartitionId: c.PartitionId(p.GetPartitionId()),
+			Iterate:     time.Duration(p.GetIterateTime()),
+			RowsScanned: p.GetRowsScanned(),
+		})
+	}
+	return trace
+}
+
 // Count implements common.IndexStatistics{} method.
 func (s *IndexStatistics) Count() (int64, error) {
 	return int64(s.GetKeysCount()), nil
@@ -80,6 +115,9 @@ func (s *IndexStatistics) Count() (int64, error) {
 
 // Min implements common.IndexStatistics{} method.
 func (s *IndexStatistics) MinKey() (c.SecondaryKey, error) {
+	if len(s.GetKeyMin()) == 0 {
+		return nil, nil
+	}
 	skey := make(c.SecondaryKey, 0)
 	if err := json.Unmarshal(s.GetKeyMin(), &skey); err != nil {
 		return nil, err
@@ -89,6 +127,9 @@ func (s *IndexStatistics) MinKey() (c.SecondaryKey, error) {
 
 // Max implements common.IndexStatistics{} method.
 func (s *IndexStatistics) MaxKey() (c.SecondaryKey, error) {
+	if len(s.GetKeyMax()) == 0 {
+		return nil, nil
+	}
 	skey := make(c.SecondaryKey, 0)
 	if err := json.Unmarshal(s.GetKeyMax(), &skey); err != nil {
 		return nil, err
@@ -101,11 +142,50 @@ func (s *IndexStatistics) DistinctCount() (int64, error) {
 	return int64(s.GetUniqueKeysCount()), nil
 }
 
+// Size implements common.IndexStatistics{} method.
+func (s *IndexStatistics) Size() (int64, error) {
+	return int64(s.GetKeysSize()), nil
+}
+
 // Bins implements common.IndexStatistics{} method.
 func (s *IndexStatistics) Bins() ([]c.IndexStatistics, error) {
 	return nil, nil
 }
 
+// NewHistogram returns the protobuf message of histogram h.
+func NewHistogram(h *c.Histogram) *Histogram {
+	ph := &Histogram{
+		NumDocs: proto.Int64(h.NumDocs),
+		Bins:    make([]*HistogramBin, len(h.Bins)),
+	}
+	for i, bin := range h.Bins {
+		ph.Bins[i] = &HistogramBin{
+			Min:      bin.Min,
+			Max:      bin.Max,
+			Size:     proto.Int64(bin.Size),
+			Distinct: proto.Int64(bin.Distinct),
+		}
+	}
+	return ph
+}
+
+// ToHistogram converts the message to common.Histogram.
+func (ph *Histogram) ToHistogram() *c.Histogram {
+	h := &c.Histogram{
+		NumDocs: ph.GetNumDocs(),
+		Bins:    make([]*c.HistogramBin, len(ph.GetBins())),
+	}
+	for i, bin := range ph.GetBins() {
+		h.Bins[i] = &c.HistogramBin{
+			Min:      bin.GetMin(),
+			Max:      bin.GetMax(),
+			Size:     bin.GetSize(),
+			Distinct: bin.GetDistinct(),
+		}
+	}
+	return h
+}
+
 func NewTsConsistency(
 	vbnos []uint16, seqnos []uint64, vbuuids []uint64,
 	crc64 uint64) *TsConsistency {
@@ -119,3 +199,13 @@ func NewTsConsistency(
 		Crc64: proto.Uint64(crc64),
 	}
 }
+
+// Error returns the error of a range watch, if any.
+func (r *WatchResponse) Error() error {
+	if e := r.GetErr(); e != nil {
+		if ee := e.GetError(); ee != "" {
+			return errors.New(ee)
+		}
+	}
+	return nil
+}
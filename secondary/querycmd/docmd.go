@@ -79,6 +79,9 @@ type Command struct {
 	Limit       int64
 	Distinct    bool
 	Consistency c.Consistency
+	Staleness   time.Duration
+	Trace       bool
+	Priority    string
 	// Configuration
 	ConfigKey string
 	ConfigVal string
@@ -190,8 +193,11 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 	fset.UintVar(&inclusion, "incl", 0, "Range: 0|1|2|3")
 	fset.Int64Var(&cmdOptions.Limit, "limit", 10, "Row limit")
 	fset.BoolVar(&cmdOptions.Distinct, "distinct", false, "Only distinct entries")
+	fset.BoolVar(&cmdOptions.Trace, "trace", false, "Print per-phase timing breakdown of scan")
+	fset.StringVar(&cmdOptions.Priority, "priority", "", "Priority class of scan for admission at the indexer: interactive|batch|background")
 	fset.BoolVar(&cmdOptions.Help, "h", false, "print help")
 	fset.BoolVar(&useSessionCons, "consistency", false, "Use session consistency")
+	fset.DurationVar(&cmdOptions.Staleness, "staleness", 0, "Use bounded staleness consistency, accepting results this far behind the mutations received by the indexer, e.g. 200ms")
 	// options for setting configuration
 	fset.StringVar(&cmdOptions.ConfigKey, "ckey", "", "Config key")
 	fset.StringVar(&cmdOptions.ConfigVal, "cval", "", "Config value")
@@ -226,8 +232,14 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 		return nil, nil, fset, err
 	}
 
+	if useSessionCons && cmdOptions.Staleness > 0 {
+		return nil, nil, fset, fmt.Errorf("Invalid flags. Flags 'consistency' and 'staleness' cannot be used together")
+	}
+
 	if useSessionCons {
 		cmdOptions.Consistency = c.SessionConsistency
+	} else if cmdOptions.Staleness > 0 {
+		cmdOptions.Consistency = c.BoundedStalenessConsistency
 	}
 
 	// validate combinations
@@ -333,7 +345,7 @@ func ParseArgs(arguments []string) (*Command, []string, *flag.FlagSet, error) {
 	cmdOptions.Scheme = c.PartitionScheme(scheme)
 	switch cmdOptions.Scheme {
 
-	case c.KEY:
+	case c.KEY, c.RANGE:
 		if partitionKeys == "" {
 			logging.Errorf("Missing input partition keys")
 			os.Exit(1)
@@ -412,6 +424,15 @@ func HandleCommand(
 
 	dataEncFmt := client.GetDataEncodingFormat()
 	var scanParams = map[string]interface{}{"skipReadMetering": true, "user": ""}
+	if cmd.Trace {
+		scanParams["trace"] = true
+	}
+	if cons == c.BoundedStalenessConsistency {
+		scanParams["staleness"] = cmd.Staleness
+	}
+	if cmd.Priority != "" {
+		scanParams["priority"] = cmd.Priority
+	}
 
 	var tmpbuf *[]byte
 	var tmpbufPoolIdx uint32
@@ -423,6 +444,11 @@ func HandleCommand(
 			return true
 		}
 
+		if trace := res.GetScanTrace(); trace != nil {
+			fmt.Fprintf(w, "Scan trace: %v\n", trace)
+			return true
+		}
+
 		var skeys *c.ScanResultEntries
 		var pkeys [][]byte
 		var err error
@@ -1113,11 +1139,11 @@ func validate(cmd *Command, fset *flag.FlagSet) error {
 
 	case "stats":
 		have = []string{"type", "server", "auth", "index", "bucket"}
-		dont = []string{"h", "where", "fields", "primary", "with", "index_ddl", "indexes", "limit", "distinct", "ckey", "cval"}
+		dont = []string{"h", "where", "fields", "primary", "with", "index_ddl", "indexes", "limit", "distinct", "ckey", "cval", "staleness", "priority"}
 
 	case "count":
 		have = []string{"type", "server", "auth", "index", "bucket"}
-		dont = []string{"h", "where", "fields", "primary", "with", "index_ddl", "indexes", "ckey", "cval"}
+		dont = []string{"h", "where", "fields", "primary", "with", "index_ddl", "indexes", "ckey", "cval", "staleness"}
 
 	case "config":
 		have = []string{"type", "server", "auth"}
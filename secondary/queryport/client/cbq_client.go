@@ -198,6 +198,24 @@ func (b *cbqClient) AlterReplicaCount(action string, defnID uint64, plan map[str
 	panic("cbqClient does not implement alter replica count")
 }
 
+// RebuildIndex implement BridgeAccessor{} interface.
+func (b *cbqClient) RebuildIndex(
+	defnID uint64, exprType, whereExpr string,
+	secExprs []string, desc []bool, indexMissingLeadingKey bool,
+	with []byte) (uint64, error) {
+	panic("cbqClient does not implement rebuild index")
+}
+
+// CreateAggregate implement BridgeAccessor{} interface.
+func (b *cbqClient) CreateAggregate(defnID uint64, aggregate *common.IndexAggregate) error {
+	panic("cbqClient does not implement create aggregate")
+}
+
+// DropAggregate implement BridgeAccessor{} interface.
+func (b *cbqClient) DropAggregate(defnID uint64, name string) error {
+	panic("cbqClient does not implement drop aggregate")
+}
+
 // DropIndex implement BridgeAccessor{} interface.
 func (b *cbqClient) DropIndex(defnID uint64, _ string) error {
 	var resp *http.Response
@@ -242,6 +260,11 @@ func (b *cbqClient) GetScanport(
 	return []string{b.queryport}, defnID, nil, []int64{math.MaxInt64}, nil, 0, true
 }
 
+// IsRebuilt implements BridgeAccessor{} interface.
+func (b *cbqClient) IsRebuilt(defnID uint64) bool {
+	return false
+}
+
 // GetIndexDefn implements BridgeAccessor{} interface.
 func (b *cbqClient) GetIndexDefn(defnID uint64) *common.IndexDefn {
 	panic("cbqClient does not implement GetIndexDefn")
@@ -15,6 +15,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,6 +29,7 @@ import (
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
 	mclient "github.com/couchbase/indexing/secondary/manager/client"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/security"
 	"github.com/couchbase/query/value"
 )
@@ -57,6 +59,10 @@ type ResponseReader interface {
 	Error() error
 
 	GetReadUnits() uint64
+
+	// GetScanTrace returns the per-phase timing of a traced scan, nil
+	// if the scan is not traced or trace is not yet available.
+	GetScanTrace() *common.ScanTrace
 }
 
 // ResponseSender is responsible for forwarding result to the client
@@ -74,6 +80,9 @@ type ScanRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.
 // CountRequestHandler initiates a request to a single server connection
 type CountRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId) (int64, error, bool)
 
+// StatisticsRequestHandler initiates a request to a single server connection
+type StatisticsRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId) (common.IndexStatistics, error, bool)
+
 // ResponseTimer updates timing of responses
 type ResponseTimer func(instID uint64, partitionId common.PartitionId, value float64)
 
@@ -201,6 +210,22 @@ type BridgeAccessor interface {
 	// AlterReplicaCount to change replica count of index
 	AlterReplicaCount(action string, defnID uint64, with map[string]interface{}) error
 
+	// RebuildIndex to rebuild index specified by `defnID` with new
+	// secExprs and whereExpr, and return defnID of the index that
+	// replaces it once built.
+	RebuildIndex(
+		defnID uint64, exprType, whereExpr string,
+		secExprs []string, desc []bool, indexMissingLeadingKey bool,
+		with []byte) (uint64, error)
+
+	// CreateAggregate to create precomputed aggregate of index specified
+	// by `defnID`.
+	CreateAggregate(defnID uint64, aggregate *common.IndexAggregate) error
+
+	// DropAggregate to drop precomputed aggregate `name` of index
+	// specified by `defnID`.
+	DropAggregate(defnID uint64, name string) error
+
 	// DropIndex to drop index specified by `defnID`.
 	// - if index is in deferred build state, it shall be removed
 	//   from deferred list.
@@ -221,6 +246,11 @@ type BridgeAccessor interface {
 		skips map[common.IndexDefnId]bool) (queryport []string, targetDefnID uint64, targetInstID []uint64,
 		rollbackTime []int64, partition [][]common.PartitionId, numPartitions uint32, ok bool)
 
+	// IsRebuilt returns true if index `defnID` is rebuilt with a different
+	// definition and its shadow index is built. Such an index is not to be
+	// scanned, as the query has to be planned again for the new definition.
+	IsRebuilt(defnID uint64) bool
+
 	// GetIndexDefn will return the index-definition structure for defnID.
 	GetIndexDefn(defnID uint64) *common.IndexDefn
 
@@ -627,6 +657,54 @@ func (c *GsiClient) AlterReplicaCount(action string, defnID uint64, with map[str
 	return err
 }
 
+// RebuildIndex implements BridgeAccessor{} interface.
+func (c *GsiClient) RebuildIndex(
+	defnID uint64, exprType, whereExpr string,
+	secExprs []string, desc []bool, indexMissingLeadingKey bool,
+	with []byte) (uint64, error) {
+
+	if c.bridge == nil {
+		return 0, ErrorClientUninitialized
+	}
+
+	logging.Infof("RebuildIndex %v ...", defnID)
+	begin := time.Now()
+	newDefnID, err := c.bridge.RebuildIndex(
+		defnID, exprType, whereExpr, secExprs, desc, indexMissingLeadingKey, with)
+	fmsg := "RebuildIndex %v as %v exprType:%v whereExpr:%v secExprs:%v desc:%v " +
+		"indexMissingLeadingKey:%v with:%v - elapsed(%v) err(%v)"
+
+	origSecExprs, _, _ := common.GetUnexplodedExprs(secExprs, desc)
+	logging.Infof(
+		fmsg, defnID, newDefnID, exprType, logging.TagUD(whereExpr), logging.TagUD(origSecExprs),
+		desc, indexMissingLeadingKey, string(with), time.Since(begin), err)
+	return newDefnID, err
+}
+
+// CreateAggregate implements BridgeAccessor{} interface.
+func (c *GsiClient) CreateAggregate(defnID uint64, aggregate *common.IndexAggregate) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+
+	begin := time.Now()
+	err := c.bridge.CreateAggregate(defnID, aggregate)
+	logging.Infof("CreateAggregate %v %v - elapsed(%v) err(%v)", defnID, aggregate, time.Since(begin), err)
+	return err
+}
+
+// DropAggregate implements BridgeAccessor{} interface.
+func (c *GsiClient) DropAggregate(defnID uint64, name string) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+
+	begin := time.Now()
+	err := c.bridge.DropAggregate(defnID, name)
+	logging.Infof("DropAggregate %v %v - elapsed(%v) err(%v)", defnID, name, time.Since(begin), err)
+	return err
+}
+
 // DropIndex implements BridgeAccessor{} interface.
 func (c *GsiClient) DropIndex(defnID uint64, bucketName string) error {
 	if c.bridge == nil {
@@ -643,17 +721,115 @@ func (c *GsiClient) DropIndex(defnID uint64, bucketName string) error {
 
 // LookupStatistics for a single secondary-key.
 func (c *GsiClient) LookupStatistics(
-	defnID uint64, requestId string, value common.SecondaryKey) (common.IndexStatistics, error) {
+	defnID uint64, requestId string, value common.SecondaryKey) (stats common.IndexStatistics, err error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return nil, err
+	}
+
+	begin := time.Now()
+
+	dataEncFmt := c.GetDataEncodingFormat()
+	broker := makeDefaultRequestBroker(nil, dataEncFmt)
+
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+		partitions []common.PartitionId) (common.IndexStatistics, error, bool) {
+
+		var err error
+		var stats common.IndexStatistics
+
+		if c.bridge.IsPrimary(uint64(index.DefnId)) {
+			var e []byte
+			// primary keys are plain sequence of binary.
+			if len(value) > 0 {
+				e, _ = curePrimaryKey(value[0])
+			}
+			stats, err = qc.RangeStatisticsPrimary(
+				uint64(index.DefnId), requestId, e, e, Both, rollbackTime, partitions, broker.DoRetry())
+			return stats, err, false
+		}
 
-	return nil, ErrorNotImplemented
+		stats, err = qc.LookupStatistics(
+			uint64(index.DefnId), requestId, value, rollbackTime, partitions, broker.DoRetry())
+		return stats, err, false
+	}
+
+	broker.SetStatisticsRequestHandler(handler)
+
+	_, err = c.doScan(defnID, requestId, broker)
+
+	fmsg := "LookupStatistics {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	if err != nil {
+		return nil, err
+	}
+	return broker.GetStatistics(), nil
 }
 
 // RangeStatistics for index range.
 func (c *GsiClient) RangeStatistics(
 	defnID uint64, requestId string, low, high common.SecondaryKey,
-	inclusion Inclusion) (common.IndexStatistics, error) {
+	inclusion Inclusion) (stats common.IndexStatistics, err error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return nil, err
+	}
+
+	begin := time.Now()
+
+	dataEncFmt := c.GetDataEncodingFormat()
+	broker := makeDefaultRequestBroker(nil, dataEncFmt)
+
+	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64,
+		partitions []common.PartitionId) (common.IndexStatistics, error, bool) {
+
+		var err error
+		var stats common.IndexStatistics
+
+		if c.bridge.IsPrimary(uint64(index.DefnId)) {
+			var l, h []byte
+			var what string
+			// primary keys are plain sequence of binary.
+			if low != nil && len(low) > 0 {
+				if l, what = curePrimaryKey(low[0]); what == "after" {
+					return nil, nil, true
+				}
+			}
+			if high != nil && len(high) > 0 {
+				if h, what = curePrimaryKey(high[0]); what == "before" {
+					return nil, nil, true
+				}
+			}
+			stats, err = qc.RangeStatisticsPrimary(
+				uint64(index.DefnId), requestId, l, h, inclusion, rollbackTime, partitions, broker.DoRetry())
+			return stats, err, false
+		}
 
-	return nil, ErrorNotImplemented
+		stats, err = qc.RangeStatistics(
+			uint64(index.DefnId), requestId, low, high, inclusion, rollbackTime, partitions, broker.DoRetry())
+		return stats, err, false
+	}
+
+	broker.SetStatisticsRequestHandler(handler)
+
+	_, err = c.doScan(defnID, requestId, broker)
+
+	fmsg := "RangeStatistics {%v,%v} - elapsed(%v) err(%v)"
+	logging.Verbosef(fmsg, defnID, requestId, time.Since(begin), err)
+	if err != nil {
+		return nil, err
+	}
+	return broker.GetStatistics(), nil
 }
 
 // Lookup scan index between low and high.
@@ -1183,6 +1359,296 @@ func (c *GsiClient) Scan3Internal(
 	return
 }
 
+// Scan3Resumable is same as Scan3, and in addition returns an opaque cursor
+// identifying the last row passed to callb. If the scan fails midway, it can
+// be resumed right after that row by calling Scan3Resumable again with the
+// same arguments and the returned cursor, instead of restarting the scan.
+// Offset and limit apply to the scan as a whole and are not to be adjusted
+// by the caller while resuming.
+//
+// Cursor carries the timestamp of the scanned snapshot. Indexer retains that
+// snapshot for indexer.scan.cursor_snapshot_retention after its last use, and
+// a scan resumed against it returns each row exactly once. If the snapshot
+// is no longer available, e.g. the scan is resumed after the retention or on
+// another replica, the scan resumes against a different snapshot, and rows
+// that changed in between may be returned twice or not at all.
+//
+// Resumable scans are not supported for distinct and aggregate scans, and
+// for partitioned indexes whose partitions are scattered across indexer
+// nodes. Scans on partitioned indexes should pass indexOrder.
+func (c *GsiClient) Scan3Resumable(
+	defnID uint64, requestId string, scans Scans, reverse,
+	distinct bool, projection *IndexProjection, offset, limit int64,
+	groupAggr *GroupAggr, indexOrder *IndexKeyOrder,
+	cons common.Consistency, vector *TsConsistency, cursor []byte,
+	callb ResponseHandler, scanParams map[string]interface{}) ([]byte, error) {
+
+	params := make(map[string]interface{}, len(scanParams)+2)
+	for k, v := range scanParams {
+		params[k] = v
+	}
+	params["needCursor"] = true
+	params["resumeCursor"] = cursor
+
+	var mutex sync.Mutex
+	next := cursor
+
+	dataEncFmt := c.GetDataEncodingFormat()
+	broker := makeDefaultRequestBroker(callb, dataEncFmt)
+	broker.SetCursorCallback(func(cursor []byte) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		next = append([]byte(nil), cursor...)
+	})
+
+	err := c.Scan3Internal(defnID, requestId, scans, reverse, distinct,
+		projection, offset, limit, groupAggr, indexOrder, cons, vector,
+		broker, params)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return next, err
+}
+
+// VectorScan returns the topK entries of a vector index nearest to
+// queryVector, in the order of distance. Distance is computed using
+// similarity, or the similarity of the index if empty, and is returned as
+// the trailing key of every entry. Only the entries qualifying scans are
+// considered, scans on the scalar keys of the index can be used to
+// pre-filter the nearest neighbours. nprobe is the number of IVF lists
+// searched in every partition, a higher value improves recall at the cost
+// of latency.
+func (c *GsiClient) VectorScan(
+	defnID uint64, requestId string, scans Scans, queryVector []float32,
+	similarity common.VectorSimilarity, topK int64, nprobe int,
+	cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler, scanParams map[string]interface{}) error {
+
+	if topK <= 0 {
+		return ErrorInvalidTopK
+	}
+
+	params := make(map[string]interface{}, len(scanParams)+4)
+	for k, v := range scanParams {
+		params[k] = v
+	}
+	params["queryVector"] = queryVector
+	params["similarity"] = similarity
+	params["topK"] = topK
+	params["nprobe"] = nprobe
+
+	type result struct {
+		pkey     []byte
+		skey     common.ScanResultKey
+		distance float64
+	}
+
+	// Every partition returns its own top-K in the order of distance,
+	// results are merged once all partitions are scanned.
+	var mutex sync.Mutex
+	var results []result
+	var merr error
+
+	dataEncFmt := c.GetDataEncodingFormat()
+	broker := makeDefaultRequestBroker(nil, dataEncFmt)
+	broker.SetResponseSender(func(pkey []byte, mskey []value.Value,
+		uskey common.ScanResultKey, tmpbuf *[]byte) (bool, *[]byte) {
+
+		if tmpbuf == nil {
+			buf := make([]byte, 0, 1024)
+			tmpbuf = &buf
+		}
+		vals, err, retbuf := uskey.Get(tmpbuf)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if err != nil || len(vals) == 0 {
+			if merr == nil {
+				merr = ErrorInvalidVectorResult
+			}
+			return false, retbuf
+		}
+		distance, _ := vals[len(vals)-1].Actual().(float64)
+
+		r := result{pkey: append([]byte(nil), pkey...), skey: uskey, distance: distance}
+		r.skey.Skeycjson = append([]byte(nil), uskey.Skeycjson...)
+		results = append(results, r)
+		return true, retbuf
+	})
+
+	err := c.Scan3Internal(defnID, requestId, scans, false, false, nil, 0, 0,
+		nil, nil, cons, vector, broker, params)
+	if err != nil {
+		return err
+	}
+	if merr != nil {
+		return merr
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].distance < results[j].distance
+	})
+	if int64(len(results)) > topK {
+		results = results[:topK]
+	}
+
+	if callb != nil {
+		for _, r := range results {
+			reader := bypassResponseReader{pkey: r.pkey, skey: r.skey}
+			if !callb(&reader) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+//-------------------------------------
+// LeadKeyHistogram implementation
+//-------------------------------------
+
+// LeadKeyHistogram returns the histogram of the leading key of the index,
+// with numBins bins or indexer default if numBins is 0. Partitions are
+// chosen the same way as for a scan, histograms of the partitions on each
+// indexer node are merged into one. Like StorageStatistics, there is no
+// replica retry and consumer of this API should retry in case of error.
+func (c *GsiClient) LeadKeyHistogram(defnID uint64, requestId string,
+	numBins uint32) (*common.Histogram, error) {
+
+	var excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool
+	skips := make(map[common.IndexDefnId]bool)
+
+	queryports, targetDefnID, _, rollbackTimes, partitions, _, ok := c.bridge.GetScanport(defnID, excludes, skips)
+	if !ok {
+		return nil, errors.New("Unable to retrieve histogram from any replica index.")
+	}
+
+	qcs, ok := c.getScanClients(queryports)
+	if !ok {
+		return nil, ErrorNoHost
+	}
+
+	hists := make([]*common.Histogram, 0, len(qcs))
+	bins := int(numBins)
+	for i, qc := range qcs {
+		hist, err := qc.LeadKeyHistogram(targetDefnID, requestId, numBins, rollbackTimes[i],
+			partitions[i], true)
+		if err != nil {
+			return nil, err
+		}
+		// indexer default number of bins
+		if numBins == 0 && len(hist.Bins) > bins {
+			bins = len(hist.Bins)
+		}
+		hists = append(hists, hist)
+	}
+
+	return common.MergeHistograms(hists, bins), nil
+}
+
+//-------------------------------------
+// Watch implementation
+//-------------------------------------
+
+// WatchEvent is a change to an entry in the key range of a watch. Entries
+// of the snapshot the watch starts from are inserts without seqno.
+type WatchEvent struct {
+	Type       common.WatchEventType
+	PrimaryKey []byte
+	SecKey     common.SecondaryKey // nil for WatchDelete
+	Vbucket    uint16
+	Seqno      uint64
+	Snapshot   bool
+}
+
+// WatchHandler is called with the changes to the entries in the range of a
+// watch, in the order they are applied by the indexer. boundary, if not nil,
+// is the timestamp upto which all the changes have been received. If the
+// handler is not interested in receiving any more changes it shall return
+// false.
+type WatchHandler func(events []*WatchEvent, boundary *TsConsistency) bool
+
+// Watch subscribes to the insert, update and delete of the entries of the
+// index in the key range [low, high]. The indexer first sends the entries
+// in the range as of a snapshot, followed by the changes after the snapshot.
+// Watch blocks until callb returns false, or the watch fails.
+//
+// Changes are buffered by the indexer upto a limit while callb is slow to
+// consume them, beyond which the watch fails. After a disconnect or failure,
+// the watch can be resumed with the last boundary received as resumeTs. The
+// entries of the snapshot the resumed watch starts from then replace the
+// ones received before.
+//
+// Watch is not supported on partitioned, array, primary or descending
+// indexes.
+func (c *GsiClient) Watch(defnID uint64, requestId string, low, high common.SecondaryKey,
+	inclusion Inclusion, resumeTs *TsConsistency, callb WatchHandler) error {
+
+	var excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool
+	skips := make(map[common.IndexDefnId]bool)
+
+	queryports, targetDefnID, _, _, _, _, ok := c.bridge.GetScanport(defnID, excludes, skips)
+	if !ok {
+		return errors.New("Unable to watch any replica index.")
+	}
+
+	qcs, ok := c.getScanClients(queryports)
+	if !ok {
+		return ErrorNoHost
+	}
+	if len(qcs) != 1 {
+		return ErrorWatchNotSupported
+	}
+
+	var boundary *TsConsistency
+	if resumeTs != nil {
+		boundary = NewTsConsistency(append([]uint16(nil), resumeTs.Vbnos...),
+			append([]uint64(nil), resumeTs.Seqnos...), append([]uint64(nil), resumeTs.Vbuuids...))
+	}
+
+	return qcs[0].Watch(targetDefnID, requestId, low, high, inclusion, resumeTs,
+		func(resp *protobuf.WatchResponse) bool {
+			events := make([]*WatchEvent, 0, len(resp.GetEvents()))
+			for _, e := range resp.GetEvents() {
+				event := &WatchEvent{
+					Type:       common.WatchEventType(e.GetType()),
+					PrimaryKey: e.GetPrimaryKey(),
+					Vbucket:    uint16(e.GetVbucket()),
+					Seqno:      e.GetSeqno(),
+					Snapshot:   resp.GetSnapshot(),
+				}
+				if key := e.GetEntryKey(); len(key) > 0 {
+					if err := json.Unmarshal(key, &event.SecKey); err != nil {
+						logging.Errorf("GsiClient::Watch %v error decoding key of %v: %v",
+							requestId, logging.TagUD(string(event.PrimaryKey)), err)
+						return false
+					}
+				}
+				events = append(events, event)
+			}
+
+			// Indexer sends the vbuckets of the boundary that moved, except
+			// for the boundary of the snapshot
+			var ts *TsConsistency
+			if b := resp.GetBoundary(); b != nil {
+				if resp.GetSnapshot() || boundary == nil {
+					boundary = NewTsConsistency(nil, nil, nil)
+				}
+				for i, vbno := range b.GetVbnos() {
+					boundary.Override(uint16(vbno), b.GetSeqnos()[i], b.GetVbuuids()[i])
+				}
+				ts = NewTsConsistency(append([]uint16(nil), boundary.Vbnos...),
+					append([]uint64(nil), boundary.Seqnos...), append([]uint64(nil), boundary.Vbuuids...))
+			}
+
+			if len(events) == 0 && ts == nil {
+				return true
+			}
+			return callb(events, ts)
+		})
+}
+
 //-------------------------------------
 // StorageStatistics implementation
 //-------------------------------------
@@ -1467,7 +1933,7 @@ func (c *GsiClient) updateScanClients() {
 
 			if qc.IsClosed() {
 				logging.Infof("Found a closed scanclient for %v. Initializing a new scan client.", queryport)
-				if qc, err := NewGsiScanClient(queryport, c.cluster, c.config, c.needsAuth); err == nil {
+				if qc, err := newGsiScanClient(queryport, c.cluster, c.config, c.needsAuth, c.settings); err == nil {
 					clients[queryport] = qc
 				} else {
 					logging.Errorf("Unable to initialize gsi scanclient (%v)", err)
@@ -1481,7 +1947,7 @@ func (c *GsiClient) updateScanClients() {
 		}
 
 		for queryport := range newclients {
-			if qc, err := NewGsiScanClient(queryport, c.cluster, c.config, c.needsAuth); err == nil {
+			if qc, err := newGsiScanClient(queryport, c.cluster, c.config, c.needsAuth, c.settings); err == nil {
 				clients[queryport] = qc
 			} else {
 				logging.Errorf("Unable to initialize gsi scanclient (%v)", err)
@@ -1525,14 +1991,14 @@ func (c *GsiClient) updateExcludes(defnID uint64, excludes map[common.IndexDefnI
 
 	for partnId, instErrMap := range errMap {
 		for instId, err := range instErrMap {
-			if !isgone(err) {
+			if !isgone(err) && !isServerBusy(err) {
 				if _, ok := excludes[defnId][partnId]; !ok {
 					excludes[defnId][partnId] = make(map[uint64]bool)
 				}
 				excludes[defnId][partnId][instId] = true
 			} else {
-				// if it is network error, then
-				// exclude all partitions on all replicas
+				// if it is network error or the indexer is busy,
+				// then exclude all partitions on all replicas
 				// residing on the failed node.
 
 				// doScan() may scan the partition from insts or rebalInsts.
@@ -1588,6 +2054,10 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 	var excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool
 	var err error
 
+	if c.bridge.IsRebuilt(defnID) {
+		return 0, ErrorIndexRebuilt
+	}
+
 	broker.SetResponseTimer(c.bridge.Timeit)
 	skips := make(map[common.IndexDefnId]bool)
 
@@ -1615,9 +2085,17 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 
 				if c.isTimeit(scan_errs) {
 					c.updateScanResponse(time.Now().Sub(start).Nanoseconds())
+					if len(scan_errs) == 0 {
+						broker.notifyScanTrace()
+					}
 					return count, getScanError(scan_errs)
 				}
 
+				// a scan cancelled by admin is not retried on a replica
+				if isAdminCancel(scan_errs) {
+					return 0, getScanError(scan_errs)
+				}
+
 				excludes = c.updateExcludes(defnID, excludes, scan_errs)
 				if len(scan_errs) != 0 && partial {
 					// partially succeeded scans, we don't reset-hash and we don't retry
@@ -1725,7 +2203,7 @@ func (c *GsiClient) getConsistency(
 		} else {
 			vector = nil
 		}
-	} else if cons == common.AnyConsistency {
+	} else if cons == common.AnyConsistency || cons == common.BoundedStalenessConsistency {
 		vector = nil
 	} else {
 		return nil, ErrorInvalidConsistency
@@ -1881,9 +2359,9 @@ func (c *GsiClient) GetDataEncodingFormat() common.DataEncodingFormat {
 // TsConsistency specifies a subset of vbuckets to be used as
 // timestamp vector to specify consistency criteria.
 //
-// Timestamp-vector will be ignored for AnyConsistency, computed
-// locally by scan-coordinator or accepted as scan-arguments for
-// SessionConsistency.
+// Timestamp-vector will be ignored for AnyConsistency and
+// BoundedStalenessConsistency, computed locally by scan-coordinator or
+// accepted as scan-arguments for SessionConsistency.
 type TsConsistency struct {
 	Vbnos   []uint16
 	Seqnos  []uint64
@@ -1967,6 +2445,24 @@ func isgone(scan_err error) bool {
 	return false
 }
 
+func isAdminCancel(errMap map[common.PartitionId]map[uint64]error) bool {
+	for _, instErrMap := range errMap {
+		for _, err := range instErrMap {
+			if strings.Contains(err.Error(), ErrAdminCancel.Error()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isServerBusy returns true if the indexer did not admit the scan, in which
+// case the scan can be retried on a replica.
+func isServerBusy(scan_err error) bool {
+	return scan_err != nil && strings.Contains(scan_err.Error(), ErrServerBusy.Error())
+}
+
 func getScanError(errMap map[common.PartitionId]map[uint64]error) error {
 
 	if len(errMap) == 0 {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -54,6 +55,19 @@ type connectionPool struct {
 	authHost         string
 	cluster          string
 	needsAuth        *uint32
+
+	// compression returns the compression to request on new connections,
+	// nil if responses are not to be compressed
+	compression func() byte
+
+	// Pooled connections are streams multiplexed on a single connection
+	// to host, if multiplex is true and the server supports it.
+	multiplex  bool
+	mux        *transport.Mux
+	muxAuth    bool          // authentication of the multiplexed connection
+	muxDialing chan struct{} // closed once the connection being dialed is done
+	muxClosed  bool
+	muxMutex   sync.Mutex
 }
 
 type connection struct {
@@ -106,17 +120,33 @@ func newConnectionPool(
 var ConnPoolCallback func(host string, source string, start time.Time, err error)
 
 func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
+	if cn, ok, err := cp.mkStream(host); ok {
+		return cn, err
+	}
+
+	cn, err := cp.dial(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Negotiate payload compression
+	err = cp.doCompression(cn)
+	if err != nil {
+		cn.conn.Close()
+		return nil, err
+	}
+
+	return cn, nil
+}
+
+// dial opens an authenticated connection to host.
+func (cp *connectionPool) dial(host string) (*connection, error) {
 	logging.Infof("%v open new connection ...\n", cp.logPrefix)
 	conn, err := security.MakeConn(host)
 	if err != nil {
 		return nil, err
 	}
 
-	flags := transport.TransportFlag(0).SetProtobuf()
-	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
-	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
-	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
-
 	if cp.kaInterval > time.Duration(0) {
 		tcpconn, ok := conn.(*net.TCPConn)
 		if ok {
@@ -127,7 +157,7 @@ func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 
 	cn := &connection{
 		conn:          conn,
-		pkt:           pkt,
+		pkt:           cp.newPacket(),
 		authenticated: false,
 	}
 
@@ -140,6 +170,249 @@ func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 	return cn, nil
 }
 
+func (cp *connectionPool) newPacket() *transport.TransportPacket {
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	return pkt
+}
+
+// mkStream opens a new stream on the multiplexed connection to host. The
+// connection is opened on first use, or once it is closed. If the server
+// does not support multiplexing, the connection opened for it is returned
+// as a regular connection and the pool stops multiplexing. It returns
+// false if the pool does not multiplex.
+func (cp *connectionPool) mkStream(host string) (*connection, bool, error) {
+	var stream *transport.MuxStream
+	var auth bool
+	for {
+		mux, muxAuth, cn, ok, err := cp.getMux(host)
+		if !ok || cn != nil || err != nil {
+			return cn, ok, err
+		}
+
+		// Stream ids of the connection can run out between getMux and
+		// Open, a new connection is dialed then
+		stream, err = mux.Open()
+		if err == transport.ErrorMuxExhausted {
+			continue
+		} else if err != nil {
+			return nil, true, err
+		}
+		auth = muxAuth
+		break
+	}
+
+	// Streams inherit the authentication of the multiplexed connection
+	cn := &connection{
+		conn:          stream,
+		pkt:           cp.newPacket(),
+		authenticated: auth,
+	}
+
+	// Negotiate payload compression of the stream
+	if err := cp.doCompression(cn); err != nil {
+		stream.Close()
+		return nil, true, err
+	}
+
+	return cn, true, nil
+}
+
+// getMux returns the multiplexed connection to host, dialing it if it is
+// not open, or if its stream ids have run out. The connection whose stream
+// ids have run out is closed by the mux once its streams are closed.
+// Connection is dialed without holding muxMutex, concurrent
+// callers wait for the connection being dialed. If the connection is not
+// multiplexed, it is returned as a regular connection.
+func (cp *connectionPool) getMux(host string) (*transport.Mux, bool, *connection, bool, error) {
+	for {
+		cp.muxMutex.Lock()
+		if cp.muxClosed {
+			cp.muxMutex.Unlock()
+			return nil, false, nil, true, ErrorClosedPool
+		}
+		if !cp.multiplex {
+			cp.muxMutex.Unlock()
+			return nil, false, nil, false, nil
+		}
+		if cp.mux != nil && !cp.mux.IsClosed() && !cp.mux.IsExhausted() {
+			mux, auth := cp.mux, cp.muxAuth
+			cp.muxMutex.Unlock()
+			return mux, auth, nil, true, nil
+		}
+		if dialing := cp.muxDialing; dialing != nil {
+			cp.muxMutex.Unlock()
+			<-dialing
+			continue
+		}
+		dialing := make(chan struct{})
+		cp.muxDialing = dialing
+		cp.muxMutex.Unlock()
+
+		mux, cn, err := cp.dialMux(host)
+
+		cp.muxMutex.Lock()
+		cp.muxDialing = nil
+		close(dialing)
+		if err == nil && cp.muxClosed {
+			err = ErrorClosedPool
+		}
+		if err != nil {
+			cp.muxMutex.Unlock()
+			if mux != nil {
+				mux.Close()
+			} else if cn != nil {
+				cn.conn.Close()
+			}
+			return nil, false, nil, true, err
+		}
+		if mux == nil {
+			logging.Infof("%v server does not support multiplexing, using regular connections\n",
+				cp.logPrefix)
+			cp.multiplex = false
+			cp.muxMutex.Unlock()
+			return nil, false, cn, true, nil
+		}
+		cp.mux, cp.muxAuth = mux, cn.authenticated
+		cp.muxMutex.Unlock()
+	}
+}
+
+// dialMux dials a connection to host and requests the server to multiplex
+// streams on it. If the server does not support multiplexing, the
+// connection is returned without a mux, with compression negotiated. Only
+// an authenticated connection is multiplexed.
+func (cp *connectionPool) dialMux(host string) (*transport.Mux, *connection, error) {
+	cn, err := cp.dial(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ok := false
+	if cn.authenticated {
+		if ok, err = cp.doMultiplex(cn); err != nil {
+			return nil, cn, err
+		}
+	}
+
+	if !ok {
+		if err := cp.doCompression(cn); err != nil {
+			return nil, cn, err
+		}
+		return nil, cn, nil
+	}
+
+	return transport.NewMux(cn.conn, true), cn, nil
+}
+
+// doMultiplex requests the server to multiplex streams on the connection.
+// It returns false if the server does not support multiplexing.
+func (cp *connectionPool) doMultiplex(conn *connection) (bool, error) {
+	heloReq := &protobuf.HeloRequest{
+		Version:   proto.Uint32(uint32(protobuf.ProtobufVersion())),
+		Multiplex: proto.Bool(true),
+	}
+
+	err := conn.pkt.Send(conn.conn, heloReq)
+	if err != nil {
+		logging.Errorf("%v doMultiplex pkt.Send returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return false, err
+	}
+
+	resp, err := conn.pkt.Receive(conn.conn)
+	if err != nil {
+		logging.Errorf("%v doMultiplex pkt.Receive returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return false, err
+	}
+
+	heloResp, ok := resp.(*protobuf.HeloResponse)
+	if !ok {
+		logging.Errorf("%v doMultiplex invalid helo response from %v for connection (%v,%v)",
+			cp.logPrefix, cp.host, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return false, ErrorProtocol
+	}
+
+	// <--- protobuf.StreamEndResponse or end of response
+	resp, err = conn.pkt.Receive(conn.conn)
+	if err != nil {
+		logging.Errorf("%v doMultiplex pkt.Receive returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return false, err
+	} else if resp != nil {
+		if _, ok := resp.(*protobuf.StreamEndResponse); !ok {
+			return false, ErrorProtocol
+		}
+	}
+
+	logging.Infof("%v doMultiplex multiplexing %v for connection (%v,%v)",
+		cp.logPrefix, heloResp.GetMultiplex(), conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+
+	return heloResp.GetMultiplex(), nil
+}
+
+// doCompression requests the server to compress responses sent on this
+// connection. Servers that do not support compression will respond with
+// CompressionNone and responses will be sent uncompressed.
+func (cp *connectionPool) doCompression(conn *connection) error {
+	if cp.compression == nil {
+		return nil
+	}
+	requested := cp.compression()
+	if requested == transport.CompressionNone {
+		return nil
+	}
+
+	heloReq := &protobuf.HeloRequest{
+		Version:     proto.Uint32(uint32(protobuf.ProtobufVersion())),
+		Compression: proto.Uint32(uint32(requested)),
+	}
+
+	err := conn.pkt.Send(conn.conn, heloReq)
+	if err != nil {
+		logging.Errorf("%v doCompression pkt.Send returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return err
+	}
+
+	var resp interface{}
+	resp, err = conn.pkt.Receive(conn.conn)
+	if err != nil {
+		logging.Errorf("%v doCompression pkt.Receive returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return err
+	}
+
+	heloResp, ok := resp.(*protobuf.HeloResponse)
+	if !ok {
+		logging.Errorf("%v doCompression invalid helo response from %v for connection (%v,%v)",
+			cp.logPrefix, cp.host, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return ErrorProtocol
+	}
+
+	// <--- protobuf.StreamEndResponse or end of response
+	resp, err = conn.pkt.Receive(conn.conn)
+	if err != nil {
+		logging.Errorf("%v doCompression pkt.Receive returns error %v for connection (%v,%v)",
+			cp.logPrefix, err, conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+		return err
+	} else if resp != nil {
+		if _, ok := resp.(*protobuf.StreamEndResponse); !ok {
+			return ErrorProtocol
+		}
+	}
+
+	compression := byte(heloResp.GetCompression())
+	logging.Verbosef("%v doCompression using %v compression for connection (%v,%v)",
+		cp.logPrefix, transport.CompressionName(compression),
+		conn.conn.LocalAddr(), conn.conn.RemoteAddr())
+
+	return nil
+}
+
 func (cp *connectionPool) doAuth(conn *connection) error {
 
 	// Check if auth is supported / configured before doing auth
@@ -270,6 +543,13 @@ func (cp *connectionPool) Close() (err error) {
 	for connectn := range cp.connections {
 		connectn.conn.Close()
 	}
+
+	cp.muxMutex.Lock()
+	cp.muxClosed = true
+	if cp.mux != nil {
+		cp.mux.Close()
+	}
+	cp.muxMutex.Unlock()
 	logging.Infof("%v ... stopped\n", cp.logPrefix)
 	return
 }
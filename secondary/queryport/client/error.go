@@ -27,20 +27,56 @@ var ErrorInvalidConsistency = errors.New("queryport.invalidConsistency")
 // ErrorExpectedTimestamp
 var ErrorExpectedTimestamp = errors.New("queryport.expectedTimestamp")
 
+// ErrorScanCursorNotSupported
+var ErrorScanCursorNotSupported = errors.New("queryport.scanCursorNotSupported")
+
+// ErrorInvalidTopK
+var ErrorInvalidTopK = errors.New("queryport.invalidTopK")
+
+// ErrorInvalidVectorResult
+var ErrorInvalidVectorResult = errors.New("queryport.invalidVectorResult")
+
+// ErrorWatchNotSupported
+var ErrorWatchNotSupported = errors.New("queryport.watchNotSupported")
+
+// ErrorIndexRebuilt
+var ErrorIndexRebuilt = errors.New("queryport.indexRebuilt")
+
+// ErrorAggrMergeLimit
+var ErrorAggrMergeLimit = errors.New("queryport.aggrMergeLimit")
+
 // These error strings need to be in sync with common.ErrIndexNotFound
 // and common.ErrIndexNotReady.
 var ErrIndexNotFound = fmt.Errorf("Index not found")
 var ErrIndexNotReady = fmt.Errorf("Index not ready for serving queries")
 
+// This error string needs to be in sync with common.ErrIndexerBusy.
+var ErrServerBusy = fmt.Errorf("Indexer busy. Please retry the request on another replica.")
+
+// This error string needs to be in sync with common.ErrIndexCorrupted.
+var ErrIndexCorrupted = fmt.Errorf("Index partition corrupted. Please retry the request on another replica.")
+
+// This error string needs to be in sync with common.ErrAdminCancel.
+var ErrAdminCancel = fmt.Errorf("Scan cancelled by admin")
+
 var errorDescriptions = map[string]string{
-	ErrorProtocol.Error():            "fatal protocol error with server",
-	ErrorNoHost.Error():              "All indexer replica is down or unavailable or unable to process request",
-	ErrorIndexNotFound.Error():       "index deleted or node hosting the index is down",
-	ErrorInstanceNotFound.Error():    "no instance available for the index",
-	ErrorClientUninitialized.Error(): "gsi client is not initialized",
-	ErrorNotImplemented.Error():      "client API not implemented",
-	ErrorInvalidConsistency.Error():  "supplied consistency is invalid",
-	ErrorExpectedTimestamp.Error():   "consistency timestamp is expected",
-	ErrIndexNotFound.Error():         "index is deleted or node hosting index is down",
-	ErrIndexNotReady.Error():         ErrIndexNotReady.Error(),
+	ErrorProtocol.Error():               "fatal protocol error with server",
+	ErrorNoHost.Error():                 "All indexer replica is down or unavailable or unable to process request",
+	ErrorIndexNotFound.Error():          "index deleted or node hosting the index is down",
+	ErrorInstanceNotFound.Error():       "no instance available for the index",
+	ErrorClientUninitialized.Error():    "gsi client is not initialized",
+	ErrorNotImplemented.Error():         "client API not implemented",
+	ErrorInvalidConsistency.Error():     "supplied consistency is invalid",
+	ErrorExpectedTimestamp.Error():      "consistency timestamp is expected",
+	ErrorScanCursorNotSupported.Error(): "resumable scan is not supported for index scattered across indexer nodes",
+	ErrorInvalidTopK.Error():            "topK of vector scan must be a positive value",
+	ErrorInvalidVectorResult.Error():    "vector scan result is missing distance",
+	ErrorWatchNotSupported.Error():      "range watch is not supported for index scattered across indexer nodes",
+	ErrorIndexRebuilt.Error():           "index is rebuilt with a different definition, query has to be planned again",
+	ErrorAggrMergeLimit.Error():         "number of groups exceeds queryport.client.scan.max_aggr_merge_groups while merging partial aggregates",
+	ErrIndexNotFound.Error():            "index is deleted or node hosting index is down",
+	ErrIndexNotReady.Error():            ErrIndexNotReady.Error(),
+	ErrServerBusy.Error():               "indexer is too busy to admit the scan at its priority",
+	ErrAdminCancel.Error():              "scan is cancelled by administrator on the indexer",
+	ErrIndexCorrupted.Error():           "index partition is found corrupted, and is rebuilt on the next restart of the indexer",
 }
@@ -106,6 +106,12 @@ type indexTopology struct {
 
 	defns      map[common.IndexDefnId]*mclient.IndexMetadata // existing indexes by defnId
 	allIndexes []*mclient.IndexMetadata                      // existing indexes as a slice
+
+	// rebuilds maps an index being rebuilt to its shadow index, once the
+	// shadow index is built, if their definitions are the same. replans
+	// maps an index being rebuilt to its built shadow index otherwise.
+	rebuilds map[common.IndexDefnId]common.IndexDefnId
+	replans  map[common.IndexDefnId]common.IndexDefnId
 }
 
 func newMetaBridgeClient(
@@ -423,6 +429,46 @@ func (b *metadataClient) AlterReplicaCount(action string, defnID uint64, planJSO
 	return b.mdClient.AlterReplicaCount(action, common.IndexDefnId(defnID), planJSON)
 }
 
+// RebuildIndex implements BridgeAccessor{} interface.
+func (b *metadataClient) RebuildIndex(
+	defnID uint64, exprType, whereExpr string,
+	secExprs []string, desc []bool, indexMissingLeadingKey bool,
+	planJSON []byte) (uint64, error) {
+
+	plan := make(map[string]interface{})
+	if planJSON != nil && len(planJSON) > 0 {
+		err := json.Unmarshal(planJSON, &plan)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newDefnID, err := b.mdClient.RebuildIndex(common.IndexDefnId(defnID),
+		exprType, whereExpr, secExprs, desc, indexMissingLeadingKey, plan)
+	if err == nil {
+		b.safeupdate(nil, false /*force*/)
+	}
+	return uint64(newDefnID), err
+}
+
+// CreateAggregate implements BridgeAccessor{} interface.
+func (b *metadataClient) CreateAggregate(defnID uint64, aggregate *common.IndexAggregate) error {
+	err := b.mdClient.CreateAggregate(common.IndexDefnId(defnID), aggregate)
+	if err == nil {
+		b.safeupdate(nil, false /*force*/)
+	}
+	return err
+}
+
+// DropAggregate implements BridgeAccessor{} interface.
+func (b *metadataClient) DropAggregate(defnID uint64, name string) error {
+	err := b.mdClient.DropAggregate(common.IndexDefnId(defnID), name)
+	if err == nil {
+		b.safeupdate(nil, false /*force*/)
+	}
+	return err
+}
+
 // DropIndex implements BridgeAccessor{} interface.
 func (b *metadataClient) DropIndex(defnID uint64, bucketName string) error {
 	err := b.mdClient.DropIndex(common.IndexDefnId(defnID), bucketName)
@@ -443,6 +489,13 @@ func (b *metadataClient) GetScanports() (queryports []string) {
 	return queryports
 }
 
+// IsRebuilt implements BridgeAccessor{} interface.
+func (b *metadataClient) IsRebuilt(defnID uint64) bool {
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+	_, ok := currmeta.replans[common.IndexDefnId(defnID)]
+	return ok
+}
+
 // GetScanport implements BridgeAccessor{} interface.
 func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDefnId]map[common.PartitionId]map[uint64]bool,
 	skips map[common.IndexDefnId]bool) (qp []string,
@@ -453,6 +506,12 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 
 	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
 
+	// route scans of a rebuilt index to its shadow index once built, if
+	// the shadow index has the same definition
+	if shadowID, ok := currmeta.rebuilds[common.IndexDefnId(defnID)]; ok {
+		defnID = uint64(shadowID)
+	}
+
 	defnID = b.pickEquivalent(defnID, skips)
 	if defnID == 0 {
 		return nil, 0, nil, nil, nil, 0, false
@@ -611,6 +670,74 @@ func (b *metadataClient) computeReplicas(topo map[common.IndexerId][]*mclient.In
 }
 
 // compute a map of eqivalent indexes for each index in 2i.
+// computeRebuilds returns the indexes without the shadow indexes of index
+// rebuild, and for shadow indexes that are built, the map of rebuilt index
+// to its shadow index having the same definition (rebuilds) or a different
+// definition (replans). Scans of the former are routed to the shadow index.
+// For the latter, the shadow index replaces the rebuilt index in the indexes,
+// and scans of the rebuilt index fail, so that the query is planned again
+// with the new definition. A shadow index is not equivalent to any index
+// until the rebuilt index is dropped.
+func (b *metadataClient) computeRebuilds(mindexes []*mclient.IndexMetadata,
+	defns map[common.IndexDefnId]*mclient.IndexMetadata,
+	equivalents map[common.IndexDefnId][]common.IndexDefnId) ([]*mclient.IndexMetadata,
+	map[common.IndexDefnId]common.IndexDefnId, map[common.IndexDefnId]common.IndexDefnId) {
+
+	rebuilds := make(map[common.IndexDefnId]common.IndexDefnId)
+	replans := make(map[common.IndexDefnId]common.IndexDefnId)
+	shadows := make(map[common.IndexDefnId]bool)
+
+	for _, index := range mindexes {
+		oldID := index.Definition.RebuildOf
+		old, ok := defns[oldID]
+		if oldID == 0 || !ok {
+			continue
+		}
+
+		defnID := index.Definition.DefnId
+		shadows[defnID] = true
+
+		built := len(index.Instances) != 0
+		for _, inst := range index.Instances {
+			if inst.State != common.INDEX_STATE_ACTIVE {
+				built = false
+			}
+		}
+		if built && b.equivalentIndex(old, index) {
+			rebuilds[oldID] = defnID
+		} else if built {
+			replans[oldID] = defnID
+		}
+	}
+
+	indexes := make([]*mclient.IndexMetadata, 0, len(mindexes))
+	for _, index := range mindexes {
+		defnID := index.Definition.DefnId
+		if _, ok := replans[defnID]; ok {
+			continue
+		}
+		if _, ok := replans[index.Definition.RebuildOf]; shadows[defnID] && !ok {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+
+	for defnID, equivalent := range equivalents {
+		if shadows[defnID] {
+			continue
+		}
+		filtered := make([]common.IndexDefnId, 0, len(equivalent))
+		for _, id := range equivalent {
+			if !shadows[id] {
+				filtered = append(filtered, id)
+			}
+		}
+		equivalents[defnID] = filtered
+	}
+
+	return indexes, rebuilds, replans
+}
+
 func (b *metadataClient) computeEquivalents(topo map[common.IndexerId][]*mclient.IndexMetadata) map[common.IndexDefnId][]common.IndexDefnId {
 
 	equivalentMap := make(map[common.IndexDefnId][]common.IndexDefnId)
@@ -714,6 +841,20 @@ func (b *metadataClient) equivalentIndex(
 		}
 	}
 
+	if len(d1.PartitionRanges) != len(d2.PartitionRanges) {
+		return false
+	}
+
+	for i, s1 := range d1.PartitionRanges {
+		if s1 != d2.PartitionRanges[i] {
+			return false
+		}
+	}
+
+	if !d1.VectorMeta.Equals(d2.VectorMeta) {
+		return false
+	}
+
 	if len(d1.Desc) != len(d2.Desc) {
 		return false
 	}
@@ -1771,6 +1912,9 @@ func (b *metadataClient) updateTopology(
 	// equivalent index
 	newmeta.equivalents = b.computeEquivalents(newmeta.topology)
 
+	// shadow index of rebuild
+	newmeta.allIndexes, newmeta.rebuilds, newmeta.replans = b.computeRebuilds(mindexes, newmeta.defns, newmeta.equivalents)
+
 	// loads - after creation, newmeta.loads is immutable, even though the
 	// content (loadHeuristics) is mutable
 	newmeta.loads = make(map[common.IndexInstId]*loadHeuristics)
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+)
+
+func TestComputeRebuilds(t *testing.T) {
+	index := func(defnID, rebuildOf common.IndexDefnId, state common.IndexState,
+		where string, secExprs ...string) *mclient.IndexMetadata {
+
+		return &mclient.IndexMetadata{
+			Definition: &common.IndexDefn{
+				DefnId:    defnID,
+				Bucket:    "default",
+				SecExprs:  secExprs,
+				WhereExpr: where,
+				RebuildOf: rebuildOf,
+			},
+			Instances: []*mclient.InstanceDefn{{State: state}},
+		}
+	}
+
+	mindexes := []*mclient.IndexMetadata{
+		index(1, 0, common.INDEX_STATE_ACTIVE, "", "a"),
+		index(2, 0, common.INDEX_STATE_ACTIVE, "", "a"),
+		index(3, 0, common.INDEX_STATE_ACTIVE, "", "b"),
+		index(4, 0, common.INDEX_STATE_ACTIVE, "", "c"),
+		// Identical definition, built
+		index(11, 1, common.INDEX_STATE_ACTIVE, "", "a"),
+		// Different where clause, built
+		index(13, 3, common.INDEX_STATE_ACTIVE, "b > 0", "b"),
+		// Different keys, not built
+		index(14, 4, common.INDEX_STATE_INITIAL, "", "c", "d"),
+	}
+	defns := make(map[common.IndexDefnId]*mclient.IndexMetadata)
+	for _, index := range mindexes {
+		defns[index.Definition.DefnId] = index
+	}
+	equivalents := map[common.IndexDefnId][]common.IndexDefnId{
+		1:  {2, 11},
+		2:  {1, 11},
+		11: {1, 2},
+	}
+
+	b := &metadataClient{}
+	indexes, rebuilds, replans := b.computeRebuilds(mindexes, defns, equivalents)
+
+	// The shadow index replaces the rebuilt index of a different definition
+	ids := make(map[common.IndexDefnId]bool)
+	for _, index := range indexes {
+		ids[index.Definition.DefnId] = true
+	}
+	if len(ids) != 4 || !ids[1] || !ids[2] || !ids[13] || !ids[4] {
+		t.Errorf("Expected indexes 1, 2, 13 and 4, received %v", ids)
+	}
+	if len(rebuilds) != 1 || rebuilds[1] != 11 {
+		t.Errorf("Expected scans of 1 to be routed to 11, received %v", rebuilds)
+	}
+	if len(replans) != 1 || replans[3] != 13 {
+		t.Errorf("Expected scans of 3 to be planned again, received %v", replans)
+	}
+	if len(equivalents[1]) != 1 || equivalents[1][0] != 2 {
+		t.Errorf("Expected shadow index to not be equivalent, received %v", equivalents[1])
+	}
+}
@@ -50,6 +50,15 @@ type GsiScanClient struct {
 }
 
 func NewGsiScanClient(queryport, cluster string, config common.Config, needsAuth *uint32) (*GsiScanClient, error) {
+	return newGsiScanClient(queryport, cluster, config, needsAuth, nil)
+}
+
+// newGsiScanClient creates a scan client whose connections request the
+// compression of settings at the time they are opened, or the compression
+// of config if settings is nil.
+func newGsiScanClient(queryport, cluster string, config common.Config, needsAuth *uint32,
+	settings *ClientSettings) (*GsiScanClient, error) {
+
 	t := time.Duration(config["connPoolAvailWaitTimeout"].Int())
 	c := &GsiScanClient{
 		queryport:          queryport,
@@ -69,6 +78,15 @@ func NewGsiScanClient(queryport, cluster string, config common.Config, needsAuth
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout, c.minPoolSizeWM, c.relConnBatchSize, config["keepAliveInterval"].Int(),
 		cluster, needsAuth)
+	if settings != nil {
+		c.pool.compression = settings.Compression
+	} else if compression, err := transport.ParseCompression(config["settings.compression"].String()); err == nil {
+		c.pool.compression = func() byte { return compression }
+	} else {
+		logging.Errorf("%v invalid compression %v, responses will not be compressed",
+			c.logPrefix, config["settings.compression"].String())
+	}
+	c.pool.multiplex = config["multiplex"].Bool()
 	logging.Infof("%v started ...\n", c.logPrefix)
 
 	if version, err := c.Helo(); err == nil || err == io.EOF {
@@ -110,18 +128,72 @@ func (c *GsiScanClient) Helo() (uint32, error) {
 
 // LookupStatistics for a single secondary-key.
 func (c *GsiScanClient) LookupStatistics(
-	defnID uint64, value common.SecondaryKey) (common.IndexStatistics, error) {
+	defnID uint64, requestId string, value common.SecondaryKey,
+	rollbackTime int64, partitions []common.PartitionId, retry bool) (common.IndexStatistics, error) {
 
 	// serialize lookup value.
 	val, err := json.Marshal(value)
 	if err != nil {
 		return nil, err
 	}
+	span := &protobuf.Span{Equals: [][]byte{val}}
+	return c.doStatistics(defnID, requestId, span, rollbackTime, partitions, retry)
+}
+
+// RangeStatistics for index range.
+func (c *GsiScanClient) RangeStatistics(
+	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
+	rollbackTime int64, partitions []common.PartitionId, retry bool) (common.IndexStatistics, error) {
+
+	// serialize low and high values.
+	l, err := json.Marshal(low)
+	if err != nil {
+		return nil, err
+	}
+	h, err := json.Marshal(high)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &protobuf.Span{
+		Range: &protobuf.Range{
+			Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
+		},
+	}
+	return c.doStatistics(defnID, requestId, span, rollbackTime, partitions, retry)
+}
+
+// RangeStatistics for index range on primary index
+func (c *GsiScanClient) RangeStatisticsPrimary(
+	defnID uint64, requestId string, low, high []byte, inclusion Inclusion,
+	rollbackTime int64, partitions []common.PartitionId, retry bool) (common.IndexStatistics, error) {
+
+	span := &protobuf.Span{
+		Range: &protobuf.Range{
+			Low: low, High: high, Inclusion: proto.Uint32(uint32(inclusion)),
+		},
+	}
+	return c.doStatistics(defnID, requestId, span, rollbackTime, partitions, retry)
+}
+
+func (c *GsiScanClient) doStatistics(
+	defnID uint64, requestId string, span *protobuf.Span,
+	rollbackTime int64, partitions []common.PartitionId, retry bool) (common.IndexStatistics, error) {
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
 	req := &protobuf.StatisticsRequest{
-		DefnID: proto.Uint64(defnID),
-		Span:   &protobuf.Span{Equals: [][]byte{val}},
+		DefnID:       proto.Uint64(defnID),
+		RequestId:    proto.String(requestId),
+		Span:         span,
+		Cons:         proto.Uint32(uint32(common.AnyConsistency)),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
 	}
-	resp, _, err := c.doRequestResponse(req, "", true)
+	resp, _, err := c.doRequestResponse(req, requestId, retry)
 	if err != nil {
 		return nil, err
 	}
@@ -133,39 +205,146 @@ func (c *GsiScanClient) LookupStatistics(
 	return statResp.GetStats(), nil
 }
 
-// RangeStatistics for index range.
-func (c *GsiScanClient) RangeStatistics(
-	defnID uint64, low, high common.SecondaryKey,
-	inclusion Inclusion) (common.IndexStatistics, error) {
+// LeadKeyHistogram returns the histogram of the leading key of partitions
+// of the index, with numBins bins or indexer default if numBins is 0.
+func (c *GsiScanClient) LeadKeyHistogram(
+	defnID uint64, requestId string, numBins uint32, rollbackTime int64,
+	partitions []common.PartitionId, retry bool) (*common.Histogram, error) {
+
+	if atomic.LoadUint32(&c.serverVersion) < common.INDEXER_76_VERSION {
+		return nil, ErrorNotImplemented
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.HistogramRequest{
+		DefnID:       proto.Uint64(defnID),
+		RequestId:    proto.String(requestId),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
+		NumBins:      proto.Uint32(numBins),
+	}
+	resp, _, err := c.doRequestResponse(req, requestId, retry)
+	if err != nil {
+		return nil, err
+	}
+	histResp := resp.(*protobuf.HistogramResponse)
+	if histResp.GetErr() != nil {
+		err = errors.New(histResp.GetErr().GetError())
+		return nil, err
+	}
+	return histResp.GetHistogram().ToHistogram(), nil
+}
+
+// Watch streams the changes to the entries of the index in the range
+// [low, high] to callb, starting with the entries of a snapshot no older
+// than resumeTs, if not nil. It returns when callb returns false, or with
+// the error that ended the watch.
+func (c *GsiScanClient) Watch(
+	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
+	resumeTs *TsConsistency, callb func(*protobuf.WatchResponse) bool) error {
+
+	if atomic.LoadUint32(&c.serverVersion) < common.INDEXER_76_VERSION {
+		return ErrorNotImplemented
+	}
 
 	// serialize low and high values.
 	l, err := json.Marshal(low)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	h, err := json.Marshal(high)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	req := &protobuf.StatisticsRequest{
-		DefnID: proto.Uint64(defnID),
-		Span: &protobuf.Span{
-			Range: &protobuf.Range{
-				Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
-			},
+	req := &protobuf.WatchRequest{
+		DefnID:    proto.Uint64(defnID),
+		RequestId: proto.String(requestId),
+		Range: &protobuf.Range{
+			Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
 		},
 	}
-	resp, _, err := c.doRequestResponse(req, "", true)
+	if resumeTs != nil {
+		req.ResumeTs = protobuf.NewTsConsistency(
+			resumeTs.Vbnos, resumeTs.Seqnos, resumeTs.Vbuuids, resumeTs.Crc64)
+	}
+
+	connectn, err := c.pool.Get()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	statResp := resp.(*protobuf.StatisticsResponse)
-	if statResp.GetErr() != nil {
-		err = errors.New(statResp.GetErr().GetError())
-		return nil, err
+	healthy := true
+	defer func() { c.pool.Return(connectn, healthy) }()
+
+	var authRetry bool
+
+WATCH_RETRY:
+
+	conn, pkt := connectn.conn, connectn.pkt
+	laddr := conn.LocalAddr()
+
+	// ---> protobuf.WatchRequest
+	if err = c.sendRequest(conn, pkt, req); err != nil {
+		fmsg := "%v Watch(%v) request transport failed `%v`\n"
+		logging.Errorf(fmsg, c.logPrefix, requestId, err)
+		healthy = false
+		return err
+	}
+
+	for {
+		// <--- protobuf.WatchResponse, indexer sends one at least every
+		// keepalive interval while the watch is idle.
+		c.trySetDeadline(conn, c.readDeadline)
+		resp, err := pkt.Receive(conn)
+		if err != nil {
+			fmsg := "%v Watch(%v) connection %q response transport failed `%v`\n"
+			logging.Errorf(fmsg, c.logPrefix, requestId, laddr, err)
+			healthy = false
+			return err
+		}
+
+		switch rsp := resp.(type) {
+		case *protobuf.WatchResponse:
+			if err := rsp.Error(); err != nil {
+				// Indexer ends the stream after the error
+				healthy = false
+				return err
+			}
+			if !callb(rsp) {
+				_, healthy = c.closeStream(conn, pkt, requestId)
+				return nil
+			}
+
+		case *protobuf.AuthResponse:
+			// See doRequestResponse for the handling of auth on upgrade.
+			atomic.StoreUint32(c.needsAuth, uint32(1))
+			if rsp.GetCode() == transport.AUTH_MISSING && !authRetry {
+				logging.Infof("%v server needs authentication information. Retrying "+
+					"request with auth req(%v)", c.logPrefix, requestId)
+				authRetry = true
+				if connectn, err = c.pool.Renew(connectn); err == nil {
+					goto WATCH_RETRY
+				}
+			}
+			healthy = false
+			return ErrorProtocol
+
+		case *protobuf.StreamEndResponse:
+			healthy = false
+			if err := rsp.Error(); err != nil {
+				return err
+			}
+			return ErrorProtocol
+
+		default:
+			healthy = false
+			return ErrorProtocol
+		}
 	}
-	return statResp.GetStats(), nil
 }
 
 // Lookup scan index between low and high.
@@ -207,6 +386,9 @@ func (c *GsiScanClient) Lookup(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -331,6 +513,9 @@ func (c *GsiScanClient) Range(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -370,6 +555,9 @@ func (c *GsiScanClient) RangePrimary(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -401,6 +589,9 @@ func (c *GsiScanClient) ScanAll(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
@@ -503,12 +694,17 @@ func (c *GsiScanClient) MultiScan(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
 
+	setScanCursor(req, scanParams)
+
 	return c.doStreamingWithRetry(requestId, req, callb, "MultiScan", retry)
 }
 
@@ -610,12 +806,17 @@ func (c *GsiScanClient) MultiScanPrimary(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
 
+	setScanCursor(req, scanParams)
+
 	return c.doStreamingWithRetry(requestId, req, callb, "MultiScanPrimary", retry)
 }
 
@@ -860,6 +1061,8 @@ func (c *GsiScanClient) MultiScanCount(
 		PartitionIds:     partnIds,
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 
 	if vector != nil {
@@ -961,6 +1164,8 @@ func (c *GsiScanClient) MultiScanCountPrimary(
 		PartitionIds:     partnIds,
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 
 	if vector != nil {
@@ -1114,12 +1319,18 @@ func (c *GsiScanClient) Scan3(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
 
+	setScanCursor(req, scanParams)
+	setVectorScan(req, scanParams)
+
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3", retry)
 }
 
@@ -1261,15 +1472,80 @@ func (c *GsiScanClient) Scan3Primary(
 		DataEncFmt:       proto.Uint32(uint32(dataEncFmt)),
 		SkipReadMetering: proto.Bool(scanParams["skipReadMetering"].(bool)),
 		User:             proto.String(scanParams["user"].(string)),
+		Trace:            scanTraceParam(scanParams),
+		Priority:         scanPriorityParam(scanParams),
+		Staleness:        scanStalenessParam(scanParams),
 	}
 	if vector != nil {
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
 
+	setScanCursor(req, scanParams)
+
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3Primary", retry)
 }
 
+// setScanCursor requests a scan cursor with every batch of rows and
+// resumes the scan from a previously returned cursor, as specified by
+// scanParams. See GsiClient.Scan3Resumable.
+func setScanCursor(req *protobuf.ScanRequest, scanParams map[string]interface{}) {
+	if needCursor, ok := scanParams["needCursor"].(bool); ok && needCursor {
+		req.NeedCursor = proto.Bool(true)
+	}
+	if cursor, ok := scanParams["resumeCursor"].([]byte); ok && len(cursor) > 0 {
+		req.ResumeCursor = cursor
+	}
+}
+
+// scanTraceParam returns the trace flag of the request, nil unless
+// scanParams asks for a per-phase timing breakdown of the scan.
+func scanTraceParam(scanParams map[string]interface{}) *bool {
+	if trace, ok := scanParams["trace"].(bool); ok && trace {
+		return proto.Bool(true)
+	}
+	return nil
+}
+
+// scanPriorityParam returns the priority class of the request, one of
+// interactive, batch or background, nil unless set in scanParams.
+func scanPriorityParam(scanParams map[string]interface{}) *string {
+	if priority, ok := scanParams["priority"].(string); ok && priority != "" {
+		return proto.String(priority)
+	}
+	return nil
+}
+
+// scanStalenessParam returns the staleness in nanoseconds accepted by a scan
+// with BoundedStalenessConsistency, nil unless set in scanParams.
+func scanStalenessParam(scanParams map[string]interface{}) *int64 {
+	if staleness, ok := scanParams["staleness"].(time.Duration); ok {
+		return proto.Int64(int64(staleness))
+	}
+	return nil
+}
+
+// setVectorScan turns the scan into a top-K nearest-neighbour scan of a
+// vector index, as specified by scanParams. See GsiClient.VectorScan.
+func setVectorScan(req *protobuf.ScanRequest, scanParams map[string]interface{}) {
+	query, ok := scanParams["queryVector"].([]float32)
+	if !ok {
+		return
+	}
+
+	vs := &protobuf.VectorScan{
+		QueryVector: query,
+		TopK:        proto.Int64(scanParams["topK"].(int64)),
+	}
+	if similarity, ok := scanParams["similarity"].(common.VectorSimilarity); ok && similarity != "" {
+		vs.Similarity = proto.String(string(similarity))
+	}
+	if nprobe, ok := scanParams["nprobe"].(int); ok && nprobe > 0 {
+		vs.Nprobe = proto.Int32(int32(nprobe))
+	}
+	req.VectorScan = vs
+}
+
 func (c *GsiScanClient) Close() error {
 	atomic.StoreUint32(&c.closed, 1)
 	return c.pool.Close()
@@ -17,6 +17,7 @@ import (
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/query/value"
 
 	//"runtime"
@@ -78,6 +79,7 @@ type RequestBroker struct {
 	// callback
 	scan    ScanRequestHandler
 	count   CountRequestHandler
+	stats   StatisticsRequestHandler
 	factory ResponseHandlerFactory
 	sender  ResponseSender
 	timer   ResponseTimer
@@ -122,12 +124,22 @@ type RequestBroker struct {
 	// pruned from index entry row before sending to N1QL
 	indexOrderPosPruneMap map[int64]bool
 
+	// resumable scan, called with cursor of the last row sent
+	cursorCb func(cursor []byte)
+
 	// stats
 	sendCount    int64
 	receiveCount int64
 	numIndexers  int64
 	dataEncFmt   uint32 // common.DataEncodingFormat
 
+	// statistics gathered across all connections
+	statistics *indexStatistics
+
+	// scan trace gathered across all connections
+	scanTrace   *common.ScanTrace
+	scanTraceCb func(trace *common.ScanTrace)
+
 	// Temporary bufferes needed for DecodeN1QLValues.
 	tmpbufs        []*[]byte
 	tmpbufsPoolIdx []uint32
@@ -188,6 +200,70 @@ func (b *RequestBroker) SetCountRequestHandler(handler CountRequestHandler) {
 	b.count = handler
 }
 
+//
+// Set StatisticsRequestHandler
+//
+func (b *RequestBroker) SetStatisticsRequestHandler(handler StatisticsRequestHandler) {
+
+	b.stats = handler
+}
+
+//
+// Get statistics gathered by the last statistics request
+//
+func (b *RequestBroker) GetStatistics() common.IndexStatistics {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.statistics == nil {
+		return &indexStatistics{}
+	}
+	return b.statistics
+}
+
+//
+// Set callback notified of the scan trace, once a traced scan completes
+//
+func (b *RequestBroker) SetScanTraceCallback(cb func(trace *common.ScanTrace)) {
+
+	b.scanTraceCb = cb
+}
+
+//
+// Add scan trace returned by one of the connections
+//
+func (b *RequestBroker) AddScanTrace(trace *common.ScanTrace) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.scanTrace == nil {
+		b.scanTrace = &common.ScanTrace{}
+	}
+	b.scanTrace.Merge(trace)
+}
+
+//
+// Get scan trace gathered by the last scan request
+//
+func (b *RequestBroker) GetScanTrace() *common.ScanTrace {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.scanTrace
+}
+
+// notifyScanTrace notifies the scan trace callback, if the last scan
+// returned a trace.
+func (b *RequestBroker) notifyScanTrace() {
+
+	if trace := b.GetScanTrace(); trace != nil && b.scanTraceCb != nil {
+		b.scanTraceCb(trace)
+	}
+}
+
 //
 // Set ResponseSender
 //
@@ -238,6 +314,11 @@ func (b *RequestBroker) SetDistinct(distinct bool) {
 	b.distinct = distinct
 }
 
+func (b *RequestBroker) SetCursorCallback(cb func(cursor []byte)) {
+
+	b.cursorCb = cb
+}
+
 //
 // Set sorted
 //
@@ -527,6 +608,8 @@ func (b *RequestBroker) reset() {
 	b.sendCount = 0
 	b.receiveCount = 0
 	b.numIndexers = 0
+	b.statistics = nil
+	b.scanTrace = nil
 
 	// scans
 	b.defn = nil
@@ -592,6 +675,9 @@ func (c *RequestBroker) scatter(clientMaker scanClientMaker, index *common.Index
 	} else if c.count != nil {
 		count, err, partial := c.scatterCount(client, index, targetInstId, rollback, partition, numPartition)
 		return count, err, partial, false
+	} else if c.stats != nil {
+		err, partial := c.scatterStatistics(client, index, targetInstId, rollback, partition, numPartition)
+		return 0, err, partial, false
 	}
 
 	e := fmt.Errorf("Intenral error: Fail to process request for index %v:%v:%v:%v.  Unknown request handler.", index.Bucket,
@@ -780,6 +866,18 @@ func (c *RequestBroker) scatterScan2(client []*GsiScanClient, index *common.Inde
 		}
 	}
 
+	// Rows are merged before they are sent, if needed
+	var merger *aggrMerger
+	sender := c.sender
+	if c.needsAggrMerge() {
+		merger = newAggrMerger(c.grpAggr, c.projections, c.GetDataEncodingFormat(),
+			settings.MaxAggrMergeGroups())
+		c.sender = merger.add
+		defer func() {
+			c.sender = sender
+		}()
+	}
+
 	donech_scatter := make([]chan *doneStatus, len(client))
 	for i, _ := range client {
 		donech_scatter[i] = make(chan *doneStatus, 1)
@@ -827,6 +925,19 @@ func (c *RequestBroker) scatterScan2(client []*GsiScanClient, index *common.Inde
 	errMap = c.GetError()
 	partial = c.IsPartial()
 
+	if merger != nil && len(errMap) == 0 {
+		err := merger.err
+		if err == nil {
+			tmpbuf, tmpbufPoolIdx = GetFromPools()
+			err = merger.flush(sender, tmpbuf)
+			PutInPools(tmpbuf, tmpbufPoolIdx)
+		}
+		if err != nil {
+			logging.Errorf("RequestBroker.scatterScan2: requestId %v error %v in merging partial aggregates", c.requestId, err)
+			errMap = c.makeErrorMap(targetInstId, partition, err)
+		}
+	}
+
 	return
 }
 
@@ -851,6 +962,27 @@ func (c *RequestBroker) scatterCount(client []*GsiScanClient, index *common.Inde
 	return
 }
 
+//
+// Scatter statistics requests over multiple connections
+//
+func (c *RequestBroker) scatterStatistics(client []*GsiScanClient, index *common.IndexDefn, targetInstId []uint64, rollback []int64,
+	partition [][]common.PartitionId, numPartition uint32) (err map[common.PartitionId]map[uint64]error, partial bool) {
+
+	donech := make([]chan *doneStatus, len(client))
+	for i, _ := range client {
+		donech[i] = make(chan *doneStatus, 1)
+		go c.statisticsSingleNode(ResponseHandlerId(i), client[i], index, targetInstId[i], rollback[i], partition[i], numPartition, donech[i])
+	}
+
+	for i, _ := range client {
+		status := <-donech[i]
+		partial = partial || status.partial
+	}
+
+	err = c.GetError()
+	return
+}
+
 // sort bubble sorts the heads of all queues into the rows
 // argument. If all queues have entries, it returns true and
 // the rows values will be fully sorted; otherwise it returns
@@ -1249,8 +1381,20 @@ func (c *RequestBroker) scanSingleNode(id ResponseHandlerId, client *GsiScanClie
 		return
 	}
 
+	handler := c.factory(id, instId, partition)
+	if c.cursorCb != nil {
+		// Rows from multiple nodes are merged by the client, cursor
+		// returned by one of the nodes does not identify the last row sent.
+		if c.useGather() {
+			c.Error(ErrorScanCursorNotSupported, instId, partition)
+			donech <- &doneStatus{err: ErrorScanCursorNotSupported, partial: false}
+			return
+		}
+		handler = c.makeCursorResponseHandler(handler)
+	}
+
 	begin := time.Now()
-	err, partial := c.scan(client, index, rollback, partition, c.factory(id, instId, partition))
+	err, partial := c.scan(client, index, rollback, partition, handler)
 	if err != nil {
 		// If there is any error, then stop the broker.
 		// This will force other go-routine to terminate.
@@ -1297,6 +1441,162 @@ func (c *RequestBroker) countSingleNode(id ResponseHandlerId, client *GsiScanCli
 	donech <- &doneStatus{err: err, partial: partial}
 }
 
+//
+// This function makes a statistics request through a single connection.
+//
+func (c *RequestBroker) statisticsSingleNode(id ResponseHandlerId, client *GsiScanClient, index *common.IndexDefn, instId uint64, rollback int64,
+	partition []common.PartitionId, numPartition uint32, donech chan *doneStatus) {
+
+	if len(partition) == 0 {
+		donech <- &doneStatus{err: nil, partial: false}
+		return
+	}
+
+	stats, err, partial := c.stats(client, index, rollback, partition)
+	if err != nil {
+		// If there is any error, then stop the broker.
+		// This will force other go-routine to terminate.
+		c.Partial(partial)
+		c.Error(err, instId, partition)
+	}
+
+	if err == nil && !partial && stats != nil {
+		if err = c.mergeStatistics(index, stats); err != nil {
+			c.Error(err, instId, partition)
+		}
+	}
+
+	donech <- &doneStatus{err: err, partial: partial}
+}
+
+//
+// Merge statistics from a single connection into the result.  Counts are
+// summed up.  Distinct count is exact within a connection but can only be
+// approximated (summed) across connections.  A connection that does not know
+// its min or max key (e.g. the span is larger than the entries it reads)
+// makes the merged min or max unknown.
+//
+func (c *RequestBroker) mergeStatistics(index *common.IndexDefn, stats common.IndexStatistics) error {
+
+	count, err := stats.Count()
+	if err != nil {
+		return err
+	}
+	distinct, err := stats.DistinctCount()
+	if err != nil {
+		return err
+	}
+	size, err := stats.Size()
+	if err != nil {
+		return err
+	}
+	min, err := stats.MinKey()
+	if err != nil {
+		return err
+	}
+	max, err := stats.MaxKey()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.statistics == nil {
+		c.statistics = &indexStatistics{}
+	}
+
+	result := c.statistics
+	if count == 0 {
+		return nil
+	}
+
+	if min == nil {
+		result.minUnknown = true
+		result.min = nil
+	} else if !result.minUnknown && (result.count == 0 || compareStatsKey(index, min, result.min) < 0) {
+		result.min = min
+	}
+	if max == nil {
+		result.maxUnknown = true
+		result.max = nil
+	} else if !result.maxUnknown && (result.count == 0 || compareStatsKey(index, max, result.max) > 0) {
+		result.max = max
+	}
+
+	result.count += count
+	result.distinct += distinct
+	result.size += size
+	return nil
+}
+
+//
+// Compare two keys returned by statistics request, honoring descending
+// index keys.
+//
+func compareStatsKey(index *common.IndexDefn, key1, key2 common.SecondaryKey) int {
+
+	for i := 0; i < len(key1) && i < len(key2); i++ {
+		cmp := qvalue.NewValue(key1[i]).Collate(qvalue.NewValue(key2[i]))
+		if cmp != 0 {
+			if index != nil && i < len(index.Desc) && index.Desc[i] {
+				return -cmp
+			}
+			return cmp
+		}
+	}
+
+	return len(key1) - len(key2)
+}
+
+//--------------------------
+// statistics
+//--------------------------
+
+// indexStatistics is the result of a statistics request, merged across
+// all the indexer nodes hosting the index.
+type indexStatistics struct {
+	count    int64
+	distinct int64
+	size     int64
+	min      common.SecondaryKey
+	max      common.SecondaryKey
+
+	// min or max is not known by some of the nodes
+	minUnknown bool
+	maxUnknown bool
+}
+
+// Count implements common.IndexStatistics{} method.
+func (s *indexStatistics) Count() (int64, error) {
+	return s.count, nil
+}
+
+// MinKey implements common.IndexStatistics{} method.
+func (s *indexStatistics) MinKey() (common.SecondaryKey, error) {
+	return s.min, nil
+}
+
+// MaxKey implements common.IndexStatistics{} method.
+func (s *indexStatistics) MaxKey() (common.SecondaryKey, error) {
+	return s.max, nil
+}
+
+// DistinctCount implements common.IndexStatistics{} method.
+func (s *indexStatistics) DistinctCount() (int64, error) {
+	return s.distinct, nil
+}
+
+// Size implements common.IndexStatistics{} method.
+func (s *indexStatistics) Size() (int64, error) {
+	return s.size, nil
+}
+
+// Bins implements common.IndexStatistics{} method.
+func (s *indexStatistics) Bins() ([]common.IndexStatistics, error) {
+	return nil, nil
+}
+
 //
 // When a response is received from a connection, the response will first be passed to the caller so the caller
 // has a chance to handle the rows first (e.g. backfill).    The caller will then forward the rows back to the
@@ -1416,6 +1716,32 @@ func (d *bypassResponseReader) GetReadUnits() uint64 {
 	return 0
 }
 
+func (d *bypassResponseReader) GetScanTrace() *common.ScanTrace {
+	return nil
+}
+
+// scanTraceReader returns the scan trace gathered across all connections,
+// after the last row of a traced scan.
+type scanTraceReader struct {
+	trace *common.ScanTrace
+}
+
+func (d *scanTraceReader) GetEntries(dataEncFmt common.DataEncodingFormat) (*common.ScanResultEntries, [][]byte, error) {
+	return common.NewScanResultEntries(dataEncFmt), nil, nil
+}
+
+func (d *scanTraceReader) Error() error {
+	return nil
+}
+
+func (d *scanTraceReader) GetReadUnits() uint64 {
+	return 0
+}
+
+func (d *scanTraceReader) GetScanTrace() *common.ScanTrace {
+	return d.trace
+}
+
 func makeDefaultRequestBroker(cb ResponseHandler,
 	dataEncFmt common.DataEncodingFormat) *RequestBroker {
 
@@ -1440,9 +1766,29 @@ func makeDefaultRequestBroker(cb ResponseHandler,
 	broker.SetResponseHandlerFactory(factory)
 	broker.SetResponseSender(sender)
 
+	if cb != nil {
+		broker.SetScanTraceCallback(func(trace *common.ScanTrace) {
+			cb(&scanTraceReader{trace: trace})
+		})
+	}
+
 	return broker
 }
 
+// makeCursorResponseHandler wraps handler to notify the cursor of the last
+// row, once all rows of a response are sent.
+func (c *RequestBroker) makeCursorResponseHandler(handler ResponseHandler) ResponseHandler {
+	return func(resp ResponseReader) bool {
+		cont := handler(resp)
+		if stream, ok := resp.(*protobuf.ResponseStream); ok && cont {
+			if cursor := stream.GetCursor(); len(cursor) > 0 {
+				c.cursorCb(cursor)
+			}
+		}
+		return cont
+	}
+}
+
 func makeDefaultResponseHandler(id ResponseHandlerId, broker *RequestBroker, instId uint64, partitions []common.PartitionId) ResponseHandler {
 
 	handler := func(resp ResponseReader) bool {
@@ -1453,6 +1799,9 @@ func makeDefaultResponseHandler(id ResponseHandlerId, broker *RequestBroker, ins
 			broker.Error(err, instId, partitions)
 			return false
 		}
+		if trace := resp.GetScanTrace(); trace != nil {
+			broker.AddScanTrace(trace)
+		}
 		skeys, pkeys, err := resp.GetEntries(broker.GetDataEncodingFormat())
 		if err != nil {
 			logging.Errorf("defaultResponseHandler: %v", err)
@@ -1496,6 +1845,15 @@ func (c *RequestBroker) filterPartitions(index *common.IndexDefn, partitions [][
 		return partitions
 	}
 
+	if index.PartitionScheme == common.RANGE {
+		filter := partitionKeyRange(c.requestId, partitionKeyPos, c.scans, index)
+		if len(filter) == 0 {
+			return partitions
+		}
+
+		return filterPartitionIds(partitions, filter)
+	}
+
 	partitionKeyValues := partitionKeyValues(c.requestId, partitionKeyPos, c.scans)
 	if len(partitionKeyValues) == 0 {
 		return partitions
@@ -1677,6 +2035,79 @@ func partitionKeyHash(partnKeyValues [][]interface{}, scans Scans, numPartition
 	return result
 }
 
+//
+// Generate a list of partitionId covering the partition key range of each
+// scan for RANGE partitioned index.   Range partitioned index has a single
+// partition key.  If any scan does not restrict the partition key, then the
+// request needs to be scatter-gather.
+//
+func partitionKeyRange(requestId string, partnKeyPos []int, scans Scans, index *common.IndexDefn) map[common.PartitionId]bool {
+
+	if len(partnKeyPos) != 1 || len(scans) == 0 {
+		return nil
+	}
+
+	// n1ql only push down span on primary key for metaId(), so a composite
+	// filter cannot be pruned on meta id
+	metaId := partnKeyPos[0] == MetaIdPos
+	pos := partnKeyPos[0]
+	if metaId {
+		pos = 0
+	}
+	desc := pos < len(index.Desc) && index.Desc[pos]
+
+	first := common.PartitionId(1)
+	last := common.PartitionId(len(index.PartitionRanges) + 1)
+
+	result := make(map[common.PartitionId]bool)
+	for _, scan := range scans {
+		if scan == nil {
+			continue
+		}
+
+		var low, high interface{}
+		if len(scan.Filter) > 0 {
+			if pos >= len(scan.Filter) || (metaId && len(scan.Filter) != 1) {
+				return nil
+			}
+			low, high = scan.Filter[pos].Low, scan.Filter[pos].High
+		} else if len(scan.Seek) > 0 {
+			if pos >= len(scan.Seek) || (metaId && len(scan.Seek) != 1) {
+				return nil
+			}
+			low, high = scan.Seek[pos], scan.Seek[pos]
+		} else {
+			return nil
+		}
+
+		start, end := first, last
+		lowBounded := low != common.MinUnbounded && low != nil
+		highBounded := high != common.MaxUnbounded && high != nil
+
+		if lowBounded && highBounded {
+			lv, hv := qvalue.NewValue(low), qvalue.NewValue(high)
+			if lv.Collate(hv) > 0 {
+				lv, hv = hv, lv
+			}
+			start = common.RangeValuePartition(lv, index.PartitionRanges)
+			end = common.RangeValuePartition(hv, index.PartitionRanges)
+		} else if !desc && lowBounded {
+			start = common.RangeValuePartition(qvalue.NewValue(low), index.PartitionRanges)
+		} else if !desc && highBounded {
+			end = common.RangeValuePartition(qvalue.NewValue(high), index.PartitionRanges)
+		}
+
+		logging.Debugf("scatter: requestId %v range partition low %v high %v partitions [%v, %v]",
+			requestId, logging.TagUD(low), logging.TagUD(high), start, end)
+
+		for partnId := start; partnId <= end; partnId++ {
+			result[partnId] = true
+		}
+	}
+
+	return result
+}
+
 //
 // Given the indexer-partitionId map, filter out the partitionId that are not used in the scans
 //
@@ -1908,6 +2339,208 @@ func (c *RequestBroker) isPartialAggregate(partitions [][]common.PartitionId, nu
 	return false
 }
 
+//--------------------------
+// partial aggregate merge
+//--------------------------
+
+//
+// Partial results of aggregates having intermediate state (AVG, ARRAY_AGG,
+// VARIANCE, STDDEV, COUNT_APPROX) cannot be merged by cbq-engine.  For such
+// queries, rows returned by the indexers are merged by group in the client
+// and only the final aggregates are sent to cbq-engine, once all indexers
+// are done.
+//
+// All groups are held in memory until then, so the scan fails with
+// ErrorAggrMergeLimit if the number of groups exceeds maxGroups.
+type aggrMerger struct {
+	cols       []aggrMergeCol // one for each column in projection order
+	groups     map[string]*aggrMergeRow
+	rows       []*aggrMergeRow // groups in the order they are received
+	maxGroups  uint64          // 0 if there is no limit
+	dataEncFmt common.DataEncodingFormat
+	err        error
+}
+
+type aggrMergeCol struct {
+	grpKey   bool
+	typ      common.AggrFuncType
+	distinct bool
+}
+
+type aggrMergeRow struct {
+	pkey  []byte
+	vals  []value.Value     // group keys
+	aggrs []common.AggrFunc // merged aggregates
+}
+
+func (c *RequestBroker) needsAggrMerge() bool {
+
+	if c.grpAggr == nil || !c.grpAggr.AllowPartialAggr || c.projections == nil {
+		return false
+	}
+
+	for _, aggr := range c.grpAggr.Aggrs {
+		if aggr.AggrFunc.HasPartialState() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newAggrMerger(grpAggr *GroupAggr, projections *IndexProjection,
+	dataEncFmt common.DataEncodingFormat, maxGroups uint64) *aggrMerger {
+
+	m := &aggrMerger{
+		cols:       make([]aggrMergeCol, len(projections.EntryKeys)),
+		groups:     make(map[string]*aggrMergeRow),
+		maxGroups:  maxGroups,
+		dataEncFmt: dataEncFmt,
+	}
+
+	for i, entryId := range projections.EntryKeys {
+		m.cols[i].grpKey = true
+		for _, aggr := range grpAggr.Aggrs {
+			if int64(aggr.EntryKeyId) == entryId {
+				m.cols[i] = aggrMergeCol{typ: aggr.AggrFunc, distinct: aggr.Distinct}
+				break
+			}
+		}
+	}
+
+	return m
+}
+
+// add is the ResponseSender used while rows are being merged
+func (m *aggrMerger) add(pkey []byte, mskey []value.Value, uskey common.ScanResultKey, tmpbuf *[]byte) (bool, *[]byte) {
+
+	var err error
+	var retBuf *[]byte
+
+	vals := mskey
+	if vals == nil {
+		if vals, err, retBuf = uskey.Get(tmpbuf); err != nil {
+			m.err = err
+			return false, nil
+		}
+	}
+
+	if len(vals) != len(m.cols) {
+		m.err = common.ErrInvalidAggrState
+		return false, retBuf
+	}
+
+	var key []byte
+	for i, col := range m.cols {
+		if col.grpKey {
+			b, _ := vals[i].MarshalJSON()
+			key = append(key, byte(vals[i].Type()))
+			key = append(key, b...)
+		}
+	}
+
+	row, ok := m.groups[string(key)]
+	if !ok {
+		if m.maxGroups > 0 && uint64(len(m.rows)) >= m.maxGroups {
+			m.err = ErrorAggrMergeLimit
+			return false, retBuf
+		}
+		row = &aggrMergeRow{pkey: pkey, vals: make([]value.Value, len(vals)),
+			aggrs: make([]common.AggrFunc, len(vals))}
+		m.groups[string(key)] = row
+		m.rows = append(m.rows, row)
+	}
+
+	for i, col := range m.cols {
+		if col.grpKey {
+			row.vals[i] = vals[i]
+		} else if row.aggrs[i], err = mergeAggr(row.aggrs[i], col, vals[i]); err != nil {
+			m.err = err
+			return false, retBuf
+		}
+	}
+
+	return true, retBuf
+}
+
+// flush sends the final aggregates of all groups to sender
+func (m *aggrMerger) flush(sender ResponseSender, tmpbuf *[]byte) error {
+
+	for _, row := range m.rows {
+		vals := make([]value.Value, len(m.cols))
+		for i, col := range m.cols {
+			if col.grpKey {
+				vals[i] = row.vals[i]
+			} else {
+				vals[i] = finalAggr(row.aggrs[i], col)
+			}
+		}
+
+		uskey, err := common.NewScanResultKey(vals, m.dataEncFmt)
+		if err != nil {
+			return err
+		}
+
+		cont, rb := sender(row.pkey, vals, uskey, tmpbuf)
+		if rb != nil {
+			tmpbuf = rb
+		}
+		if !cont {
+			break
+		}
+	}
+
+	return nil
+}
+
+//
+// Partial SUM, COUNT and COUNTN are added up and partial MIN and MAX
+// are aggregated again.  Other aggregates merge their state.
+//
+func mergeAggr(fn common.AggrFunc, col aggrMergeCol, val value.Value) (common.AggrFunc, error) {
+
+	switch col.typ {
+
+	case common.AGG_MIN, common.AGG_MAX:
+		if fn == nil {
+			return common.NewAggrFunc(col.typ, val, false, true), nil
+		}
+		fn.AddDeltaObj(val)
+
+	case common.AGG_SUM, common.AGG_COUNT, common.AGG_COUNTN:
+		if fn == nil {
+			return common.NewAggrFunc(common.AGG_SUM, val, false, true), nil
+		}
+		fn.AddDeltaObj(val)
+
+	default:
+		if fn == nil {
+			fn = common.NewPartialAggrFunc(col.typ, col.distinct, true)
+			if fn == nil {
+				return nil, common.ErrInvalidAggrState
+			}
+		}
+		return fn, fn.(common.PartialAggrFunc).MergeState(val)
+	}
+
+	return fn, nil
+}
+
+func finalAggr(fn common.AggrFunc, col aggrMergeCol) value.Value {
+
+	if fn == nil || !fn.IsValid() {
+		if col.typ == common.AGG_COUNT || col.typ == common.AGG_COUNTN {
+			return value.NewValue(int64(0))
+		}
+		return value.NewNullValue()
+	}
+
+	if v, ok := fn.Value().(value.Value); ok {
+		return v
+	}
+	return value.NewValue(fn.Value())
+}
+
 //
 // We cannot sort if it is pre-aggregate result, so set sorted to false.  Otherwise, the result
 // is sorted if there is an order-by clause.
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestMergeStatistics(t *testing.T) {
+	index := &common.IndexDefn{}
+
+	nodes := []*indexStatistics{
+		{count: 10, distinct: 5, size: 100, min: common.SecondaryKey{"b"}, max: common.SecondaryKey{"x"}},
+		{count: 20, distinct: 8, size: 200, min: common.SecondaryKey{"a"}, max: common.SecondaryKey{"y"}},
+		{count: 0},
+	}
+
+	b := NewRequestBroker("", 0, 0)
+	for _, stats := range nodes {
+		if err := b.mergeStatistics(index, stats); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	result := b.statistics
+	if result.count != 30 || result.distinct != 13 || result.size != 300 {
+		t.Errorf("Unexpected merged counts %+v", result)
+	}
+	if compareStatsKey(index, result.min, common.SecondaryKey{"a"}) != 0 ||
+		compareStatsKey(index, result.max, common.SecondaryKey{"y"}) != 0 {
+		t.Errorf("Unexpected merged min %v max %v", result.min, result.max)
+	}
+
+	// A node whose stats were truncated makes the merged min and max unknown,
+	// whichever node responds first
+	truncated := &indexStatistics{count: 1000, distinct: 100, size: 10000}
+	for _, order := range [][]*indexStatistics{
+		{nodes[0], truncated, nodes[1]},
+		{truncated, nodes[0], nodes[1]},
+		{nodes[0], nodes[1], truncated},
+	} {
+		b := NewRequestBroker("", 0, 0)
+		for _, stats := range order {
+			if err := b.mergeStatistics(index, stats); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+		if min, _ := b.statistics.MinKey(); min != nil {
+			t.Errorf("Expected unknown min, got %v", min)
+		}
+		if max, _ := b.statistics.MaxKey(); max != nil {
+			t.Errorf("Expected unknown max, got %v", max)
+		}
+		if count, _ := b.statistics.Count(); count != 1030 {
+			t.Errorf("Expected count 1030, got %v", count)
+		}
+	}
+
+	// Only max is unknown for a range of a single lookup key
+	b = NewRequestBroker("", 0, 0)
+	b.mergeStatistics(index, nodes[0])
+	b.mergeStatistics(index, &indexStatistics{count: 1000, min: common.SecondaryKey{"c"}})
+	if compareStatsKey(index, b.statistics.min, common.SecondaryKey{"b"}) != 0 || b.statistics.max != nil {
+		t.Errorf("Unexpected merged min %v max %v", b.statistics.min, b.statistics.max)
+	}
+}
+
+func TestPartitionKeyRange(t *testing.T) {
+	index := &common.IndexDefn{
+		PartitionScheme: common.RANGE,
+		PartitionRanges: []string{`10`, `20`, `30`},
+	}
+
+	// partitionOf returns the partition the row of the value is flushed to,
+	// from the partition key as encoded by the projector
+	partitionOf := func(v interface{}) common.PartitionId {
+		key, err := json.Marshal([]interface{}{v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return common.RangeKeyPartition(key, index.PartitionRanges)
+	}
+
+	filter := func(low, high interface{}) *Scan {
+		return &Scan{Filter: []*CompositeElementFilter{{Low: low, High: high, Inclusion: Both}}}
+	}
+
+	testcases := []struct {
+		scans Scans
+		rows  []interface{}
+		partn []common.PartitionId
+	}{
+		{Scans{filter(12, 15)}, []interface{}{12, 15}, []common.PartitionId{2}},
+		{Scans{filter(5, 25)}, []interface{}{5, 10, 20, 25}, []common.PartitionId{1, 2, 3}},
+		{Scans{filter(35, common.MaxUnbounded)}, []interface{}{35, 100}, []common.PartitionId{4}},
+		{Scans{filter(common.MinUnbounded, 10)}, []interface{}{nil, 0, 10}, []common.PartitionId{1, 2}},
+		{Scans{filter(1, 1), filter(31, 31)}, []interface{}{1, 31}, []common.PartitionId{1, 4}},
+		{Scans{{Seek: common.SecondaryKey{22}}}, []interface{}{22}, []common.PartitionId{3}},
+	}
+
+	for i, tc := range testcases {
+		result := partitionKeyRange("", []int{0}, tc.scans, index)
+		if len(result) != len(tc.partn) {
+			t.Errorf("case %v: expected partitions %v, got %v", i, tc.partn, result)
+		}
+		for _, partnId := range tc.partn {
+			if !result[partnId] {
+				t.Errorf("case %v: expected partitions %v, got %v", i, tc.partn, result)
+			}
+		}
+
+		// The partitions scanned must own every row matching the scans
+		for _, row := range tc.rows {
+			if partnId := partitionOf(row); !result[partnId] {
+				t.Errorf("case %v: row %v of partition %v is not scanned, got %v", i, row, partnId, result)
+			}
+		}
+	}
+
+	// Scans that do not restrict the partition key are scatter-gather
+	if result := partitionKeyRange("", []int{1}, Scans{filter(1, 2)}, index); result != nil {
+		t.Errorf("Expected scatter-gather, got %v", result)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/logging/systemevent"
 	"github.com/couchbase/indexing/secondary/planner"
+	"github.com/couchbase/indexing/secondary/transport"
 )
 
 type ClientSettings struct {
@@ -30,6 +31,8 @@ type ClientSettings struct {
 	prune_replica  int32
 	queueSize      uint64
 	concurrency    uint32
+	maxAggrGroups  uint64
+	compression    uint32
 	usePlanner     uint32
 	config         common.Config
 	cancelCh       chan struct{}
@@ -186,6 +189,21 @@ func (s *ClientSettings) handleSettings(config common.Config) {
 		logging.Errorf("ClientSettings: invalid setting value for queueSize=%v", queueSize)
 	}
 
+	maxAggrGroups := config["queryport.client.scan.max_aggr_merge_groups"].Int()
+	if maxAggrGroups >= 0 {
+		atomic.StoreUint64(&s.maxAggrGroups, uint64(maxAggrGroups))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for max_aggr_merge_groups=%v", maxAggrGroups)
+	}
+
+	compression, err := transport.ParseCompression(config["queryport.client.settings.compression"].String())
+	if err == nil {
+		atomic.StoreUint32(&s.compression, uint32(compression))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for compression=%v",
+			config["queryport.client.settings.compression"].String())
+	}
+
 	concurrency := config["queryport.client.scan.max_concurrency"].Int()
 	if concurrency >= 0 {
 		atomic.StoreUint32(&s.concurrency, uint32(concurrency))
@@ -412,10 +430,20 @@ func (s *ClientSettings) ScanQueueSize() uint64 {
 	return atomic.LoadUint64(&s.queueSize)
 }
 
+func (s *ClientSettings) MaxAggrMergeGroups() uint64 {
+	return atomic.LoadUint64(&s.maxAggrGroups)
+}
+
 func (s *ClientSettings) MaxConcurrency() uint32 {
 	return atomic.LoadUint32(&s.concurrency)
 }
 
+// Compression returns the compression requested for the scan responses of
+// new connections.
+func (s *ClientSettings) Compression() byte {
+	return byte(atomic.LoadUint32(&s.compression))
+}
+
 func (s *ClientSettings) AllowCJsonScanFormat() bool {
 	return atomic.LoadUint32(&s.allowCJsonScanFormat) == 1
 }
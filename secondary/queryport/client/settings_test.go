@@ -0,0 +1,32 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/transport"
+)
+
+func TestClientSettingsCompression(t *testing.T) {
+	s := NewClientSettings(false)
+	if compression := s.Compression(); compression != transport.CompressionNone {
+		t.Fatalf("Expected no compression by default, got %v", transport.CompressionName(compression))
+	}
+
+	// New connections of the pool request the compression of the settings
+	// at the time they are opened
+	cp := &connectionPool{compression: s.Compression}
+
+	config := s.config.Clone()
+	config.SetValue("queryport.client.settings.compression", "snappy")
+	s.handleSettings(config)
+	if compression := cp.compression(); compression != transport.CompressionSnappy {
+		t.Errorf("Expected snappy compression, got %v", transport.CompressionName(compression))
+	}
+
+	// Invalid values keep the current compression
+	config.SetValue("queryport.client.settings.compression", "lz4")
+	s.handleSettings(config)
+	if compression := cp.compression(); compression != transport.CompressionSnappy {
+		t.Errorf("Expected snappy compression, got %v", transport.CompressionName(compression))
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -50,6 +51,11 @@ const DONEREQUEST = 1
 const BACKFILLPREFIX = "scan-results"
 const BACKFILLTICK = 5
 
+// n1qlPrimaryScanPriority is the priority class, for admission at the indexer,
+// of the full scans of primary indexes, which are mostly of analytic queries
+// and should not hold up the point lookups and range scans of other queries.
+const n1qlPrimaryScanPriority = "batch"
+
 // ErrorIndexEmpty is index not initialized.
 var ErrorIndexEmpty = errors.NewError(
 	fmt.Errorf("gsi.indexEmpty"), "Fatal null reference to index")
@@ -951,6 +957,9 @@ type secondaryIndex struct {
 
 	indexMissingLeadingKey bool
 
+	// precomputed aggregates
+	aggregates []*c.IndexAggregate
+
 	indexStatsHolder *mclient.IndexStatsHolder
 }
 
@@ -998,6 +1007,7 @@ func newSecondaryIndexFromMetaData(
 		scheduled:              imd.Scheduled,
 		schedFail:              imd.ScheduleFailed,
 		indexMissingLeadingKey: indexDefn.IndexMissingLeadingKey,
+		aggregates:             indexDefn.Aggregates,
 		indexStatsHolder:       imd.Stats,
 	}
 
@@ -1283,7 +1293,8 @@ func (si *secondaryIndex) ScanEntries(
 	sender := conn.Sender()
 	skipReadMetering := conn.SkipMetering()
 	user := conn.User()
-	var scanParams = map[string]interface{}{"skipReadMetering": skipReadMetering, "user": user}
+	var scanParams = map[string]interface{}{"skipReadMetering": skipReadMetering, "user": user,
+		"priority": n1qlPrimaryScanPriority}
 	var backfillSync int64
 
 	var waitGroup sync.WaitGroup
@@ -1519,15 +1530,41 @@ type secondaryIndex3 struct {
 // CreateAggregate implement Index3 interface.
 func (si *secondaryIndex3) CreateAggregate(requestId string, groupAggs *datastore.IndexGroupAggregates,
 	with value.Value) errors.Error {
-	return errors.NewError(fmt.Errorf("Create Aggregate not supported"), "")
+
+	if with != nil {
+		return errors.NewError(fmt.Errorf("WITH clause is not supported for aggregate"), "GSI CreateAggregate()")
+	}
+
+	aggregate, err := n1qlgroupaggrtoindexaggr(groupAggs)
+	if err != nil {
+		return errors.NewError(err, "GSI CreateAggregate()")
+	}
+
+	if err := si.gsi.gsiClient.CreateAggregate(si.defnID, aggregate); err != nil {
+		return errors.NewError(err, "GSI CreateAggregate()")
+	}
+	return nil
 }
 
+// DropAggregate implement Index3 interface.
 func (si *secondaryIndex3) DropAggregate(requestId, name string) errors.Error {
-	return errors.NewError(fmt.Errorf("Drops Aggregate not supported"), "")
+
+	if err := si.gsi.gsiClient.DropAggregate(si.defnID, name); err != nil {
+		return errors.NewError(err, "GSI DropAggregate()")
+	}
+	return nil
 }
 
+// Aggregates implement Index3 interface.
 func (si *secondaryIndex3) Aggregates() ([]datastore.IndexGroupAggregates, errors.Error) {
-	return nil, errors.NewError(fmt.Errorf("Precomputed Aggregates not supported"), "")
+
+	aggregates := make([]datastore.IndexGroupAggregates, 0, len(si.aggregates))
+	for _, ia := range si.aggregates {
+		if groupAggs := indexaggrton1ql(ia, si.secExprs); groupAggs != nil {
+			aggregates = append(aggregates, *groupAggs)
+		}
+	}
+	return aggregates, nil
 }
 
 func (si *secondaryIndex3) PartitionKeys() (*datastore.IndexPartition, errors.Error) {
@@ -1557,10 +1594,26 @@ func (si *secondaryIndex3) Scan3(
 	cons datastore.ScanConsistency, vector timestamp.Vector,
 	conn *datastore.IndexConnection) {
 
+	si.scan3(requestId, spans, reverse, distinctAfterProjection, projection, offset, limit,
+		groupAggs, indexOrders, cons, vector, conn, "")
+}
+
+// scan3 is Scan3 at the priority class of the scan for admission at the
+// indexer, or at the default class if priority is "".
+func (si *secondaryIndex3) scan3(
+	requestId string, spans datastore.Spans2, reverse, distinctAfterProjection bool,
+	projection *datastore.IndexProjection, offset, limit int64,
+	groupAggs *datastore.IndexGroupAggregates, indexOrders datastore.IndexKeyOrders,
+	cons datastore.ScanConsistency, vector timestamp.Vector,
+	conn *datastore.IndexConnection, priority string) {
+
 	sender := conn.Sender()
 	skipReadMetering := conn.SkipMetering()
 	user := conn.User()
 	var scanParams = map[string]interface{}{"skipReadMetering": skipReadMetering, "user": user}
+	if priority != "" {
+		scanParams["priority"] = priority
+	}
 	var backfillSync int64
 	var waitGroup sync.WaitGroup
 	var broker *qclient.RequestBroker
@@ -1681,8 +1734,8 @@ func (si *secondaryIndex3) ScanEntries3(
 	}
 
 	spans := datastore.Spans2{&datastore.Span2{}} // Span for full table scan
-	si.Scan3(requestId, spans, false, false, nil, offset, limit, nil, indexOrders,
-		cons, vector, conn)
+	si.scan3(requestId, spans, false, false, nil, offset, limit, nil, indexOrders,
+		cons, vector, conn, n1qlPrimaryScanPriority)
 }
 
 //-------------------------------------
@@ -1711,7 +1764,29 @@ func (si *secondaryIndex4) StorageMode() (datastore.IndexStorageMode, errors.Err
 }
 
 func (si *secondaryIndex4) LeadKeyHistogram(requestId string) (*datastore.Histogram, errors.Error) {
-	return nil, errors.NewNotImplemented("Index4 LeadKeyHistogram")
+
+	if si == nil {
+		return nil, ErrorIndexEmpty
+	}
+	client := si.gsi.gsiClient
+
+	if err := si.CheckScheduled(); err != nil {
+		return nil, n1qlError(client, err)
+	}
+
+	hist, e := client.LeadKeyHistogram(si.defnID, requestId, 0)
+	if e != nil {
+		if e == qclient.ErrorNotImplemented {
+			return nil, errors.NewNotImplemented("Index4 LeadKeyHistogram")
+		}
+		return nil, n1qlError(client, e)
+	}
+
+	n1qlHist, e := gsihistogramton1ql(hist)
+	if e != nil {
+		return nil, errors.NewError(e, "Index4 LeadKeyHistogram")
+	}
+	return n1qlHist, nil
 }
 
 func (si *secondaryIndex4) StorageStatistics(requestid string) ([]map[datastore.IndexStatType]value.Value,
@@ -2074,6 +2149,9 @@ func n1qlError(client *qclient.GsiClient, err error) errors.Error {
 		return errors.NewCbIndexNotFoundError(err)
 	case qclient.ErrIndexNotFound.Error():
 		return errors.NewCbIndexNotFoundError(err)
+	case qclient.ErrorIndexRebuilt.Error():
+		// The index is replaced by its shadow index of a new definition
+		return errors.NewReprepareError(err)
 	}
 
 	return errors.NewError(err, client.DescribeError(err))
@@ -2418,6 +2496,80 @@ func n1qlgroupaggrtogsi(groupAggs *datastore.IndexGroupAggregates) *qclient.Grou
 	return ga
 }
 
+// n1qlgroupaggrtoindexaggr converts group aggregate to precomputed index
+// aggregate, which supports only group by and aggregates on index keys.
+func n1qlgroupaggrtoindexaggr(groupAggs *datastore.IndexGroupAggregates) (*c.IndexAggregate, error) {
+	if groupAggs == nil {
+		return nil, fmt.Errorf("Aggregate is not specified")
+	}
+
+	ia := &c.IndexAggregate{Name: groupAggs.Name}
+	for _, grp := range groupAggs.Group {
+		if grp.KeyPos < 0 {
+			return nil, fmt.Errorf("Aggregate %v can group by index keys only", groupAggs.Name)
+		}
+		ia.Group = append(ia.Group, int32(grp.KeyPos))
+	}
+
+	for _, aggr := range groupAggs.Aggregates {
+		if aggr.KeyPos < 0 || aggr.Distinct {
+			return nil, fmt.Errorf("Aggregate %v can aggregate index keys only, without DISTINCT", groupAggs.Name)
+		}
+		ia.Aggrs = append(ia.Aggrs, c.IndexAggregateKey{
+			AggrFunc: n1qlaggrtypetogsi(aggr.Operation),
+			KeyPos:   int32(aggr.KeyPos),
+		})
+	}
+
+	return ia, nil
+}
+
+// indexaggrton1ql converts precomputed index aggregate to group aggregate,
+// with group keys followed by aggregates as entry keys.  It returns nil if
+// an aggregate is not supported by n1ql.
+func indexaggrton1ql(ia *c.IndexAggregate, secExprs expression.Expressions) *datastore.IndexGroupAggregates {
+
+	keyExpr := func(pos int32) expression.Expression {
+		if int(pos) < len(secExprs) {
+			return secExprs[pos]
+		}
+		return nil
+	}
+
+	groupAggs := &datastore.IndexGroupAggregates{Name: ia.Name}
+	depends := make(map[int]bool)
+
+	for i, pos := range ia.Group {
+		groupAggs.Group = append(groupAggs.Group, &datastore.IndexGroupKey{
+			EntryKeyId: i,
+			KeyPos:     int(pos),
+			Expr:       keyExpr(pos),
+		})
+		depends[int(pos)] = true
+	}
+
+	for i, ak := range ia.Aggrs {
+		op, ok := gsiaggrtypeton1ql(ak.AggrFunc)
+		if !ok {
+			return nil
+		}
+		groupAggs.Aggregates = append(groupAggs.Aggregates, &datastore.IndexAggregate{
+			Operation:  op,
+			EntryKeyId: len(ia.Group) + i,
+			KeyPos:     int(ak.KeyPos),
+			Expr:       keyExpr(ak.KeyPos),
+		})
+		depends[int(ak.KeyPos)] = true
+	}
+
+	for pos := range depends {
+		groupAggs.DependsOnIndexKeys = append(groupAggs.DependsOnIndexKeys, pos)
+	}
+	sort.Ints(groupAggs.DependsOnIndexKeys)
+
+	return groupAggs
+}
+
 func n1qlindexordertogsi(indexOrders datastore.IndexKeyOrders) *qclient.IndexKeyOrder {
 
 	if len(indexOrders) == 0 {
@@ -2437,6 +2589,14 @@ func n1qlindexordertogsi(indexOrders datastore.IndexKeyOrders) *qclient.IndexKey
 	return order
 }
 
+// Aggregates computed by the indexer that have no datastore constant, named
+// after the n1ql aggregate functions they compute.
+const (
+	n1qlAggVariance    = datastore.AggregateType("VARIANCE")
+	n1qlAggStddev      = datastore.AggregateType("STDDEV")
+	n1qlAggCountApprox = datastore.AggregateType("APPROX_COUNT_DISTINCT")
+)
+
 func n1qlaggrtypetogsi(aggrType datastore.AggregateType) c.AggrFuncType {
 	switch aggrType {
 	case datastore.AGG_MIN:
@@ -2449,11 +2609,85 @@ func n1qlaggrtypetogsi(aggrType datastore.AggregateType) c.AggrFuncType {
 		return c.AGG_COUNT
 	case datastore.AGG_COUNTN:
 		return c.AGG_COUNTN
+	case datastore.AGG_AVG:
+		return c.AGG_AVG
+	case datastore.AGG_ARRAY:
+		return c.AGG_ARRAY_AGG
+	case n1qlAggVariance:
+		return c.AGG_VARIANCE
+	case n1qlAggStddev:
+		return c.AGG_STDDEV
+	case n1qlAggCountApprox:
+		return c.AGG_COUNT_APPROX
 	default:
 		return c.AGG_INVALID
 	}
 }
 
+func gsiaggrtypeton1ql(aggrType c.AggrFuncType) (datastore.AggregateType, bool) {
+	switch aggrType {
+	case c.AGG_MIN:
+		return datastore.AGG_MIN, true
+	case c.AGG_MAX:
+		return datastore.AGG_MAX, true
+	case c.AGG_SUM:
+		return datastore.AGG_SUM, true
+	case c.AGG_COUNT:
+		return datastore.AGG_COUNT, true
+	case c.AGG_COUNTN:
+		return datastore.AGG_COUNTN, true
+	case c.AGG_AVG:
+		return datastore.AGG_AVG, true
+	case c.AGG_ARRAY_AGG:
+		return datastore.AGG_ARRAY, true
+	case c.AGG_VARIANCE:
+		return n1qlAggVariance, true
+	case c.AGG_STDDEV:
+		return n1qlAggStddev, true
+	case c.AGG_COUNT_APPROX:
+		return n1qlAggCountApprox, true
+	default:
+		return datastore.AGG_COUNT, false
+	}
+}
+
+// gsihistogramton1ql converts the leading key histogram to
+// datastore.Histogram, with collatejson encoded bin boundaries decoded to
+// N1QL values.
+func gsihistogramton1ql(hist *c.Histogram) (*datastore.Histogram, error) {
+
+	codec := collatejson.NewCodec(16)
+	decode := func(code []byte) (value.Value, error) {
+		text, err := codec.Decode(code, make([]byte, 0, 3*len(code)+collatejson.MinBufferSize))
+		if err != nil {
+			return nil, err
+		}
+		return value.NewValue(text), nil
+	}
+
+	n1qlHist := &datastore.Histogram{
+		NumDocs: hist.NumDocs,
+		Bins:    make([]*datastore.HistogramBin, 0, len(hist.Bins)),
+	}
+	for _, bin := range hist.Bins {
+		minVal, err := decode(bin.Min)
+		if err != nil {
+			return nil, err
+		}
+		maxVal, err := decode(bin.Max)
+		if err != nil {
+			return nil, err
+		}
+		n1qlHist.Bins = append(n1qlHist.Bins, &datastore.HistogramBin{
+			Min:      minVal,
+			Max:      maxVal,
+			Size:     bin.Size,
+			Distinct: bin.Distinct,
+		})
+	}
+	return n1qlHist, nil
+}
+
 func gsistatston1ql(stats []map[string]interface{}) []map[datastore.IndexStatType]value.Value {
 	storageStats := make([]map[datastore.IndexStatType]value.Value, 0)
 	for _, partitionStats := range stats {
@@ -2,6 +2,9 @@ package n1ql
 
 import (
 	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/datastore"
 )
 
 func TestIndexConfig(t *testing.T) {
@@ -66,3 +69,23 @@ func validateConf(t *testing.T, preconf, postconf map[string]interface{}) {
 		t.Errorf("config mismatch %v %v", preconf, postconf)
 	}
 }
+
+func TestAggrTypeRoundTrip(t *testing.T) {
+	for typ := c.AGG_MIN; typ < c.AGG_INVALID; typ++ {
+		op, ok := gsiaggrtypeton1ql(typ)
+		if !ok {
+			t.Errorf("Aggregate %v is not supported by n1ql", typ)
+			continue
+		}
+		if back := n1qlaggrtypetogsi(op); back != typ {
+			t.Errorf("Aggregate %v round trips through %v to %v", typ, op, back)
+		}
+	}
+
+	if _, ok := gsiaggrtypeton1ql(c.AGG_INVALID); ok {
+		t.Errorf("Expected invalid aggregate to not be supported by n1ql")
+	}
+	if typ := n1qlaggrtypetogsi(datastore.AggregateType("MEDIAN")); typ != c.AGG_INVALID {
+		t.Errorf("Expected unknown aggregate to be invalid, got %v", typ)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	c "github.com/couchbase/indexing/secondary/common"
 
 	"github.com/couchbase/cbauth"
+	"github.com/golang/protobuf/proto"
 
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/transport"
@@ -372,6 +373,78 @@ func (s *Server) handleConnection(conn net.Conn) {
 		tcpconn.SetKeepAlivePeriod(s.keepAliveInterval)
 	}
 
+	// Client requesting streams multiplexed on the connection sends
+	// HeloRequest as the first request on the connection. Only an
+	// authenticated connection (req is nil) is multiplexed, and its
+	// streams inherit the authentication of the connection.
+	if req == nil {
+		if req, err = s.receiveRequest(conn); err != nil {
+			if err != io.EOF {
+				logging.Errorf("%v connection %q exited %v\n", s.logPrefix, raddr, err)
+			}
+			return
+		}
+		if helo, ok := req.(*protobuf.HeloRequest); ok && helo.GetMultiplex() {
+			s.serveMux(conn, clientVersion)
+			return
+		}
+	}
+
+	s.serve(conn, req, clientVersion)
+}
+
+// receiveRequest receives a request on the connection.
+func (s *Server) receiveRequest(conn net.Conn) (interface{}, error) {
+	flags := transport.TransportFlag(0).SetProtobuf()
+	rpkt := transport.NewTransportPacket(s.maxPayload, flags)
+	rpkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	return rpkt.Receive(conn)
+}
+
+// serveMux serves streams multiplexed on the connection, every stream is
+// served as a connection of its own. Responses of concurrent requests on
+// different streams are interleaved on the connection.
+func (s *Server) serveMux(conn net.Conn, clientVersion uint32) {
+	raddr := conn.RemoteAddr()
+
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(s.maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+
+	res := &protobuf.HeloResponse{
+		Version:   proto.Uint32(uint32(c.INDEXER_CUR_VERSION)),
+		Multiplex: proto.Bool(true),
+	}
+	if err := pkt.Send(conn, res); err != nil {
+		logging.Errorf("%v connection %q helo response error %v\n", s.logPrefix, raddr, err)
+		return
+	}
+	if err := pkt.Send(conn, &protobuf.StreamEndResponse{}); err != nil {
+		logging.Errorf("%v connection %q helo response error %v\n", s.logPrefix, raddr, err)
+		return
+	}
+
+	logging.Infof("%v connection %q multiplexing streams\n", s.logPrefix, raddr)
+
+	mux := transport.NewMux(conn, false)
+	defer mux.Close()
+
+	for {
+		stream, err := mux.Accept()
+		if err != nil {
+			break
+		}
+
+		go func() {
+			defer stream.Close()
+			s.serve(stream, nil, clientVersion)
+		}()
+	}
+}
+
+// serve requests received on the connection, one request at a time.
+func (s *Server) serve(conn net.Conn, req interface{}, clientVersion uint32) {
+
 	// start a receive routine.
 	killch := make(chan bool)
 	rcvch := make(chan request, s.streamChanSize)
@@ -0,0 +1,531 @@
+//  Copyright 2023-Present Couchbase, Inc.
+//
+//  Use of this software is governed by the Business Source License included
+//  in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+//  in that file, in accordance with the Business Source License, use of this
+//  software will be governed by the Apache License, Version 2.0, included in
+//  the file licenses/APL2.txt.
+
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/couchbase/indexing/secondary/iowrap"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//////////////////////////////////////////////////////
+// Encryption at rest
+//////////////////////////////////////////////////////
+
+// Data encrypted at rest is a sequence of segments, each sealed with
+// AES-GCM by one key:
+//
+//	magic | key id length (1 byte) | key id | nonce prefix (8 bytes)
+//	chunk header (4 bytes) | ciphertext
+//	...
+//
+// A chunk header holds the length of the ciphertext, with the high bit
+// set on the last chunk of the segment. The nonce of a chunk is the nonce
+// prefix followed by the index of the chunk in the segment, and the chunk
+// header is authenticated with it, so that chunks cannot be reordered or
+// truncated. Segments are appended to a file by each open of a writer.
+//
+// Data that does not start with the magic is plaintext. Plaintext is read
+// as is while encryption at rest is disabled. Once it is enabled, plaintext
+// is rejected, unless files written before it was enabled are explicitly
+// being migrated by SetAtRestMigration, so that data cannot be replaced by
+// plaintext unnoticed.
+
+var atRestMagic = []byte("\xeaGSIEAR\x01")
+
+const (
+	atRestChunkSize   = 64 * 1024
+	atRestFinalChunk  = uint32(1) << 31
+	atRestNoncePrefix = 8
+)
+
+var (
+	ErrAtRestNoKeyProvider = errors.New("No key provider for encryption at rest")
+	ErrAtRestUnknownKey    = errors.New("Unknown key for encryption at rest")
+	ErrAtRestTruncated     = errors.New("Data encrypted at rest is truncated")
+	ErrAtRestCorrupted     = errors.New("Data encrypted at rest is corrupted")
+	ErrAtRestPlaintext     = errors.New("Data is not encrypted at rest")
+)
+
+// AtRestKey is a key of encryption at rest.
+type AtRestKey struct {
+	Id   string
+	aead cipher.AEAD
+}
+
+// NewAtRestKey returns the key named id, for AES-GCM with an AES-128,
+// AES-192 or AES-256 key.
+func NewAtRestKey(id string, key []byte) (*AtRestKey, error) {
+	if len(id) == 0 || len(id) > 255 {
+		return nil, fmt.Errorf("Invalid key id %q", id)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AtRestKey{Id: id, aead: aead}, nil
+}
+
+// AtRestKeyProvider provides the keys of encryption at rest. Data is
+// encrypted with the active key, and keys that were active before are
+// needed to decrypt the data they encrypted.
+type AtRestKeyProvider interface {
+	ActiveKey() (*AtRestKey, error)
+	GetKey(id string) (*AtRestKey, error)
+}
+
+type atRestKeyProviderHolder struct {
+	provider AtRestKeyProvider
+}
+
+var pAtRestKeyProvider unsafe.Pointer = unsafe.Pointer(new(atRestKeyProviderHolder))
+
+// SetAtRestKeyProvider sets the key provider of encryption at rest. Data
+// is written in plaintext if provider is nil.
+func SetAtRestKeyProvider(provider AtRestKeyProvider) {
+	atomic.StorePointer(&pAtRestKeyProvider, unsafe.Pointer(&atRestKeyProviderHolder{provider}))
+}
+
+func GetAtRestKeyProvider() AtRestKeyProvider {
+	return (*atRestKeyProviderHolder)(atomic.LoadPointer(&pAtRestKeyProvider)).provider
+}
+
+var atRestMigration int32
+
+// SetAtRestMigration sets whether data in plaintext is read while
+// encryption at rest is enabled, to migrate the files written before it
+// was enabled. Files read are encrypted when they are written next.
+func SetAtRestMigration(migrate bool) {
+	var v int32
+	if migrate {
+		v = 1
+	}
+	atomic.StoreInt32(&atRestMigration, v)
+}
+
+// plaintextAllowed returns nil if data in plaintext can be read.
+func plaintextAllowed() error {
+	if GetAtRestKeyProvider() == nil || atomic.LoadInt32(&atRestMigration) == 1 {
+		return nil
+	}
+	return decryptFailure(ErrAtRestPlaintext)
+}
+
+// ActiveAtRestKey returns the key data is to be encrypted with, or nil if
+// encryption at rest is disabled.
+func ActiveAtRestKey() (*AtRestKey, error) {
+	provider := GetAtRestKeyProvider()
+	if provider == nil {
+		return nil, nil
+	}
+	return provider.ActiveKey()
+}
+
+// EncryptAtRest encrypts data with the active key. data is returned as is
+// if encryption at rest is disabled.
+func EncryptAtRest(data []byte) ([]byte, error) {
+	key, err := ActiveAtRestKey()
+	if err != nil || key == nil {
+		return data, err
+	}
+
+	var buf bytes.Buffer
+	w := NewAtRestWriter(&buf, key)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptAtRest decrypts data written by EncryptAtRest. Data in plaintext
+// is returned as is, if it can be read.
+func DecryptAtRest(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, atRestMagic) {
+		if err := plaintextAllowed(); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return io.ReadAll(NewAtRestReader(bytes.NewReader(data)))
+}
+
+//////////////////////////////////////////////////////
+// Writer
+//////////////////////////////////////////////////////
+
+type atRestWriter struct {
+	w      io.Writer
+	key    *AtRestKey
+	nonce  [12]byte
+	header bool
+	chunk  uint32
+	plain  []byte
+	sealed []byte
+	closed bool
+}
+
+// NewAtRestWriter returns a writer that encrypts a segment to w with key.
+// The segment is complete on Close, which does not close w.
+func NewAtRestWriter(w io.Writer, key *AtRestKey) io.WriteCloser {
+	return &atRestWriter{
+		w:     w,
+		key:   key,
+		plain: make([]byte, 0, atRestChunkSize),
+	}
+}
+
+func (w *atRestWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		l := atRestChunkSize - len(w.plain)
+		if l > len(p) {
+			l = len(p)
+		}
+		w.plain = append(w.plain, p[:l]...)
+		p = p[l:]
+
+		if len(w.plain) == atRestChunkSize {
+			if err := w.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *atRestWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *atRestWriter) seal(final bool) error {
+	if !w.header {
+		if _, err := rand.Read(w.nonce[:atRestNoncePrefix]); err != nil {
+			return err
+		}
+
+		hdr := make([]byte, 0, len(atRestMagic)+1+len(w.key.Id)+atRestNoncePrefix)
+		hdr = append(hdr, atRestMagic...)
+		hdr = append(hdr, byte(len(w.key.Id)))
+		hdr = append(hdr, w.key.Id...)
+		hdr = append(hdr, w.nonce[:atRestNoncePrefix]...)
+		if _, err := w.w.Write(hdr); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	l := uint32(len(w.plain) + w.key.aead.Overhead())
+	if final {
+		l |= atRestFinalChunk
+	}
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], l)
+	binary.BigEndian.PutUint32(w.nonce[atRestNoncePrefix:], w.chunk)
+	w.sealed = append(w.sealed[:0], hdr[:]...)
+	w.sealed = w.key.aead.Seal(w.sealed, w.nonce[:], w.plain, hdr[:])
+	w.chunk++
+	w.plain = w.plain[:0]
+
+	_, err := w.w.Write(w.sealed)
+	return err
+}
+
+//////////////////////////////////////////////////////
+// Reader
+//////////////////////////////////////////////////////
+
+type atRestReader struct {
+	r       *bufio.Reader
+	key     *AtRestKey
+	nonce   [12]byte
+	chunk   uint32
+	segment bool
+	sealed  []byte
+	plain   []byte
+	err     error
+}
+
+// NewAtRestReader returns a reader that decrypts the segments read from r.
+// Data in plaintext is read as is, if it can be read, and fails with
+// ErrAtRestPlaintext otherwise.
+func NewAtRestReader(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(atRestMagic)); !bytes.Equal(magic, atRestMagic) {
+		if err := plaintextAllowed(); err != nil {
+			return &atRestReader{r: br, err: err}
+		}
+		return br
+	}
+	return &atRestReader{r: br}
+}
+
+func (r *atRestReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk, starting a segment if needed.
+func (r *atRestReader) next() error {
+	if !r.segment {
+		if _, err := r.r.Peek(1); err == io.EOF {
+			return io.EOF
+		}
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return truncated(err)
+	}
+
+	l := binary.BigEndian.Uint32(hdr[:])
+	final := l&atRestFinalChunk != 0
+	l &^= atRestFinalChunk
+	if l < uint32(r.key.aead.Overhead()) || l > atRestChunkSize+uint32(r.key.aead.Overhead()) {
+		return decryptFailure(ErrAtRestCorrupted)
+	}
+
+	if cap(r.sealed) < int(l) {
+		r.sealed = make([]byte, l)
+	}
+	r.sealed = r.sealed[:l]
+	if _, err := io.ReadFull(r.r, r.sealed); err != nil {
+		return truncated(err)
+	}
+
+	binary.BigEndian.PutUint32(r.nonce[atRestNoncePrefix:], r.chunk)
+	plain, err := iowrap.AEAD_Open(r.key.aead, r.sealed[:0], r.nonce[:], r.sealed, hdr[:])
+	if err != nil {
+		return ErrAtRestCorrupted
+	}
+
+	r.plain = plain
+	r.chunk++
+	r.segment = !final
+	return nil
+}
+
+func (r *atRestReader) readHeader() error {
+	hdr := make([]byte, len(atRestMagic)+1)
+	if _, err := io.ReadFull(r.r, hdr); err != nil {
+		return truncated(err)
+	}
+	if !bytes.Equal(hdr[:len(atRestMagic)], atRestMagic) {
+		return decryptFailure(ErrAtRestCorrupted)
+	}
+
+	id := make([]byte, hdr[len(atRestMagic)])
+	if _, err := io.ReadFull(r.r, id); err != nil {
+		return truncated(err)
+	}
+	if _, err := io.ReadFull(r.r, r.nonce[:atRestNoncePrefix]); err != nil {
+		return truncated(err)
+	}
+
+	provider := GetAtRestKeyProvider()
+	if provider == nil {
+		return decryptFailure(ErrAtRestNoKeyProvider)
+	}
+
+	key, err := provider.GetKey(string(id))
+	if err != nil {
+		return decryptFailure(err)
+	}
+
+	r.key, r.chunk, r.segment = key, 0, true
+	return nil
+}
+
+// AtRestKeyId returns the id of the key the data read from r starts to be
+// encrypted with, or "" if the data is in plaintext.
+func AtRestKeyId(r io.Reader) (string, error) {
+	hdr := make([]byte, len(atRestMagic)+1)
+	n, err := io.ReadFull(r, hdr)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if n < len(hdr) || !bytes.Equal(hdr[:len(atRestMagic)], atRestMagic) {
+		return "", nil
+	}
+
+	id := make([]byte, hdr[len(atRestMagic)])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", truncated(err)
+	}
+	return string(id), nil
+}
+
+// truncated returns the error of a read within a segment.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return decryptFailure(ErrAtRestTruncated)
+	}
+	return err
+}
+
+func decryptFailure(err error) error {
+	iowrap.CountDecryptFailure()
+	return err
+}
+
+//////////////////////////////////////////////////////
+// Keyfile key provider
+//////////////////////////////////////////////////////
+
+// KeyFileProvider provides keys from a local keyfile:
+//
+//	{"active": "<key id>", "keys": {"<key id>": "<base64 AES key>", ...}}
+//
+// The keyfile is reloaded when it changes, so a key is rotated by adding
+// a key to the keyfile and making it active, which is used from the next
+// file written, e.g. the next snapshot. Keys removed from the keyfile are
+// retained until restart, to read the files already open.
+type KeyFileProvider struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+	active  string
+	keys    map[string]*AtRestKey
+}
+
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	p := &KeyFileProvider{
+		path: path,
+		keys: make(map[string]*AtRestKey),
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *KeyFileProvider) ActiveKey() (*AtRestKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	return p.keys[p.active], nil
+}
+
+func (p *KeyFileProvider) GetKey(id string) (*AtRestKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%v: %v", ErrAtRestUnknownKey, id)
+}
+
+// refresh reloads the keyfile if it changed since it was loaded.
+func (p *KeyFileProvider) refresh() error {
+	fi, err := iowrap.Os_Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return nil
+	}
+
+	bs, err := iowrap.Ioutil_ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(bs, &kf); err != nil {
+		return fmt.Errorf("Invalid keyfile %v: %v", p.path, err)
+	}
+
+	keys := make(map[string]*AtRestKey, len(kf.Keys))
+	for id, encoded := range kf.Keys {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("Invalid key %v in keyfile %v: %v", id, p.path, err)
+		}
+		if keys[id], err = NewAtRestKey(id, raw); err != nil {
+			return fmt.Errorf("Invalid key %v in keyfile %v: %v", id, p.path, err)
+		}
+	}
+	if _, ok := keys[kf.Active]; !ok {
+		return fmt.Errorf("Active key %q not found in keyfile %v", kf.Active, p.path)
+	}
+
+	for id, key := range keys {
+		p.keys[id] = key
+	}
+	if p.active != kf.Active {
+		logging.Infof("KeyFileProvider: Active key of encryption at rest is %v", kf.Active)
+	}
+	p.active, p.modTime, p.size = kf.Active, fi.ModTime(), fi.Size()
+	return nil
+}
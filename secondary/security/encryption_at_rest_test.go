@@ -0,0 +1,218 @@
+package security
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/iowrap"
+)
+
+type testKeyProvider struct {
+	active string
+	keys   map[string]*AtRestKey
+}
+
+func (p *testKeyProvider) ActiveKey() (*AtRestKey, error) {
+	return p.GetKey(p.active)
+}
+
+func (p *testKeyProvider) GetKey(id string) (*AtRestKey, error) {
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrAtRestUnknownKey
+}
+
+func newTestKeyProvider(t *testing.T, ids ...string) *testKeyProvider {
+	p := &testKeyProvider{active: ids[0], keys: make(map[string]*AtRestKey)}
+	for _, id := range ids {
+		key, err := NewAtRestKey(id, []byte(fmt.Sprintf("%032s", id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.keys[id] = key
+	}
+	return p
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestAtRestTamper(t *testing.T) {
+	SetAtRestKeyProvider(newTestKeyProvider(t, "key1"))
+	defer SetAtRestKeyProvider(nil)
+
+	data := testData(3*atRestChunkSize + 100)
+	enc, err := EncryptAtRest(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if bytes.Contains(enc, data[:64]) {
+		t.Fatalf("Expected data to be encrypted")
+	}
+
+	out, err := DecryptAtRest(enc)
+	if err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("Expected data to be decrypted, received %v", err)
+	}
+
+	// Any modified byte of the ciphertext or of a chunk header is detected
+	hdrlen := len(atRestMagic) + 1 + len("key1") + atRestNoncePrefix
+	for _, pos := range []int{hdrlen + 2, hdrlen + 4 + 10, len(enc) - 1} {
+		tampered := append([]byte(nil), enc...)
+		tampered[pos] ^= 0x01
+
+		failures := iowrap.GetDecryptFailures()
+		if _, err := DecryptAtRest(tampered); err != ErrAtRestCorrupted {
+			t.Errorf("Expected %v for byte %v, received %v", ErrAtRestCorrupted, pos, err)
+		}
+		if iowrap.GetDecryptFailures() == failures {
+			t.Errorf("Expected decrypt failure to be counted for byte %v", pos)
+		}
+	}
+
+	// Reordered chunks are detected
+	chunk := 4 + atRestChunkSize + 16
+	reordered := append([]byte(nil), enc[:hdrlen]...)
+	reordered = append(reordered, enc[hdrlen+chunk:hdrlen+2*chunk]...)
+	reordered = append(reordered, enc[hdrlen:hdrlen+chunk]...)
+	reordered = append(reordered, enc[hdrlen+2*chunk:]...)
+	if _, err := DecryptAtRest(reordered); err != ErrAtRestCorrupted {
+		t.Errorf("Expected %v for reordered chunks, received %v", ErrAtRestCorrupted, err)
+	}
+}
+
+func TestAtRestTruncation(t *testing.T) {
+	SetAtRestKeyProvider(newTestKeyProvider(t, "key1"))
+	defer SetAtRestKeyProvider(nil)
+
+	enc, err := EncryptAtRest(testData(2*atRestChunkSize + 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncation within the header, within a chunk and at a chunk boundary
+	hdrlen := len(atRestMagic) + 1 + len("key1") + atRestNoncePrefix
+	chunk := 4 + atRestChunkSize + 16
+	for _, l := range []int{len(atRestMagic) + 2, hdrlen + 10, hdrlen + chunk, hdrlen + 2*chunk, len(enc) - 1} {
+		if _, err := DecryptAtRest(enc[:l]); err != ErrAtRestTruncated {
+			t.Errorf("Expected %v for length %v, received %v", ErrAtRestTruncated, l, err)
+		}
+	}
+
+	// Appended bytes that are not a segment are detected
+	if _, err := DecryptAtRest(append(enc, []byte("plaintext")...)); err == nil {
+		t.Errorf("Expected error for appended plaintext")
+	}
+}
+
+func TestAtRestKeyRotation(t *testing.T) {
+	provider := newTestKeyProvider(t, "key1", "key2")
+	SetAtRestKeyProvider(provider)
+	defer SetAtRestKeyProvider(nil)
+
+	// Segments appended with a rotated key are read with the key of each
+	data1, data2 := testData(100), testData(atRestChunkSize+1)
+	var buf bytes.Buffer
+	for _, id := range []string{"key1", "key2"} {
+		provider.active = id
+		key, _ := ActiveAtRestKey()
+		w := NewAtRestWriter(&buf, key)
+		data := data1
+		if id == "key2" {
+			data = data2
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	if id, err := AtRestKeyId(bytes.NewReader(buf.Bytes())); err != nil || id != "key1" {
+		t.Errorf("Expected key1, received %v %v", id, err)
+	}
+	out, err := io.ReadAll(NewAtRestReader(bytes.NewReader(buf.Bytes())))
+	if err != nil || !bytes.Equal(out, append(append([]byte(nil), data1...), data2...)) {
+		t.Errorf("Expected segments of both keys to be read, received %v", err)
+	}
+
+	// Data can not be read once its key is removed
+	delete(provider.keys, "key1")
+	if _, err := io.ReadAll(NewAtRestReader(bytes.NewReader(buf.Bytes()))); err != ErrAtRestUnknownKey {
+		t.Errorf("Expected %v, received %v", ErrAtRestUnknownKey, err)
+	}
+
+	// Keyfile is reloaded on rotation
+	keyfile := filepath.Join(t.TempDir(), "keyfile")
+	writeKeyFile := func(active string, ids ...string) {
+		keys := "{"
+		for i, id := range ids {
+			if i > 0 {
+				keys += ","
+			}
+			keys += fmt.Sprintf("%q:%q", id, base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%032s", id))))
+		}
+		content := fmt.Sprintf(`{"active":%q,"keys":%v}}`, active, keys)
+		if err := os.WriteFile(keyfile, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeKeyFile("key1", "key1")
+	kp, err := NewKeyFileProvider(keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetAtRestKeyProvider(kp)
+	enc1, _ := EncryptAtRest(data1)
+
+	writeKeyFile("key2", "key1", "key2")
+	enc2, _ := EncryptAtRest(data1)
+	if id, _ := AtRestKeyId(bytes.NewReader(enc2)); id != "key2" {
+		t.Errorf("Expected data to be encrypted with the rotated key, received %v", id)
+	}
+	for _, enc := range [][]byte{enc1, enc2} {
+		if out, err := DecryptAtRest(enc); err != nil || !bytes.Equal(out, data1) {
+			t.Errorf("Expected data to be decrypted after rotation, received %v", err)
+		}
+	}
+}
+
+func TestAtRestPlaintext(t *testing.T) {
+	data := []byte("plaintext")
+
+	// Plaintext is read while encryption at rest is disabled
+	if out, err := DecryptAtRest(data); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("Expected plaintext to be read, received %v", err)
+	}
+
+	// and rejected once it is enabled, unless it is migrated
+	SetAtRestKeyProvider(newTestKeyProvider(t, "key1"))
+	defer SetAtRestKeyProvider(nil)
+
+	if _, err := DecryptAtRest(data); err != ErrAtRestPlaintext {
+		t.Errorf("Expected %v, received %v", ErrAtRestPlaintext, err)
+	}
+	if _, err := io.ReadAll(NewAtRestReader(bytes.NewReader(data))); err != ErrAtRestPlaintext {
+		t.Errorf("Expected %v, received %v", ErrAtRestPlaintext, err)
+	}
+
+	SetAtRestMigration(true)
+	defer SetAtRestMigration(false)
+
+	if out, err := DecryptAtRest(data); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("Expected plaintext to be migrated, received %v", err)
+	}
+	if out, err := io.ReadAll(NewAtRestReader(bytes.NewReader(data))); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("Expected plaintext to be migrated, received %v", err)
+	}
+}
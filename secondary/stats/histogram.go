@@ -10,6 +10,7 @@ import (
 type Histogram struct {
 	buckets    []int64
 	vals       []int64
+	sum        *int64 // shared by copies of the histogram, like vals
 	humanizeFn func(int64) string
 	bitmap     uint64
 }
@@ -21,6 +22,7 @@ func (h *Histogram) Init(buckets []int64, humanizeFn func(int64) string) {
 	h.buckets[0] = math.MinInt64
 	h.buckets[l+1] = math.MaxInt64
 	h.vals = make([]int64, l+1)
+	h.sum = new(int64)
 
 	if humanizeFn == nil {
 		humanizeFn = func(v int64) string { return fmt.Sprint(v) }
@@ -40,6 +42,7 @@ func (h *Histogram) InitLatency(buckets []int64, humanizeFn func(int64) string)
 	h.buckets[0] = math.MinInt64
 	h.buckets[l+1] = math.MaxInt64
 	h.vals = make([]int64, l+1)
+	h.sum = new(int64)
 
 	if humanizeFn == nil {
 		humanizeFn = func(v int64) string { return fmt.Sprint(v) }
@@ -53,6 +56,7 @@ func (h *Histogram) InitLatency(buckets []int64, humanizeFn func(int64) string)
 func (h *Histogram) Add(val int64) {
 	i := h.findBucket(val)
 	atomic.AddInt64(&h.vals[i], 1)
+	atomic.AddInt64(h.sum, val)
 }
 
 func (h *Histogram) Merge(src Histogram) {
@@ -70,9 +74,30 @@ func (h *Histogram) Merge(src Histogram) {
 		}
 	}
 
-	for i, val := range src.vals {
-		h.vals[i] += val
+	for i := range src.vals {
+		atomic.AddInt64(&h.vals[i], atomic.LoadInt64(&src.vals[i]))
 	}
+	if h.sum != nil && src.sum != nil {
+		atomic.AddInt64(h.sum, atomic.LoadInt64(src.sum))
+	}
+}
+
+// Cumulative returns the upper bounds of the buckets except the last one, which is unbounded, the
+// cumulative counts of values up to each bucket including the last one, and the sum of all values.
+// These are the buckets, count and sum of a Prometheus histogram.
+func (h *Histogram) Cumulative() (bounds []int64, counts []int64, sum int64) {
+	if len(h.buckets) == 0 {
+		return nil, []int64{0}, 0
+	}
+
+	bounds = h.buckets[1 : len(h.buckets)-1]
+	counts = make([]int64, len(h.vals))
+	var count int64
+	for i := range h.vals {
+		count += atomic.LoadInt64(&h.vals[i])
+		counts[i] = count
+	}
+	return bounds, counts, atomic.LoadInt64(h.sum)
 }
 
 func (h *Histogram) findBucket(val int64) int {
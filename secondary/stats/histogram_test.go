@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogramCumulative(t *testing.T) {
+	var h Histogram
+	h.InitLatency([]int64{1, 10}, nil)
+
+	for _, ms := range []int64{0, 1, 5, 10, 20, 30} {
+		h.Add(ms * int64(time.Millisecond))
+	}
+
+	bounds, counts, sum := h.Cumulative()
+	if !reflect.DeepEqual(bounds, []int64{int64(time.Millisecond), 10 * int64(time.Millisecond)}) {
+		t.Errorf("Unexpected bounds %v", bounds)
+	}
+	if !reflect.DeepEqual(counts, []int64{2, 4, 6}) {
+		t.Errorf("Expected counts [2 4 6], received %v", counts)
+	}
+	if sum != 66*int64(time.Millisecond) {
+		t.Errorf("Expected sum %v, received %v", 66*time.Millisecond, time.Duration(sum))
+	}
+
+	var empty Histogram
+	if bounds, counts, sum := empty.Cumulative(); bounds != nil || len(counts) != 1 || counts[0] != 0 || sum != 0 {
+		t.Errorf("Unexpected empty histogram %v %v %v", bounds, counts, sum)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var src Histogram
+	src.InitLatency([]int64{1, 10}, nil)
+
+	// Merge reads the histogram while it is updated
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			src.Add(int64(time.Millisecond))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		var dst Histogram
+		dst.InitLatency([]int64{1, 10}, nil)
+		dst.Merge(src)
+	}
+	wg.Wait()
+
+	var dst Histogram
+	dst.InitLatency([]int64{1, 10}, nil)
+	dst.Merge(src)
+	dst.Merge(src)
+	if _, counts, sum := dst.Cumulative(); counts[2] != 2000 || sum != 2000*int64(time.Millisecond) {
+		t.Errorf("Expected count 2000 and sum %v, received %v %v", 2000*time.Millisecond, counts,
+			time.Duration(sum))
+	}
+
+	// Histograms of other buckets are not merged
+	var other Histogram
+	other.InitLatency([]int64{1, 100}, nil)
+	other.Merge(src)
+	if _, counts, _ := other.Cumulative(); counts[2] != 0 {
+		t.Errorf("Expected histogram of other buckets to not be merged, received %v", counts)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+// Tool checks the entries of an index against the documents of its
+// collection.
+//
+// The index is scanned at a timestamp, and its expressions are evaluated on
+// the documents upto the same timestamp, the same way as the projector does
+// for the indexer. Documents are read over DCP from the bucket, or from a
+// DCP log recorded by the projector under `dcp.record.dir`, which must have
+// the streams of the collection from seqno 0.
+//
+// Entries are compared by document, and the documents are reported as,
+//
+//	missing    - indexed document, having no entries in the index
+//	extra      - entries of a document that is deleted or not indexed
+//	mismatched - entries different from the keys of the document
+//
+// The scan can return the mutations after the timestamp on a live bucket, so
+// the documents that differ are rechecked against the bucket, and the ones
+// changed after the timestamp are not reported.
+//
+// The keys of the documents checked are held in memory. With -passes, the
+// documents are checked in as many passes over the bucket and the index, each
+// holding a part of them. The multiplicity of the entries of a document in an
+// array index is not checked.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/cbauth"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/querycmd"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+var options struct {
+	cluster    string
+	auth       string
+	bucket     string
+	scope      string
+	collection string
+	index      string
+	dump       string
+	sample     float64
+	passes     int
+	workers    int
+	limit      int
+	debug      bool
+}
+
+func argParse() {
+	flag.StringVar(&options.cluster, "cluster", "127.0.0.1:9000",
+		"cluster to connect")
+	flag.StringVar(&options.auth, "auth", "",
+		"Auth user and password")
+	flag.StringVar(&options.bucket, "bucket", "default",
+		"bucket of the index")
+	flag.StringVar(&options.scope, "scope", c.DEFAULT_SCOPE,
+		"scope of the index")
+	flag.StringVar(&options.collection, "collection", c.DEFAULT_COLLECTION,
+		"collection of the index")
+	flag.StringVar(&options.index, "index", "",
+		"name of the index to check")
+	flag.StringVar(&options.dump, "dump", "",
+		"read documents from a recorded DCP log instead of the bucket, "+
+			"given the first log file of a recording")
+	flag.Float64Var(&options.sample, "sample", 1.0,
+		"fraction of the documents to check, between 0 and 1")
+	flag.IntVar(&options.passes, "passes", 1,
+		"number of passes to check the documents in, to bound the memory used")
+	flag.IntVar(&options.workers, "workers", runtime.NumCPU(),
+		"number of workers evaluating the documents in parallel, "+
+			"each on the documents of a hash of their docid")
+	flag.IntVar(&options.limit, "limit", 20,
+		"maximum number of documents to report for each inconsistency")
+	flag.BoolVar(&options.debug, "debug", false,
+		"display debug logs")
+
+	flag.Parse()
+
+	if options.debug {
+		logging.SetLogLevel(logging.Debug)
+	} else {
+		logging.SetLogLevel(logging.Warn)
+	}
+	if options.index == "" {
+		usage()
+		os.Exit(1)
+	}
+	if options.sample <= 0 || options.sample > 1 {
+		logging.Fatalf("-sample shall be in the range (0, 1]")
+		os.Exit(1)
+	}
+	if options.passes < 1 {
+		options.passes = 1
+	}
+	if options.workers < 1 {
+		options.workers = 1
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage : %s [OPTIONS] -index <name>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	argParse()
+
+	// setup cbauth
+	if options.auth != "" {
+		up := strings.Split(options.auth, ":")
+		if _, err := cbauth.InternalRetryDefaultInit(options.cluster, up[0], up[1]); err != nil {
+			logging.Fatalf("Failed to initialize cbauth: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	config := c.SystemConfig.SectionConfig("queryport.client.", true)
+	client, err := qclient.NewGsiClient(options.cluster, config)
+	mf(err, "gsi client")
+	defer client.Close()
+
+	index, ok := querycmd.GetIndex(client, options.bucket, options.scope,
+		options.collection, options.index)
+	if !ok {
+		logging.Fatalf("index %v not found in %v:%v:%v", options.index,
+			options.bucket, options.scope, options.collection)
+		os.Exit(1)
+	}
+
+	defn := index.Definition
+	eval, err := newEvaluator(defn)
+	mf(err, "evaluator")
+
+	src := &gsiSource{client: client, defn: defn, dump: options.dump}
+	report, err := check(src, eval, defn.Name)
+	mf(err, "check")
+
+	report.print(os.Stdout, options.limit)
+	if !report.consistent() {
+		os.Exit(2)
+	}
+}
+
+func mf(err error, msg string) {
+	if err != nil {
+		logging.Fatalf("%v: %v", msg, err)
+		os.Exit(1)
+	}
+}
+
+// check checks the documents of the index in options.passes passes.
+func check(src source, eval keyer, name string) (*report, error) {
+	r := &report{index: name}
+	for pass := 0; pass < options.passes; pass++ {
+		if err := checkPass(src, eval, pass, r); err != nil {
+			return nil, err
+		}
+	}
+	r.sort()
+	return r, nil
+}
+
+// checkPass evaluates the documents of the pass upto a timestamp, and then
+// scans the index at the same timestamp.
+func checkPass(src source, eval keyer, pass int, r *report) error {
+	shards := make([]*shard, options.workers)
+	var wg sync.WaitGroup
+	for i := range shards {
+		shards[i] = newShard(eval)
+		wg.Add(1)
+		go shards[i].run(&wg)
+	}
+	stop := func() {
+		for _, shard := range shards {
+			close(shard.docch)
+		}
+		wg.Wait()
+	}
+
+	ts, err := src.read(func(doc *document) {
+		if shard := shardOf(shards, doc.docid, pass); shard != nil {
+			shard.docch <- doc
+		}
+	})
+	stop()
+	if err != nil {
+		return err
+	}
+
+	// Scan the index at the timestamp of the documents
+	err = src.scan(ts,
+		func(docid string) bool {
+			return shardOf(shards, docid, pass) != nil
+		},
+		func(docid, key string) {
+			shardOf(shards, docid, pass).addEntry(docid, key)
+			r.scanned++
+		})
+	if err != nil {
+		return err
+	}
+
+	changed, err := changedAfter(src, ts, shards, pass)
+	if err != nil {
+		logging.Warnf("unable to recheck the documents against bucket %v: %v, "+
+			"documents changed after the check may be reported", options.bucket, err)
+	}
+	for _, shard := range shards {
+		shard.compare(r, changed)
+	}
+	return nil
+}
+
+// changedAfter returns the documents of the pass changed in the bucket after
+// ts, which the scan at ts may have returned the entries of.
+func changedAfter(src source, ts *qclient.TsConsistency, shards []*shard,
+	pass int) (map[string]bool, error) {
+
+	changed := make(map[string]bool)
+	err := src.changes(ts, func(doc *document) {
+		if shardOf(shards, doc.docid, pass) != nil {
+			changed[doc.docid] = true
+		}
+	})
+	return changed, err
+}
+
+// shardOf returns the shard that checks docid, nil if docid is not in the
+// sample or in the pass.
+func shardOf(shards []*shard, docid string, pass int) *shard {
+	h := fnv.New64a()
+	h.Write([]byte(docid))
+	sum := h.Sum64()
+	if options.sample < 1 && float64(sum%10000) >= options.sample*10000 {
+		return nil
+	}
+	if int((sum>>40)%uint64(options.passes)) != pass {
+		return nil
+	}
+	return shards[int((sum>>16)%uint64(len(shards)))]
+}
+
+//---- shard
+
+// shard evaluates the keys of a subset of the documents, and compares them
+// with their entries in the index.
+type shard struct {
+	eval  keyer
+	docch chan *document
+
+	mu       sync.Mutex
+	expected map[string][]string // docid to keys of the document
+	actual   map[string][]string // docid to keys of its entries in the index
+	errors   int
+}
+
+func newShard(eval keyer) *shard {
+	return &shard{
+		eval:     eval,
+		docch:    make(chan *document, 1000),
+		expected: make(map[string][]string),
+		actual:   make(map[string][]string),
+	}
+}
+
+func (p *shard) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for doc := range p.docch {
+		keys, err := p.eval.keys(doc)
+		p.mu.Lock()
+		if err != nil {
+			logging.Errorf("evaluating %v: %v", logging.TagUD(doc.docid), err)
+			p.errors++
+		}
+		if len(keys) > 0 {
+			p.expected[doc.docid] = keys
+		} else {
+			delete(p.expected, doc.docid)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *shard) addEntry(docid, key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.actual[docid] = append(p.actual[docid], key)
+}
+
+// compare adds the documents that differ to the report, except the ones
+// changed after the timestamp of the check.
+func (p *shard) compare(r *report, changed map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	add := func(diffs *[]diff, d diff) {
+		if changed[d.docid] {
+			r.changed++
+			return
+		}
+		*diffs = append(*diffs, d)
+	}
+
+	r.checked += len(p.expected)
+	r.errors += p.errors
+	for docid, keys := range p.expected {
+		entries, ok := p.actual[docid]
+		if !ok {
+			add(&r.missing, diff{docid: docid, expected: keys})
+		} else if entries = dedupKeys(entries); !equalKeys(keys, entries) {
+			add(&r.mismatched, diff{docid: docid, expected: keys, actual: entries})
+		}
+	}
+	for docid, entries := range p.actual {
+		if _, ok := p.expected[docid]; !ok {
+			add(&r.extra, diff{docid: docid, actual: dedupKeys(entries)})
+		}
+	}
+}
+
+func dedupKeys(keys []string) []string {
+	sort.Strings(keys)
+	out := keys[:0]
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func equalKeys(keys1, keys2 []string) bool {
+	if len(keys1) != len(keys2) {
+		return false
+	}
+	for i := range keys1 {
+		if keys1[i] != keys2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//---- report
+
+type diff struct {
+	docid    string
+	expected []string
+	actual   []string
+}
+
+type report struct {
+	index      string
+	checked    int // documents indexed as per their keys
+	scanned    int // entries scanned in the sample
+	errors     int // documents that failed evaluation
+	changed    int // documents that differ, but changed after the check
+	missing    []diff
+	extra      []diff
+	mismatched []diff
+}
+
+func (r *report) consistent() bool {
+	return len(r.missing) == 0 && len(r.extra) == 0 && len(r.mismatched) == 0
+}
+
+func (r *report) sort() {
+	for _, diffs := range [][]diff{r.missing, r.extra, r.mismatched} {
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i].docid < diffs[j].docid })
+	}
+}
+
+func (r *report) print(w *os.File, limit int) {
+	fmt.Fprintf(w, "index %v: %v documents, %v entries checked", r.index, r.checked, r.scanned)
+	if options.sample < 1 {
+		fmt.Fprintf(w, " (sample %v)", options.sample)
+	}
+	fmt.Fprintln(w)
+	if r.errors > 0 {
+		fmt.Fprintf(w, "%v documents failed evaluation\n", r.errors)
+	}
+	if r.changed > 0 {
+		fmt.Fprintf(w, "%v documents changed during the check, not reported\n", r.changed)
+	}
+
+	printDiffs := func(kind string, diffs []diff) {
+		fmt.Fprintf(w, "%v: %v\n", kind, len(diffs))
+		for i, d := range diffs {
+			if i >= limit {
+				fmt.Fprintf(w, "    ...\n")
+				break
+			}
+			fmt.Fprintf(w, "    %q", d.docid)
+			if d.expected != nil {
+				fmt.Fprintf(w, " expected %v", strings.Join(d.expected, " "))
+			}
+			if d.actual != nil {
+				fmt.Fprintf(w, " indexed %v", strings.Join(d.actual, " "))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	printDiffs("missing", r.missing)
+	printDiffs("extra", r.extra)
+	printDiffs("mismatched", r.mismatched)
+
+	if r.consistent() {
+		fmt.Fprintln(w, "index is consistent")
+	}
+}
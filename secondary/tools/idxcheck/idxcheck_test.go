@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+// fakeSource has documents upto ts, the entries of the index at ts and the
+// documents changed after ts.
+type fakeSource struct {
+	ts         *qclient.TsConsistency
+	docs       []*document
+	entries    map[string][]string // docid to keys of its entries
+	changed    []*document
+	changesErr error
+}
+
+func (src *fakeSource) read(callb func(*document)) (*qclient.TsConsistency, error) {
+	for _, doc := range src.docs {
+		callb(doc)
+	}
+	return src.ts, nil
+}
+
+func (src *fakeSource) changes(ts *qclient.TsConsistency, callb func(*document)) error {
+	if ts != src.ts {
+		return fmt.Errorf("unexpected timestamp %v", ts)
+	}
+	if src.changesErr != nil {
+		return src.changesErr
+	}
+	for _, doc := range src.changed {
+		callb(doc)
+	}
+	return nil
+}
+
+func (src *fakeSource) scan(ts *qclient.TsConsistency, include func(docid string) bool,
+	callb func(docid, key string)) error {
+
+	if ts != src.ts {
+		return fmt.Errorf("unexpected timestamp %v", ts)
+	}
+	for docid, keys := range src.entries {
+		if include(docid) {
+			for _, key := range keys {
+				callb(docid, key)
+			}
+		}
+	}
+	return nil
+}
+
+// fakeKeyer takes the keys of a document as the comma separated values of
+// its body, none if the body is empty or the document is deleted.
+type fakeKeyer struct{}
+
+func (fakeKeyer) keys(doc *document) ([]string, error) {
+	if doc.m.Opcode != mcd.DCP_MUTATION || len(doc.m.Value) == 0 {
+		return nil, nil
+	}
+	return dedupKeys(strings.Split(string(doc.m.Value), ",")), nil
+}
+
+func mutation(docid, value string) *document {
+	m := &mc.DcpEvent{Opcode: mcd.DCP_MUTATION, Key: []byte(docid), Value: []byte(value)}
+	return &document{docid: docid, m: m}
+}
+
+func deletion(docid string) *document {
+	m := &mc.DcpEvent{Opcode: mcd.DCP_DELETION, Key: []byte(docid)}
+	return &document{docid: docid, m: m}
+}
+
+func docids(diffs []diff) []string {
+	out := []string{}
+	for _, d := range diffs {
+		out = append(out, d.docid)
+	}
+	return out
+}
+
+func setOptions(t *testing.T, sample float64, passes, workers int) {
+	saved := options
+	t.Cleanup(func() { options = saved })
+	options.sample, options.passes, options.workers = sample, passes, workers
+}
+
+func TestShardOf(t *testing.T) {
+	setOptions(t, 1.0, 3, 4)
+
+	shards := make([]*shard, options.workers)
+	for i := range shards {
+		shards[i] = newShard(fakeKeyer{})
+	}
+	used := make(map[*shard]bool)
+	passes := make(map[int]int)
+	assigned := make(map[string]*shard)
+	for i := 0; i < 1000; i++ {
+		docid := fmt.Sprintf("doc_%v", i)
+		n := 0
+		for pass := 0; pass < options.passes; pass++ {
+			if shard := shardOf(shards, docid, pass); shard != nil {
+				if shard != shardOf(shards, docid, pass) {
+					t.Fatalf("%v: shard is not stable", docid)
+				}
+				assigned[docid] = shard
+				used[shard] = true
+				passes[pass]++
+				n++
+			}
+		}
+		if n != 1 {
+			t.Fatalf("%v: expected to be checked in 1 pass, in %v", docid, n)
+		}
+	}
+	if len(used) != len(shards) || len(passes) != options.passes {
+		t.Errorf("Expected all shards and passes to check documents, %v shards %v passes",
+			len(used), passes)
+	}
+
+	// Sample is a subset of the documents, on the same shards, and the
+	// sample of a smaller fraction is a subset of a larger one
+	var sampled [2]map[string]bool
+	for i, sample := range []float64{0.25, 0.5} {
+		options.sample = sample
+		sampled[i] = make(map[string]bool)
+		for docid, assignedShard := range assigned {
+			for pass := 0; pass < options.passes; pass++ {
+				if shard := shardOf(shards, docid, pass); shard != nil {
+					if shard != assignedShard {
+						t.Fatalf("%v: sample moved document to another shard", docid)
+					}
+					sampled[i][docid] = true
+				}
+			}
+		}
+		if n := len(sampled[i]); float64(n) < (sample-0.1)*1000 || float64(n) > (sample+0.1)*1000 {
+			t.Errorf("Sample %v of 1000 documents has %v documents", sample, n)
+		}
+	}
+	for docid := range sampled[0] {
+		if !sampled[1][docid] {
+			t.Errorf("%v: in sample 0.25, not in sample 0.5", docid)
+		}
+	}
+}
+
+func TestShardCompare(t *testing.T) {
+	testcases := []struct {
+		name     string
+		expected []string // keys of the document, nil if not indexed
+		actual   []string // keys of its entries, nil if none
+		changed  bool     // changed after the check
+		kind     string   // kind of the difference, "" if none
+	}{
+		{"consistent", []string{"a"}, []string{"a"}, false, ""},
+		{"array", []string{"a", "b"}, []string{"b", "a", "a"}, false, ""},
+		{"unindexed", nil, nil, false, ""},
+		{"missing", []string{"a"}, nil, false, "missing"},
+		{"extra", nil, []string{"a"}, false, "extra"},
+		{"mismatched", []string{"a"}, []string{"b"}, false, "mismatched"},
+		{"partial", []string{"a", "b"}, []string{"a"}, false, "mismatched"},
+		{"changedMissing", []string{"a"}, nil, true, "changed"},
+		{"changedExtra", nil, []string{"a"}, true, "changed"},
+		{"changedMismatched", []string{"a"}, []string{"b"}, true, "changed"},
+		{"changedConsistent", []string{"a"}, []string{"a"}, true, ""},
+	}
+
+	for _, tc := range testcases {
+		p := newShard(fakeKeyer{})
+		if tc.expected != nil {
+			p.expected[tc.name] = tc.expected
+		}
+		for _, key := range tc.actual {
+			p.addEntry(tc.name, key)
+		}
+		changed := map[string]bool{tc.name: tc.changed}
+
+		r := &report{}
+		p.compare(r, changed)
+
+		kinds := map[string]int{
+			"missing":    len(r.missing),
+			"extra":      len(r.extra),
+			"mismatched": len(r.mismatched),
+			"changed":    r.changed,
+		}
+		for kind, n := range kinds {
+			expected := 0
+			if kind == tc.kind {
+				expected = 1
+			}
+			if n != expected {
+				t.Errorf("%v: expected %v %v, received %v", tc.name, expected, kind, n)
+			}
+		}
+		checked := 0
+		if tc.expected != nil {
+			checked = 1
+		}
+		if r.checked != checked {
+			t.Errorf("%v: expected %v checked, received %v", tc.name, checked, r.checked)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	newSource := func() *fakeSource {
+		return &fakeSource{
+			ts: qclient.NewTsConsistency([]uint16{0}, []uint64{10}, []uint64{1}),
+			docs: []*document{
+				mutation("consistent", "a"),
+				mutation("updated", "a"), mutation("updated", "b"),
+				mutation("missing", "a"),
+				mutation("extra", "a"), deletion("extra"),
+				mutation("unindexed", ""),
+				mutation("mismatched", "a,b"),
+				mutation("array", "a,b"),
+				mutation("changed", "a"),
+			},
+			entries: map[string][]string{
+				"consistent": {"a"},
+				"updated":    {"b"},
+				"extra":      {"a"},
+				"mismatched": {"a"},
+				"array":      {"b", "a", "a"},
+				"changed":    {"b"},
+			},
+			changed: []*document{mutation("changed", "b")},
+		}
+	}
+
+	for _, tc := range []struct {
+		passes, workers int
+	}{
+		{1, 1},
+		{1, 4},
+		{3, 2},
+	} {
+		setOptions(t, 1.0, tc.passes, tc.workers)
+		name := fmt.Sprintf("passes %v workers %v", tc.passes, tc.workers)
+
+		r, err := check(newSource(), fakeKeyer{}, "idx")
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if r.checked != 6 || r.scanned != 8 || r.changed != 1 || r.errors != 0 {
+			t.Errorf("%v: unexpected %v checked %v scanned %v changed %v errors", name,
+				r.checked, r.scanned, r.changed, r.errors)
+		}
+		for kind, diffs := range map[string][]diff{
+			"missing":    r.missing,
+			"extra":      r.extra,
+			"mismatched": r.mismatched,
+		} {
+			if ids := docids(diffs); len(ids) != 1 || ids[0] != kind {
+				t.Errorf("%v: expected %v [%v], received %v", name, kind, kind, ids)
+			}
+		}
+		if d := r.mismatched[0]; strings.Join(d.expected, " ") != "a b" ||
+			strings.Join(d.actual, " ") != "a" {
+			t.Errorf("%v: unexpected mismatch %v", name, d)
+		}
+	}
+
+	// Without the recheck, the changed document is reported
+	setOptions(t, 1.0, 2, 2)
+	src := newSource()
+	src.changesErr = errors.New("bucket unavailable")
+	r, err := check(src, fakeKeyer{}, "idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := docids(r.mismatched)
+	sort.Strings(ids)
+	if r.changed != 0 || strings.Join(ids, " ") != "changed mismatched" {
+		t.Errorf("Expected changed document to be reported, received %v changed %v mismatched",
+			r.changed, ids)
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright 2023-Present Couchbase, Inc.
+//
+// Use of this software is governed by the Business Source License included
+// in the file licenses/BSL-Couchbase.txt.  As of the Change Date specified
+// in that file, in accordance with the Business Source License, use of this
+// software will be governed by the Apache License, Version 2.0, included in
+// the file licenses/APL2.txt.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	mcd "github.com/couchbase/indexing/secondary/dcp/transport"
+	mc "github.com/couchbase/indexing/secondary/dcp/transport/client"
+	"github.com/couchbase/indexing/secondary/projector"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+	"github.com/golang/protobuf/proto"
+)
+
+// document is the latest mutation or deletion of a document.
+type document struct {
+	docid string
+	m     *mc.DcpEvent
+}
+
+// source is the documents of the collection of the index, and its entries.
+type source interface {
+	// read calls callb with the documents upto a timestamp, and returns
+	// the timestamp.
+	read(callb func(*document)) (*qclient.TsConsistency, error)
+
+	// changes calls callb with the documents changed in the bucket after ts.
+	changes(ts *qclient.TsConsistency, callb func(*document)) error
+
+	// scan calls callb with the docid and the canonical key of the entries
+	// of the index at ts, of the docids accepted by include.
+	scan(ts *qclient.TsConsistency, include func(docid string) bool,
+		callb func(docid, key string)) error
+}
+
+// keyer evaluates the canonical keys of the entries of a document.
+type keyer interface {
+	keys(doc *document) ([]string, error)
+}
+
+// gsiSource reads the documents from the bucket, or from a DCP log recorded
+// by the projector, and scans the index with the GSI client.
+type gsiSource struct {
+	client *qclient.GsiClient
+	defn   *c.IndexDefn
+	dump   string // first log file of a recording, "" to read the bucket
+}
+
+func (src *gsiSource) read(callb func(*document)) (*qclient.TsConsistency, error) {
+	if src.dump != "" {
+		return readDump(src.dump, src.defn, callb)
+	}
+	ts, err := src.client.BucketTs(options.bucket)
+	if err != nil {
+		return nil, err
+	}
+	return ts, streamBucket(options.cluster, options.bucket, src.defn, nil, ts, callb)
+}
+
+func (src *gsiSource) changes(ts *qclient.TsConsistency, callb func(*document)) error {
+	bucketTs, err := src.client.BucketTs(options.bucket)
+	if err != nil {
+		return err
+	}
+	return streamBucket(options.cluster, options.bucket, src.defn, ts, bucketTs, callb)
+}
+
+func (src *gsiSource) scan(ts *qclient.TsConsistency, include func(docid string) bool,
+	callb func(docid, key string)) error {
+
+	dataEncFmt := src.client.GetDataEncodingFormat()
+	scanParams := map[string]interface{}{"skipReadMetering": true, "user": ""}
+	var buf *[]byte
+	var scanErr error
+	err := src.client.ScanAll(
+		uint64(src.defn.DefnId), "idxcheck", math.MaxInt64, c.QueryConsistency, ts,
+		func(res qclient.ResponseReader) bool {
+			if scanErr = res.Error(); scanErr != nil {
+				return false
+			}
+			skeys, pkeys, err := res.GetEntries(dataEncFmt)
+			if err != nil {
+				scanErr = err
+				return false
+			}
+			for i, pkey := range pkeys {
+				if !include(string(pkey)) {
+					continue
+				}
+				var key string
+				if !src.defn.IsPrimary {
+					skey, err, retBuf := skeys.Getkth(buf, i)
+					if err != nil {
+						scanErr = err
+						return false
+					}
+					if retBuf != nil {
+						buf = retBuf
+					}
+					if key, err = canonicalKey(skey); err != nil {
+						scanErr = err
+						return false
+					}
+				}
+				callb(string(pkey), key)
+			}
+			return true
+		}, scanParams)
+	if err == nil {
+		err = scanErr
+	}
+	return err
+}
+
+func isDocumentEvent(m *mc.DcpEvent) bool {
+	switch m.Opcode {
+	case mcd.DCP_MUTATION, mcd.DCP_DELETION, mcd.DCP_EXPIRATION:
+		return true
+	}
+	return false
+}
+
+// streamBucket streams the documents of the collection of the index changed
+// after the seqnos of from upto ts, from seqno 0 if from is nil.
+func streamBucket(cluster, bucketn string, defn *c.IndexDefn,
+	from, ts *qclient.TsConsistency, callb func(*document)) error {
+
+	b, err := c.ConnectBucket(cluster, "default", bucketn)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	kvaddrs, err := c.GetKVAddrs(cluster, "default", bucketn)
+	if err != nil {
+		return err
+	}
+
+	dcpConfig := map[string]interface{}{
+		"genChanSize":      10000,
+		"dataChanSize":     10000,
+		"numConnections":   4,
+		"activeVbOnly":     true,
+		"collectionsAware": true,
+	}
+	uuid := c.GetUUID(fmt.Sprintf("idxcheck-%v", defn.DefnId), 0)
+	feed, err := b.StartDcpFeedOver(
+		couchbase.NewDcpFeedName(fmt.Sprintf("idxcheck-%v", uuid)),
+		uint32(0), uint32(0x0), kvaddrs, 0xABCD, dcpConfig)
+	if err != nil {
+		return err
+	}
+	defer feed.Close()
+
+	var collectionIds []string
+	if defn.CollectionId != "" {
+		collectionIds = []string{defn.CollectionId}
+	}
+
+	starts := make(map[uint16]int)
+	if from != nil {
+		for i, vbno := range from.Vbnos {
+			starts[vbno] = i
+		}
+	}
+
+	pending := 0
+	for i, vbno := range ts.Vbnos {
+		vbuuid, start := ts.Vbuuids[i], uint64(0)
+		if j, ok := starts[vbno]; ok {
+			vbuuid, start = from.Vbuuids[j], from.Seqnos[j]
+		}
+		if ts.Seqnos[i] <= start {
+			continue
+		}
+		err := feed.DcpRequestStream(
+			vbno, 0xABCD, uint32(0), vbuuid, start, ts.Seqnos[i], start, start,
+			"", "", collectionIds)
+		if err != nil {
+			return fmt.Errorf("stream request for vbucket %v: %v", vbno, err)
+		}
+		pending++
+	}
+
+	for pending > 0 {
+		m, ok := <-feed.C
+		if !ok {
+			return fmt.Errorf("dcp feed closed with %v vbuckets pending", pending)
+		}
+		switch {
+		case m.Opcode == mcd.DCP_STREAMREQ && m.Status != mcd.SUCCESS:
+			return fmt.Errorf("stream request for vbucket %v: %v", m.VBucket, m.Status)
+		case m.Opcode == mcd.DCP_STREAMEND:
+			pending--
+		case isDocumentEvent(m):
+			callb(&document{docid: string(m.Key), m: m})
+		}
+	}
+	return nil
+}
+
+// readDump reads the documents of the collection of the index from a DCP
+// recording of the projector, starting from the log file at path, and
+// returns the timestamp of the recording.
+func readDump(path string, defn *c.IndexDefn, callb func(*document)) (*qclient.TsConsistency, error) {
+	cid, err := strconv.ParseUint(defn.CollectionId, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection id %q: %v", defn.CollectionId, err)
+	}
+
+	vbuuids := make(map[uint16]uint64)
+	seqnos := make(map[uint16]uint64)
+	err = projector.ReadDcpLog(path, func(m *mc.DcpEvent) bool {
+		switch {
+		case m.Opcode == mcd.DCP_STREAMREQ && m.Status == mcd.SUCCESS && m.FailoverLog != nil:
+			if vbuuid, _, err := m.FailoverLog.Latest(); err == nil {
+				vbuuids[m.VBucket] = vbuuid
+			}
+		case isDocumentEvent(m) || m.Opcode == mcd.DCP_SYSTEM_EVENT ||
+			m.Opcode == mcd.DCP_SEQNO_ADVANCED:
+			if m.Seqno > seqnos[m.VBucket] {
+				seqnos[m.VBucket] = m.Seqno
+			}
+			if isDocumentEvent(m) && uint64(m.CollectionID) == cid {
+				callb(&document{docid: string(m.Key), m: m})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ts := qclient.NewTsConsistency(nil, nil, nil)
+	for vbno, seqno := range seqnos {
+		vbuuid, ok := vbuuids[vbno]
+		if !ok {
+			return nil, fmt.Errorf("dump has no stream request for vbucket %v", vbno)
+		}
+		ts.Override(vbno, seqno, vbuuid)
+	}
+	return ts, nil
+}
+
+//---- evaluator
+
+// evaluator evaluates the keys of the documents with the IndexEvaluator of
+// the projector, and explodes the array key into the keys of the entries of
+// the document.
+type evaluator struct {
+	defn      *c.IndexDefn
+	ie        *protobuf.IndexEvaluator
+	arrayPos  int // position of the array key, -1 if none
+	flattened int // number of keys in flatten_keys of the array key
+}
+
+func newEvaluator(defn *c.IndexDefn) (*evaluator, error) {
+	if defn.VectorMeta != nil {
+		return nil, fmt.Errorf("vector index %v is not supported", defn.Name)
+	}
+
+	secExprs, _, _ := c.GetUnexplodedExprs(defn.SecExprs, nil)
+	using := protobuf.StorageType(
+		protobuf.StorageType_value[strings.ToLower(string(defn.Using))]).Enum()
+	instance := &protobuf.IndexInst{
+		InstId: proto.Uint64(uint64(defn.DefnId)),
+		State:  protobuf.IndexState_IndexActive.Enum(),
+		Definition: &protobuf.IndexDefn{
+			DefnID:                 proto.Uint64(uint64(defn.DefnId)),
+			Bucket:                 proto.String(defn.Bucket),
+			IsPrimary:              proto.Bool(defn.IsPrimary),
+			Name:                   proto.String(defn.Name),
+			Using:                  using,
+			ExprType:               protobuf.ExprType_N1QL.Enum(),
+			SecExpressions:         secExprs,
+			PartitionScheme:        protobuf.PartitionScheme_SINGLE.Enum(),
+			WhereExpression:        proto.String(defn.WhereExpr),
+			RetainDeletedXATTR:     proto.Bool(defn.RetainDeletedXATTR),
+			Scope:                  proto.String(defn.Scope),
+			ScopeID:                proto.String(defn.ScopeId),
+			Collection:             proto.String(defn.Collection),
+			CollectionID:           proto.String(defn.CollectionId),
+			IndexMissingLeadingKey: proto.Bool(defn.IndexMissingLeadingKey),
+		},
+	}
+	keyspaceId := strings.Join([]string{defn.Bucket, defn.Scope, defn.Collection}, ":")
+	ie, err := protobuf.NewIndexEvaluator(instance, protobuf.FeedVersion_cheshireCat, keyspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	eval := &evaluator{defn: defn, ie: ie, arrayPos: -1}
+	if !defn.IsPrimary {
+		cExprs, err := protobuf.CompileN1QLExpression(secExprs)
+		if err != nil {
+			return nil, err
+		}
+		for i, cExpr := range cExprs {
+			expr := cExpr.(qexpr.Expression)
+			if isArray, _, isFlattened := expr.IsArrayIndexKey(); isArray {
+				eval.arrayPos = i
+				if isFlattened {
+					eval.flattened = expr.(*qexpr.All).FlattenSize()
+				}
+				break
+			}
+		}
+	}
+	return eval, nil
+}
+
+// keys returns the canonical keys of the entries of the document, nil if
+// the document is not indexed.
+func (eval *evaluator) keys(doc *document) ([]string, error) {
+	m := doc.m
+
+	var nvalue qvalue.Value
+	if m.IsJSON() {
+		nvalue = qvalue.NewParsedValueWithOptions(m.Value, true, true)
+	} else {
+		nvalue = qvalue.NewBinaryValue(m.Value)
+	}
+	context := qexpr.NewIndexContext()
+	docval := qvalue.NewAnnotatedValue(nvalue)
+
+	key, err := eval.ie.SecondaryKey(m, docval, context)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if eval.defn.IsPrimary {
+		return []string{""}, nil
+	}
+
+	var vals []qvalue.Value
+	v := qvalue.NewValue(key)
+	for i := 0; ; i++ {
+		e, ok := v.Index(i)
+		if !ok {
+			break
+		}
+		vals = append(vals, e)
+	}
+
+	entries := [][]qvalue.Value{vals}
+	if eval.arrayPos >= 0 && eval.arrayPos < len(vals) {
+		entries = entries[:0]
+		array := vals[eval.arrayPos]
+		for i := 0; ; i++ {
+			e, ok := array.Index(i)
+			if !ok {
+				break
+			}
+			entry := append([]qvalue.Value(nil), vals[:eval.arrayPos]...)
+			if eval.flattened > 0 {
+				for j := 0; j < eval.flattened; j++ {
+					fe, _ := e.Index(j)
+					entry = append(entry, fe)
+				}
+			} else {
+				entry = append(entry, e)
+			}
+			entries = append(entries, append(entry, vals[eval.arrayPos+1:]...))
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		key, err := canonicalKey(entry)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return dedupKeys(keys), nil
+}
+
+// canonicalKey returns the JSON encoding of the key, so that the keys of
+// the documents and the entries of the index compare equal.
+func canonicalKey(vals []qvalue.Value) (string, error) {
+	out, err := qvalue.NewValue(vals).MarshalJSON()
+	return string(out), err
+}
@@ -0,0 +1,185 @@
+// Payload compression for transport packets. Compression is applied on
+// the encoded payload and the scheme is carried in the packet flags, so
+// that receiver can always decompress the packet without any additional
+// context.
+
+package transport
+
+import "bytes"
+import "compress/bzip2"
+import "compress/gzip"
+import "errors"
+import "io"
+import "io/ioutil"
+import "strings"
+import "sync/atomic"
+
+import "github.com/golang/snappy"
+
+// ErrorCompressionUnknown for unknown or unsupported compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
+// ErrorDecompressOverflow is decompressed payload overflows maximum
+// configured payload size.
+var ErrorDecompressOverflow = errors.New("transport.decompressOverflow")
+
+// CompressionStats accumulate payload size, in bytes, before and after
+// compression for packets sent and received by this process.
+type CompressionStats struct {
+	SentUncompressed     uint64
+	SentCompressed       uint64
+	ReceivedCompressed   uint64
+	ReceivedUncompressed uint64
+}
+
+var compressionStats CompressionStats
+
+// GetCompressionStats returns a snapshot of compression statistics.
+func GetCompressionStats() CompressionStats {
+	return CompressionStats{
+		SentUncompressed:     atomic.LoadUint64(&compressionStats.SentUncompressed),
+		SentCompressed:       atomic.LoadUint64(&compressionStats.SentCompressed),
+		ReceivedCompressed:   atomic.LoadUint64(&compressionStats.ReceivedCompressed),
+		ReceivedUncompressed: atomic.LoadUint64(&compressionStats.ReceivedUncompressed),
+	}
+}
+
+// SendRatio is the ratio of uncompressed to compressed size of packets sent.
+func (s CompressionStats) SendRatio() float64 {
+	if s.SentCompressed == 0 {
+		return 0
+	}
+	return float64(s.SentUncompressed) / float64(s.SentCompressed)
+}
+
+// ReceiveRatio is the ratio of uncompressed to compressed size of packets
+// received.
+func (s CompressionStats) ReceiveRatio() float64 {
+	if s.ReceivedCompressed == 0 {
+		return 0
+	}
+	return float64(s.ReceivedUncompressed) / float64(s.ReceivedCompressed)
+}
+
+// ParseCompression returns the compression for its (case insensitive)
+// name, one of "none", "snappy" or "gzip".
+func ParseCompression(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "gzip":
+		return CompressionGzip, nil
+	}
+	return CompressionNone, ErrorCompressionUnknown
+}
+
+// CompressionName returns the name of compression.
+func CompressionName(compression byte) string {
+	switch compression {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionBzip2:
+		return "bzip2"
+	}
+	return "unknown"
+}
+
+// IsSupportedCompression returns true if payload can be compressed using
+// `compression`. Bzip2 is only supported for decompression.
+func IsSupportedCompression(compression byte) bool {
+	switch compression {
+	case CompressionNone, CompressionSnappy, CompressionGzip:
+		return true
+	}
+	return false
+}
+
+// Compress payload using `compression`.
+func Compress(compression byte, big []byte) (small []byte, err error) {
+	switch compression {
+	case CompressionNone:
+		return big, nil
+
+	case CompressionSnappy:
+		small = snappy.Encode(nil, big)
+
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(big); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		small = buf.Bytes()
+
+	default:
+		return nil, ErrorCompressionUnknown
+	}
+
+	atomic.AddUint64(&compressionStats.SentUncompressed, uint64(len(big)))
+	atomic.AddUint64(&compressionStats.SentCompressed, uint64(len(small)))
+	return small, nil
+}
+
+// Decompress payload that was compressed using `compression`. Payload that
+// decompresses to more than `maxPayload` bytes is rejected.
+func Decompress(compression byte, small []byte, maxPayload int) (big []byte, err error) {
+	switch compression {
+	case CompressionNone:
+		return small, nil
+
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(small)
+		if err != nil {
+			return nil, err
+		} else if n > maxPayload {
+			return nil, ErrorDecompressOverflow
+		}
+		if big, err = snappy.Decode(nil, small); err != nil {
+			return nil, err
+		}
+
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(small))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if big, err = readLimited(r, maxPayload); err != nil {
+			return nil, err
+		}
+
+	case CompressionBzip2:
+		r := bzip2.NewReader(bytes.NewReader(small))
+		if big, err = readLimited(r, maxPayload); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, ErrorCompressionUnknown
+	}
+
+	atomic.AddUint64(&compressionStats.ReceivedCompressed, uint64(len(small)))
+	atomic.AddUint64(&compressionStats.ReceivedUncompressed, uint64(len(big)))
+	return big, nil
+}
+
+// readLimited reads `r` till EOF, failing if it has more than `maxPayload`
+// bytes.
+func readLimited(r io.Reader, maxPayload int) ([]byte, error) {
+	big, err := ioutil.ReadAll(io.LimitReader(r, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	} else if len(big) > maxPayload {
+		return nil, ErrorDecompressOverflow
+	}
+	return big, nil
+}
@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, compression := range []byte{CompressionNone, CompressionSnappy, CompressionGzip} {
+		small, err := Compress(compression, big)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := Decompress(compression, small, len(big))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(out, big) {
+			t.Errorf("Mismatch after %v decompression", CompressionName(compression))
+		}
+
+		// A small payload can not decompress beyond the maximum payload
+		if compression == CompressionNone {
+			continue
+		}
+		if _, err := Decompress(compression, small, len(big)-1); err != ErrorDecompressOverflow {
+			t.Errorf("Expected %v for %v, received %v", ErrorDecompressOverflow,
+				CompressionName(compression), err)
+		}
+	}
+}
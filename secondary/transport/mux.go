@@ -0,0 +1,543 @@
+// Multiplexing of streams on a connection.
+//
+//      { uint32(streamId), uint8(frameType), uint32(len), []byte(data) }
+//
+// Every stream is a net.Conn, packets are sent and received on a stream
+// the same way as on a connection. Data written to a stream is split into
+// frames of at most MuxMaxFrameSize bytes, and frames of different
+// streams are interleaved on the connection.
+//
+// Flow control is per stream. A sender can have at most MuxWindowSize
+// bytes of a stream that are not yet read by the receiver, receiver
+// acknowledges data as it is read from the stream with a window frame.
+// So a stream that is not read does not hold up other streams. A stream
+// that receives more than MuxWindowSize bytes not yet read is closed.
+//
+// Streams are opened by the client, with odd stream ids in increasing
+// order. A stream is opened by its first data frame and is closed by a
+// close frame from either end. Stream ids are not reused, once they run
+// out no more streams can be opened, and the connection is closed after
+// its last stream is closed, for the client to dial a new one.
+
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// ErrorMuxClosed is returned for streams of a closed multiplexed
+// connection.
+var ErrorMuxClosed = errors.New("transport.muxClosed")
+
+// ErrorStreamClosed is returned on read or write of a closed stream.
+var ErrorStreamClosed = errors.New("transport.streamClosed")
+
+// ErrorMuxExhausted is returned on open of a stream once the stream ids of
+// the connection have run out.
+var ErrorMuxExhausted = errors.New("transport.muxExhausted")
+
+// ErrorMuxWindowOverflow is returned for a stream whose sender exceeds
+// MuxWindowSize.
+var ErrorMuxWindowOverflow = errors.New("transport.muxWindowOverflow")
+
+const ( // types of frames of a multiplexed connection.
+	muxFrameData   byte = 1
+	muxFrameWindow byte = 2
+	muxFrameClose  byte = 3
+)
+
+const (
+	muxHeaderSize int = 9
+	// MuxMaxFrameSize is the maximum data of a frame.
+	MuxMaxFrameSize int = 16 * 1024
+	// MuxWindowSize is the maximum data of a stream not yet read by the
+	// receiver.
+	MuxWindowSize int = 256 * 1024
+	// muxAcceptBacklog is the number of opened streams not yet accepted,
+	// beyond which new streams are closed.
+	muxAcceptBacklog int = 1024
+)
+
+// Mux multiplexes streams on a connection.
+type Mux struct {
+	conn      net.Conn
+	client    bool
+	logPrefix string
+
+	wmu  sync.Mutex // serializes frames written on the connection
+	whdr []byte
+
+	mu        sync.Mutex
+	streams   map[uint32]*MuxStream
+	nextId    uint32 // next stream id to open, client only
+	exhausted bool   // last stream id is opened, client only
+	lastId    uint32 // last stream id accepted, server only
+	err       error  // error that closed the connection
+	acceptch  chan *MuxStream
+	donech    chan struct{}
+}
+
+// NewMux starts multiplexing streams on conn. Streams are opened by the
+// client end and accepted by the server end of the connection.
+func NewMux(conn net.Conn, client bool) *Mux {
+	m := &Mux{
+		conn:      conn,
+		client:    client,
+		logPrefix: "[Mux:" + conn.LocalAddr().String() + "->" + conn.RemoteAddr().String() + "]",
+		whdr:      make([]byte, muxHeaderSize),
+		streams:   make(map[uint32]*MuxStream),
+		nextId:    1,
+		acceptch:  make(chan *MuxStream, muxAcceptBacklog),
+		donech:    make(chan struct{}),
+	}
+	go m.receive()
+	return m
+}
+
+// Open opens a new stream, client only.
+func (m *Mux) Open() (*MuxStream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	} else if m.exhausted {
+		return nil, ErrorMuxExhausted
+	}
+
+	s := newMuxStream(m, m.nextId)
+	m.streams[s.id] = s
+	if m.nextId == math.MaxUint32 {
+		m.exhausted = true
+	} else {
+		m.nextId += 2
+	}
+	return s, nil
+}
+
+// IsExhausted returns true if no more streams can be opened, as the stream
+// ids have run out.
+func (m *Mux) IsExhausted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.exhausted
+}
+
+// Accept returns the next stream opened by the client, server only.
+func (m *Mux) Accept() (*MuxStream, error) {
+	select {
+	case s := <-m.acceptch:
+		return s, nil
+	case <-m.donech:
+		return nil, m.error()
+	}
+}
+
+// Close closes the connection and all its streams.
+func (m *Mux) Close() error {
+	m.fail(ErrorMuxClosed)
+	return nil
+}
+
+// IsClosed returns true if the connection is closed.
+func (m *Mux) IsClosed() bool {
+	return m.error() != nil
+}
+
+// NumStreams returns the number of open streams.
+func (m *Mux) NumStreams() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.streams)
+}
+
+func (m *Mux) error() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// fail closes the connection with err, streams return err on subsequent
+// read and write.
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	if m.err != nil {
+		m.mu.Unlock()
+		return
+	}
+	m.err = err
+	streams := m.streams
+	m.streams = make(map[uint32]*MuxStream)
+	close(m.donech)
+	m.mu.Unlock()
+
+	m.conn.Close()
+	for _, s := range streams {
+		s.fail(err)
+	}
+}
+
+func (m *Mux) writeFrame(id uint32, typ byte, data []byte) error {
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+
+	binary.BigEndian.PutUint32(m.whdr[0:4], id)
+	m.whdr[4] = typ
+	binary.BigEndian.PutUint32(m.whdr[5:9], uint32(len(data)))
+
+	err := connWrite(m.conn, m.whdr)
+	if err == nil && len(data) != 0 {
+		err = connWrite(m.conn, data)
+	}
+	if err != nil {
+		go m.fail(err)
+	}
+	return err
+}
+
+func (m *Mux) writeWindow(id uint32, n uint32) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, n)
+	return m.writeFrame(id, muxFrameWindow, data)
+}
+
+// receive routine dispatches frames received on the connection to
+// streams, until the connection is closed.
+func (m *Mux) receive() {
+	hdr := make([]byte, muxHeaderSize)
+
+	var err error
+	for {
+		if err = fullRead(m.conn, hdr); err != nil {
+			break
+		}
+
+		id, typ := binary.BigEndian.Uint32(hdr[0:4]), hdr[4]
+		size := int(binary.BigEndian.Uint32(hdr[5:9]))
+		if size > MuxMaxFrameSize {
+			logging.Errorf("%v frame of stream %v size %v exceeds %v", m.logPrefix, id, size, MuxMaxFrameSize)
+			err = ErrorPacketOverflow
+			break
+		}
+
+		data := make([]byte, size)
+		if err = fullRead(m.conn, data); err != nil {
+			break
+		}
+
+		m.dispatch(id, typ, data)
+	}
+
+	if err == io.EOF {
+		logging.Tracef("%v connection closed", m.logPrefix)
+	} else if m.error() == nil {
+		logging.Errorf("%v receive error %v", m.logPrefix, err)
+	}
+	m.fail(err)
+}
+
+func (m *Mux) dispatch(id uint32, typ byte, data []byte) {
+
+	m.mu.Lock()
+	s, ok := m.streams[id]
+	if !ok && typ == muxFrameData && !m.client && id > m.lastId {
+		m.lastId = id
+		s = newMuxStream(m, id)
+		select {
+		case m.acceptch <- s:
+			m.streams[id] = s
+			ok = true
+		default:
+			m.mu.Unlock()
+			logging.Warnf("%v closing stream %v, too many streams not accepted", m.logPrefix, id)
+			m.writeFrame(id, muxFrameClose, nil)
+			return
+		}
+	}
+	m.mu.Unlock()
+
+	// Frames of closed streams are dropped
+	if !ok {
+		return
+	}
+
+	switch typ {
+	case muxFrameData:
+		if err := s.push(data); err != nil {
+			logging.Errorf("%v closing stream %v, %v", m.logPrefix, id, err)
+			s.fail(err)
+			m.remove(id)
+			m.writeFrame(id, muxFrameClose, nil)
+		}
+	case muxFrameWindow:
+		if len(data) == 4 {
+			s.addCredit(binary.BigEndian.Uint32(data))
+		}
+	case muxFrameClose:
+		s.remoteClose()
+	}
+}
+
+// remove removes the stream, and returns true if it is the last stream of
+// a connection whose stream ids have run out.
+func (m *Mux) remove(id uint32) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.streams, id)
+	return m.exhausted && len(m.streams) == 0
+}
+
+// MuxStream is a stream of a multiplexed connection.
+type MuxStream struct {
+	mux *Mux
+	id  uint32
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	buf       []byte // data received and not yet read
+	unacked   uint32 // data read and not yet acknowledged to the sender
+	credit    uint32 // data that can be sent before it is acknowledged
+	closed    bool   // closed by this end
+	rclosed   bool   // closed by the remote end
+	err       error  // connection error
+	rdeadline time.Time
+	wdeadline time.Time
+}
+
+func newMuxStream(m *Mux, id uint32) *MuxStream {
+	s := &MuxStream{mux: m, id: id, credit: uint32(MuxWindowSize)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Id returns the stream id.
+func (s *MuxStream) Id() uint32 {
+	return s.id
+}
+
+func (s *MuxStream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 {
+		if err := s.errLOCKED(true); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+		if err := s.waitLOCKED(s.rdeadline); err != nil {
+			s.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+
+	var ack uint32
+	s.unacked += uint32(n)
+	if s.unacked >= uint32(MuxWindowSize/2) && !s.rclosed {
+		ack, s.unacked = s.unacked, 0
+	}
+	s.mu.Unlock()
+
+	if ack != 0 {
+		s.mux.writeWindow(s.id, ack)
+	}
+	return n, nil
+}
+
+func (s *MuxStream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		s.mu.Lock()
+		for s.credit == 0 {
+			if err := s.errLOCKED(false); err != nil {
+				s.mu.Unlock()
+				return written, err
+			}
+			if err := s.waitLOCKED(s.wdeadline); err != nil {
+				s.mu.Unlock()
+				return written, err
+			}
+		}
+		if err := s.errLOCKED(false); err != nil {
+			s.mu.Unlock()
+			return written, err
+		}
+
+		n := len(b)
+		if n > int(s.credit) {
+			n = int(s.credit)
+		}
+		if n > MuxMaxFrameSize {
+			n = MuxMaxFrameSize
+		}
+		s.credit -= uint32(n)
+		s.mu.Unlock()
+
+		if err := s.mux.writeFrame(s.id, muxFrameData, b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close closes the stream, the remote end of the stream reads io.EOF
+// after the data written so far.
+func (s *MuxStream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.buf = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	last := s.mux.remove(s.id)
+	if s.mux.IsClosed() {
+		return nil
+	}
+	err := s.mux.writeFrame(s.id, muxFrameClose, nil)
+	if last {
+		s.mux.Close()
+	}
+	return err
+}
+
+func (s *MuxStream) LocalAddr() net.Addr {
+	return s.mux.conn.LocalAddr()
+}
+
+func (s *MuxStream) RemoteAddr() net.Addr {
+	return s.mux.conn.RemoteAddr()
+}
+
+func (s *MuxStream) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rdeadline, s.wdeadline = t, t
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *MuxStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rdeadline = t
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *MuxStream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.wdeadline = t
+	s.cond.Broadcast()
+	return nil
+}
+
+// errLOCKED returns the error of read (or write) on the stream, if it is
+// closed.
+func (s *MuxStream) errLOCKED(read bool) error {
+	if s.closed {
+		return ErrorStreamClosed
+	} else if s.err != nil {
+		return s.err
+	} else if s.rclosed {
+		if read {
+			return io.EOF
+		}
+		return ErrorStreamClosed
+	}
+	return nil
+}
+
+// waitLOCKED waits for the stream to be signalled, or the deadline.
+func (s *MuxStream) waitLOCKED(deadline time.Time) error {
+	if deadline.IsZero() {
+		s.cond.Wait()
+		return nil
+	}
+
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+
+	t := time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.cond.Broadcast()
+	})
+	s.cond.Wait()
+	t.Stop()
+	return nil
+}
+
+// push adds data received for the stream, or returns an error if the
+// sender exceeds the window, i.e. data received and not yet acknowledged
+// would exceed MuxWindowSize.
+func (s *MuxStream) push(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	if len(s.buf)+int(s.unacked)+len(data) > MuxWindowSize {
+		return ErrorMuxWindowOverflow
+	}
+	s.buf = append(s.buf, data...)
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *MuxStream) addCredit(n uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credit += n
+	s.cond.Broadcast()
+}
+
+func (s *MuxStream) remoteClose() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rclosed = true
+	s.cond.Broadcast()
+}
+
+func (s *MuxStream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == io.EOF {
+		// connection closed by remote end, no more data for the stream
+		s.rclosed = true
+	} else {
+		s.err = err
+	}
+	s.cond.Broadcast()
+}
@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMux(t *testing.T) {
+	c, s := net.Pipe()
+	client, server := NewMux(c, true), NewMux(s, false)
+	defer client.Close()
+
+	big := bytes.Repeat([]byte("0123456789"), MuxWindowSize/5)
+	small := []byte("small")
+
+	cs1, _ := client.Open()
+	cs2, _ := client.Open()
+
+	// Writer of cs1 is blocked for want of window, as cs1 is not read
+	// beyond its first frame, which does not hold up cs2
+	donech := make(chan error, 1)
+	go func() {
+		_, err := cs1.Write(big)
+		donech <- err
+	}()
+
+	ss1, err := server.Accept()
+	if err != nil || ss1.Id() != cs1.Id() {
+		t.Fatalf("Unexpected stream %v error %v", ss1, err)
+	}
+	buf := make([]byte, len(big))
+	if _, err := io.ReadFull(ss1, buf[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	cs2.Write(small)
+	ss2, err := server.Accept()
+	if err != nil || ss2.Id() != cs2.Id() {
+		t.Fatalf("Unexpected stream %v error %v", ss2, err)
+	}
+	rbuf := make([]byte, len(small))
+	if _, err := io.ReadFull(ss2, rbuf); err != nil || !bytes.Equal(rbuf, small) {
+		t.Fatalf("Unexpected data %s error %v", rbuf, err)
+	}
+
+	if _, err := io.ReadFull(ss1, buf[1:]); err != nil || !bytes.Equal(buf, big) {
+		t.Fatalf("Unexpected data of stream %v error %v", ss1.Id(), err)
+	}
+	if err := <-donech; err != nil {
+		t.Fatal(err)
+	}
+
+	// Responses on server streams
+	ss2.Write(small)
+	if _, err := io.ReadFull(cs2, rbuf); err != nil || !bytes.Equal(rbuf, small) {
+		t.Fatalf("Unexpected data %s error %v", rbuf, err)
+	}
+
+	// Read deadline
+	cs2.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := cs2.Read(rbuf); err == nil {
+		t.Fatalf("Expected timeout")
+	} else if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Fatalf("Expected timeout, received %v", err)
+	}
+
+	// Close of a stream is seen by the remote end, other streams are
+	// not affected
+	cs1.Close()
+	if _, err := ss1.Read(buf); err != io.EOF {
+		t.Fatalf("Expected EOF, received %v", err)
+	}
+	if _, err := ss2.Write(small); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close of the connection closes all streams
+	server.Close()
+	if _, err := server.Accept(); err == nil {
+		t.Fatalf("Expected error on accept")
+	}
+	cs2.SetReadDeadline(time.Time{})
+	if _, err := io.ReadFull(cs2, rbuf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs2.Read(rbuf); err == nil {
+		t.Fatalf("Expected error on closed connection")
+	}
+}
+
+func TestMuxWindowOverflow(t *testing.T) {
+	c, s := net.Pipe()
+	client, server := NewMux(c, true), NewMux(s, false)
+	defer client.Close()
+	defer server.Close()
+
+	// A sender that ignores the window of the stream
+	cs, _ := client.Open()
+	frame := make([]byte, MuxMaxFrameSize)
+	for n := 0; n <= MuxWindowSize; n += len(frame) {
+		if err := client.writeFrame(cs.Id(), muxFrameData, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The stream is closed by the receiver
+	if _, err := cs.Read(frame); err != io.EOF {
+		t.Fatalf("Expected EOF, received %v", err)
+	}
+
+	ss, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rerr error
+	for rerr == nil {
+		_, rerr = ss.Read(frame)
+	}
+	if rerr != ErrorMuxWindowOverflow {
+		t.Fatalf("Expected %v, received %v", ErrorMuxWindowOverflow, rerr)
+	}
+	if server.IsClosed() || client.IsClosed() {
+		t.Fatalf("Expected connection to remain open")
+	}
+}
+
+func TestMuxExhausted(t *testing.T) {
+	c, s := net.Pipe()
+	client, server := NewMux(c, true), NewMux(s, false)
+	defer client.Close()
+	defer server.Close()
+
+	// Last two stream ids
+	client.nextId = math.MaxUint32 - 2
+
+	small := []byte("small")
+	rbuf := make([]byte, len(small))
+
+	var streams []*MuxStream
+	for i := 0; i < 2; i++ {
+		cs, err := client.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.Write(small)
+		ss, err := server.Accept()
+		if err != nil || ss.Id() != cs.Id() {
+			t.Fatalf("Unexpected stream %v error %v", ss, err)
+		}
+		if _, err := io.ReadFull(ss, rbuf); err != nil || !bytes.Equal(rbuf, small) {
+			t.Fatalf("Unexpected data %s error %v", rbuf, err)
+		}
+		streams = append(streams, cs)
+	}
+	if streams[1].Id() != math.MaxUint32 {
+		t.Fatalf("Expected last stream id, received %v", streams[1].Id())
+	}
+
+	// Stream ids are not reused
+	if !client.IsExhausted() {
+		t.Fatalf("Expected stream ids to run out")
+	}
+	if _, err := client.Open(); err != ErrorMuxExhausted {
+		t.Fatalf("Expected %v, received %v", ErrorMuxExhausted, err)
+	}
+
+	// Connection is closed once its last stream is closed
+	streams[0].Close()
+	if client.IsClosed() {
+		t.Fatalf("Expected connection to remain open")
+	}
+	streams[1].Close()
+	if !client.IsClosed() {
+		t.Fatalf("Expected connection to be closed")
+	}
+	if _, err := server.Accept(); err == nil {
+		t.Fatalf("Expected error on accept")
+	}
+}
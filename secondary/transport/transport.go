@@ -174,20 +174,12 @@ func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error)
 
 // compress array of bytes.
 func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		small = big
-	}
-	return
+	return Compress(pkt.flags.GetCompression(), big)
 }
 
 // decompress array of bytes.
 func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		big = small
-	}
-	return
+	return Decompress(pkt.flags.GetCompression(), small, len(pkt.buf))
 }
 
 // read len(buf) bytes from `conn`.
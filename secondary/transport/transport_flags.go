@@ -50,6 +50,11 @@ func (flags TransportFlag) SetBzip2() TransportFlag {
 	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionBzip2)
 }
 
+// SetCompression will set packet compression to `compression`
+func (flags TransportFlag) SetCompression(compression byte) TransportFlag {
+	return (flags & TransportFlag(0xFFF0)) | TransportFlag(compression&0x0F)
+}
+
 // GetEncoding will get the encoding bits from flags
 func (flags TransportFlag) GetEncoding() byte {
 	return byte(flags & TransportFlag(0x00F0))